@@ -1,12 +1,21 @@
 package main
 
 import (
+	"context"
 	"log"
 	"mana/internal/api"
+	"mana/internal/automod"
 	"mana/internal/db"
+	"mana/internal/insights"
+	"mana/internal/janitor"
+	"mana/internal/memberlist"
+	"mana/internal/subscriptions"
+	"mana/internal/unfurl"
+	"mana/internal/websocket"
 	"net/http"
 	"os"
 
+	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 )
 
@@ -29,7 +38,47 @@ func main() {
 		port = "8080"
 	}
 
-	router := api.NewRouter(store)
+	// start gateway hub
+	hub := websocket.NewHub()
+	memberLists := memberlist.NewMemberLists(store, hub)
+	hub.OnUserOnline = func(userID uuid.UUID) {
+		memberLists.UserChanged(context.Background(), userID)
+	}
+	hub.OnUserOffline = func(userID uuid.UUID) {
+		memberLists.UserChanged(context.Background(), userID)
+
+		// temporary members leave once they disconnect, unless given a role meanwhile
+		if err := store.Guilds.RemoveTemporaryMemberships(context.Background(), userID); err != nil {
+			log.Printf("Failed to remove temporary memberships for %s: %v", userID, err)
+		}
+	}
+	hub.OnMemberListSubscribe = memberLists.Subscribe
+	go hub.Run()
+
+	// start member list syncing
+	go memberLists.Run(context.Background())
+
+	// start link unfurler
+	unfurler := unfurl.NewUnfurler(store, hub)
+	go unfurler.Run(context.Background())
+
+	// start retention janitor
+	retentionJanitor := janitor.NewJanitor(store, hub, memberLists)
+	go retentionJanitor.Run(context.Background())
+
+	// start insights rollup
+	insightsRollup := insights.NewRollup(store, hub)
+	go insightsRollup.Run(context.Background())
+
+	// start event subscription deliveries
+	deliverer := subscriptions.NewDeliverer(store)
+	go deliverer.Run(context.Background())
+
+	// start automod, it only prunes message history in the background
+	automodEngine := automod.NewEngine(store)
+	go automodEngine.Run(context.Background())
+
+	router := api.NewRouter(store, hub, unfurler, deliverer, memberLists, automodEngine)
 
 	// Start server
 	log.Printf("Mana server on port %s...\n", port)
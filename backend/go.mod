@@ -11,12 +11,14 @@ require (
 
 require github.com/golang-jwt/jwt/v5 v5.2.2
 
-require github.com/joho/godotenv v1.5.1
+require (
+	github.com/gorilla/websocket v1.5.3
+	github.com/jackc/pgconn v1.14.3
+	github.com/joho/godotenv v1.5.1
+)
 
 require (
-	github.com/gorilla/websocket v1.5.3 // indirect
 	github.com/jackc/chunkreader/v2 v2.0.1 // indirect
-	github.com/jackc/pgconn v1.14.3 // indirect
 	github.com/jackc/pgio v1.0.0 // indirect
 	github.com/jackc/pgpassfile v1.0.0 // indirect
 	github.com/jackc/pgproto3/v2 v2.3.3 // indirect
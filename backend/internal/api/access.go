@@ -0,0 +1,207 @@
+package api
+
+import (
+	"context"
+	"mana/internal/middleware"
+	"mana/internal/models"
+	"mana/internal/permissions"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// what every recipient of a dm can do, dms have no roles or overrides
+const dmPermissions = permissions.PermissionViewChannels |
+	permissions.PermissionSendMessages |
+	permissions.PermissionEmbedLinks |
+	permissions.PermissionAttachFiles |
+	permissions.PermissionAddReaction |
+	permissions.PermissionUseExternalEmotes |
+	permissions.PermissionReadMessageHistory
+
+type accessContextKey string
+
+const accessKey accessContextKey = "access"
+
+// What the permission layer resolved for the current request. Channel and
+// DM are only set on channel routes, and only one of them at a time.
+type access struct {
+	Guild       *models.Guild
+	Channel     *models.GuildChannel
+	DM          *models.DMChannel
+	Permissions uint64
+}
+
+// Only valid in handlers mounted behind requireGuildPermission or requireChannelPermission
+func accessFromContext(ctx context.Context) *access {
+	return ctx.Value(accessKey).(*access)
+}
+
+// Guards a /guild/{id} route. Non-members get a 404 as if the guild didn't
+// exist, members without perm get a 403. A perm of 0 only requires membership.
+func (api *API) requireGuildPermission(perm uint64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+
+			userID, ok := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+			if !ok {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			guildID, err := uuid.Parse(chi.URLParam(r, "id"))
+			if err != nil {
+				http.Error(w, "Invalid guild ID", http.StatusBadRequest)
+				return
+			}
+
+			guild, status := api.loadGuildForMember(ctx, guildID, userID)
+			if status != http.StatusOK {
+				writeAccessError(w, status, "Guild not found")
+				return
+			}
+
+			perms, err := api.resolveGuildPermissions(ctx, guild, userID)
+			if err != nil {
+				http.Error(w, "Failed to resolve permissions", http.StatusInternalServerError)
+				return
+			}
+
+			if !permissions.HasPermission(perms, perm) {
+				http.Error(w, "Missing permissions", http.StatusForbidden)
+				return
+			}
+
+			ctx = context.WithValue(ctx, accessKey, &access{Guild: guild, Permissions: perms})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Guards a /channel/{id} route, guild channels and dms alike. Anyone who
+// can't see the channel gets a 404, anyone who can but lacks perm gets a 403.
+func (api *API) requireChannelPermission(perm uint64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+
+			userID, ok := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+			if !ok {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			channelID, err := uuid.Parse(chi.URLParam(r, "id"))
+			if err != nil {
+				http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+				return
+			}
+
+			channelAccess, status := api.resolveChannelAccess(ctx, channelID, userID)
+			if status != http.StatusOK {
+				writeAccessError(w, status, "Channel not found")
+				return
+			}
+
+			if !permissions.HasPermission(channelAccess.Permissions, permissions.PermissionViewChannels) {
+				http.Error(w, "Channel not found", http.StatusNotFound)
+				return
+			}
+
+			if !permissions.HasPermission(channelAccess.Permissions, perm) {
+				http.Error(w, "Missing permissions", http.StatusForbidden)
+				return
+			}
+
+			ctx = context.WithValue(ctx, accessKey, channelAccess)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Categories hold no messages, chain after requireChannelPermission
+func (api *API) rejectCategory(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		channel := accessFromContext(r.Context()).Channel
+		if channel != nil && channel.Type == models.ChannelTypeCategory {
+			http.Error(w, "Categories cannot hold messages", http.StatusBadRequest)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Keeps bots off routes meant for the people behind accounts, like joining
+// guilds on their own or managing friends and applications
+func (api *API) rejectBots(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if middleware.IsBot(r.Context()) {
+			http.Error(w, "Bots cannot use this endpoint", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Finds the guild or dm behind channelID and the user's permissions in it
+func (api *API) resolveChannelAccess(ctx context.Context, channelID uuid.UUID, userID uuid.UUID) (*access, int) {
+	channel, err := api.Store.GuildChannels.GetChannelByID(ctx, channelID)
+	if err != nil {
+		return nil, http.StatusInternalServerError
+	}
+
+	// not a guild channel, dms share the same routes
+	if channel == nil {
+		dm, err := api.Store.DMChannels.GetDMChannelByID(ctx, channelID)
+		if err != nil {
+			return nil, http.StatusInternalServerError
+		}
+		if dm == nil || !dm.HasRecipient(userID) {
+			return nil, http.StatusNotFound
+		}
+		return &access{DM: dm, Permissions: dmPermissions}, http.StatusOK
+	}
+
+	guild, status := api.loadGuildForMember(ctx, channel.GuildID, userID)
+	if status != http.StatusOK {
+		return nil, status
+	}
+
+	perms, err := api.resolveChannelPermissions(ctx, guild, channel, userID)
+	if err != nil {
+		return nil, http.StatusInternalServerError
+	}
+
+	return &access{Guild: guild, Channel: channel, Permissions: perms}, http.StatusOK
+}
+
+// Gets the guild if userID is a member, a missing guild and a non-member look the same
+func (api *API) loadGuildForMember(ctx context.Context, guildID uuid.UUID, userID uuid.UUID) (*models.Guild, int) {
+	guild, err := api.Store.Guilds.GetGuildByID(ctx, guildID)
+	if err != nil {
+		return nil, http.StatusInternalServerError
+	}
+	if guild == nil {
+		return nil, http.StatusNotFound
+	}
+
+	isMember, err := api.Store.Guilds.CheckUserMemberOfGuild(ctx, guildID, userID)
+	if err != nil {
+		return nil, http.StatusInternalServerError
+	}
+	if !isMember {
+		return nil, http.StatusNotFound
+	}
+
+	return guild, http.StatusOK
+}
+
+func writeAccessError(w http.ResponseWriter, status int, notFound string) {
+	if status == http.StatusNotFound {
+		http.Error(w, notFound, http.StatusNotFound)
+		return
+	}
+	http.Error(w, "Failed to resolve permissions", http.StatusInternalServerError)
+}
@@ -1,7 +1,23 @@
 package api
 
-import "mana/internal/db"
+import (
+	"mana/internal/automod"
+	"mana/internal/db"
+	"mana/internal/memberlist"
+	"mana/internal/middleware"
+	"mana/internal/subscriptions"
+	"mana/internal/unfurl"
+	"mana/internal/websocket"
+)
 
 type API struct {
-	Store *db.Store
+	Store       *db.Store
+	Hub         *websocket.Hub
+	Unfurler    *unfurl.Unfurler
+	Deliverer   *subscriptions.Deliverer
+	MemberLists *memberlist.MemberLists
+	Automod     *automod.Engine
+
+	WebhookLimiter        *middleware.RateLimiter
+	WebhookFailureLimiter *middleware.RateLimiter
 }
@@ -0,0 +1,419 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mana/internal/db"
+	"mana/internal/middleware"
+	"mana/internal/models"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+var errInvalidBotToken = errors.New("invalid bot token")
+
+type CreateApplicationRequest struct {
+	Name        string `json:"name"` // the bot's username
+	Description string `json:"description"`
+	Public      bool   `json:"public"`
+}
+
+type UpdateApplicationRequest struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	Public      *bool   `json:"public"`
+}
+
+type AuthorizeBotRequest struct {
+	ApplicationID uuid.UUID `json:"application_id"`
+	Permissions   uint64    `json:"permissions"` // granted through the bot's managed role
+}
+
+// Satisfies middleware.BotTokenValidator. Revoked tokens, and tokens of
+// deleted applications, fail the same way as made up ones.
+func (api *API) ValidateBotToken(ctx context.Context, token string) (uuid.UUID, error) {
+	botID, ok := models.ParseBotToken(token)
+	if !ok {
+		return uuid.Nil, errInvalidBotToken
+	}
+
+	application, err := api.Store.Applications.GetApplicationByBot(ctx, botID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	tokenHash := models.HashBotToken(token)
+	if application == nil || !application.HasToken || subtle.ConstantTimeCompare([]byte(tokenHash), []byte(application.TokenHash)) != 1 {
+		return uuid.Nil, errInvalidBotToken
+	}
+
+	return botID, nil
+}
+
+func (api *API) GetApplications(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+
+	applications, err := api.Store.Applications.GetApplicationsForOwner(ctx, userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch applications", http.StatusInternalServerError)
+		return
+	}
+	if applications == nil {
+		applications = []*models.Application{}
+	}
+
+	resp := map[string]interface{}{
+		"applications": applications,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (api *API) CreateApplication(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+
+	var req CreateApplicationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	req.Description = strings.TrimSpace(req.Description)
+	if !usernameRegex.MatchString(req.Name) {
+		http.Error(w, "Invalid name. Must be: 5-32 characters, alphanumeric or underscore only", http.StatusBadRequest)
+		return
+	}
+	if msg := validateApplicationDescription(req.Description); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	count, err := api.Store.Applications.CountApplicationsForOwner(ctx, userID)
+	if err != nil {
+		http.Error(w, "Failed to create application", http.StatusInternalServerError)
+		return
+	}
+	if count >= models.MaxApplicationsPerUser {
+		http.Error(w, fmt.Sprintf("You can have at most %d applications", models.MaxApplicationsPerUser), http.StatusBadRequest)
+		return
+	}
+
+	// bots share usernames with everyone else
+	if exists, _ := api.Store.Users.CheckUserExistsByUsername(ctx, req.Name); exists {
+		http.Error(w, "Username already taken", http.StatusConflict)
+		return
+	}
+
+	bot := models.NewBotUser(req.Name)
+	application, token := models.NewApplication(userID, bot, req.Description)
+	application.Public = req.Public
+
+	if err := api.Store.Applications.CreateApplication(ctx, application, bot); err != nil {
+		http.Error(w, "Failed to create application", http.StatusInternalServerError)
+		return
+	}
+
+	// the only time the token is shown
+	resp := map[string]interface{}{
+		"application": application,
+		"token":       token,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (api *API) GetApplication(w http.ResponseWriter, r *http.Request) {
+	application, ok := api.loadOwnedApplication(w, r)
+	if !ok {
+		return
+	}
+
+	resp := map[string]interface{}{
+		"application": application,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (api *API) UpdateApplication(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req UpdateApplicationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	application, ok := api.loadOwnedApplication(w, r)
+	if !ok {
+		return
+	}
+
+	renamed := false
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if !usernameRegex.MatchString(name) {
+			http.Error(w, "Invalid name. Must be: 5-32 characters, alphanumeric or underscore only", http.StatusBadRequest)
+			return
+		}
+
+		if name != application.Name {
+			if exists, _ := api.Store.Users.CheckUserExistsByUsername(ctx, name); exists {
+				http.Error(w, "Username already taken", http.StatusConflict)
+				return
+			}
+			application.Name = name
+			renamed = true
+		}
+	}
+
+	if req.Description != nil {
+		description := strings.TrimSpace(*req.Description)
+		if msg := validateApplicationDescription(description); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+		application.Description = description
+	}
+
+	if req.Public != nil {
+		application.Public = *req.Public
+	}
+
+	if err := api.Store.Applications.UpdateApplication(ctx, application); err != nil {
+		http.Error(w, "Failed to update application", http.StatusInternalServerError)
+		return
+	}
+
+	// the bot shows under its new name in every guild it's in
+	if renamed {
+		api.MemberLists.UserChanged(ctx, application.BotID)
+	}
+
+	resp := map[string]interface{}{
+		"application": application,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Deletes the bot along with it, which leaves every guild it was in
+func (api *API) DeleteApplication(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	application, ok := api.loadOwnedApplication(w, r)
+	if !ok {
+		return
+	}
+
+	// looked up while it's still a member, the lists are rebuilt without it
+	api.MemberLists.UserChanged(ctx, application.BotID)
+
+	if err := api.Store.Applications.DeleteApplication(ctx, application); err != nil {
+		http.Error(w, "Failed to delete application", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Issues a new token, the previous one stops working right away
+func (api *API) RegenerateApplicationToken(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	application, ok := api.loadOwnedApplication(w, r)
+	if !ok {
+		return
+	}
+
+	token := models.GenerateBotToken(application.BotID)
+	if err := api.Store.Applications.SetTokenHash(ctx, application.ID, models.HashBotToken(token)); err != nil {
+		http.Error(w, "Failed to regenerate token", http.StatusInternalServerError)
+		return
+	}
+	application.HasToken = true
+
+	resp := map[string]interface{}{
+		"application": application,
+		"token":       token,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Leaves the bot without a working token until a new one is generated
+func (api *API) RevokeApplicationToken(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	application, ok := api.loadOwnedApplication(w, r)
+	if !ok {
+		return
+	}
+
+	if err := api.Store.Applications.SetTokenHash(ctx, application.ID, ""); err != nil {
+		http.Error(w, "Failed to revoke token", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Adds an application's bot to the guild with a managed role holding the
+// chosen permissions. Private applications can only be added by their owner.
+func (api *API) AuthorizeBot(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	guild := accessFromContext(ctx).Guild
+
+	var req AuthorizeBotRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.ApplicationID == uuid.Nil {
+		http.Error(w, "application_id is required", http.StatusBadRequest)
+		return
+	}
+
+	if guild.DeletesAt != nil {
+		http.Error(w, "Restore the guild before adding bots", http.StatusConflict)
+		return
+	}
+
+	application, err := api.Store.Applications.GetApplication(ctx, req.ApplicationID)
+	if err != nil {
+		http.Error(w, "Failed to fetch application", http.StatusInternalServerError)
+		return
+	}
+	if application == nil || (!application.Public && application.OwnerID != userID) {
+		http.Error(w, "Application not found", http.StatusNotFound)
+		return
+	}
+
+	actor, err := api.getRoleActor(ctx, userID)
+	if err != nil {
+		http.Error(w, "Failed to resolve permissions", http.StatusInternalServerError)
+		return
+	}
+
+	position := models.MaxRoles - 1
+	if !actor.canMoveTo(position) {
+		http.Error(w, "You cannot create a role at or above your highest role", http.StatusForbidden)
+		return
+	}
+
+	if !actor.canChangePermissions(0, req.Permissions) {
+		http.Error(w, "You cannot grant permissions you do not have", http.StatusForbidden)
+		return
+	}
+
+	count, err := api.Store.GuildRoles.CountRolesForGuild(ctx, guild.ID)
+	if err != nil {
+		http.Error(w, "Failed to add bot", http.StatusInternalServerError)
+		return
+	}
+	if count >= int(models.MaxRoles) {
+		http.Error(w, "Guild has reached the maximum number of roles", http.StatusBadRequest)
+		return
+	}
+
+	exists, err := api.Store.GuildRoles.RoleExistsByName(ctx, guild.ID, application.Name)
+	if err != nil {
+		http.Error(w, "Failed to add bot", http.StatusInternalServerError)
+		return
+	}
+	if exists {
+		http.Error(w, fmt.Sprintf("A role named %s already exists", application.Name), http.StatusConflict)
+		return
+	}
+
+	member := models.NewGuildMember(guild.ID, application.BotID)
+	role := models.NewGuildRole(guild.ID, application.Name, position, req.Permissions, "#000000")
+	role.BotID = &application.BotID
+
+	err = api.Store.Guilds.AddBotToGuild(ctx, member, role)
+	if errors.Is(err, db.ErrBanned) {
+		http.Error(w, "The bot is banned from this guild", http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, db.ErrAlreadyMember) {
+		http.Error(w, "The bot is already a member of this guild", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to add bot", http.StatusInternalServerError)
+		return
+	}
+
+	entry := models.NewAuditLogEntry(guild.ID, userID, models.AuditBotAdd, models.AuditTargetMember, application.BotID.String())
+	entry.Changes = []models.AuditChange{{Key: "permissions", New: models.AuditValue(req.Permissions)}}
+	api.audit(r, entry)
+	api.MemberLists.Invalidate(guild.ID)
+	api.emit(r, guild.ID, models.SubscriptionEventMemberJoin, map[string]interface{}{
+		"user_id": application.BotID,
+		"bot":     true,
+	})
+	api.emit(r, guild.ID, models.SubscriptionEventRoleCreate, role)
+
+	if guild.SystemChannelID != nil {
+		api.postSystemMessage(r, guild, models.NewSystemMessage(*guild.SystemChannelID, application.BotID, models.MessageTypeMemberJoin))
+	}
+
+	resp := map[string]interface{}{
+		"application": application,
+		"member":      member,
+		"role":        role,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Loads the {application_id} application, writing the error response itself
+// when it doesn't exist. Other people's applications look like they don't.
+func (api *API) loadOwnedApplication(w http.ResponseWriter, r *http.Request) (*models.Application, bool) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+
+	applicationID, err := uuid.Parse(chi.URLParam(r, "application_id"))
+	if err != nil {
+		http.Error(w, "Invalid application ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	application, err := api.Store.Applications.GetApplication(ctx, applicationID)
+	if err != nil {
+		http.Error(w, "Failed to fetch application", http.StatusInternalServerError)
+		return nil, false
+	}
+	if application == nil || application.OwnerID != userID {
+		http.Error(w, "Application not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	return application, true
+}
+
+func validateApplicationDescription(description string) string {
+	if len(description) > models.MaxApplicationDescriptionLength {
+		return fmt.Sprintf("Description must be at most %d characters", models.MaxApplicationDescriptionLength)
+	}
+	return ""
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mana/internal/middleware"
+	"mana/internal/models"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+type UpdateAuditRetentionRequest struct {
+	Days int `json:"days"`
+}
+
+const auditReasonKey accessContextKey = "auditReason"
+
+// Reads the optional X-Audit-Log-Reason header, URL encoded so it can carry
+// any text, and keeps it for audit to pick up
+func readAuditReason(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get(models.AuditLogReasonHeader)
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		reason, err := url.PathUnescape(header)
+		if err != nil {
+			http.Error(w, "Invalid audit log reason", http.StatusBadRequest)
+			return
+		}
+
+		reason = strings.TrimSpace(reason)
+		if len(reason) > models.MaxAuditLogReasonLength {
+			http.Error(w, fmt.Sprintf("Audit log reason must be at most %d characters", models.MaxAuditLogReasonLength), http.StatusBadRequest)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), auditReasonKey, reason)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Records entry once the change it describes went through. A failure is
+// logged rather than failing a request whose change already happened.
+func (api *API) audit(r *http.Request, entry *models.AuditLogEntry) {
+	if entry.Reason == "" {
+		entry.Reason, _ = r.Context().Value(auditReasonKey).(string)
+	}
+
+	if err := api.Store.AuditLog.InsertEntry(r.Context(), entry); err != nil {
+		log.Printf("Failed to record %s audit log entry for guild %s: %v", entry.Action, entry.GuildID, err)
+	}
+}
+
+// Lists entries newest first, filtered by user_id, action and target_id and
+// paged with before (an entry id) and limit
+func (api *API) GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	guild := accessFromContext(ctx).Guild
+	query := r.URL.Query()
+
+	filter := &models.AuditLogFilter{
+		Action:   query.Get("action"),
+		TargetID: query.Get("target_id"),
+		Limit:    models.DefaultAuditLogLimit,
+	}
+
+	if userIDStr := query.Get("user_id"); userIDStr != "" {
+		actorID, err := uuid.Parse(userIDStr)
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+		filter.ActorID = &actorID
+	}
+
+	if beforeStr := query.Get("before"); beforeStr != "" {
+		before, err := uuid.Parse(beforeStr)
+		if err != nil {
+			http.Error(w, "Invalid before entry ID", http.StatusBadRequest)
+			return
+		}
+		filter.Before = &before
+	}
+
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > models.MaxAuditLogLimit {
+			http.Error(w, fmt.Sprintf("Limit must be between 1 and %d", models.MaxAuditLogLimit), http.StatusBadRequest)
+			return
+		}
+		filter.Limit = limit
+	}
+
+	entries, err := api.Store.AuditLog.GetEntries(ctx, guild.ID, filter)
+	if err != nil {
+		http.Error(w, "Failed to fetch audit log", http.StatusInternalServerError)
+		return
+	}
+	if entries == nil {
+		entries = []*models.AuditLogEntry{}
+	}
+
+	resp := map[string]interface{}{
+		"entries": entries,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (api *API) UpdateAuditRetention(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	guild := accessFromContext(ctx).Guild
+
+	var req UpdateAuditRetentionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.Days < 0 || req.Days > models.MaxAuditRetentionDays {
+		http.Error(w, fmt.Sprintf("Days must be between 0 and %d", models.MaxAuditRetentionDays), http.StatusBadRequest)
+		return
+	}
+
+	if err := api.Store.Guilds.UpdateAuditRetention(ctx, guild.ID, req.Days); err != nil {
+		http.Error(w, "Failed to update audit log retention", http.StatusInternalServerError)
+		return
+	}
+
+	before := *guild
+	guild.AuditRetentionDays = req.Days
+
+	entry := models.NewAuditLogEntry(guild.ID, userID, models.AuditGuildUpdate, models.AuditTargetGuild, guild.ID.String())
+	entry.Changes = models.DiffAuditChanges(before, guild)
+	api.audit(r, entry)
+
+	resp := map[string]interface{}{
+		"guild": guild,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
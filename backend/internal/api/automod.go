@@ -0,0 +1,680 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mana/internal/automod"
+	"mana/internal/markdown"
+	"mana/internal/middleware"
+	"mana/internal/models"
+	"mana/internal/permissions"
+	"mana/internal/types"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type CreateAutomodRuleRequest struct {
+	Name             string                 `json:"name"`
+	Trigger          string                 `json:"trigger"`
+	Enabled          *bool                  `json:"enabled"` // defaults to true
+	Config           models.AutomodConfig   `json:"config"`
+	Actions          []models.AutomodAction `json:"actions"`
+	ExemptRoleIDs    []uuid.UUID            `json:"exempt_role_ids"`
+	ExemptChannelIDs []uuid.UUID            `json:"exempt_channel_ids"`
+}
+
+// Unset fields are left alone, the trigger can't be changed
+type UpdateAutomodRuleRequest struct {
+	Name             *string                `json:"name"`
+	Enabled          *bool                  `json:"enabled"`
+	Config           *models.AutomodConfig  `json:"config"`
+	Actions          []models.AutomodAction `json:"actions"`
+	ExemptRoleIDs    []uuid.UUID            `json:"exempt_role_ids"`
+	ExemptChannelIDs []uuid.UUID            `json:"exempt_channel_ids"`
+}
+
+func (api *API) GetAutomodRules(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	guild := accessFromContext(ctx).Guild
+
+	rules, err := api.Store.Automod.GetRulesForGuild(ctx, guild.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch automod rules", http.StatusInternalServerError)
+		return
+	}
+	if rules == nil {
+		rules = []*models.AutomodRule{}
+	}
+
+	resp := map[string]interface{}{
+		"rules": rules,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (api *API) CreateAutomodRule(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	guild := accessFromContext(ctx).Guild
+
+	var req CreateAutomodRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if !models.AutomodTriggers[req.Trigger] {
+		http.Error(w, "Unknown trigger", http.StatusBadRequest)
+		return
+	}
+
+	rule := models.NewAutomodRule(guild.ID, userID, strings.TrimSpace(req.Name), req.Trigger)
+	rule.Config = req.Config
+	rule.Actions = req.Actions
+	if req.Enabled != nil {
+		rule.Enabled = *req.Enabled
+	}
+	if req.ExemptRoleIDs != nil {
+		rule.ExemptRoleIDs = req.ExemptRoleIDs
+	}
+	if req.ExemptChannelIDs != nil {
+		rule.ExemptChannelIDs = req.ExemptChannelIDs
+	}
+
+	if msg := validateAutomodRule(rule); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	if !api.checkAutomodRule(w, r, rule) {
+		return
+	}
+
+	count, err := api.Store.Automod.CountRulesForGuild(ctx, guild.ID)
+	if err != nil {
+		http.Error(w, "Failed to create automod rule", http.StatusInternalServerError)
+		return
+	}
+	if count >= models.MaxAutomodRulesPerGuild {
+		http.Error(w, fmt.Sprintf("A guild can have at most %d automod rules", models.MaxAutomodRulesPerGuild), http.StatusBadRequest)
+		return
+	}
+
+	if err := api.Store.Automod.CreateRule(ctx, rule); err != nil {
+		http.Error(w, "Failed to create automod rule", http.StatusInternalServerError)
+		return
+	}
+	api.Automod.Invalidate(guild.ID)
+
+	entry := models.NewAuditLogEntry(guild.ID, userID, models.AuditAutomodRuleCreate, models.AuditTargetAutomodRule, rule.ID.String())
+	entry.Changes = models.DiffAuditChanges(nil, rule)
+	api.audit(r, entry)
+
+	resp := map[string]interface{}{
+		"rule": rule,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (api *API) UpdateAutomodRule(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+
+	rule, ok := api.loadAutomodRule(w, r)
+	if !ok {
+		return
+	}
+
+	var req UpdateAutomodRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	before := *rule
+
+	if req.Name != nil {
+		rule.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Enabled != nil {
+		rule.Enabled = *req.Enabled
+	}
+	if req.Config != nil {
+		rule.Config = *req.Config
+	}
+	if req.Actions != nil {
+		rule.Actions = req.Actions
+	}
+	if req.ExemptRoleIDs != nil {
+		rule.ExemptRoleIDs = req.ExemptRoleIDs
+	}
+	if req.ExemptChannelIDs != nil {
+		rule.ExemptChannelIDs = req.ExemptChannelIDs
+	}
+
+	if msg := validateAutomodRule(rule); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	if !api.checkAutomodRule(w, r, rule) {
+		return
+	}
+
+	if err := api.Store.Automod.UpdateRule(ctx, rule); err != nil {
+		http.Error(w, "Failed to update automod rule", http.StatusInternalServerError)
+		return
+	}
+	api.Automod.Invalidate(rule.GuildID)
+
+	entry := models.NewAuditLogEntry(rule.GuildID, userID, models.AuditAutomodRuleUpdate, models.AuditTargetAutomodRule, rule.ID.String())
+	entry.Changes = models.DiffAuditChanges(before, rule)
+	api.audit(r, entry)
+
+	resp := map[string]interface{}{
+		"rule": rule,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (api *API) DeleteAutomodRule(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+
+	rule, ok := api.loadAutomodRule(w, r)
+	if !ok {
+		return
+	}
+
+	if err := api.Store.Automod.DeleteRule(ctx, rule.ID); err != nil {
+		http.Error(w, "Failed to delete automod rule", http.StatusInternalServerError)
+		return
+	}
+	api.Automod.Invalidate(rule.GuildID)
+
+	entry := models.NewAuditLogEntry(rule.GuildID, userID, models.AuditAutomodRuleDelete, models.AuditTargetAutomodRule, rule.ID.String())
+	entry.Changes = models.DiffAuditChanges(rule, nil)
+	api.audit(r, entry)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Parses {rule_id} and gets the rule if it belongs to the guild, writing the
+// error response itself when it doesn't
+func (api *API) loadAutomodRule(w http.ResponseWriter, r *http.Request) (*models.AutomodRule, bool) {
+	ctx := r.Context()
+	guild := accessFromContext(ctx).Guild
+
+	ruleID, err := uuid.Parse(chi.URLParam(r, "rule_id"))
+	if err != nil {
+		http.Error(w, "Invalid rule ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	rule, err := api.Store.Automod.GetRule(ctx, ruleID)
+	if err != nil {
+		http.Error(w, "Failed to fetch automod rule", http.StatusInternalServerError)
+		return nil, false
+	}
+	if rule == nil || rule.GuildID != guild.ID {
+		http.Error(w, "Automod rule not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	return rule, true
+}
+
+// Checks the roles and channels the rule points at belong to the guild, and
+// that the caller could time members out themselves before a rule does it
+// for them. Writes the error response itself.
+func (api *API) checkAutomodRule(w http.ResponseWriter, r *http.Request, rule *models.AutomodRule) bool {
+	ctx := r.Context()
+	guildAccess := accessFromContext(ctx)
+
+	if rule.Action(models.AutomodActionTimeout) != nil && !permissions.HasPermission(guildAccess.Permissions, permissions.PermissionTimeoutMembers) {
+		http.Error(w, "Missing permissions", http.StatusForbidden)
+		return false
+	}
+
+	for _, roleID := range rule.ExemptRoleIDs {
+		role, err := api.Store.GuildRoles.GetRoleByID(ctx, rule.GuildID, roleID)
+		if err != nil {
+			http.Error(w, "Failed to fetch role", http.StatusInternalServerError)
+			return false
+		}
+		if role == nil {
+			http.Error(w, fmt.Sprintf("Role %s not found", roleID), http.StatusNotFound)
+			return false
+		}
+	}
+
+	for _, channelID := range rule.ExemptChannelIDs {
+		channel, err := api.Store.GuildChannels.GetChannelByID(ctx, channelID)
+		if err != nil {
+			http.Error(w, "Failed to fetch channel", http.StatusInternalServerError)
+			return false
+		}
+		if channel == nil || channel.GuildID != rule.GuildID {
+			http.Error(w, fmt.Sprintf("Channel %s not found", channelID), http.StatusNotFound)
+			return false
+		}
+	}
+
+	if alert := rule.Action(models.AutomodActionAlert); alert != nil {
+		channel, err := api.Store.GuildChannels.GetChannelByID(ctx, *alert.ChannelID)
+		if err != nil {
+			http.Error(w, "Failed to fetch channel", http.StatusInternalServerError)
+			return false
+		}
+		if channel == nil || channel.GuildID != rule.GuildID {
+			http.Error(w, "Alert channel not found", http.StatusNotFound)
+			return false
+		}
+		if channel.Type != models.ChannelTypeText {
+			http.Error(w, "Alert channel must be a text channel", http.StatusBadRequest)
+			return false
+		}
+	}
+
+	return true
+}
+
+// Checks the rule's name, config and actions, dropping config fields its
+// trigger doesn't use and duplicate exemptions. Returns an error message,
+// empty if the rule is valid.
+func validateAutomodRule(rule *models.AutomodRule) string {
+	if rule.Name == "" || utf8.RuneCountInString(rule.Name) > models.MaxAutomodRuleNameLength {
+		return fmt.Sprintf("Name must be between 1 and %d characters", models.MaxAutomodRuleNameLength)
+	}
+
+	config, msg := normalizeAutomodConfig(rule.Trigger, rule.Config)
+	if msg != "" {
+		return msg
+	}
+	rule.Config = config
+
+	if msg := validateAutomodActions(rule.Actions); msg != "" {
+		return msg
+	}
+
+	rule.ExemptRoleIDs = uniqueIDs(rule.ExemptRoleIDs)
+	if len(rule.ExemptRoleIDs) > models.MaxAutomodExemptRoles {
+		return fmt.Sprintf("A rule can exempt at most %d roles", models.MaxAutomodExemptRoles)
+	}
+
+	rule.ExemptChannelIDs = uniqueIDs(rule.ExemptChannelIDs)
+	if len(rule.ExemptChannelIDs) > models.MaxAutomodExemptChannels {
+		return fmt.Sprintf("A rule can exempt at most %d channels", models.MaxAutomodExemptChannels)
+	}
+
+	return ""
+}
+
+func normalizeAutomodConfig(trigger string, config models.AutomodConfig) (models.AutomodConfig, string) {
+	switch trigger {
+	case models.AutomodTriggerKeyword:
+		if len(config.Keywords) > models.MaxAutomodKeywords {
+			return config, fmt.Sprintf("A rule can have at most %d keywords", models.MaxAutomodKeywords)
+		}
+		if len(config.RegexPatterns) > models.MaxAutomodRegexPatterns {
+			return config, fmt.Sprintf("A rule can have at most %d regex patterns", models.MaxAutomodRegexPatterns)
+		}
+
+		keywords := make([]string, 0, len(config.Keywords))
+		seen := make(map[string]bool)
+		for _, keyword := range config.Keywords {
+			keyword = strings.ToLower(strings.TrimSpace(keyword))
+			if strings.Trim(keyword, "*") == "" {
+				return config, "Keywords cannot be empty"
+			}
+			if utf8.RuneCountInString(keyword) > models.MaxAutomodKeywordLength {
+				return config, fmt.Sprintf("Keywords must be at most %d characters", models.MaxAutomodKeywordLength)
+			}
+			if !seen[keyword] {
+				seen[keyword] = true
+				keywords = append(keywords, keyword)
+			}
+		}
+
+		for _, pattern := range config.RegexPatterns {
+			if pattern == "" || len(pattern) > models.MaxAutomodRegexLength {
+				return config, fmt.Sprintf("Regex patterns must be between 1 and %d characters", models.MaxAutomodRegexLength)
+			}
+			if _, err := regexp.Compile(pattern); err != nil {
+				return config, fmt.Sprintf("Invalid regex pattern %q", pattern)
+			}
+		}
+
+		if len(keywords) == 0 && len(config.RegexPatterns) == 0 {
+			return config, "A keyword rule needs at least one keyword or regex pattern"
+		}
+		return models.AutomodConfig{Keywords: keywords, RegexPatterns: config.RegexPatterns}, ""
+
+	case models.AutomodTriggerMentionSpam:
+		if config.MentionLimit < 1 || config.MentionLimit > models.MaxAutomodMentionLimit {
+			return config, fmt.Sprintf("mention_limit must be between 1 and %d", models.MaxAutomodMentionLimit)
+		}
+		return models.AutomodConfig{MentionLimit: config.MentionLimit}, ""
+
+	case models.AutomodTriggerLinks:
+		if config.LinkMode != models.AutomodLinksAllow && config.LinkMode != models.AutomodLinksDeny {
+			return config, "link_mode must be allow or deny"
+		}
+		if len(config.Domains) > models.MaxAutomodDomains {
+			return config, fmt.Sprintf("A rule can have at most %d domains", models.MaxAutomodDomains)
+		}
+
+		domains := make([]string, 0, len(config.Domains))
+		seen := make(map[string]bool)
+		for _, domain := range config.Domains {
+			domain = strings.ToLower(strings.TrimSpace(domain))
+			domain = strings.TrimSuffix(strings.TrimPrefix(domain, "*."), ".")
+			if domain == "" || len(domain) > models.MaxAutomodDomainLength || strings.ContainsAny(domain, "/:@ \t") {
+				return config, fmt.Sprintf("Invalid domain %q", domain)
+			}
+			if !seen[domain] {
+				seen[domain] = true
+				domains = append(domains, domain)
+			}
+		}
+
+		// an empty allow list lets no links through at all
+		if config.LinkMode == models.AutomodLinksDeny && len(domains) == 0 {
+			return config, "A deny list needs at least one domain"
+		}
+		return models.AutomodConfig{LinkMode: config.LinkMode, Domains: domains}, ""
+
+	case models.AutomodTriggerRepeated, models.AutomodTriggerFlood:
+		if config.MaxMessages < 1 || config.MaxMessages > models.MaxAutomodMessages {
+			return config, fmt.Sprintf("max_messages must be between 1 and %d", models.MaxAutomodMessages)
+		}
+		maxWindow := int(models.MaxAutomodWindow / time.Second)
+		if config.WindowSeconds < 1 || config.WindowSeconds > maxWindow {
+			return config, fmt.Sprintf("window_seconds must be between 1 and %d", maxWindow)
+		}
+		return models.AutomodConfig{MaxMessages: config.MaxMessages, WindowSeconds: config.WindowSeconds}, ""
+	}
+
+	return config, "Unknown trigger"
+}
+
+// Each action type at most once, alerts need a channel and timeouts a duration
+func validateAutomodActions(actions []models.AutomodAction) string {
+	if len(actions) == 0 {
+		return "A rule needs at least one action"
+	}
+
+	seen := make(map[string]bool)
+	for i := range actions {
+		action := &actions[i]
+		if !models.AutomodActions[action.Type] {
+			return fmt.Sprintf("Unknown action %q", action.Type)
+		}
+		if seen[action.Type] {
+			return fmt.Sprintf("Action %s is listed more than once", action.Type)
+		}
+		seen[action.Type] = true
+
+		switch action.Type {
+		case models.AutomodActionAlert:
+			if action.ChannelID == nil {
+				return "An alert needs a channel_id"
+			}
+			action.DurationSeconds = 0
+		case models.AutomodActionTimeout:
+			duration := time.Duration(action.DurationSeconds) * time.Second
+			if duration <= 0 || duration > models.MaxTimeoutDuration {
+				return "Timeout duration_seconds must be between 1 and 2419200"
+			}
+			action.ChannelID = nil
+		default:
+			action.ChannelID = nil
+			action.DurationSeconds = 0
+		}
+	}
+
+	return ""
+}
+
+func uniqueIDs(ids []uuid.UUID) []uuid.UUID {
+	seen := make(map[uuid.UUID]bool, len(ids))
+	unique := make([]uuid.UUID, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// Runs a message about to be sent or edited in a guild channel past the
+// guild's automod rules. Members who can manage the guild are never checked.
+// Failing to check lets the message through, automod being down shouldn't
+// stop everyone from talking.
+func (api *API) checkAutomod(r *http.Request, msg *models.Message, edit bool) []*automod.Violation {
+	ctx := r.Context()
+	channelAccess := accessFromContext(ctx)
+	if channelAccess.Channel == nil || msg.AuthorID == nil {
+		return nil
+	}
+	if permissions.HasPermission(channelAccess.Permissions, permissions.PermissionManageGuild) {
+		return nil
+	}
+
+	roles, err := api.Store.GuildRoles.GetRolesForMember(ctx, channelAccess.Guild.ID, *msg.AuthorID)
+	if err != nil {
+		log.Printf("Failed to fetch roles for automod in guild %s: %v", channelAccess.Guild.ID, err)
+		return nil
+	}
+	roleIDs := make([]uuid.UUID, len(roles))
+	for i, role := range roles {
+		roleIDs[i] = role.ID
+	}
+
+	violations, err := api.Automod.Check(ctx, &automod.Subject{
+		Channel: channelAccess.Channel,
+		RoleIDs: roleIDs,
+		Message: msg,
+		Edit:    edit,
+	})
+	if err != nil {
+		log.Printf("Failed to run automod in guild %s: %v", channelAccess.Guild.ID, err)
+		return nil
+	}
+
+	return violations
+}
+
+// Runs a webhook message past the guild's automod rules that look at the
+// message alone. Nobody sent it as a member, so there's no history for
+// repeated and flood, nobody to time out and no author to alert about. A
+// rule that would block or delete it turns it away instead. Failing to
+// check lets it through, like for members.
+func (api *API) checkWebhookAutomod(ctx context.Context, webhook *models.Webhook, msg *models.Message) *automod.Violation {
+	channel, err := api.Store.GuildChannels.GetChannelByID(ctx, webhook.ChannelID)
+	if err != nil || channel == nil {
+		log.Printf("Failed to fetch channel %s for automod on webhook %s: %v", webhook.ChannelID, webhook.ID, err)
+		return nil
+	}
+
+	violations, err := api.Automod.Check(ctx, &automod.Subject{
+		Channel: channel,
+		Message: msg,
+	})
+	if err != nil {
+		log.Printf("Failed to run automod in guild %s: %v", webhook.GuildID, err)
+		return nil
+	}
+
+	for _, violation := range violations {
+		if violation.Rule.Action(models.AutomodActionBlock) != nil || violation.Rule.Action(models.AutomodActionDelete) != nil {
+			return violation
+		}
+	}
+
+	return nil
+}
+
+// Carries out what the violated rules call for besides blocking. sent is
+// whether msg was saved, it's only deleted then. Reports whether msg was
+// deleted.
+func (api *API) enforceAutomod(r *http.Request, msg *models.Message, violations []*automod.Violation, sent bool) bool {
+	if len(violations) == 0 {
+		return false
+	}
+
+	var deleteIDs []uuid.UUID
+	var timeout *models.AutomodAction
+	var timeoutRule *models.AutomodRule
+	removed := false
+
+	for _, violation := range violations {
+		if violation.Rule.Action(models.AutomodActionDelete) != nil {
+			deleteIDs = append(deleteIDs, violation.BurstIDs...)
+			if sent && !removed {
+				deleteIDs = append(deleteIDs, msg.ID)
+				removed = true
+			}
+		}
+		if action := violation.Rule.Action(models.AutomodActionTimeout); action != nil {
+			if timeout == nil || action.DurationSeconds > timeout.DurationSeconds {
+				timeout, timeoutRule = action, violation.Rule
+			}
+		}
+	}
+
+	if len(deleteIDs) > 0 {
+		api.deleteAutomodMessages(r, uniqueIDs(deleteIDs))
+	}
+	if timeout != nil {
+		api.automodTimeout(r, msg, timeoutRule, timeout)
+	}
+
+	verb := "flagged"
+	if !sent {
+		verb = "blocked"
+	} else if removed {
+		verb = "deleted"
+	}
+	for _, violation := range violations {
+		if violation.Rule.Action(models.AutomodActionAlert) != nil {
+			api.postAutomodAlert(r, msg, violation, verb)
+		}
+	}
+
+	return removed
+}
+
+// Names the first rule that blocked the message
+func automodBlockedMessage(violations []*automod.Violation) string {
+	for _, violation := range violations {
+		if violation.Rule.Action(models.AutomodActionBlock) != nil {
+			return fmt.Sprintf("Message blocked by automod rule %q", violation.Rule.Name)
+		}
+	}
+	return "Message blocked by automod"
+}
+
+func (api *API) deleteAutomodMessages(r *http.Request, messageIDs []uuid.UUID) {
+	guild := accessFromContext(r.Context()).Guild
+
+	deleted, err := api.Store.Messages.DeleteMessages(r.Context(), messageIDs)
+	if err != nil {
+		log.Printf("Failed to delete messages caught by automod in guild %s: %v", guild.ID, err)
+		return
+	}
+
+	for channelID, ids := range deleted {
+		if len(ids) == 1 {
+			payload := types.MessageDeletePayload{ID: ids[0], ChannelID: channelID}
+			api.dispatch(types.EventMessageDelete, channelID, payload)
+			api.emitInChannel(r, guild.ID, channelID, models.SubscriptionEventMessageDelete, payload)
+			continue
+		}
+
+		payload := types.MessageDeleteBulkPayload{IDs: ids, ChannelID: channelID}
+		api.dispatch(types.EventMessageDeleteBulk, channelID, payload)
+		api.emitInChannel(r, guild.ID, channelID, models.SubscriptionEventMessageDeleteBulk, payload)
+	}
+}
+
+// Times the author out on the rule's behalf, there's no moderator to credit
+func (api *API) automodTimeout(r *http.Request, msg *models.Message, rule *models.AutomodRule, timeout *models.AutomodAction) {
+	ctx := r.Context()
+	channelAccess := accessFromContext(ctx)
+	guild := channelAccess.Guild
+	userID := *msg.AuthorID
+
+	reason := fmt.Sprintf("Automod rule %q", rule.Name)
+	until := time.Now().UTC().Add(time.Duration(timeout.DurationSeconds) * time.Second)
+
+	action := models.NewAutomodAction(guild.ID, models.ModerationActionTimeout, reason)
+	action.TargetUserID = &userID
+	action.ChannelID = &channelAccess.Channel.ID
+	action.Metadata, _ = json.Marshal(map[string]interface{}{"until": until, "rule_id": rule.ID})
+
+	if err := api.Store.Moderation.SetMemberTimeout(ctx, guild.ID, userID, &until, action); err != nil {
+		log.Printf("Failed to time out %s for automod in guild %s: %v", userID, guild.ID, err)
+		return
+	}
+
+	entry := models.NewAuditLogEntry(guild.ID, uuid.Nil, models.AuditMemberTimeout, models.AuditTargetMember, userID.String())
+	entry.ActorID = nil
+	entry.ChannelID = &channelAccess.Channel.ID
+	entry.Reason = reason
+	entry.Changes = []models.AuditChange{{Key: "timeout_until", New: models.AuditValue(until)}}
+	api.audit(r, entry)
+}
+
+// Posts a notice of what was caught in the rule's alert channel, quoting
+// the message when it fits
+func (api *API) postAutomodAlert(r *http.Request, msg *models.Message, violation *automod.Violation, verb string) {
+	ctx := r.Context()
+	channelAccess := accessFromContext(ctx)
+	guild := channelAccess.Guild
+	alert := violation.Rule.Action(models.AutomodActionAlert)
+
+	// the channel may have been deleted since the rule was saved
+	channel, err := api.Store.GuildChannels.GetChannelByID(ctx, *alert.ChannelID)
+	if err != nil || channel == nil || channel.GuildID != guild.ID {
+		log.Printf("Skipping automod alert in guild %s: alert channel %s unavailable", guild.ID, *alert.ChannelID)
+		return
+	}
+
+	content := fmt.Sprintf("Automod %s a message from <@%s> in <#%s>. Rule %q caught %s.",
+		verb, *msg.AuthorID, channelAccess.Channel.ID, violation.Rule.Name, violation.Reason)
+
+	excerpt := strings.Join(strings.Fields(msg.PlainText), " ")
+	if utf8.RuneCountInString(excerpt) > models.MaxAutomodAlertExcerptLength {
+		excerpt = string([]rune(excerpt)[:models.MaxAutomodAlertExcerptLength]) + "…"
+	}
+
+	ast, err := markdown.Parse(content + "\n> " + excerpt)
+	if err == nil {
+		content += "\n> " + excerpt
+	} else if ast, err = markdown.Parse(content); err != nil {
+		log.Printf("Skipping automod alert in guild %s: %v", guild.ID, err)
+		return
+	}
+
+	notice := models.NewSystemMessage(channel.ID, *msg.AuthorID, models.MessageTypeAutomodAlert)
+	notice.Content = content
+	notice.AST = ast
+	notice.PlainText = markdown.PlainText(ast, api.mentionNameResolver(ctx, guild.ID, ast))
+
+	api.postSystemMessage(r, guild, notice)
+}
@@ -2,32 +2,585 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
+	"mana/internal/middleware"
+	"mana/internal/models"
+	"mana/internal/permissions"
 	"net/http"
+	"regexp"
+	"strings"
 
-	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 )
 
+// text channel names are lowercase-with-dashes, voice names are free form
+var textChannelNameRegex = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+type CreateChannelRequest struct {
+	Name      string             `json:"name"`
+	Type      models.ChannelType `json:"type"`
+	Topic     string             `json:"topic"`
+	Bitrate   *int               `json:"bitrate"`
+	UserLimit *int               `json:"user_limit"`
+	ParentID  *uuid.UUID         `json:"parent_id"` // starts synced to the category
+}
+
+type UpdateChannelRequest struct {
+	Name      *string `json:"name"`
+	Topic     *string `json:"topic"`
+	Bitrate   *int    `json:"bitrate"`
+	UserLimit *int    `json:"user_limit"`
+}
+
+type MoveChannelRequest struct {
+	ParentID        *uuid.UUID `json:"parent_id"` // null moves the channel out of its category
+	SyncPermissions bool       `json:"sync_permissions"`
+}
+
+type ReorderChannelsRequest struct {
+	ChannelIDs []uuid.UUID `json:"channel_ids"`
+}
+
 func (api *API) GetGuildChannels(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	guild := accessFromContext(ctx).Guild
 
-	guildIDParam := chi.URLParam(r, "id")
-	guildID, err := uuid.Parse(guildIDParam)
+	channels, err := api.Store.GuildChannels.GetChannelsForGuild(ctx, guild.ID)
 	if err != nil {
-		http.Error(w, "Invalid guild ID", http.StatusBadRequest)
+		http.Error(w, "Failed to fetch channels", http.StatusInternalServerError)
 		return
 	}
 
-	channels, err := api.Store.GuildChannels.GetChannelsForGuild(ctx, guildID)
+	overrides, err := api.Store.GuildChannelOverrides.GetOverridesForGuild(ctx, guild.ID)
 	if err != nil {
-		http.Error(w, "Failed to fetch channels", http.StatusInternalServerError)
+		http.Error(w, "Failed to fetch overrides", http.StatusInternalServerError)
+		return
+	}
+
+	// leave out channels the user can't see
+	visible := make([]*models.GuildChannel, 0, len(channels))
+	for _, channel := range channels {
+		if channel.ParentID != nil {
+			synced := models.OverridesMatch(overrides[channel.ID], overrides[*channel.ParentID])
+			channel.PermissionsSynced = &synced
+		}
+
+		perms, err := api.resolveChannelPermissions(ctx, guild, channel, userID)
+		if err != nil {
+			http.Error(w, "Failed to resolve permissions", http.StatusInternalServerError)
+			return
+		}
+		if permissions.HasPermission(perms, permissions.PermissionViewChannels) {
+			visible = append(visible, channel)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(visible)
+}
+
+func (api *API) CreateChannel(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	guild := accessFromContext(ctx).Guild
+
+	var req CreateChannelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.Type == "" {
+		req.Type = models.ChannelTypeText
+	}
+	if req.Type != models.ChannelTypeText && req.Type != models.ChannelTypeVoice && req.Type != models.ChannelTypeCategory {
+		http.Error(w, "Invalid type. Must be: text, voice or category", http.StatusBadRequest)
+		return
+	}
+
+	name, msg := normalizeChannelName(req.Name, req.Type)
+	if msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	if msg := validateChannelFields(req.Type, &req.Topic, req.Bitrate, req.UserLimit); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	if req.Type == models.ChannelTypeVoice && req.Bitrate == nil {
+		bitrate := models.DefaultVoiceBitrate
+		req.Bitrate = &bitrate
+	}
+
+	count, err := api.Store.GuildChannels.CountChannelsForGuild(ctx, guild.ID)
+	if err != nil {
+		http.Error(w, "Failed to create channel", http.StatusInternalServerError)
+		return
+	}
+	if count >= int(models.MaxChannels) {
+		http.Error(w, "Guild has reached the maximum number of channels", http.StatusBadRequest)
+		return
+	}
+
+	exists, err := api.Store.GuildChannels.ChannelExistsByName(ctx, guild.ID, name)
+	if err != nil {
+		http.Error(w, "Failed to create channel", http.StatusInternalServerError)
+		return
+	}
+	if exists {
+		http.Error(w, "A channel with that name already exists", http.StatusConflict)
+		return
+	}
+
+	if req.ParentID != nil {
+		if req.Type == models.ChannelTypeCategory {
+			http.Error(w, "Categories cannot be nested", http.StatusBadRequest)
+			return
+		}
+
+		if _, ok := api.loadCategory(w, r, *req.ParentID); !ok {
+			return
+		}
+	}
+
+	// new channels go to the bottom
+	position, err := api.Store.GuildChannels.NextChannelPosition(ctx, guild.ID)
+	if err != nil {
+		http.Error(w, "Failed to create channel", http.StatusInternalServerError)
+		return
+	}
+	if position >= int(models.MaxChannels) {
+		http.Error(w, "No position left for another channel, reorder the guild's channels first", http.StatusBadRequest)
+		return
+	}
+
+	channel := models.NewGuildChannel(guild.ID, name, req.Type, uint8(position), req.Topic, req.Bitrate, req.UserLimit)
+	channel.ParentID = req.ParentID
+
+	// the category's overrides are copied over, which is only allowed
+	// for someone who could've set them by hand
+	if channel.ParentID != nil && !api.checkCanSyncOverrides(w, r, userID, channel.ID, *channel.ParentID) {
+		return
+	}
+
+	if err := api.Store.GuildChannels.CreateChannel(ctx, channel); err != nil {
+		http.Error(w, "Failed to create channel", http.StatusInternalServerError)
+		return
+	}
+
+	if channel.ParentID != nil {
+		if err := api.Store.GuildChannelOverrides.SyncOverrides(ctx, channel.ID, *channel.ParentID); err != nil {
+			http.Error(w, "Failed to sync permissions", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	entry := models.NewAuditLogEntry(guild.ID, userID, models.AuditChannelCreate, models.AuditTargetChannel, channel.ID.String())
+	entry.Changes = models.DiffAuditChanges(nil, channel)
+	api.audit(r, entry)
+	api.emit(r, guild.ID, models.SubscriptionEventChannelCreate, channel)
+
+	resp := map[string]interface{}{
+		"channel": channel,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (api *API) UpdateChannel(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+
+	// manage channels is checked by the route, dms never have it
+	channel := accessFromContext(ctx).Channel
+	before := *channel
+
+	var req UpdateChannelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if msg := validateChannelFields(channel.Type, req.Topic, req.Bitrate, req.UserLimit); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	if req.Name != nil {
+		name, msg := normalizeChannelName(*req.Name, channel.Type)
+		if msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		if !strings.EqualFold(name, channel.Name) {
+			exists, err := api.Store.GuildChannels.ChannelExistsByName(ctx, channel.GuildID, name)
+			if err != nil {
+				http.Error(w, "Failed to update channel", http.StatusInternalServerError)
+				return
+			}
+			if exists {
+				http.Error(w, "A channel with that name already exists", http.StatusConflict)
+				return
+			}
+		}
+		channel.Name = name
+	}
+
+	if req.Topic != nil {
+		channel.Topic = *req.Topic
+	}
+	if req.Bitrate != nil {
+		channel.Bitrate = req.Bitrate
+	}
+	if req.UserLimit != nil {
+		channel.UserLimit = req.UserLimit
+	}
+
+	if err := api.Store.GuildChannels.UpdateChannel(ctx, channel); err != nil {
+		http.Error(w, "Failed to update channel", http.StatusInternalServerError)
+		return
+	}
+
+	entry := models.NewAuditLogEntry(channel.GuildID, userID, models.AuditChannelUpdate, models.AuditTargetChannel, channel.ID.String())
+	entry.Changes = models.DiffAuditChanges(before, channel)
+	api.audit(r, entry)
+	api.emit(r, channel.GuildID, models.SubscriptionEventChannelUpdate, channel)
+
+	resp := map[string]interface{}{
+		"channel": channel,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (api *API) DeleteChannel(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	channel := accessFromContext(ctx).Channel
+
+	if err := api.Store.GuildChannels.DeleteChannel(ctx, channel.ID); err != nil {
+		http.Error(w, "Failed to delete channel", http.StatusInternalServerError)
+		return
+	}
+
+	entry := models.NewAuditLogEntry(channel.GuildID, userID, models.AuditChannelDelete, models.AuditTargetChannel, channel.ID.String())
+	entry.Changes = models.DiffAuditChanges(channel, nil)
+	api.audit(r, entry)
+	api.emit(r, channel.GuildID, models.SubscriptionEventChannelDelete, channel)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Moves a channel into or out of a category, optionally syncing its
+// overrides to the new category's
+func (api *API) MoveChannel(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+
+	// manage channels is checked by the route, dms never have it
+	channel := accessFromContext(ctx).Channel
+
+	var req MoveChannelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if channel.Type == models.ChannelTypeCategory {
+		http.Error(w, "Categories cannot be nested", http.StatusBadRequest)
+		return
+	}
+
+	if req.ParentID == nil {
+		if req.SyncPermissions {
+			http.Error(w, "Only channels in a category can sync permissions", http.StatusBadRequest)
+			return
+		}
+	} else {
+		if _, ok := api.loadCategory(w, r, *req.ParentID); !ok {
+			return
+		}
+
+		if req.SyncPermissions && !api.checkCanSyncOverrides(w, r, userID, channel.ID, *req.ParentID) {
+			return
+		}
+	}
+
+	if err := api.Store.GuildChannels.SetParent(ctx, channel.ID, req.ParentID); err != nil {
+		http.Error(w, "Failed to move channel", http.StatusInternalServerError)
 		return
 	}
+	before := *channel
+	channel.ParentID = req.ParentID
+
+	if req.SyncPermissions {
+		if err := api.Store.GuildChannelOverrides.SyncOverrides(ctx, channel.ID, *req.ParentID); err != nil {
+			http.Error(w, "Failed to sync permissions", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	entry := models.NewAuditLogEntry(channel.GuildID, userID, models.AuditChannelUpdate, models.AuditTargetChannel, channel.ID.String())
+	entry.Changes = models.DiffAuditChanges(before, channel)
+	api.audit(r, entry)
+
+	if req.SyncPermissions {
+		api.audit(r, models.NewAuditLogEntry(channel.GuildID, userID, models.AuditChannelSync, models.AuditTargetChannel, channel.ID.String()))
+		api.recheckChannelAccess(ctx, accessFromContext(ctx).Guild, []*models.GuildChannel{channel}, uuid.Nil)
+	}
+	api.emit(r, channel.GuildID, models.SubscriptionEventChannelUpdate, channel)
+
+	resp := map[string]interface{}{
+		"channel": channel,
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(channels)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Overwrites a channel's overrides with its category's
+func (api *API) SyncChannelPermissions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+
+	// manage roles is checked by the route, dms never have it
+	channel := accessFromContext(ctx).Channel
+
+	if channel.ParentID == nil {
+		http.Error(w, "Only channels in a category can sync permissions", http.StatusBadRequest)
+		return
+	}
+
+	if !api.checkCanSyncOverrides(w, r, userID, channel.ID, *channel.ParentID) {
+		return
+	}
+
+	if err := api.Store.GuildChannelOverrides.SyncOverrides(ctx, channel.ID, *channel.ParentID); err != nil {
+		http.Error(w, "Failed to sync permissions", http.StatusInternalServerError)
+		return
+	}
+
+	api.audit(r, models.NewAuditLogEntry(channel.GuildID, userID, models.AuditChannelSync, models.AuditTargetChannel, channel.ID.String()))
+	api.recheckChannelAccess(ctx, accessFromContext(ctx).Guild, []*models.GuildChannel{channel}, uuid.Nil)
+
+	w.WriteHeader(http.StatusNoContent)
 }
 
-//func (api *API) EditChannelName(w http.ResponseWriter, r *http.Request) {}
+// Gets a category in the current guild the caller can manage, writing the
+// error response itself when there isn't one
+func (api *API) loadCategory(w http.ResponseWriter, r *http.Request, categoryID uuid.UUID) (*models.GuildChannel, bool) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	guild := accessFromContext(ctx).Guild
+
+	category, err := api.Store.GuildChannels.GetChannelByID(ctx, categoryID)
+	if err != nil {
+		http.Error(w, "Failed to fetch category", http.StatusInternalServerError)
+		return nil, false
+	}
+	if category == nil || category.GuildID != guild.ID || category.Type != models.ChannelTypeCategory {
+		http.Error(w, "parent_id must be a category in this guild", http.StatusBadRequest)
+		return nil, false
+	}
 
-//func (api *API) EditChannelTopic(w http.ResponseWriter, r *http.Request) {}
+	perms, err := api.resolveChannelPermissions(ctx, guild, category, userID)
+	if err != nil {
+		http.Error(w, "Failed to resolve permissions", http.StatusInternalServerError)
+		return nil, false
+	}
+	if !permissions.HasPermission(perms, permissions.PermissionManageChannels) {
+		http.Error(w, "You do not have permission to manage that category", http.StatusForbidden)
+		return nil, false
+	}
+
+	return category, true
+}
+
+// Syncing rewrites overrides wholesale, so it's held to the same rules as
+// editing each of them by hand
+func (api *API) checkCanSyncOverrides(w http.ResponseWriter, r *http.Request, userID uuid.UUID, channelID uuid.UUID, parentID uuid.UUID) bool {
+	ctx := r.Context()
+	guild := accessFromContext(ctx).Guild
+
+	actor, err := api.getRoleActor(ctx, userID)
+	if err != nil {
+		http.Error(w, "Failed to resolve permissions", http.StatusInternalServerError)
+		return false
+	}
+	if actor.owner {
+		return true
+	}
+
+	overrides, err := api.Store.GuildChannelOverrides.GetOverridesForGuild(ctx, guild.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch overrides", http.StatusInternalServerError)
+		return false
+	}
+
+	roles, err := api.Store.GuildRoles.GetRolesForGuild(ctx, guild.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch roles", http.StatusInternalServerError)
+		return false
+	}
+	rolesByID := make(map[uuid.UUID]*models.GuildRole, len(roles))
+	for _, role := range roles {
+		rolesByID[role.ID] = role
+	}
+
+	none := &models.GuildChannelPermissionOverride{}
+	checkChange := func(target *models.GuildChannelPermissionOverride, current *models.GuildChannelPermissionOverride, updated *models.GuildChannelPermissionOverride) bool {
+		if current.Allow == updated.Allow && current.Deny == updated.Deny {
+			return true
+		}
+
+		if target.RoleID != nil {
+			if role := rolesByID[*target.RoleID]; role != nil && !actor.canManage(role) {
+				http.Error(w, "You cannot manage a role at or above your highest role", http.StatusForbidden)
+				return false
+			}
+		}
+
+		if !actor.canChangePermissions(current.Allow, updated.Allow) || !actor.canChangePermissions(current.Deny, updated.Deny) {
+			http.Error(w, "You cannot allow or deny permissions you do not have", http.StatusForbidden)
+			return false
+		}
+		return true
+	}
+
+	// overrides the channel loses or has changed
+	for _, override := range overrides[channelID] {
+		updated := matchingOverride(overrides[parentID], override)
+		if updated == nil {
+			updated = none
+		}
+		if !checkChange(override, override, updated) {
+			return false
+		}
+	}
+
+	// overrides the channel gains
+	for _, override := range overrides[parentID] {
+		if matchingOverride(overrides[channelID], override) == nil && !checkChange(override, none, override) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Takes every channel in the guild in its new order
+func (api *API) ReorderChannels(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	guild := accessFromContext(ctx).Guild
+
+	var req ReorderChannelsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	channels, err := api.Store.GuildChannels.GetChannelsForGuild(ctx, guild.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch channels", http.StatusInternalServerError)
+		return
+	}
+
+	// a partial list would leave two channels on the same position
+	if len(req.ChannelIDs) != len(channels) {
+		http.Error(w, "channel_ids must list every channel in the guild exactly once", http.StatusBadRequest)
+		return
+	}
+
+	known := make(map[uuid.UUID]bool, len(channels))
+	for _, channel := range channels {
+		known[channel.ID] = true
+	}
+	for _, id := range req.ChannelIDs {
+		if !known[id] {
+			http.Error(w, "channel_ids must list every channel in the guild exactly once", http.StatusBadRequest)
+			return
+		}
+		delete(known, id)
+	}
+
+	if err := api.Store.GuildChannels.ReorderChannels(ctx, guild.ID, req.ChannelIDs); err != nil {
+		http.Error(w, "Failed to reorder channels", http.StatusInternalServerError)
+		return
+	}
+
+	// one entry per channel that actually moved
+	channelsByID := make(map[uuid.UUID]*models.GuildChannel, len(channels))
+	for _, channel := range channels {
+		channelsByID[channel.ID] = channel
+	}
+	for i, id := range req.ChannelIDs {
+		channel := channelsByID[id]
+		if int(channel.Position) == i {
+			continue
+		}
+
+		before := *channel
+		channel.Position = uint8(i)
+
+		entry := models.NewAuditLogEntry(guild.ID, userID, models.AuditChannelUpdate, models.AuditTargetChannel, channel.ID.String())
+		entry.Changes = models.DiffAuditChanges(before, channel)
+		api.audit(r, entry)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Trims the name and, for text channels, lowercases it and swaps spaces for dashes
+func normalizeChannelName(name string, channelType models.ChannelType) (string, string) {
+	name = strings.TrimSpace(name)
+	if channelType == models.ChannelTypeText {
+		name = strings.ToLower(strings.Join(strings.Fields(name), "-"))
+	}
+
+	if len(name) < 1 || len(name) > models.MaxChannelNameLength {
+		return "", fmt.Sprintf("Channel name must be between 1 and %d characters", models.MaxChannelNameLength)
+	}
+	if channelType == models.ChannelTypeText && !textChannelNameRegex.MatchString(name) {
+		return "", "Text channel names may only contain letters, numbers, dashes and underscores"
+	}
+
+	return name, ""
+}
+
+// topics are for text channels, bitrate and user limit for voice channels, categories take neither
+func validateChannelFields(channelType models.ChannelType, topic *string, bitrate *int, userLimit *int) string {
+	if channelType == models.ChannelTypeText {
+		if bitrate != nil || userLimit != nil {
+			return "bitrate and user_limit are only allowed on voice channels"
+		}
+		if topic != nil && len(*topic) > models.MaxChannelTopicLength {
+			return fmt.Sprintf("Topic must be at most %d characters", models.MaxChannelTopicLength)
+		}
+		return ""
+	}
+
+	if topic != nil && *topic != "" {
+		return "topic is only allowed on text channels"
+	}
+	if channelType == models.ChannelTypeCategory {
+		if bitrate != nil || userLimit != nil {
+			return "bitrate and user_limit are only allowed on voice channels"
+		}
+		return ""
+	}
+	if bitrate != nil && (*bitrate < models.MinVoiceBitrate || *bitrate > models.MaxVoiceBitrate) {
+		return fmt.Sprintf("bitrate must be between %d and %d", models.MinVoiceBitrate, models.MaxVoiceBitrate)
+	}
+	if userLimit != nil && (*userLimit < 0 || *userLimit > models.MaxVoiceUserLimit) {
+		return fmt.Sprintf("user_limit must be between 0 and %d", models.MaxVoiceUserLimit)
+	}
+	return ""
+}
@@ -0,0 +1,320 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"mana/internal/db"
+	"mana/internal/middleware"
+	"mana/internal/models"
+	"mana/internal/types"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type CreateDMChannelRequest struct {
+	RecipientIDs []uuid.UUID `json:"recipient_ids"`
+	Name         string      `json:"name"`
+}
+
+type DMRecipientEvent struct {
+	ChannelID uuid.UUID `json:"channel_id"`
+	UserID    uuid.UUID `json:"user_id"`
+}
+
+func (api *API) CreateDMChannel(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req CreateDMChannelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	// drop duplicates and ourselves
+	seen := map[uuid.UUID]bool{userID: true}
+	var recipientIDs []uuid.UUID
+	for _, id := range req.RecipientIDs {
+		if !seen[id] {
+			seen[id] = true
+			recipientIDs = append(recipientIDs, id)
+		}
+	}
+
+	if len(recipientIDs) == 0 {
+		http.Error(w, "At least one recipient is required", http.StatusBadRequest)
+		return
+	}
+
+	if len(recipientIDs)+1 > models.MaxGroupDMRecipients {
+		http.Error(w, "Too many recipients", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if len(req.Name) > 100 {
+		http.Error(w, "Channel name must be at most 100 characters.", http.StatusBadRequest)
+		return
+	}
+
+	// every recipient must exist and accept dms from us
+	for _, recipientID := range recipientIDs {
+		status, msg := api.checkCanDirectMessage(ctx, userID, recipientID)
+		if status != http.StatusOK {
+			http.Error(w, msg, status)
+			return
+		}
+	}
+
+	// 1:1 dms are reused instead of duplicated
+	if len(recipientIDs) == 1 && req.Name == "" {
+		existing, err := api.Store.DMChannels.FindDMChannelBetween(ctx, userID, recipientIDs[0])
+		if err != nil {
+			http.Error(w, "Failed to fetch channel", http.StatusInternalServerError)
+			return
+		}
+
+		if existing != nil {
+			resp := map[string]interface{}{"channel": existing}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+	}
+
+	var channel *models.DMChannel
+	if len(recipientIDs) == 1 && req.Name == "" {
+		channel = models.NewDMChannel(userID, recipientIDs[0])
+	} else {
+		channel = models.NewGroupDMChannel(userID, recipientIDs, req.Name)
+	}
+
+	if err := api.Store.DMChannels.CreateDMChannel(ctx, channel); err != nil {
+		http.Error(w, "Failed to create channel", http.StatusInternalServerError)
+		return
+	}
+
+	resp := map[string]interface{}{"channel": channel}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (api *API) GetUserDMChannels(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	channels, err := api.Store.DMChannels.GetDMChannelsForUser(ctx, userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch channels", http.StatusInternalServerError)
+		return
+	}
+
+	resp := map[string]interface{}{"channels": channels}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (api *API) AddDMRecipient(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	channelID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+
+	recipientID, err := uuid.Parse(chi.URLParam(r, "user_id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	channel, err := api.Store.DMChannels.GetDMChannelByID(ctx, channelID)
+	if err != nil {
+		http.Error(w, "Failed to fetch channel", http.StatusInternalServerError)
+		return
+	}
+	if channel == nil || !channel.HasRecipient(userID) {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+
+	if channel.Type != models.ChannelTypeGroupDM {
+		http.Error(w, "Recipients can only be added to group DMs", http.StatusBadRequest)
+		return
+	}
+
+	if channel.OwnerID == nil || *channel.OwnerID != userID {
+		http.Error(w, "Only the group owner can add recipients", http.StatusForbidden)
+		return
+	}
+
+	if channel.HasRecipient(recipientID) {
+		http.Error(w, "User is already a recipient", http.StatusConflict)
+		return
+	}
+
+	if len(channel.RecipientIDs) >= models.MaxGroupDMRecipients {
+		http.Error(w, "Group DM is full", http.StatusBadRequest)
+		return
+	}
+
+	status, msg := api.checkCanDirectMessage(ctx, userID, recipientID)
+	if status != http.StatusOK {
+		http.Error(w, msg, status)
+		return
+	}
+
+	err = api.Store.DMChannels.AddRecipient(ctx, channelID, recipientID)
+	if errors.Is(err, db.ErrGroupDMFull) {
+		http.Error(w, "Group DM is full", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to add recipient", http.StatusInternalServerError)
+		return
+	}
+
+	api.dispatch(types.EventChannelRecipientAdd, channelID, DMRecipientEvent{ChannelID: channelID, UserID: recipientID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *API) RemoveDMRecipient(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	channelID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+
+	recipientID, err := uuid.Parse(chi.URLParam(r, "user_id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	channel, err := api.Store.DMChannels.GetDMChannelByID(ctx, channelID)
+	if err != nil {
+		http.Error(w, "Failed to fetch channel", http.StatusInternalServerError)
+		return
+	}
+	if channel == nil || !channel.HasRecipient(userID) {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+
+	if channel.Type != models.ChannelTypeGroupDM {
+		http.Error(w, "Recipients can only be removed from group DMs", http.StatusBadRequest)
+		return
+	}
+
+	// anyone can leave, only the owner can remove others
+	isOwner := channel.OwnerID != nil && *channel.OwnerID == userID
+	if recipientID != userID && !isOwner {
+		http.Error(w, "Only the group owner can remove recipients", http.StatusForbidden)
+		return
+	}
+
+	if !channel.HasRecipient(recipientID) {
+		http.Error(w, "User is not a recipient", http.StatusNotFound)
+		return
+	}
+
+	if err := api.Store.DMChannels.RemoveRecipient(ctx, channelID, recipientID); err != nil {
+		http.Error(w, "Failed to remove recipient", http.StatusInternalServerError)
+		return
+	}
+	api.Hub.DisconnectUser(recipientID, []uuid.UUID{channelID})
+
+	var remaining []uuid.UUID
+	for _, id := range channel.RecipientIDs {
+		if id != recipientID {
+			remaining = append(remaining, id)
+		}
+	}
+
+	// last one out deletes the group
+	if len(remaining) == 0 {
+		if err := api.Store.DMChannels.DeleteDMChannel(ctx, channelID); err != nil {
+			http.Error(w, "Failed to delete channel", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// owner left, hand the group to the longest standing recipient
+	if channel.OwnerID != nil && *channel.OwnerID == recipientID {
+		if err := api.Store.DMChannels.UpdateOwner(ctx, channelID, remaining[0]); err != nil {
+			http.Error(w, "Failed to transfer ownership", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	api.dispatch(types.EventChannelRecipientRemove, channelID, DMRecipientEvent{ChannelID: channelID, UserID: recipientID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Checks the recipient exists and their privacy settings let the sender dm them.
+// Returns http.StatusOK when allowed, otherwise a status and message to send back.
+func (api *API) checkCanDirectMessage(ctx context.Context, senderID uuid.UUID, recipientID uuid.UUID) (int, string) {
+	recipient, err := api.Store.Users.GetUserByID(ctx, recipientID)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to fetch user"
+	}
+	if recipient == nil {
+		return http.StatusNotFound, "User not found"
+	}
+
+	if recipient.DMPrivacy == models.DMPrivacyEveryone {
+		return http.StatusOK, ""
+	}
+
+	shareGuild, err := api.Store.Guilds.UsersShareGuild(ctx, senderID, recipientID)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to check shared guilds"
+	}
+	if shareGuild {
+		return http.StatusOK, ""
+	}
+
+	friends, err := api.Store.Friends.AreFriends(ctx, senderID, recipientID)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to check friendship"
+	}
+	if friends {
+		return http.StatusOK, ""
+	}
+
+	return http.StatusForbidden, "This user only accepts direct messages from friends and guild members"
+}
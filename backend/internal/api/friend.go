@@ -0,0 +1,133 @@
+package api
+
+import (
+	"encoding/json"
+	"mana/internal/middleware"
+	"mana/internal/models"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+func (api *API) GetFriends(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	friendships, err := api.Store.Friends.GetFriendshipsForUser(ctx, userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch friends", http.StatusInternalServerError)
+		return
+	}
+
+	resp := map[string]interface{}{
+		"friends": friendships,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Sends a friend request, or accepts one if the other user already sent us one
+func (api *API) AddFriend(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	friendID, err := uuid.Parse(chi.URLParam(r, "user_id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if friendID == userID {
+		http.Error(w, "You cannot friend yourself", http.StatusBadRequest)
+		return
+	}
+
+	friend, err := api.Store.Users.GetUserByID(ctx, friendID)
+	if err != nil {
+		http.Error(w, "Failed to fetch user", http.StatusInternalServerError)
+		return
+	}
+	if friend == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if friend.Bot {
+		http.Error(w, "Bots cannot be added as friends", http.StatusBadRequest)
+		return
+	}
+
+	existing, err := api.Store.Friends.GetFriendship(ctx, userID, friendID)
+	if err != nil {
+		http.Error(w, "Failed to fetch friendship", http.StatusInternalServerError)
+		return
+	}
+
+	var friendship *models.Friendship
+	switch {
+	case existing == nil:
+		friendship = models.NewFriendRequest(userID, friendID)
+		if err := api.Store.Friends.InsertFriendRequest(ctx, friendship); err != nil {
+			http.Error(w, "Failed to send friend request", http.StatusInternalServerError)
+			return
+		}
+
+	case existing.Status == models.FriendshipStatusAccepted:
+		http.Error(w, "Already friends", http.StatusConflict)
+		return
+
+	case existing.RequesterID == userID:
+		http.Error(w, "Friend request already sent", http.StatusConflict)
+		return
+
+	default:
+		if err := api.Store.Friends.AcceptFriendRequest(ctx, friendID, userID); err != nil {
+			http.Error(w, "Failed to accept friend request", http.StatusInternalServerError)
+			return
+		}
+		existing.Status = models.FriendshipStatusAccepted
+		friendship = existing
+	}
+
+	resp := map[string]interface{}{
+		"friendship": friendship,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Removes a friend, or cancels/declines a pending request
+func (api *API) RemoveFriend(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	friendID, err := uuid.Parse(chi.URLParam(r, "user_id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := api.Store.Friends.DeleteFriendship(ctx, userID, friendID); err != nil {
+		http.Error(w, "Failed to remove friend", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
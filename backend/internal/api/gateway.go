@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"mana/internal/middleware"
+	"mana/internal/models"
+	"mana/internal/permissions"
+	"mana/internal/types"
+	"mana/internal/websocket"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// view channels is checked by the route, dm recipients always have it
+func (api *API) ServeGateway(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+	websocket.ServeWebsocket(api.Hub, userID, w, r)
+}
+
+// sends an event to every gateway client connected to the channel
+func (api *API) dispatch(eventType string, channelID uuid.UUID, data any) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Failed to marshal %s event: %v", eventType, err)
+		return
+	}
+
+	api.Hub.BroadcastMessage(types.Event{
+		Type:      eventType,
+		ChannelID: channelID,
+		Data:      raw,
+	})
+}
+
+// closes the user's gateway connections to the guild's channels, for once
+// they've been removed from it
+func (api *API) disconnectFromGuild(ctx context.Context, guildID, userID uuid.UUID) {
+	channels, err := api.Store.GuildChannels.GetChannelsForGuild(ctx, guildID)
+	if err != nil {
+		log.Printf("Failed to fetch channels to disconnect %s from guild %s: %v", userID, guildID, err)
+		return
+	}
+
+	channelIDs := make([]uuid.UUID, 0, len(channels))
+	for _, channel := range channels {
+		channelIDs = append(channelIDs, channel.ID)
+	}
+
+	api.Hub.DisconnectUser(userID, channelIDs)
+}
+
+// closes gateway connections to channels their user can no longer see, for
+// after roles or permission overrides changed. Only userID's connections
+// are checked, unless it's uuid.Nil.
+func (api *API) recheckChannelAccess(ctx context.Context, guild *models.Guild, channels []*models.GuildChannel, userID uuid.UUID) {
+	for _, channel := range channels {
+		for _, connectedID := range api.Hub.ChannelUsers(channel.ID) {
+			if userID != uuid.Nil && connectedID != userID {
+				continue
+			}
+
+			perms, err := api.resolveChannelPermissions(ctx, guild, channel, connectedID)
+			if err != nil {
+				log.Printf("Failed to recheck access of %s to channel %s: %v", connectedID, channel.ID, err)
+				continue
+			}
+			if !permissions.HasPermission(perms, permissions.PermissionViewChannels) {
+				api.Hub.DisconnectUser(connectedID, []uuid.UUID{channel.ID})
+			}
+		}
+	}
+}
+
+// recheckChannelAccess for every channel in the guild
+func (api *API) recheckGuildAccess(ctx context.Context, guild *models.Guild, userID uuid.UUID) {
+	channels, err := api.Store.GuildChannels.GetChannelsForGuild(ctx, guild.ID)
+	if err != nil {
+		log.Printf("Failed to fetch channels to recheck access in guild %s: %v", guild.ID, err)
+		return
+	}
+
+	api.recheckChannelAccess(ctx, guild, channels, userID)
+}
@@ -2,12 +2,14 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"mana/internal/middleware"
 	"mana/internal/models"
+	"mana/internal/permissions"
 	"net/http"
 	"strings"
+	"time"
 
-	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 )
 
@@ -15,10 +17,27 @@ type CreateGuildRequest struct {
 	Name string `json:"name"`
 }
 
+// Unset fields are left alone, empty strings clear the optional ones
+type UpdateGuildRequest struct {
+	Name                  *string                   `json:"name"`
+	Description           *string                   `json:"description"`
+	IconURL               *string                   `json:"icon_url"`
+	BannerURL             *string                   `json:"banner_url"`
+	SystemChannelID       *string                   `json:"system_channel_id"` // empty turns join messages off
+	DefaultNotifications  *string                   `json:"default_notifications"`
+	ExplicitContentFilter *bool                     `json:"explicit_content_filter"`
+	VerificationLevel     *models.VerificationLevel `json:"verification_level"`
+}
+
 type GetGuildByIDRequest struct {
 	ID string `json:"id"`
 }
 
+type TransferOwnershipRequest struct {
+	UserID   uuid.UUID `json:"user_id"`
+	Password string    `json:"password"`
+}
+
 func (api *API) CreateGuild(w http.ResponseWriter, r *http.Request) {
 	var req CreateGuildRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -28,27 +47,29 @@ func (api *API) CreateGuild(w http.ResponseWriter, r *http.Request) {
 
 	// input vaidation
 	req.Name = strings.TrimSpace(req.Name)
-	if len(req.Name) < 2 || len(req.Name) > 100 {
-		http.Error(w, "Guild name must be between 2 and 100 characters.", http.StatusBadRequest)
+	if msg := validateGuildName(req.Name); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
 		return
 	}
 
 	ctx := r.Context()
 	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
 
-	// Create guild and owner membership
-	guild := models.NewGuild(req.Name, userID)
+	// Create guild with its roles, owner membership and #general
+	result := models.CreateGuild(req.Name, userID)
+	result.EveryoneRole.Permissions = permissions.DefaultEveryonePermissions
 
 	// insert into db
-	if err := api.Store.Guilds.InsertGuild(ctx, guild); err != nil {
+	if err := api.Store.Guilds.CreateGuild(ctx, result); err != nil {
 		http.Error(w, "Failed to create guild", http.StatusInternalServerError)
 		return
 	}
 
-	// TODO: handle everyone/default role ? handle here or somewhere else
-
 	resp := map[string]interface{}{
-		"guild": guild,
+		"guild":    result.Guild,
+		"roles":    []*models.GuildRole{result.OwnerRole, result.EveryoneRole},
+		"channels": []*models.GuildChannel{result.GeneralChannel},
+		"invite":   result.Invite,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -56,137 +77,297 @@ func (api *API) CreateGuild(w http.ResponseWriter, r *http.Request) {
 }
 
 func (api *API) GetGuildByID(w http.ResponseWriter, r *http.Request) {
-	// grab that id
-	guildIDStr := chi.URLParam(r, "id")
-	if guildIDStr == "" {
-		http.Error(w, "Missing guild id", http.StatusBadRequest)
+	// membership is checked by the route
+	guild := accessFromContext(r.Context()).Guild
+
+	// success
+	resp := map[string]interface{}{"guild": guild}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (api *API) UpdateGuild(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	guild := accessFromContext(ctx).Guild
+
+	var req UpdateGuildRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
 
-	// convert str -> uuid
-	guildID, err := uuid.Parse(strings.TrimSpace(guildIDStr))
-	if err != nil {
-		http.Error(w, "Invalid guild id", http.StatusBadRequest)
-		return
+	before := *guild
+
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if msg := validateGuildName(name); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+		guild.Name = name
 	}
 
-	// find that guild
-	ctx := r.Context()
-	guild, err := api.Store.Guilds.GetGuildByID(ctx, guildID)
-	if err != nil {
-		http.Error(w, "Could not find guild.", http.StatusNotFound)
-		return
+	if req.Description != nil {
+		description := strings.TrimSpace(*req.Description)
+		if len(description) > models.MaxGuildDescriptionLength {
+			http.Error(w, fmt.Sprintf("Description must be at most %d characters", models.MaxGuildDescriptionLength), http.StatusBadRequest)
+			return
+		}
+		guild.Description = description
+	}
+
+	if req.IconURL != nil {
+		if msg := validateHTTPURL("icon_url", *req.IconURL); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+		guild.IconURL = *req.IconURL
+	}
+
+	if req.BannerURL != nil {
+		if msg := validateHTTPURL("banner_url", *req.BannerURL); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+		guild.BannerURL = *req.BannerURL
+	}
+
+	if req.SystemChannelID != nil {
+		guild.SystemChannelID = nil
+
+		if *req.SystemChannelID != "" {
+			channelID, err := uuid.Parse(*req.SystemChannelID)
+			if err != nil {
+				http.Error(w, "Invalid system channel ID", http.StatusBadRequest)
+				return
+			}
+
+			channel, err := api.Store.GuildChannels.GetChannelByID(ctx, channelID)
+			if err != nil {
+				http.Error(w, "Failed to fetch channel", http.StatusInternalServerError)
+				return
+			}
+			if channel == nil || channel.GuildID != guild.ID {
+				http.Error(w, "System channel not found", http.StatusNotFound)
+				return
+			}
+			if channel.Type != models.ChannelTypeText {
+				http.Error(w, "System channel must be a text channel", http.StatusBadRequest)
+				return
+			}
+			guild.SystemChannelID = &channel.ID
+		}
+	}
+
+	if req.DefaultNotifications != nil {
+		if *req.DefaultNotifications != models.NotificationsAllMessages && *req.DefaultNotifications != models.NotificationsOnlyMentions {
+			http.Error(w, "Invalid default_notifications. Must be: all_messages or only_mentions", http.StatusBadRequest)
+			return
+		}
+		guild.DefaultNotifications = *req.DefaultNotifications
+	}
+
+	if req.ExplicitContentFilter != nil {
+		guild.ExplicitContentFilter = *req.ExplicitContentFilter
+	}
+
+	if req.VerificationLevel != nil {
+		if !req.VerificationLevel.Valid() {
+			http.Error(w, fmt.Sprintf("Verification level must be between %d and %d", models.VerificationNone, models.VerificationHigh), http.StatusBadRequest)
+			return
+		}
+		if req.VerificationLevel.NeedsVerifiedEmail() {
+			http.Error(w, "Verification level 1 needs a verified email, which can't be verified yet", http.StatusBadRequest)
+			return
+		}
+		guild.VerificationLevel = *req.VerificationLevel
+	}
+
+	changes := models.DiffAuditChanges(before, guild)
+	if len(changes) > 0 {
+		if err := api.Store.Guilds.UpdateGuildSettings(ctx, guild); err != nil {
+			http.Error(w, "Failed to update guild", http.StatusInternalServerError)
+			return
+		}
+
+		entry := models.NewAuditLogEntry(guild.ID, userID, models.AuditGuildUpdate, models.AuditTargetGuild, guild.ID.String())
+		entry.Changes = changes
+		api.audit(r, entry)
+	}
+
+	resp := map[string]interface{}{
+		"guild": guild,
 	}
 
-	// success
-	resp := map[string]interface{}{"guild": guild}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
+// Owner only, not even administrators. Nothing is removed straight away, the
+// guild is purged by the janitor once GuildDeletionGracePeriod is up and can
+// be restored until then.
 func (api *API) DeleteGuild(w http.ResponseWriter, r *http.Request) {
-	// grab THAT id
-	guildIDStr := chi.URLParam(r, "id")
-	guildID, err := uuid.Parse(guildIDStr)
-	if err != nil {
-		http.Error(w, "Invalid guild ID", http.StatusBadRequest)
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	guild := accessFromContext(ctx).Guild
+
+	if !guild.IsOwner(userID) {
+		http.Error(w, "You do not have permission to delete this guild", http.StatusForbidden)
 		return
 	}
 
-	ctx := r.Context()
-	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	var req ConfirmPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
 
-	guild, err := api.Store.Guilds.GetGuildByID(ctx, guildID)
-	if err != nil {
-		http.Error(w, "Guild not found", http.StatusNotFound)
+	if !api.confirmPassword(w, r, userID, req.Password) {
 		return
 	}
 
-	if guild.OwnerID != userID {
-		http.Error(w, "You do not have permission to delete this guild", http.StatusForbidden)
+	if guild.DeletesAt != nil {
+		http.Error(w, "Guild is already scheduled for deletion", http.StatusConflict)
 		return
 	}
 
-	if err := api.Store.Guilds.DeleteGuild(ctx, guildID); err != nil {
+	deletesAt := time.Now().UTC().Add(models.GuildDeletionGracePeriod)
+	if err := api.Store.Guilds.ScheduleDeletion(ctx, guild.ID, &deletesAt); err != nil {
 		http.Error(w, "Failed to delete guild", http.StatusInternalServerError)
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	before := *guild
+	guild.DeletesAt = &deletesAt
+
+	entry := models.NewAuditLogEntry(guild.ID, userID, models.AuditGuildUpdate, models.AuditTargetGuild, guild.ID.String())
+	entry.Changes = models.DiffAuditChanges(before, guild)
+	api.audit(r, entry)
+
+	resp := map[string]interface{}{
+		"guild": guild,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(resp)
 }
 
-func (api *API) GetUserGuilds(w http.ResponseWriter, r *http.Request) {
+func (api *API) RestoreGuild(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	guild := accessFromContext(ctx).Guild
 
-	userID, ok := ctx.Value(middleware.UserIDKey).(uuid.UUID)
-	if !ok {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	// frozen guilds have no owner to restore them
+	if !guild.IsOwner(userID) {
+		http.Error(w, "You do not have permission to restore this guild", http.StatusForbidden)
 		return
 	}
 
-	guilds, err := api.Store.Guilds.GetGuildsForUserID(ctx, userID)
-	if err != nil {
-		http.Error(w, "Failed to fetch guilds", http.StatusInternalServerError)
+	if guild.DeletesAt == nil {
+		http.Error(w, "Guild is not scheduled for deletion", http.StatusConflict)
+		return
+	}
+
+	if err := api.Store.Guilds.ScheduleDeletion(ctx, guild.ID, nil); err != nil {
+		http.Error(w, "Failed to restore guild", http.StatusInternalServerError)
 		return
 	}
 
+	before := *guild
+	guild.DeletesAt = nil
+
+	entry := models.NewAuditLogEntry(guild.ID, userID, models.AuditGuildUpdate, models.AuditTargetGuild, guild.ID.String())
+	entry.Changes = models.DiffAuditChanges(before, guild)
+	api.audit(r, entry)
+
 	resp := map[string]interface{}{
-		"guilds": guilds,
+		"guild": guild,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
-
 }
 
-func (api *API) JoinGuildByInvite(w http.ResponseWriter, r *http.Request) {
-	inviteCode := chi.URLParam(r, "code")
+// Owner only, and they have to enter their password again
+func (api *API) TransferGuildOwnership(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	guild := accessFromContext(ctx).Guild
 
-	// empty code
-	if inviteCode == "" {
-		http.Error(w, "Missing invite code", http.StatusBadRequest)
+	if !guild.IsOwner(userID) {
+		http.Error(w, "Only the guild owner can transfer ownership", http.StatusForbidden)
 		return
 	}
 
-	// grab user id from ctx
-	ctx := r.Context()
-	userID, ok := ctx.Value(middleware.UserIDKey).(uuid.UUID)
-	if !ok {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	var req TransferOwnershipRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
 
-	// Get guild by invite code
-	guild, err := api.Store.Guilds.GetGuildByInviteCode(ctx, inviteCode)
-	if err != nil {
-		http.Error(w, "Invalid or expired invite code", http.StatusNotFound)
+	if req.UserID == uuid.Nil {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+	if req.UserID == userID {
+		http.Error(w, "You already own this guild", http.StatusBadRequest)
 		return
 	}
 
-	// Check if user is member of guild already
-	exists, err := api.Store.Guilds.CheckUserMemberOfGuild(ctx, guild.ID, userID)
+	if !api.confirmPassword(w, r, userID, req.Password) {
+		return
+	}
+
+	if guild.DeletesAt != nil {
+		http.Error(w, "Restore the guild before transferring it", http.StatusConflict)
+		return
+	}
+
+	member, err := api.Store.Guilds.GetGuildMember(ctx, guild.ID, req.UserID)
 	if err != nil {
-		http.Error(w, "Failed to check membership", http.StatusInternalServerError)
+		http.Error(w, "Failed to fetch member", http.StatusInternalServerError)
+		return
+	}
+	if member == nil {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+	// they'd lose the guild again as soon as they disconnect
+	if member.Temporary {
+		http.Error(w, "Temporary members cannot own a guild", http.StatusBadRequest)
 		return
 	}
 
-	// already a member
-	if exists {
-		http.Error(w, "Already a member of this guild", http.StatusConflict)
+	newOwner, err := api.Store.Users.GetUserByID(ctx, req.UserID)
+	if err != nil || newOwner == nil {
+		http.Error(w, "Failed to fetch user", http.StatusInternalServerError)
+		return
+	}
+	if newOwner.Bot {
+		http.Error(w, "Bots cannot own a guild", http.StatusBadRequest)
 		return
 	}
 
-	// Add user to guild
-	member := models.NewGuildMember(guild.ID, userID)
-	err = api.Store.Guilds.AddUserToGuild(ctx, member)
+	transferred, err := api.Store.Guilds.TransferOwnership(ctx, guild.ID, userID, req.UserID)
 	if err != nil {
-		http.Error(w, "Failed to join guild", http.StatusInternalServerError)
+		http.Error(w, "Failed to transfer ownership", http.StatusInternalServerError)
+		return
+	}
+	if !transferred {
+		http.Error(w, "Only the guild owner can transfer ownership", http.StatusForbidden)
 		return
 	}
 
-	// do @everyone here ?
+	before := *guild
+	guild.OwnerID = &req.UserID
+
+	entry := models.NewAuditLogEntry(guild.ID, userID, models.AuditGuildUpdate, models.AuditTargetGuild, guild.ID.String())
+	entry.Changes = models.DiffAuditChanges(before, guild)
+	api.audit(r, entry)
+	api.MemberLists.Invalidate(guild.ID) // the owner's role moved with it
 
-	// send response
 	resp := map[string]interface{}{
 		"guild": guild,
 	}
@@ -194,3 +375,34 @@ func (api *API) JoinGuildByInvite(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
+
+func (api *API) GetUserGuilds(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	guilds, err := api.Store.Guilds.GetGuildsForUserID(ctx, userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch guilds", http.StatusInternalServerError)
+		return
+	}
+
+	resp := map[string]interface{}{
+		"guilds": guilds,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+
+}
+
+func validateGuildName(name string) string {
+	if len(name) < models.MinGuildNameLength || len(name) > models.MaxGuildNameLength {
+		return fmt.Sprintf("Guild name must be between %d and %d characters.", models.MinGuildNameLength, models.MaxGuildNameLength)
+	}
+	return ""
+}
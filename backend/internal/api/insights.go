@@ -0,0 +1,169 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"mana/internal/models"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Daily totals for the guild plus messages per channel, between from and to
+// (inclusive YYYY-MM-DD days, the last 30 days by default)
+func (api *API) GetGuildInsights(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	guild := accessFromContext(ctx).Guild
+
+	from, to, ok := parseInsightsRange(w, r)
+	if !ok {
+		return
+	}
+
+	days, err := api.Store.Insights.GetGuildInsights(ctx, guild.ID, from, to)
+	if err != nil {
+		http.Error(w, "Failed to fetch insights", http.StatusInternalServerError)
+		return
+	}
+
+	channels, err := api.Store.Insights.GetChannelInsights(ctx, guild.ID, from, to)
+	if err != nil {
+		http.Error(w, "Failed to fetch insights", http.StatusInternalServerError)
+		return
+	}
+	if channels == nil {
+		channels = []*models.ChannelInsightsDay{}
+	}
+
+	resp := map[string]interface{}{
+		"from":     from.Format(models.InsightsDateLayout),
+		"to":       to.Format(models.InsightsDateLayout),
+		"days":     fillInsightsDays(days, from, to),
+		"channels": channels,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Same range as GetGuildInsights as a CSV download, one row per day, or one
+// row per channel per day with breakdown=channels
+func (api *API) ExportGuildInsights(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	guild := accessFromContext(ctx).Guild
+
+	from, to, ok := parseInsightsRange(w, r)
+	if !ok {
+		return
+	}
+
+	var records [][]string
+	breakdown := r.URL.Query().Get("breakdown")
+
+	switch breakdown {
+	case "":
+		days, err := api.Store.Insights.GetGuildInsights(ctx, guild.ID, from, to)
+		if err != nil {
+			http.Error(w, "Failed to fetch insights", http.StatusInternalServerError)
+			return
+		}
+
+		records = append(records, []string{"day", "messages", "active_members", "joins", "leaves", "invite_uses", "peak_connections"})
+		for _, day := range fillInsightsDays(days, from, to) {
+			records = append(records, []string{
+				day.Day,
+				strconv.Itoa(day.Messages),
+				strconv.Itoa(day.ActiveMembers),
+				strconv.Itoa(day.Joins),
+				strconv.Itoa(day.Leaves),
+				strconv.Itoa(day.InviteUses),
+				strconv.Itoa(day.PeakConnections),
+			})
+		}
+	case "channels":
+		channels, err := api.Store.Insights.GetChannelInsights(ctx, guild.ID, from, to)
+		if err != nil {
+			http.Error(w, "Failed to fetch insights", http.StatusInternalServerError)
+			return
+		}
+
+		records = append(records, []string{"day", "channel_id", "messages"})
+		for _, channel := range channels {
+			records = append(records, []string{
+				channel.Day,
+				channel.ChannelID.String(),
+				strconv.Itoa(channel.Messages),
+			})
+		}
+	default:
+		http.Error(w, "Breakdown must be channels or left out", http.StatusBadRequest)
+		return
+	}
+
+	filename := fmt.Sprintf("insights-%s-%s-%s.csv", guild.ID, from.Format(models.InsightsDateLayout), to.Format(models.InsightsDateLayout))
+	if breakdown != "" {
+		filename = fmt.Sprintf("insights-%s-%s-%s-%s.csv", guild.ID, breakdown, from.Format(models.InsightsDateLayout), to.Format(models.InsightsDateLayout))
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	csv.NewWriter(w).WriteAll(records)
+}
+
+// Reads from and to, writing the error response itself when they're invalid
+func parseInsightsRange(w http.ResponseWriter, r *http.Request) (time.Time, time.Time, bool) {
+	query := r.URL.Query()
+
+	to := models.InsightsDay(time.Now())
+	if toStr := query.Get("to"); toStr != "" {
+		parsed, err := time.Parse(models.InsightsDateLayout, toStr)
+		if err != nil {
+			http.Error(w, "To must be a YYYY-MM-DD date", http.StatusBadRequest)
+			return time.Time{}, time.Time{}, false
+		}
+		to = parsed
+	}
+
+	// both ends are inclusive, so the default range is 30 days counting to
+	from := to.Add(-models.DefaultInsightsRange + 24*time.Hour)
+	if fromStr := query.Get("from"); fromStr != "" {
+		parsed, err := time.Parse(models.InsightsDateLayout, fromStr)
+		if err != nil {
+			http.Error(w, "From must be a YYYY-MM-DD date", http.StatusBadRequest)
+			return time.Time{}, time.Time{}, false
+		}
+		from = parsed
+	}
+
+	if from.After(to) {
+		http.Error(w, "From must not be after to", http.StatusBadRequest)
+		return time.Time{}, time.Time{}, false
+	}
+	if to.Sub(from) >= models.MaxInsightsRange {
+		http.Error(w, fmt.Sprintf("Range must be at most %d days", int(models.MaxInsightsRange/(24*time.Hour))), http.StatusBadRequest)
+		return time.Time{}, time.Time{}, false
+	}
+
+	return from, to, true
+}
+
+// Gives every day in the range a row, days the rollup never saw are all zero
+func fillInsightsDays(days []*models.GuildInsightsDay, from time.Time, to time.Time) []*models.GuildInsightsDay {
+	byDay := make(map[string]*models.GuildInsightsDay, len(days))
+	for _, day := range days {
+		byDay[day.Day] = day
+	}
+
+	filled := []*models.GuildInsightsDay{}
+	for day := from; !day.After(to); day = day.Add(24 * time.Hour) {
+		key := day.Format(models.InsightsDateLayout)
+		if existing, ok := byDay[key]; ok {
+			filled = append(filled, existing)
+			continue
+		}
+		filled = append(filled, &models.GuildInsightsDay{Day: key})
+	}
+
+	return filled
+}
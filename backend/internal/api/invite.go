@@ -0,0 +1,254 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"mana/internal/db"
+	"mana/internal/middleware"
+	"mana/internal/models"
+	"mana/internal/permissions"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type CreateInviteRequest struct {
+	ChannelID *uuid.UUID `json:"channel_id"`
+	MaxAge    *int       `json:"max_age"`  // seconds, 0 never expires, defaults to a day
+	MaxUses   int        `json:"max_uses"` // 0 is unlimited
+	Temporary bool       `json:"temporary"`
+}
+
+func (api *API) CreateInvite(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	guild := accessFromContext(ctx).Guild
+
+	var req CreateInviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	maxAge := models.DefaultInviteAge
+	if req.MaxAge != nil {
+		maxAge = time.Duration(*req.MaxAge) * time.Second
+	}
+	if maxAge < 0 || maxAge > models.MaxInviteAge {
+		http.Error(w, "Max age must be between 0 and 604800 seconds", http.StatusBadRequest)
+		return
+	}
+
+	if req.MaxUses < 0 || req.MaxUses > models.MaxInviteUses {
+		http.Error(w, "Max uses must be between 0 and 100", http.StatusBadRequest)
+		return
+	}
+
+	// the invite can only point somewhere the creator can see
+	if req.ChannelID != nil {
+		channel, err := api.Store.GuildChannels.GetChannelByID(ctx, *req.ChannelID)
+		if err != nil {
+			http.Error(w, "Failed to fetch channel", http.StatusInternalServerError)
+			return
+		}
+		if channel == nil || channel.GuildID != guild.ID {
+			http.Error(w, "Channel not found", http.StatusNotFound)
+			return
+		}
+
+		perms, err := api.resolveChannelPermissions(ctx, guild, channel, userID)
+		if err != nil {
+			http.Error(w, "Failed to resolve permissions", http.StatusInternalServerError)
+			return
+		}
+		if !permissions.HasPermission(perms, permissions.PermissionViewChannels) {
+			http.Error(w, "Channel not found", http.StatusNotFound)
+			return
+		}
+
+		if channel.Type == models.ChannelTypeCategory {
+			http.Error(w, "Invites cannot point at a category", http.StatusBadRequest)
+			return
+		}
+	}
+
+	count, err := api.Store.Invites.CountActiveInvitesForGuild(ctx, guild.ID)
+	if err != nil {
+		http.Error(w, "Failed to create invite", http.StatusInternalServerError)
+		return
+	}
+	if count >= models.MaxInvitesPerGuild {
+		http.Error(w, "Guild has reached the maximum number of invites", http.StatusBadRequest)
+		return
+	}
+
+	invite := models.NewInvite(guild.ID, req.ChannelID, userID, maxAge, req.MaxUses, req.Temporary)
+	if err := api.Store.Invites.CreateInvite(ctx, invite); err != nil {
+		http.Error(w, "Failed to create invite", http.StatusInternalServerError)
+		return
+	}
+
+	entry := models.NewAuditLogEntry(guild.ID, userID, models.AuditInviteCreate, models.AuditTargetInvite, invite.Code)
+	entry.ChannelID = invite.ChannelID
+	entry.Changes = models.DiffAuditChanges(nil, invite)
+	api.audit(r, entry)
+
+	resp := map[string]interface{}{
+		"invite": invite,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (api *API) GetGuildInvites(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	guild := accessFromContext(ctx).Guild
+
+	invites, err := api.Store.Invites.GetInvitesForGuild(ctx, guild.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch invites", http.StatusInternalServerError)
+		return
+	}
+	if invites == nil {
+		invites = []*models.Invite{}
+	}
+
+	resp := map[string]interface{}{
+		"invites": invites,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Anyone who can manage the guild can revoke an invite, creators can revoke their own
+func (api *API) RevokeInvite(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	guildAccess := accessFromContext(ctx)
+
+	invite, err := api.Store.Invites.GetInvite(ctx, chi.URLParam(r, "code"))
+	if err != nil {
+		http.Error(w, "Failed to fetch invite", http.StatusInternalServerError)
+		return
+	}
+	if invite == nil || invite.GuildID != guildAccess.Guild.ID {
+		http.Error(w, "Invite not found", http.StatusNotFound)
+		return
+	}
+
+	isCreator := invite.CreatorID != nil && *invite.CreatorID == userID
+	if !isCreator && !permissions.HasPermission(guildAccess.Permissions, permissions.PermissionManageGuild) {
+		http.Error(w, "Missing permissions", http.StatusForbidden)
+		return
+	}
+
+	if err := api.Store.Invites.RevokeInvite(ctx, invite.Code); err != nil {
+		http.Error(w, "Failed to revoke invite", http.StatusInternalServerError)
+		return
+	}
+
+	entry := models.NewAuditLogEntry(invite.GuildID, userID, models.AuditInviteRevoke, models.AuditTargetInvite, invite.Code)
+	entry.ChannelID = invite.ChannelID
+	api.audit(r, entry)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Public, lets someone see where an invite leads before signing in to join
+func (api *API) GetInvitePreview(w http.ResponseWriter, r *http.Request) {
+	preview, err := api.Store.Invites.GetInvitePreview(r.Context(), chi.URLParam(r, "code"))
+	if err != nil {
+		http.Error(w, "Failed to fetch invite", http.StatusInternalServerError)
+		return
+	}
+
+	// revoked, expired and used up invites look the same as ones that never existed
+	if preview == nil {
+		http.Error(w, "Invalid or expired invite code", http.StatusNotFound)
+		return
+	}
+
+	resp := map[string]interface{}{
+		"invite": preview,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (api *API) JoinGuildByInvite(w http.ResponseWriter, r *http.Request) {
+	inviteCode := chi.URLParam(r, "code")
+
+	// empty code
+	if inviteCode == "" {
+		http.Error(w, "Missing invite code", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+
+	// uses the invite and adds the user with the everyone role
+	invite, member, err := api.Store.Invites.JoinWithInvite(ctx, inviteCode, userID)
+	if errors.Is(err, db.ErrInviteUnusable) {
+		http.Error(w, "Invalid or expired invite code", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, db.ErrBanned) {
+		http.Error(w, "You are banned from this guild", http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, db.ErrAlreadyMember) {
+		http.Error(w, "Already a member of this guild", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to join guild", http.StatusInternalServerError)
+		return
+	}
+
+	api.MemberLists.Invalidate(invite.GuildID)
+	api.emit(r, invite.GuildID, models.SubscriptionEventMemberJoin, map[string]interface{}{
+		"user_id":     userID,
+		"invite_code": invite.Code,
+		"temporary":   invite.Temporary,
+	})
+
+	guild, err := api.Store.Guilds.GetGuildByID(ctx, invite.GuildID)
+	if err != nil || guild == nil {
+		http.Error(w, "Failed to fetch guild", http.StatusInternalServerError)
+		return
+	}
+
+	if guild.SystemChannelID != nil {
+		api.postSystemMessage(r, guild, models.NewSystemMessage(*guild.SystemChannelID, userID, models.MessageTypeMemberJoin))
+	}
+
+	onboarding, err := api.getOnboarding(r, guild.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch onboarding", http.StatusInternalServerError)
+		return
+	}
+
+	// pending members are welcomed once they pass screening
+	if !member.Pending {
+		api.welcomeMember(r, guild, onboarding, userID)
+	}
+
+	// send response
+	resp := map[string]interface{}{
+		"guild":      guild,
+		"channel_id": invite.ChannelID,
+		"temporary":  invite.Temporary,
+		"pending":    member.Pending,
+		"onboarding": onboarding,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -6,6 +6,8 @@ import (
 	"mana/internal/models"
 	"net/http"
 	"strings"
+
+	"github.com/google/uuid"
 )
 
 type LoginRequest struct {
@@ -14,6 +16,11 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
+// Re-authenticates the caller before something that can't be undone
+type ConfirmPasswordRequest struct {
+	Password string `json:"password"`
+}
+
 func (req *LoginRequest) isValid() bool {
 	hasUsername := strings.TrimSpace(req.Username) != ""
 	hasEmail := strings.TrimSpace(req.Email) != ""
@@ -98,3 +105,26 @@ func (api *API) Login(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
+
+// Checks password against the caller's account, writing the error response
+// itself when it doesn't match
+func (api *API) confirmPassword(w http.ResponseWriter, r *http.Request, userID uuid.UUID, password string) bool {
+	password = strings.TrimSpace(password)
+	if password == "" {
+		http.Error(w, "Password is required", http.StatusBadRequest)
+		return false
+	}
+
+	user, err := api.Store.Users.GetUserByID(r.Context(), userID)
+	if err != nil || user == nil {
+		http.Error(w, "Failed to fetch user", http.StatusInternalServerError)
+		return false
+	}
+
+	if !auth.CheckPassword(user.Password, password) {
+		http.Error(w, "Incorrect password", http.StatusUnauthorized)
+		return false
+	}
+
+	return true
+}
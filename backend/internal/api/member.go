@@ -0,0 +1,285 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"mana/internal/middleware"
+	"mana/internal/models"
+	"mana/internal/permissions"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// Unset fields are left alone, empty strings clear them
+type UpdateCurrentMemberRequest struct {
+	Nickname  *string `json:"nickname"`
+	AvatarURL *string `json:"avatar_url"`
+	Bio       *string `json:"bio"`
+}
+
+// Other members' avatars and bios are theirs, only the nickname can be managed
+type UpdateMemberRequest struct {
+	Nickname *string `json:"nickname"`
+}
+
+// Lists members by join date, filtered by query (a username or nickname
+// prefix) and role_id, and paged with after (a user id) and limit
+func (api *API) GetGuildMembers(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	guild := accessFromContext(ctx).Guild
+	query := r.URL.Query()
+
+	filter := &models.MemberFilter{
+		Query: strings.TrimSpace(query.Get("query")),
+		Sort:  models.MemberSortJoinedAsc,
+		Limit: models.DefaultMemberListLimit,
+	}
+
+	if len(filter.Query) > models.MaxNicknameLength {
+		http.Error(w, fmt.Sprintf("Query must be at most %d characters", models.MaxNicknameLength), http.StatusBadRequest)
+		return
+	}
+
+	if roleIDStr := query.Get("role_id"); roleIDStr != "" {
+		roleID, err := uuid.Parse(roleIDStr)
+		if err != nil {
+			http.Error(w, "Invalid role ID", http.StatusBadRequest)
+			return
+		}
+		filter.RoleID = &roleID
+	}
+
+	if sort := query.Get("sort"); sort != "" {
+		if sort != models.MemberSortJoinedAsc && sort != models.MemberSortJoinedDesc {
+			http.Error(w, "Invalid sort. Must be: joined_asc or joined_desc", http.StatusBadRequest)
+			return
+		}
+		filter.Sort = sort
+	}
+
+	if afterStr := query.Get("after"); afterStr != "" {
+		after, err := uuid.Parse(afterStr)
+		if err != nil {
+			http.Error(w, "Invalid after user ID", http.StatusBadRequest)
+			return
+		}
+		filter.After = &after
+	}
+
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > models.MaxMemberListLimit {
+			http.Error(w, fmt.Sprintf("Limit must be between 1 and %d", models.MaxMemberListLimit), http.StatusBadRequest)
+			return
+		}
+		filter.Limit = limit
+	}
+
+	members, err := api.Store.Guilds.ListGuildMembers(ctx, guild.ID, filter)
+	if err != nil {
+		http.Error(w, "Failed to fetch members", http.StatusInternalServerError)
+		return
+	}
+	if members == nil {
+		members = []*models.GuildMemberProfile{}
+	}
+
+	resp := map[string]interface{}{
+		"members": members,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (api *API) GetGuildMember(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	guild := accessFromContext(ctx).Guild
+
+	memberID, err := uuid.Parse(chi.URLParam(r, "user_id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	member, err := api.Store.Guilds.GetGuildMember(ctx, guild.ID, memberID)
+	if err != nil {
+		http.Error(w, "Failed to fetch member", http.StatusInternalServerError)
+		return
+	}
+	if member == nil {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+
+	resp := map[string]interface{}{
+		"member": member,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Avatar and bio are always the member's own to change, the nickname needs
+// the change nickname permission
+func (api *API) UpdateCurrentMember(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	guildAccess := accessFromContext(ctx)
+
+	var req UpdateCurrentMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	member, err := api.Store.Guilds.GetGuildMember(ctx, guildAccess.Guild.ID, userID)
+	if err != nil || member == nil {
+		http.Error(w, "Failed to fetch member", http.StatusInternalServerError)
+		return
+	}
+
+	before := member.GuildMember
+
+	if req.Nickname != nil {
+		// managing everyone's nicknames covers your own
+		canChange := permissions.HasPermission(guildAccess.Permissions, permissions.PermissionChangeNickname) ||
+			permissions.HasPermission(guildAccess.Permissions, permissions.PermissionManageNicknames)
+		if !canChange {
+			http.Error(w, "Missing permissions", http.StatusForbidden)
+			return
+		}
+
+		nickname := strings.TrimSpace(*req.Nickname)
+		if msg := validateNickname(nickname); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+		member.Nickname = nickname
+	}
+
+	if req.AvatarURL != nil {
+		if msg := validateHTTPURL("avatar_url", *req.AvatarURL); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+		member.AvatarURL = *req.AvatarURL
+	}
+
+	if req.Bio != nil {
+		bio := strings.TrimSpace(*req.Bio)
+		if len(bio) > models.MaxMemberBioLength {
+			http.Error(w, fmt.Sprintf("Bio must be at most %d characters", models.MaxMemberBioLength), http.StatusBadRequest)
+			return
+		}
+		member.Bio = bio
+	}
+
+	api.saveMemberProfile(w, r, userID, &before, member)
+}
+
+// Sets or clears another member's nickname. Members with the manage
+// nicknames permission can also rename themselves through here.
+func (api *API) UpdateGuildMember(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	guild := accessFromContext(ctx).Guild
+
+	var req UpdateMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	memberID, err := uuid.Parse(chi.URLParam(r, "user_id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	member, err := api.Store.Guilds.GetGuildMember(ctx, guild.ID, memberID)
+	if err != nil {
+		http.Error(w, "Failed to fetch member", http.StatusInternalServerError)
+		return
+	}
+	if member == nil {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+
+	if memberID != userID {
+		if guild.IsOwner(memberID) {
+			http.Error(w, "Only the guild owner can change their nickname", http.StatusForbidden)
+			return
+		}
+
+		actor, err := api.getRoleActor(ctx, userID)
+		if err != nil {
+			http.Error(w, "Failed to resolve permissions", http.StatusInternalServerError)
+			return
+		}
+
+		targetRoles, err := api.Store.GuildRoles.GetRolesForMember(ctx, guild.ID, memberID)
+		if err != nil {
+			http.Error(w, "Failed to fetch roles", http.StatusInternalServerError)
+			return
+		}
+
+		if !actor.canModerate(targetRoles) {
+			http.Error(w, "You cannot change the nickname of a member whose highest role is at or above yours", http.StatusForbidden)
+			return
+		}
+	}
+
+	before := member.GuildMember
+
+	if req.Nickname != nil {
+		nickname := strings.TrimSpace(*req.Nickname)
+		if msg := validateNickname(nickname); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+		member.Nickname = nickname
+	}
+
+	api.saveMemberProfile(w, r, userID, &before, member)
+}
+
+// Stores the updated profile, audits and announces whatever changed and
+// writes the member back
+func (api *API) saveMemberProfile(w http.ResponseWriter, r *http.Request, userID uuid.UUID, before *models.GuildMember, member *models.GuildMemberProfile) {
+	ctx := r.Context()
+
+	changes := models.DiffAuditChanges(before, &member.GuildMember)
+	if len(changes) > 0 {
+		if err := api.Store.Guilds.UpdateMemberProfile(ctx, &member.GuildMember); err != nil {
+			http.Error(w, "Failed to update member", http.StatusInternalServerError)
+			return
+		}
+
+		entry := models.NewAuditLogEntry(member.GuildID, userID, models.AuditMemberUpdate, models.AuditTargetMember, member.UserID.String())
+		entry.Changes = changes
+		api.audit(r, entry)
+		api.MemberLists.Invalidate(member.GuildID)
+		api.emit(r, member.GuildID, models.SubscriptionEventMemberUpdate, member)
+	}
+
+	resp := map[string]interface{}{
+		"member": member,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Empty clears the nickname
+func validateNickname(nickname string) string {
+	if len(nickname) > models.MaxNicknameLength {
+		return fmt.Sprintf("Nickname must be at most %d characters", models.MaxNicknameLength)
+	}
+	return ""
+}
@@ -1,11 +1,20 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mana/internal/automod"
+	"mana/internal/markdown"
 	"mana/internal/middleware"
 	"mana/internal/models"
+	"mana/internal/permissions"
+	"mana/internal/types"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -37,22 +46,69 @@ func (api *API) CreateMessage(w http.ResponseWriter, r *http.Request) {
 	var input MessageContent
 
 	// get our input message
-	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.Content == "" {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, models.MaxMessageBodySize)).Decode(&input); err != nil || input.Content == "" {
 		http.Error(w, "Invalid message content", http.StatusBadRequest)
 		return
 	}
 
+	// parse markdown, rejects content that's too long or too heavily formatted
+	ast, err := markdown.Parse(input.Content)
+	if err != nil {
+		http.Error(w, markdownErrorMessage(err), http.StatusBadRequest)
+		return
+	}
+
+	// dm channels reuse the same message route
+	channelAccess := accessFromContext(ctx)
+
 	// insert message
 	msg := models.NewMessage(channelID, userID, input.Content)
+	if channelAccess.DM != nil {
+		msg = models.NewDirectMessage(channelID, userID, input.Content)
+	}
+	msg.Bot = middleware.IsBot(ctx)
+	msg.AST = ast
+	msg.PlainText = markdown.PlainText(ast, api.mentionNameResolver(ctx, messageGuildID(channelAccess), ast))
+
+	violations := api.checkAutomod(r, msg, false)
+	if automod.Blocks(violations) {
+		api.enforceAutomod(r, msg, violations, false)
+		http.Error(w, automodBlockedMessage(violations), http.StatusBadRequest)
+		return
+	}
+
 	if err := api.Store.Messages.InsertMessage(ctx, msg); err != nil {
 		http.Error(w, "Failed to send message", http.StatusInternalServerError)
 		return
 	}
 
+	api.dispatch(types.EventReceiveMessage, channelID, msg)
+	if channelAccess.Channel != nil {
+		api.emitInChannel(r, channelAccess.Channel.GuildID, channelID, models.SubscriptionEventMessageCreate, msg)
+	}
+
+	removed := api.enforceAutomod(r, msg, violations, true)
+
+	if !removed && api.canEmbedLinks(ctx, msg, channelAccess.Permissions) {
+		api.Unfurler.Enqueue(msg)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(msg)
 }
 
+// Posts a message on the guild's behalf, failing only logs since whatever
+// prompted it already happened
+func (api *API) postSystemMessage(r *http.Request, guild *models.Guild, msg *models.Message) {
+	if err := api.Store.Messages.InsertMessage(r.Context(), msg); err != nil {
+		log.Printf("Failed to post %s message in guild %s: %v", msg.Type, guild.ID, err)
+		return
+	}
+
+	api.dispatch(types.EventReceiveMessage, msg.ChannelID, msg)
+	api.emitInChannel(r, guild.ID, msg.ChannelID, models.SubscriptionEventMessageCreate, msg)
+}
+
 func (api *API) GetMessagesByChannel(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
@@ -95,6 +151,368 @@ func (api *API) GetMessagesByChannel(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(messages)
 }
 
-// func (api *API) DeleteMessage(w http.ResponseWriter, r *http.Request) {}
+func (api *API) SearchMessages(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	channelID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" || len(query) > 200 {
+		http.Error(w, "Search query must be between 1 and 200 characters", http.StatusBadRequest)
+		return
+	}
+
+	limit := 25
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 100 {
+			limit = parsed
+		}
+	}
+
+	messages, err := api.Store.Messages.SearchMessages(ctx, channelID, query, limit)
+	if err != nil {
+		http.Error(w, "Failed to search messages", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(messages)
+}
+
+// Resolves mentions in nodes to names for the plain text projection.
+// Each mention type is looked up in one query up front. Roles and
+// channels only resolve within guildID, uuid.Nil for DMs, so a message
+// can't leak names from guilds it wasn't sent in.
+func (api *API) mentionNameResolver(ctx context.Context, guildID uuid.UUID, nodes []*markdown.Node) markdown.NameResolver {
+	var userIDs, roleIDs, channelIDs []uuid.UUID
+	var collect func(nodes []*markdown.Node)
+	collect = func(nodes []*markdown.Node) {
+		for _, node := range nodes {
+			collect(node.Children)
+			id, err := uuid.Parse(node.ID)
+			if err != nil {
+				continue
+			}
+			switch node.Type {
+			case markdown.NodeUserMention:
+				userIDs = append(userIDs, id)
+			case markdown.NodeRoleMention:
+				roleIDs = append(roleIDs, id)
+			case markdown.NodeChannelMention:
+				channelIDs = append(channelIDs, id)
+			}
+		}
+	}
+	collect(nodes)
+
+	var usernames, roleNames, channelNames map[uuid.UUID]string
+	if len(userIDs) > 0 {
+		usernames, _ = api.Store.Users.GetUsernames(ctx, userIDs)
+	}
+	if guildID != uuid.Nil && len(roleIDs) > 0 {
+		roleNames, _ = api.Store.GuildRoles.GetRoleNames(ctx, guildID, roleIDs)
+	}
+	if guildID != uuid.Nil && len(channelIDs) > 0 {
+		channelNames, _ = api.Store.GuildChannels.GetChannelNames(ctx, guildID, channelIDs)
+	}
+
+	return func(node *markdown.Node) string {
+		id, err := uuid.Parse(node.ID)
+		if err != nil {
+			return ""
+		}
+
+		switch node.Type {
+		case markdown.NodeUserMention:
+			return usernames[id]
+		case markdown.NodeRoleMention:
+			return roleNames[id]
+		case markdown.NodeChannelMention:
+			return channelNames[id]
+		}
+		return ""
+	}
+}
+
+// The guild a message is sent in, uuid.Nil in DMs
+func messageGuildID(channelAccess *access) uuid.UUID {
+	if channelAccess.Guild == nil {
+		return uuid.Nil
+	}
+	return channelAccess.Guild.ID
+}
+
+func markdownErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, markdown.ErrContentTooLong):
+		return fmt.Sprintf("Message content must be at most %d characters", markdown.MaxContentLength)
+	case errors.Is(err, markdown.ErrRenderedTooLong):
+		return fmt.Sprintf("Message must be at most %d characters once formatted", markdown.MaxRenderedLength)
+	case errors.Is(err, markdown.ErrTooComplex):
+		return "Message has too much formatting"
+	default:
+		return "Invalid message content"
+	}
+}
+
+// Checks if links in a message should be unfurled: the author hasn't turned
+// embeds off and has the embed links permission in the channel
+func (api *API) canEmbedLinks(ctx context.Context, msg *models.Message, perms uint64) bool {
+	if !permissions.HasPermission(perms, permissions.PermissionEmbedLinks) {
+		return false
+	}
+
+	if msg.AuthorID == nil {
+		return true
+	}
+
+	author, err := api.Store.Users.GetUserByID(ctx, *msg.AuthorID)
+	return err == nil && author != nil && !author.SuppressEmbeds
+}
+
+type BulkDeleteMessagesRequest struct {
+	MessageIDs []uuid.UUID `json:"message_ids"`
+	AuthorID   *uuid.UUID  `json:"author_id"`
+	After      *time.Time  `json:"after"`
+	Before     *time.Time  `json:"before"`
+	Contains   string      `json:"contains"`
+	Reason     string      `json:"reason"`
+}
+
+func (api *API) DeleteMessage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	channelID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+
+	messageID, err := uuid.Parse(chi.URLParam(r, "message_id"))
+	if err != nil {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	msg, err := api.Store.Messages.GetMessageByID(ctx, messageID)
+	if err != nil {
+		http.Error(w, "Failed to fetch message", http.StatusInternalServerError)
+		return
+	}
+	if msg == nil || msg.ChannelID != channelID {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+
+	// authors can always delete their own, otherwise it takes manage messages
+	isAuthor := msg.AuthorID != nil && *msg.AuthorID == userID
+	if !isAuthor && !permissions.HasPermission(accessFromContext(ctx).Permissions, permissions.PermissionManageMessages) {
+		http.Error(w, "You do not have permission to delete this message", http.StatusForbidden)
+		return
+	}
+
+	if err := api.Store.Messages.DeleteMessage(ctx, messageID); err != nil {
+		http.Error(w, "Failed to delete message", http.StatusInternalServerError)
+		return
+	}
+
+	payload := types.MessageDeletePayload{ID: messageID, ChannelID: channelID}
+	api.dispatch(types.EventMessageDelete, channelID, payload)
+	if channel := accessFromContext(ctx).Channel; channel != nil {
+		api.emitInChannel(r, channel.GuildID, channelID, models.SubscriptionEventMessageDelete, payload)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Deletes up to models.MaxBulkDeleteMessages messages by id and/or filters.
+// With no filters it purges the newest messages in the channel.
+func (api *API) BulkDeleteMessages(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	channelID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req BulkDeleteMessagesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
 
-//func (api *API) EditMessage(w http.ResponseWriter, r *http.Request) {}
+	if len(req.MessageIDs) > models.MaxBulkDeleteMessages {
+		http.Error(w, fmt.Sprintf("Cannot delete more than %d messages at once", models.MaxBulkDeleteMessages), http.StatusBadRequest)
+		return
+	}
+
+	if req.After != nil && req.Before != nil && !req.After.Before(*req.Before) {
+		http.Error(w, "after must be earlier than before", http.StatusBadRequest)
+		return
+	}
+
+	req.Contains = strings.TrimSpace(req.Contains)
+	if len(req.Contains) > 200 {
+		http.Error(w, "contains must be at most 200 characters", http.StatusBadRequest)
+		return
+	}
+
+	req.Reason = strings.TrimSpace(req.Reason)
+	if len(req.Reason) > models.MaxModerationReasonLength {
+		http.Error(w, fmt.Sprintf("Reason must be at most %d characters", models.MaxModerationReasonLength), http.StatusBadRequest)
+		return
+	}
+
+	// manage messages is checked by the route, dms never have it
+	channel := accessFromContext(ctx).Channel
+
+	filter := &models.MessageFilter{
+		MessageIDs: req.MessageIDs,
+		AuthorID:   req.AuthorID,
+		After:      req.After,
+		Before:     req.Before,
+		Contains:   req.Contains,
+	}
+
+	action := models.NewModerationAction(channel.GuildID, userID, models.ModerationActionMessageBulkDelete, req.Reason)
+	action.ChannelID = &channel.ID
+	action.TargetUserID = req.AuthorID
+
+	deletedIDs, err := api.Store.Messages.BulkDeleteMessages(ctx, channelID, filter, action)
+	if err != nil {
+		http.Error(w, "Failed to delete messages", http.StatusInternalServerError)
+		return
+	}
+
+	if len(deletedIDs) > 0 {
+		payload := types.MessageDeleteBulkPayload{IDs: deletedIDs, ChannelID: channelID}
+		api.dispatch(types.EventMessageDeleteBulk, channelID, payload)
+		api.emitInChannel(r, channel.GuildID, channelID, models.SubscriptionEventMessageDeleteBulk, payload)
+
+		entry := models.NewAuditLogEntry(channel.GuildID, userID, models.AuditMessageBulkDelete, models.AuditTargetChannel, channel.ID.String())
+		entry.ChannelID = &channel.ID
+		entry.Reason = req.Reason
+		entry.Changes = []models.AuditChange{{Key: "messages_deleted", New: models.AuditValue(len(deletedIDs))}}
+		if req.AuthorID != nil {
+			entry.TargetType = models.AuditTargetMember
+			entry.TargetID = req.AuthorID.String()
+		}
+		api.audit(r, entry)
+	}
+
+	resp := map[string]interface{}{
+		"deleted": deletedIDs,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Replaces the content of one of the caller's own messages. The edit goes
+// through automod like a new message, embeds are resolved again.
+func (api *API) EditMessage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	channelID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+
+	messageID, err := uuid.Parse(chi.URLParam(r, "message_id"))
+	if err != nil {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var input MessageContent
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, models.MaxMessageBodySize)).Decode(&input); err != nil || input.Content == "" {
+		http.Error(w, "Invalid message content", http.StatusBadRequest)
+		return
+	}
+
+	ast, err := markdown.Parse(input.Content)
+	if err != nil {
+		http.Error(w, markdownErrorMessage(err), http.StatusBadRequest)
+		return
+	}
+
+	msg, err := api.Store.Messages.GetMessageByID(ctx, messageID)
+	if err != nil {
+		http.Error(w, "Failed to fetch message", http.StatusInternalServerError)
+		return
+	}
+	if msg == nil || msg.ChannelID != channelID {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+
+	// system and webhook messages have no author to edit them
+	if msg.AuthorID == nil || *msg.AuthorID != userID || msg.Type != models.MessageTypeDefault {
+		http.Error(w, "You can only edit your own messages", http.StatusForbidden)
+		return
+	}
+
+	if msg.Content == input.Content {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(msg)
+		return
+	}
+
+	editedAt := time.Now().UTC()
+	msg.Content = input.Content
+	msg.AST = ast
+	msg.PlainText = markdown.PlainText(ast, api.mentionNameResolver(ctx, messageGuildID(accessFromContext(ctx)), ast))
+	msg.Embeds = nil
+	msg.EditedAt = &editedAt
+
+	violations := api.checkAutomod(r, msg, true)
+	if automod.Blocks(violations) {
+		api.enforceAutomod(r, msg, violations, false)
+		http.Error(w, automodBlockedMessage(violations), http.StatusBadRequest)
+		return
+	}
+
+	if err := api.Store.Messages.UpdateMessageContent(ctx, msg); err != nil {
+		http.Error(w, "Failed to edit message", http.StatusInternalServerError)
+		return
+	}
+
+	channelAccess := accessFromContext(ctx)
+	api.dispatch(types.EventMessageUpdate, channelID, msg)
+	if channelAccess.Channel != nil {
+		api.emitInChannel(r, channelAccess.Channel.GuildID, channelID, models.SubscriptionEventMessageUpdate, msg)
+	}
+
+	removed := api.enforceAutomod(r, msg, violations, true)
+
+	if !removed && api.canEmbedLinks(ctx, msg, channelAccess.Permissions) {
+		api.Unfurler.Enqueue(msg)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(msg)
+}
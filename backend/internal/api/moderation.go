@@ -0,0 +1,371 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mana/internal/middleware"
+	"mana/internal/models"
+	"mana/internal/permissions"
+	"mana/internal/types"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type ModerationRequest struct {
+	Reason string `json:"reason"`
+}
+
+type BanMemberRequest struct {
+	Reason               string `json:"reason"`
+	DeleteMessageSeconds int    `json:"delete_message_seconds"` // purges their messages this far back
+}
+
+type TimeoutMemberRequest struct {
+	Reason   string `json:"reason"`
+	Duration int    `json:"duration"` // seconds
+}
+
+func (api *API) KickMember(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	guild := accessFromContext(ctx).Guild
+
+	var req ModerationRequest
+	if !decodeModerationRequest(w, r, &req, &req.Reason) {
+		return
+	}
+
+	targetID, ok := api.loadModerationTarget(w, r, userID, true)
+	if !ok {
+		return
+	}
+
+	// a bot takes its role with it
+	managedRole, err := api.Store.GuildRoles.GetManagedRole(ctx, guild.ID, targetID)
+	if err != nil {
+		http.Error(w, "Failed to fetch roles", http.StatusInternalServerError)
+		return
+	}
+
+	action := models.NewModerationAction(guild.ID, userID, models.ModerationActionKick, req.Reason)
+	action.TargetUserID = &targetID
+
+	if err := api.Store.Moderation.KickMember(ctx, guild.ID, targetID, action); err != nil {
+		http.Error(w, "Failed to kick member", http.StatusInternalServerError)
+		return
+	}
+
+	entry := models.NewAuditLogEntry(guild.ID, userID, models.AuditMemberKick, models.AuditTargetMember, targetID.String())
+	entry.Reason = req.Reason
+	api.audit(r, entry)
+	api.disconnectFromGuild(ctx, guild.ID, targetID)
+	api.MemberLists.Invalidate(guild.ID)
+	api.emit(r, guild.ID, models.SubscriptionEventMemberRemove, map[string]interface{}{"user_id": targetID, "reason": req.Reason})
+	if managedRole != nil {
+		api.emit(r, guild.ID, models.SubscriptionEventRoleDelete, managedRole)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *API) GetGuildBans(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	guild := accessFromContext(ctx).Guild
+
+	bans, err := api.Store.Moderation.GetBansForGuild(ctx, guild.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch bans", http.StatusInternalServerError)
+		return
+	}
+	if bans == nil {
+		bans = []*models.GuildBan{}
+	}
+
+	resp := map[string]interface{}{
+		"bans": bans,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Users who already left can be banned too, keeping them from coming back
+func (api *API) BanMember(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	guild := accessFromContext(ctx).Guild
+
+	var req BanMemberRequest
+	if !decodeModerationRequest(w, r, &req, &req.Reason) {
+		return
+	}
+
+	purgeWindow := time.Duration(req.DeleteMessageSeconds) * time.Second
+	if purgeWindow < 0 || purgeWindow > models.MaxBanPurgeWindow {
+		http.Error(w, "delete_message_seconds must be between 0 and 604800", http.StatusBadRequest)
+		return
+	}
+
+	targetID, ok := api.loadModerationTarget(w, r, userID, false)
+	if !ok {
+		return
+	}
+
+	target, err := api.Store.Users.GetUserByID(ctx, targetID)
+	if err != nil {
+		http.Error(w, "Failed to fetch user", http.StatusInternalServerError)
+		return
+	}
+	if target == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	managedRole, err := api.Store.GuildRoles.GetManagedRole(ctx, guild.ID, targetID)
+	if err != nil {
+		http.Error(w, "Failed to fetch roles", http.StatusInternalServerError)
+		return
+	}
+
+	var purgeSince *time.Time
+	if purgeWindow > 0 {
+		since := time.Now().UTC().Add(-purgeWindow)
+		purgeSince = &since
+	}
+
+	ban := models.NewGuildBan(guild.ID, targetID, userID, req.Reason)
+	action := models.NewModerationAction(guild.ID, userID, models.ModerationActionBan, req.Reason)
+	action.TargetUserID = &targetID
+
+	deleted, err := api.Store.Moderation.BanMember(ctx, ban, purgeSince, action)
+	if err != nil {
+		http.Error(w, "Failed to ban member", http.StatusInternalServerError)
+		return
+	}
+
+	purged := 0
+	for channelID, messageIDs := range deleted {
+		purged += len(messageIDs)
+		api.dispatch(types.EventMessageDeleteBulk, channelID, types.MessageDeleteBulkPayload{IDs: messageIDs, ChannelID: channelID})
+	}
+
+	entry := models.NewAuditLogEntry(guild.ID, userID, models.AuditMemberBan, models.AuditTargetMember, targetID.String())
+	entry.Reason = req.Reason
+	if purged > 0 {
+		entry.Changes = []models.AuditChange{{Key: "messages_deleted", New: models.AuditValue(purged)}}
+	}
+	api.audit(r, entry)
+	api.disconnectFromGuild(ctx, guild.ID, targetID)
+	api.MemberLists.Invalidate(guild.ID)
+	api.emit(r, guild.ID, models.SubscriptionEventMemberBan, ban)
+	if managedRole != nil {
+		api.emit(r, guild.ID, models.SubscriptionEventRoleDelete, managedRole)
+	}
+
+	resp := map[string]interface{}{
+		"ban": ban,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (api *API) UnbanMember(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	guild := accessFromContext(ctx).Guild
+
+	var req ModerationRequest
+	if !decodeModerationRequest(w, r, &req, &req.Reason) {
+		return
+	}
+
+	targetID, err := uuid.Parse(chi.URLParam(r, "user_id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	action := models.NewModerationAction(guild.ID, userID, models.ModerationActionUnban, req.Reason)
+	action.TargetUserID = &targetID
+
+	unbanned, err := api.Store.Moderation.UnbanMember(ctx, guild.ID, targetID, action)
+	if err != nil {
+		http.Error(w, "Failed to unban user", http.StatusInternalServerError)
+		return
+	}
+	if !unbanned {
+		http.Error(w, "Ban not found", http.StatusNotFound)
+		return
+	}
+
+	entry := models.NewAuditLogEntry(guild.ID, userID, models.AuditMemberUnban, models.AuditTargetMember, targetID.String())
+	entry.Reason = req.Reason
+	api.audit(r, entry)
+	api.emit(r, guild.ID, models.SubscriptionEventMemberUnban, map[string]interface{}{"user_id": targetID, "reason": req.Reason})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *API) TimeoutMember(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	guild := accessFromContext(ctx).Guild
+
+	var req TimeoutMemberRequest
+	if !decodeModerationRequest(w, r, &req, &req.Reason) {
+		return
+	}
+
+	duration := time.Duration(req.Duration) * time.Second
+	if duration <= 0 || duration > models.MaxTimeoutDuration {
+		http.Error(w, "Duration must be between 1 and 2419200 seconds", http.StatusBadRequest)
+		return
+	}
+
+	targetID, ok := api.loadModerationTarget(w, r, userID, true)
+	if !ok {
+		return
+	}
+
+	// their permissions would ignore the timeout anyway
+	targetPerms, err := api.resolveGuildPermissions(ctx, guild, targetID)
+	if err != nil {
+		http.Error(w, "Failed to resolve permissions", http.StatusInternalServerError)
+		return
+	}
+	if permissions.HasPermission(targetPerms, permissions.PermissionAdministrator) {
+		http.Error(w, "Administrators cannot be timed out", http.StatusBadRequest)
+		return
+	}
+
+	until := time.Now().UTC().Add(duration)
+	action := models.NewModerationAction(guild.ID, userID, models.ModerationActionTimeout, req.Reason)
+	action.TargetUserID = &targetID
+	action.Metadata, _ = json.Marshal(map[string]interface{}{"until": until})
+
+	if err := api.Store.Moderation.SetMemberTimeout(ctx, guild.ID, targetID, &until, action); err != nil {
+		http.Error(w, "Failed to time out member", http.StatusInternalServerError)
+		return
+	}
+
+	entry := models.NewAuditLogEntry(guild.ID, userID, models.AuditMemberTimeout, models.AuditTargetMember, targetID.String())
+	entry.Reason = req.Reason
+	entry.Changes = []models.AuditChange{{Key: "timeout_until", New: models.AuditValue(until)}}
+	api.audit(r, entry)
+
+	resp := map[string]interface{}{
+		"user_id":       targetID,
+		"timeout_until": until,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (api *API) RemoveMemberTimeout(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	guild := accessFromContext(ctx).Guild
+
+	var req ModerationRequest
+	if !decodeModerationRequest(w, r, &req, &req.Reason) {
+		return
+	}
+
+	targetID, ok := api.loadModerationTarget(w, r, userID, true)
+	if !ok {
+		return
+	}
+
+	action := models.NewModerationAction(guild.ID, userID, models.ModerationActionTimeoutRemove, req.Reason)
+	action.TargetUserID = &targetID
+
+	if err := api.Store.Moderation.SetMemberTimeout(ctx, guild.ID, targetID, nil, action); err != nil {
+		http.Error(w, "Failed to remove timeout", http.StatusInternalServerError)
+		return
+	}
+
+	entry := models.NewAuditLogEntry(guild.ID, userID, models.AuditMemberTimeoutRemove, models.AuditTargetMember, targetID.String())
+	entry.Reason = req.Reason
+	api.audit(r, entry)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Parses {user_id} and checks the caller ranks above them, writing the error
+// response itself when they don't. Non-members can only be targeted when
+// requireMember is false, there's no hierarchy to check for them.
+func (api *API) loadModerationTarget(w http.ResponseWriter, r *http.Request, userID uuid.UUID, requireMember bool) (uuid.UUID, bool) {
+	ctx := r.Context()
+	guild := accessFromContext(ctx).Guild
+
+	targetID, err := uuid.Parse(chi.URLParam(r, "user_id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return uuid.Nil, false
+	}
+
+	if targetID == userID {
+		http.Error(w, "You cannot moderate yourself", http.StatusBadRequest)
+		return uuid.Nil, false
+	}
+	if guild.IsOwner(targetID) {
+		http.Error(w, "The guild owner cannot be moderated", http.StatusForbidden)
+		return uuid.Nil, false
+	}
+
+	isMember, err := api.Store.Guilds.CheckUserMemberOfGuild(ctx, guild.ID, targetID)
+	if err != nil {
+		http.Error(w, "Failed to check membership", http.StatusInternalServerError)
+		return uuid.Nil, false
+	}
+	if !isMember {
+		if requireMember {
+			http.Error(w, "Member not found", http.StatusNotFound)
+			return uuid.Nil, false
+		}
+		return targetID, true
+	}
+
+	actor, err := api.getRoleActor(ctx, userID)
+	if err != nil {
+		http.Error(w, "Failed to resolve permissions", http.StatusInternalServerError)
+		return uuid.Nil, false
+	}
+
+	targetRoles, err := api.Store.GuildRoles.GetRolesForMember(ctx, guild.ID, targetID)
+	if err != nil {
+		http.Error(w, "Failed to fetch roles", http.StatusInternalServerError)
+		return uuid.Nil, false
+	}
+
+	if !actor.canModerate(targetRoles) {
+		http.Error(w, "You cannot moderate a member whose highest role is at or above yours", http.StatusForbidden)
+		return uuid.Nil, false
+	}
+
+	return targetID, true
+}
+
+// Moderation bodies are optional, DELETE requests usually have none. reason
+// points into req and is trimmed and length checked.
+func decodeModerationRequest(w http.ResponseWriter, r *http.Request, req any, reason *string) bool {
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil && err != io.EOF {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return false
+	}
+
+	*reason = strings.TrimSpace(*reason)
+	if len(*reason) > models.MaxModerationReasonLength {
+		http.Error(w, fmt.Sprintf("Reason must be at most %d characters", models.MaxModerationReasonLength), http.StatusBadRequest)
+		return false
+	}
+
+	return true
+}
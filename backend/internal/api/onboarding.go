@@ -0,0 +1,482 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"mana/internal/markdown"
+	"mana/internal/middleware"
+	"mana/internal/models"
+	"mana/internal/permissions"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Replaces the whole onboarding. Prompts and options without an id get a
+// fresh one, keeping the old ids keeps members' earlier answers meaningful.
+type UpdateOnboardingRequest struct {
+	ScreeningEnabled bool                      `json:"screening_enabled"`
+	Rules            string                    `json:"rules"`
+	Prompts          []models.OnboardingPrompt `json:"prompts"`
+	WelcomeChannelID *uuid.UUID                `json:"welcome_channel_id"`
+	WelcomeMessage   string                    `json:"welcome_message"`
+}
+
+type CompleteOnboardingRequest struct {
+	AcceptRules bool                      `json:"accept_rules"` // required while pending
+	Responses   map[uuid.UUID][]uuid.UUID `json:"responses"`    // prompt id to the option ids picked
+}
+
+func (api *API) GetGuildOnboarding(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	guild := accessFromContext(ctx).Guild
+
+	onboarding, err := api.getOnboarding(r, guild.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch onboarding", http.StatusInternalServerError)
+		return
+	}
+
+	resp := map[string]interface{}{
+		"onboarding": onboarding,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Handing out roles through prompts also needs manage roles, and only roles
+// below the caller's highest role can be offered
+func (api *API) UpdateGuildOnboarding(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	guildAccess := accessFromContext(ctx)
+	guild := guildAccess.Guild
+
+	var req UpdateOnboardingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	before, err := api.getOnboarding(r, guild.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch onboarding", http.StatusInternalServerError)
+		return
+	}
+
+	onboarding := &models.GuildOnboarding{
+		GuildID:          guild.ID,
+		ScreeningEnabled: req.ScreeningEnabled,
+		Rules:            strings.TrimSpace(req.Rules),
+		Prompts:          req.Prompts,
+		WelcomeMessage:   strings.TrimSpace(req.WelcomeMessage),
+		UpdatedAt:        time.Now().UTC(),
+	}
+	if onboarding.Prompts == nil {
+		onboarding.Prompts = []models.OnboardingPrompt{}
+	}
+
+	if len(onboarding.Rules) > models.MaxOnboardingRulesLength {
+		http.Error(w, fmt.Sprintf("Rules must be at most %d characters", models.MaxOnboardingRulesLength), http.StatusBadRequest)
+		return
+	}
+	if onboarding.ScreeningEnabled && onboarding.Rules == "" {
+		http.Error(w, "Screening needs rules to accept", http.StatusBadRequest)
+		return
+	}
+
+	if msg := validateOnboardingPrompts(onboarding.Prompts); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	if !api.checkOnboardingRoles(w, r, userID, onboarding.Prompts) {
+		return
+	}
+
+	if req.WelcomeChannelID != nil {
+		channel, err := api.Store.GuildChannels.GetChannelByID(ctx, *req.WelcomeChannelID)
+		if err != nil {
+			http.Error(w, "Failed to fetch channel", http.StatusInternalServerError)
+			return
+		}
+		if channel == nil || channel.GuildID != guild.ID {
+			http.Error(w, "Welcome channel not found", http.StatusNotFound)
+			return
+		}
+		if channel.Type != models.ChannelTypeText {
+			http.Error(w, "Welcome channel must be a text channel", http.StatusBadRequest)
+			return
+		}
+		onboarding.WelcomeChannelID = &channel.ID
+	}
+
+	if len(onboarding.WelcomeMessage) > models.MaxWelcomeMessageLength {
+		http.Error(w, fmt.Sprintf("Welcome message must be at most %d characters", models.MaxWelcomeMessageLength), http.StatusBadRequest)
+		return
+	}
+	if onboarding.WelcomeChannelID != nil {
+		if onboarding.WelcomeMessage == "" {
+			http.Error(w, "A welcome channel needs a welcome message", http.StatusBadRequest)
+			return
+		}
+		if _, err := markdown.Parse(onboarding.RenderWelcome(userID)); err != nil {
+			http.Error(w, markdownErrorMessage(err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := api.Store.Onboarding.SetOnboarding(ctx, onboarding); err != nil {
+		http.Error(w, "Failed to update onboarding", http.StatusInternalServerError)
+		return
+	}
+
+	entry := models.NewAuditLogEntry(guild.ID, userID, models.AuditOnboardingUpdate, models.AuditTargetGuild, guild.ID.String())
+	entry.Changes = models.DiffAuditChanges(before, onboarding)
+	api.audit(r, entry)
+
+	resp := map[string]interface{}{
+		"onboarding": onboarding,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Accepts the rules and answers prompts. Pending members have to accept and
+// answer every required prompt to be let in, members already in can come
+// back to change their answers.
+func (api *API) CompleteOnboarding(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	guild := accessFromContext(ctx).Guild
+
+	var req CompleteOnboardingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	onboarding, err := api.getOnboarding(r, guild.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch onboarding", http.StatusInternalServerError)
+		return
+	}
+
+	member, err := api.Store.Guilds.GetGuildMember(ctx, guild.ID, userID)
+	if err != nil || member == nil {
+		http.Error(w, "Failed to fetch member", http.StatusInternalServerError)
+		return
+	}
+
+	if member.Pending && !req.AcceptRules {
+		http.Error(w, "You must accept the rules to join", http.StatusBadRequest)
+		return
+	}
+
+	if msg := validateOnboardingResponses(onboarding, req.Responses, member.Pending); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	roles, err := api.Store.GuildRoles.GetRolesForGuild(ctx, guild.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch roles", http.StatusInternalServerError)
+		return
+	}
+
+	// prompts can still point at roles deleted since, or edited since to
+	// grant administrator or handed to a bot
+	assignable := make(map[uuid.UUID]bool, len(roles))
+	for _, role := range roles {
+		if role.Position == models.MaxRoles || role.BotID != nil {
+			continue
+		}
+		if permissions.HasPermission(role.Permissions, permissions.PermissionAdministrator) {
+			continue
+		}
+		assignable[role.ID] = true
+	}
+
+	// only what actually changed is audited, answering again with the same
+	// picks changes nothing. Un-picking only takes back roles the member
+	// picked, not ones someone else gave them.
+	add, remove := onboarding.ResolveRoles(req.Responses)
+	for _, roleID := range add {
+		if !assignable[roleID] {
+			continue
+		}
+		added, err := api.Store.GuildRoles.SelfAssignRoleToMember(ctx, guild.ID, userID, roleID)
+		if err != nil {
+			http.Error(w, "Failed to update member roles", http.StatusInternalServerError)
+			return
+		}
+		if added {
+			api.auditOnboardingRole(r, guild.ID, userID, roleID, true)
+		}
+	}
+	rolesRemoved := false
+	for _, roleID := range remove {
+		removed, err := api.Store.GuildRoles.RemoveSelfAssignedRole(ctx, guild.ID, userID, roleID)
+		if err != nil {
+			http.Error(w, "Failed to update member roles", http.StatusInternalServerError)
+			return
+		}
+		if removed {
+			rolesRemoved = true
+			api.auditOnboardingRole(r, guild.ID, userID, roleID, false)
+		}
+	}
+	if rolesRemoved {
+		api.recheckGuildAccess(ctx, guild, userID)
+	}
+
+	if member.Pending {
+		admitted, err := api.Store.Guilds.AdmitMember(ctx, guild.ID, userID)
+		if err != nil {
+			http.Error(w, "Failed to admit member", http.StatusInternalServerError)
+			return
+		}
+
+		// a second request racing this one let them in already
+		if admitted {
+			api.welcomeMember(r, guild, onboarding, userID)
+		}
+	}
+
+	member, err = api.Store.Guilds.GetGuildMember(ctx, guild.ID, userID)
+	if err != nil || member == nil {
+		http.Error(w, "Failed to fetch member", http.StatusInternalServerError)
+		return
+	}
+
+	api.MemberLists.Invalidate(guild.ID)
+	api.emit(r, guild.ID, models.SubscriptionEventMemberUpdate, member)
+
+	resp := map[string]interface{}{
+		"member": member,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// The guild's onboarding, or the default for guilds that never set it up
+func (api *API) getOnboarding(r *http.Request, guildID uuid.UUID) (*models.GuildOnboarding, error) {
+	onboarding, err := api.Store.Onboarding.GetOnboarding(r.Context(), guildID)
+	if err != nil {
+		return nil, err
+	}
+	if onboarding == nil {
+		onboarding = models.NewGuildOnboarding(guildID)
+	}
+	return onboarding, nil
+}
+
+// Posts the welcome message, if the guild has one, for a member who was just let in
+func (api *API) welcomeMember(r *http.Request, guild *models.Guild, onboarding *models.GuildOnboarding, userID uuid.UUID) {
+	content := onboarding.RenderWelcome(userID)
+	if content == "" {
+		return
+	}
+
+	// the message was valid when saved, this only fails if limits changed since
+	ast, err := markdown.Parse(content)
+	if err != nil {
+		log.Printf("Skipping welcome message in guild %s: %v", guild.ID, err)
+		return
+	}
+
+	msg := models.NewSystemMessage(*onboarding.WelcomeChannelID, userID, models.MessageTypeMemberWelcome)
+	msg.Content = content
+	msg.AST = ast
+	msg.PlainText = markdown.PlainText(ast, api.mentionNameResolver(r.Context(), guild.ID, ast))
+
+	api.postSystemMessage(r, guild, msg)
+}
+
+func (api *API) auditOnboardingRole(r *http.Request, guildID uuid.UUID, userID uuid.UUID, roleID uuid.UUID, added bool) {
+	entry := models.NewAuditLogEntry(guildID, userID, models.AuditMemberRoleAdd, models.AuditTargetMember, userID.String())
+	entry.Reason = "Onboarding"
+	change := models.AuditChange{Key: "role_id", New: models.AuditValue(roleID)}
+	event := models.SubscriptionEventMemberRoleAdd
+
+	if !added {
+		entry.Action = models.AuditMemberRoleRemove
+		change = models.AuditChange{Key: "role_id", Old: models.AuditValue(roleID)}
+		event = models.SubscriptionEventMemberRoleRemove
+	}
+
+	entry.Changes = []models.AuditChange{change}
+	api.audit(r, entry)
+	api.emit(r, guildID, event, map[string]interface{}{"user_id": userID, "role_id": roleID})
+}
+
+// Every role an option hands out has to exist, sit below the caller and
+// not make whoever picks it an administrator. Writes the error response
+// itself when one can't be offered.
+func (api *API) checkOnboardingRoles(w http.ResponseWriter, r *http.Request, userID uuid.UUID, prompts []models.OnboardingPrompt) bool {
+	ctx := r.Context()
+	guildAccess := accessFromContext(ctx)
+
+	var roleIDs []uuid.UUID
+	for _, prompt := range prompts {
+		for _, option := range prompt.Options {
+			roleIDs = append(roleIDs, option.RoleIDs...)
+		}
+	}
+	if len(roleIDs) == 0 {
+		return true
+	}
+
+	if !permissions.HasPermission(guildAccess.Permissions, permissions.PermissionManageRoles) {
+		http.Error(w, "Missing permissions", http.StatusForbidden)
+		return false
+	}
+
+	actor, err := api.getRoleActor(ctx, userID)
+	if err != nil {
+		http.Error(w, "Failed to resolve permissions", http.StatusInternalServerError)
+		return false
+	}
+
+	for _, roleID := range roleIDs {
+		role, err := api.Store.GuildRoles.GetRoleByID(ctx, guildAccess.Guild.ID, roleID)
+		if err != nil {
+			http.Error(w, "Failed to fetch role", http.StatusInternalServerError)
+			return false
+		}
+		if role == nil {
+			http.Error(w, fmt.Sprintf("Role %s not found", roleID), http.StatusNotFound)
+			return false
+		}
+		if role.Position == models.MaxRoles {
+			http.Error(w, "The everyone role cannot be handed out", http.StatusBadRequest)
+			return false
+		}
+		if role.BotID != nil {
+			http.Error(w, fmt.Sprintf("Role %s belongs to a bot and cannot be handed out", role.Name), http.StatusBadRequest)
+			return false
+		}
+		if !actor.canManage(role) {
+			http.Error(w, "You cannot offer a role at or above your highest role", http.StatusForbidden)
+			return false
+		}
+		if permissions.HasPermission(role.Permissions, permissions.PermissionAdministrator) {
+			http.Error(w, fmt.Sprintf("Role %s grants administrator and cannot be self-assigned", role.Name), http.StatusBadRequest)
+			return false
+		}
+	}
+
+	return true
+}
+
+// Checks prompt and option text and gives missing ids a fresh one. Returns
+// an error message, empty if the prompts are valid.
+func validateOnboardingPrompts(prompts []models.OnboardingPrompt) string {
+	if len(prompts) > models.MaxOnboardingPrompts {
+		return fmt.Sprintf("Onboarding can have at most %d prompts", models.MaxOnboardingPrompts)
+	}
+
+	ids := make(map[uuid.UUID]bool)
+	for i := range prompts {
+		prompt := &prompts[i]
+
+		if prompt.ID == uuid.Nil {
+			prompt.ID = uuid.New()
+		}
+		if ids[prompt.ID] {
+			return fmt.Sprintf("Prompt id %s is used more than once", prompt.ID)
+		}
+		ids[prompt.ID] = true
+
+		prompt.Title = strings.TrimSpace(prompt.Title)
+		if prompt.Title == "" || len(prompt.Title) > models.MaxOnboardingTitleLength {
+			return fmt.Sprintf("Prompt %d: title must be between 1 and %d characters", i+1, models.MaxOnboardingTitleLength)
+		}
+
+		if len(prompt.Options) == 0 || len(prompt.Options) > models.MaxOnboardingPromptOptions {
+			return fmt.Sprintf("Prompt %d: must have between 1 and %d options", i+1, models.MaxOnboardingPromptOptions)
+		}
+
+		for j := range prompt.Options {
+			option := &prompt.Options[j]
+
+			if option.ID == uuid.Nil {
+				option.ID = uuid.New()
+			}
+			if ids[option.ID] {
+				return fmt.Sprintf("Option id %s is used more than once", option.ID)
+			}
+			ids[option.ID] = true
+
+			option.Label = strings.TrimSpace(option.Label)
+			if option.Label == "" || len(option.Label) > models.MaxOnboardingOptionLabelLength {
+				return fmt.Sprintf("Prompt %d option %d: label must be between 1 and %d characters", i+1, j+1, models.MaxOnboardingOptionLabelLength)
+			}
+
+			option.Description = strings.TrimSpace(option.Description)
+			if len(option.Description) > models.MaxOnboardingOptionDescLength {
+				return fmt.Sprintf("Prompt %d option %d: description must be at most %d characters", i+1, j+1, models.MaxOnboardingOptionDescLength)
+			}
+
+			if option.RoleIDs == nil {
+				option.RoleIDs = []uuid.UUID{}
+			}
+			if len(option.RoleIDs) > models.MaxOnboardingOptionRoles {
+				return fmt.Sprintf("Prompt %d option %d: can hand out at most %d roles", i+1, j+1, models.MaxOnboardingOptionRoles)
+			}
+		}
+	}
+
+	return ""
+}
+
+// Answers have to pick options of the prompt they answer, one at most for
+// single select prompts. Members joining have to answer required prompts.
+func validateOnboardingResponses(onboarding *models.GuildOnboarding, responses map[uuid.UUID][]uuid.UUID, joining bool) string {
+	prompts := make(map[uuid.UUID]*models.OnboardingPrompt, len(onboarding.Prompts))
+	for i := range onboarding.Prompts {
+		prompts[onboarding.Prompts[i].ID] = &onboarding.Prompts[i]
+	}
+
+	for promptID, optionIDs := range responses {
+		prompt, ok := prompts[promptID]
+		if !ok {
+			return fmt.Sprintf("Unknown prompt %s", promptID)
+		}
+
+		if prompt.SingleSelect && len(optionIDs) > 1 {
+			return fmt.Sprintf("Pick at most one option for %s", prompt.Title)
+		}
+
+		picked := make(map[uuid.UUID]bool, len(optionIDs))
+		for _, optionID := range optionIDs {
+			if picked[optionID] {
+				return fmt.Sprintf("Option %s is picked more than once", optionID)
+			}
+			picked[optionID] = true
+		}
+
+		for _, option := range prompt.Options {
+			delete(picked, option.ID)
+		}
+		for optionID := range picked {
+			return fmt.Sprintf("Unknown option %s for %s", optionID, prompt.Title)
+		}
+	}
+
+	if joining {
+		for _, prompt := range onboarding.Prompts {
+			if prompt.Required && len(responses[prompt.ID]) == 0 {
+				return fmt.Sprintf("%s needs an answer", prompt.Title)
+			}
+		}
+	}
+
+	return ""
+}
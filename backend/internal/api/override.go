@@ -0,0 +1,265 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"mana/internal/middleware"
+	"mana/internal/models"
+	"mana/internal/permissions"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type UpdateOverrideRequest struct {
+	Allow uint64 `json:"allow"`
+	Deny  uint64 `json:"deny"`
+}
+
+func (api *API) GetChannelOverrides(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	channel := accessFromContext(ctx).Channel
+
+	overrides, err := api.Store.GuildChannelOverrides.GetOverridesForChannel(ctx, channel.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch overrides", http.StatusInternalServerError)
+		return
+	}
+	if overrides == nil {
+		overrides = []*models.GuildChannelPermissionOverride{}
+	}
+
+	resp := map[string]interface{}{
+		"overrides": overrides,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (api *API) UpdateRoleOverride(w http.ResponseWriter, r *http.Request) {
+	api.setOverride(w, r, true, false)
+}
+
+func (api *API) DeleteRoleOverride(w http.ResponseWriter, r *http.Request) {
+	api.setOverride(w, r, true, true)
+}
+
+func (api *API) UpdateMemberOverride(w http.ResponseWriter, r *http.Request) {
+	api.setOverride(w, r, false, false)
+}
+
+func (api *API) DeleteMemberOverride(w http.ResponseWriter, r *http.Request) {
+	api.setOverride(w, r, false, true)
+}
+
+// Creates, replaces or removes the override for one role or member. Like
+// roles, only bits the caller has can be allowed or denied.
+func (api *API) setOverride(w http.ResponseWriter, r *http.Request, forRole bool, remove bool) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+
+	// manage roles is checked by the route, dms never have it
+	channel := accessFromContext(ctx).Channel
+
+	var req UpdateOverrideRequest
+	if !remove {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if req.Allow&req.Deny != 0 {
+			http.Error(w, "A permission cannot be both allowed and denied", http.StatusBadRequest)
+			return
+		}
+	}
+
+	actor, err := api.getRoleActor(ctx, userID)
+	if err != nil {
+		http.Error(w, "Failed to resolve permissions", http.StatusInternalServerError)
+		return
+	}
+
+	override := &models.GuildChannelPermissionOverride{ChannelID: channel.ID, Allow: req.Allow, Deny: req.Deny}
+
+	if forRole {
+		roleID, err := uuid.Parse(chi.URLParam(r, "role_id"))
+		if err != nil {
+			http.Error(w, "Invalid role ID", http.StatusBadRequest)
+			return
+		}
+
+		role, err := api.Store.GuildRoles.GetRoleByID(ctx, channel.GuildID, roleID)
+		if err != nil {
+			http.Error(w, "Failed to fetch role", http.StatusInternalServerError)
+			return
+		}
+		if role == nil {
+			http.Error(w, "Role not found", http.StatusNotFound)
+			return
+		}
+
+		if !actor.canManage(role) {
+			http.Error(w, "You cannot manage a role at or above your highest role", http.StatusForbidden)
+			return
+		}
+		override.RoleID = &role.ID
+	} else {
+		memberID, err := uuid.Parse(chi.URLParam(r, "user_id"))
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		isMember, err := api.Store.Guilds.CheckUserMemberOfGuild(ctx, channel.GuildID, memberID)
+		if err != nil {
+			http.Error(w, "Failed to check membership", http.StatusInternalServerError)
+			return
+		}
+		if !isMember {
+			http.Error(w, "Member not found", http.StatusNotFound)
+			return
+		}
+		override.UserID = &memberID
+	}
+
+	current, err := api.findOverride(ctx, override)
+	if err != nil {
+		http.Error(w, "Failed to fetch overrides", http.StatusInternalServerError)
+		return
+	}
+
+	if !actor.canChangePermissions(current.Allow, override.Allow) || !actor.canChangePermissions(current.Deny, override.Deny) {
+		http.Error(w, "You cannot allow or deny permissions you do not have", http.StatusForbidden)
+		return
+	}
+
+	if remove {
+		err = api.Store.GuildChannelOverrides.DeleteOverride(ctx, channel.ID, override.UserID, override.RoleID)
+	} else {
+		err = api.Store.GuildChannelOverrides.UpsertOverride(ctx, override)
+	}
+	if err != nil {
+		http.Error(w, "Failed to update override", http.StatusInternalServerError)
+		return
+	}
+
+	entry := models.NewAuditLogEntry(channel.GuildID, userID, models.AuditOverrideUpdate, models.AuditTargetMember, "")
+	if forRole {
+		entry.TargetType = models.AuditTargetRole
+		entry.TargetID = override.RoleID.String()
+	} else {
+		entry.TargetID = override.UserID.String()
+	}
+	if remove {
+		entry.Action = models.AuditOverrideDelete
+	}
+	entry.ChannelID = &channel.ID
+	entry.Changes = models.DiffAuditChanges(current, override)
+	api.audit(r, entry)
+	api.recheckChannelAccess(ctx, accessFromContext(ctx).Guild, []*models.GuildChannel{channel}, uuid.Nil)
+
+	if remove {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	resp := map[string]interface{}{
+		"override": override,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Gets the existing override for the same role or member, an empty one for them if there is none
+func (api *API) findOverride(ctx context.Context, target *models.GuildChannelPermissionOverride) (*models.GuildChannelPermissionOverride, error) {
+	overrides, err := api.Store.GuildChannelOverrides.GetOverridesForChannel(ctx, target.ChannelID)
+	if err != nil {
+		return nil, err
+	}
+
+	if override := matchingOverride(overrides, target); override != nil {
+		return override, nil
+	}
+
+	return &models.GuildChannelPermissionOverride{ChannelID: target.ChannelID, UserID: target.UserID, RoleID: target.RoleID}, nil
+}
+
+// Breaks down how a member ended up with their permissions in a channel
+func (api *API) ExplainChannelPermissions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	channelAccess := accessFromContext(ctx)
+	channel := channelAccess.Channel
+
+	memberID, err := uuid.Parse(chi.URLParam(r, "user_id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	isMember, err := api.Store.Guilds.CheckUserMemberOfGuild(ctx, channel.GuildID, memberID)
+	if err != nil {
+		http.Error(w, "Failed to check membership", http.StatusInternalServerError)
+		return
+	}
+	if !isMember {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+
+	explanation, err := permissions.ExplainChannelPermissions(ctx, api.permissionStore(), channel.GuildID, channel.ID, memberID)
+	if err != nil {
+		http.Error(w, "Failed to resolve permissions", http.StatusInternalServerError)
+		return
+	}
+
+	timeoutUntil, err := api.Store.Guilds.GetMemberTimeout(ctx, channel.GuildID, memberID)
+	if err != nil {
+		http.Error(w, "Failed to resolve permissions", http.StatusInternalServerError)
+		return
+	}
+	if timeoutUntil != nil && time.Now().Before(*timeoutUntil) && !explanation.Administrator {
+		explanation.TimedOut = true
+		explanation.Permissions = permissions.ApplyTimeout(explanation.Permissions)
+	}
+
+	verified, err := api.meetsVerification(ctx, channelAccess.Guild, memberID)
+	if err != nil {
+		http.Error(w, "Failed to resolve permissions", http.StatusInternalServerError)
+		return
+	}
+	if !verified && !explanation.Administrator {
+		explanation.Unverified = true
+		explanation.Permissions = permissions.ApplyVerification(explanation.Permissions)
+	}
+
+	// the owner skips resolution entirely, see resolveChannelPermissions
+	if channelAccess.Guild.IsOwner(memberID) {
+		explanation.Owner = true
+		explanation.Permissions = ^uint64(0)
+	}
+
+	resp := map[string]interface{}{
+		"channel_id":  channel.ID,
+		"user_id":     memberID,
+		"explanation": explanation,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Finds the override for the same role or member as target
+func matchingOverride(overrides []*models.GuildChannelPermissionOverride, target *models.GuildChannelPermissionOverride) *models.GuildChannelPermissionOverride {
+	for _, override := range overrides {
+		sameRole := override.RoleID != nil && target.RoleID != nil && *override.RoleID == *target.RoleID
+		sameUser := override.UserID != nil && target.UserID != nil && *override.UserID == *target.UserID
+		if sameRole || sameUser {
+			return override
+		}
+	}
+	return nil
+}
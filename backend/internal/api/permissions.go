@@ -0,0 +1,104 @@
+package api
+
+import (
+	"context"
+	"mana/internal/db"
+	"mana/internal/models"
+	"mana/internal/permissions"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// satisfies permissions.PermissionStore using the role and override stores
+type permissionStore struct {
+	roles     *db.GuildRoleStore
+	overrides *db.GuildChannelOverrideStore
+}
+
+func (store permissionStore) GetRolesForMember(ctx context.Context, guildID uuid.UUID, userID uuid.UUID) ([]*models.GuildRole, error) {
+	return store.roles.GetRolesForMember(ctx, guildID, userID)
+}
+
+func (store permissionStore) GetChannelOverrides(ctx context.Context, channelID uuid.UUID) ([]*models.GuildChannelPermissionOverride, error) {
+	return store.overrides.GetChannelOverrides(ctx, channelID)
+}
+
+func (api *API) permissionStore() permissions.PermissionStore {
+	return permissionStore{roles: api.Store.GuildRoles, overrides: api.Store.GuildChannelOverrides}
+}
+
+// Resolves a user's permissions in a guild channel, the guild owner always has all of them
+func (api *API) resolveChannelPermissions(ctx context.Context, guild *models.Guild, channel *models.GuildChannel, userID uuid.UUID) (uint64, error) {
+	if guild.IsOwner(userID) {
+		return ^uint64(0), nil
+	}
+
+	perms, err := permissions.ResolveChannelPermissions(ctx, api.permissionStore(), guild.ID, channel.ID, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return api.applyMemberRestrictions(ctx, guild, userID, perms)
+}
+
+// Resolves a user's guild wide permissions, the guild owner always has all of them
+func (api *API) resolveGuildPermissions(ctx context.Context, guild *models.Guild, userID uuid.UUID) (uint64, error) {
+	if guild.IsOwner(userID) {
+		return ^uint64(0), nil
+	}
+
+	perms, err := permissions.ResolveBasePermissions(ctx, api.permissionStore(), guild.ID, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return api.applyMemberRestrictions(ctx, guild, userID, perms)
+}
+
+// Takes away what a timeout or an unmet verification level denies
+func (api *API) applyMemberRestrictions(ctx context.Context, guild *models.Guild, userID uuid.UUID, perms uint64) (uint64, error) {
+	perms, err := api.applyTimeout(ctx, guild.ID, userID, perms)
+	if err != nil {
+		return 0, err
+	}
+
+	verified, err := api.meetsVerification(ctx, guild, userID)
+	if err != nil {
+		return 0, err
+	}
+	if !verified {
+		perms = permissions.ApplyVerification(perms)
+	}
+
+	return perms, nil
+}
+
+// Whether the member meets the guild's verification level, without a level
+// nobody is held back and nothing is looked up
+func (api *API) meetsVerification(ctx context.Context, guild *models.Guild, userID uuid.UUID) (bool, error) {
+	if guild.VerificationLevel == models.VerificationNone {
+		return true, nil
+	}
+
+	standing, err := api.Store.Guilds.GetMemberStanding(ctx, guild.ID, userID)
+	if err != nil || standing == nil {
+		return true, err
+	}
+
+	return guild.VerificationLevel.Satisfied(standing, time.Now()), nil
+}
+
+// Takes away what a timeout denies while the member's timeout lasts
+func (api *API) applyTimeout(ctx context.Context, guildID uuid.UUID, userID uuid.UUID, perms uint64) (uint64, error) {
+	timeoutUntil, err := api.Store.Guilds.GetMemberTimeout(ctx, guildID, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	if timeoutUntil == nil || !time.Now().Before(*timeoutUntil) {
+		return perms, nil
+	}
+
+	return permissions.ApplyTimeout(perms), nil
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"encoding/json"
+	"mana/internal/middleware"
+	"mana/internal/models"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func (api *API) UpdateGuildRetention(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	guild := accessFromContext(ctx).Guild
+
+	var policy models.RetentionPolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := policy.Validate(); err != nil {
+		http.Error(w, "Invalid retention policy: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := api.Store.Guilds.UpdateRetention(ctx, guild.ID, policy); err != nil {
+		http.Error(w, "Failed to update retention policy", http.StatusInternalServerError)
+		return
+	}
+
+	before := *guild
+	guild.Retention = policy
+
+	entry := models.NewAuditLogEntry(guild.ID, userID, models.AuditGuildUpdate, models.AuditTargetGuild, guild.ID.String())
+	entry.Changes = models.DiffAuditChanges(before, guild)
+	api.audit(r, entry)
+
+	resp := map[string]interface{}{
+		"retention": policy,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (api *API) UpdateChannelRetention(w http.ResponseWriter, r *http.Request) {
+	var policy models.RetentionPolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := policy.Validate(); err != nil {
+		http.Error(w, "Invalid retention policy: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	api.setChannelRetention(w, r, &policy)
+}
+
+// Clears the channel's own policy so it follows the guild default again
+func (api *API) DeleteChannelRetention(w http.ResponseWriter, r *http.Request) {
+	api.setChannelRetention(w, r, nil)
+}
+
+func (api *API) setChannelRetention(w http.ResponseWriter, r *http.Request, policy *models.RetentionPolicy) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+
+	// manage channels is checked by the route, dms never have it
+	channelAccess := accessFromContext(ctx)
+	channel := channelAccess.Channel
+
+	if err := api.Store.GuildChannels.UpdateRetention(ctx, channel.ID, policy); err != nil {
+		http.Error(w, "Failed to update retention policy", http.StatusInternalServerError)
+		return
+	}
+
+	before := *channel
+	channel.Retention = policy
+
+	entry := models.NewAuditLogEntry(channel.GuildID, userID, models.AuditChannelUpdate, models.AuditTargetChannel, channel.ID.String())
+	entry.Changes = models.DiffAuditChanges(before, channel)
+	api.audit(r, entry)
+
+	resp := map[string]interface{}{
+		"retention": models.EffectiveRetention(channelAccess.Guild, channel),
+		"inherited": policy == nil,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Dry run of the janitor, reports what each channel would lose without deleting anything
+func (api *API) PreviewGuildRetention(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	guild := accessFromContext(ctx).Guild
+
+	retentions, err := api.Store.Retention.GetChannelRetentionsForGuild(ctx, guild.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch retention policies", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	report := make([]models.RetentionReport, 0, len(retentions))
+	totalToDelete := 0
+
+	for _, retention := range retentions {
+		count, oldest, newest, err := api.Store.Retention.PreviewPrune(ctx, retention.ChannelID, retention.Policy, now)
+		if err != nil {
+			http.Error(w, "Failed to build retention report", http.StatusInternalServerError)
+			return
+		}
+
+		totalToDelete += count
+		report = append(report, models.RetentionReport{
+			ChannelRetention: *retention,
+			MessagesToDelete: count,
+			OldestToDelete:   oldest,
+			NewestToDelete:   newest,
+		})
+	}
+
+	resp := map[string]interface{}{
+		"guild_retention":    guild.Retention,
+		"report":             report,
+		"messages_to_delete": totalToDelete,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,431 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"mana/internal/middleware"
+	"mana/internal/models"
+	"mana/internal/permissions"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+var roleColorRegex = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+type CreateRoleRequest struct {
+	Name        string `json:"name"`
+	Permissions uint64 `json:"permissions"`
+	Color       string `json:"color"`
+	Position    *uint8 `json:"position"` // defaults to just above everyone
+	Hoist       bool   `json:"hoist"`
+}
+
+type UpdateRoleRequest struct {
+	Name        *string `json:"name"`
+	Permissions *uint64 `json:"permissions"`
+	Color       *string `json:"color"`
+	Position    *uint8  `json:"position"`
+	Hoist       *bool   `json:"hoist"`
+}
+
+// Where the caller stands in the role hierarchy. The owner is above all of it.
+type roleActor struct {
+	owner       bool
+	highest     uint8
+	permissions uint64
+}
+
+func (api *API) getRoleActor(ctx context.Context, userID uuid.UUID) (*roleActor, error) {
+	guildAccess := accessFromContext(ctx)
+	if guildAccess.Guild.IsOwner(userID) {
+		return &roleActor{owner: true, permissions: guildAccess.Permissions}, nil
+	}
+
+	roles, err := api.Store.GuildRoles.GetRolesForMember(ctx, guildAccess.Guild.ID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &roleActor{highest: permissions.HighestPosition(roles), permissions: guildAccess.Permissions}, nil
+}
+
+func (actor *roleActor) canManage(role *models.GuildRole) bool {
+	return actor.owner || permissions.CanManageRole(actor.highest, role)
+}
+
+func (actor *roleActor) canMoveTo(position uint8) bool {
+	return actor.owner || permissions.CanMoveRoleTo(actor.highest, position)
+}
+
+func (actor *roleActor) canModerate(targetRoles []*models.GuildRole) bool {
+	return actor.owner || permissions.CanModerate(actor.highest, permissions.HighestPosition(targetRoles))
+}
+
+func (actor *roleActor) canChangePermissions(current uint64, updated uint64) bool {
+	return actor.owner || permissions.CanChangePermissions(actor.permissions, current, updated)
+}
+
+func (api *API) GetGuildRoles(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	guild := accessFromContext(ctx).Guild
+
+	roles, err := api.Store.GuildRoles.GetRolesForGuild(ctx, guild.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch roles", http.StatusInternalServerError)
+		return
+	}
+
+	resp := map[string]interface{}{
+		"roles": roles,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (api *API) CreateGuildRole(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	guild := accessFromContext(ctx).Guild
+
+	var req CreateRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if msg := validateRoleName(req.Name); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	if req.Color == "" {
+		req.Color = "#000000"
+	}
+	if !roleColorRegex.MatchString(req.Color) {
+		http.Error(w, "Color must be a hex color like #1abc9c", http.StatusBadRequest)
+		return
+	}
+
+	position := models.MaxRoles - 1
+	if req.Position != nil {
+		position = *req.Position
+	}
+	if position == 0 || position >= models.MaxRoles {
+		http.Error(w, "Position must be between 1 and 254", http.StatusBadRequest)
+		return
+	}
+
+	actor, err := api.getRoleActor(ctx, userID)
+	if err != nil {
+		http.Error(w, "Failed to resolve permissions", http.StatusInternalServerError)
+		return
+	}
+
+	if !actor.canMoveTo(position) {
+		http.Error(w, "You cannot create a role at or above your highest role", http.StatusForbidden)
+		return
+	}
+
+	if !actor.canChangePermissions(0, req.Permissions) {
+		http.Error(w, "You cannot grant permissions you do not have", http.StatusForbidden)
+		return
+	}
+
+	count, err := api.Store.GuildRoles.CountRolesForGuild(ctx, guild.ID)
+	if err != nil {
+		http.Error(w, "Failed to create role", http.StatusInternalServerError)
+		return
+	}
+	if count >= int(models.MaxRoles) {
+		http.Error(w, "Guild has reached the maximum number of roles", http.StatusBadRequest)
+		return
+	}
+
+	exists, err := api.Store.GuildRoles.RoleExistsByName(ctx, guild.ID, req.Name)
+	if err != nil {
+		http.Error(w, "Failed to create role", http.StatusInternalServerError)
+		return
+	}
+	if exists {
+		http.Error(w, "A role with that name already exists", http.StatusConflict)
+		return
+	}
+
+	role := models.NewGuildRole(guild.ID, req.Name, position, req.Permissions, req.Color)
+	role.Hoist = req.Hoist
+	if err := api.Store.GuildRoles.CreateGuildRole(ctx, role); err != nil {
+		http.Error(w, "Failed to create role", http.StatusInternalServerError)
+		return
+	}
+
+	entry := models.NewAuditLogEntry(guild.ID, userID, models.AuditRoleCreate, models.AuditTargetRole, role.ID.String())
+	entry.Changes = models.DiffAuditChanges(nil, role)
+	api.audit(r, entry)
+	api.emit(r, guild.ID, models.SubscriptionEventRoleCreate, role)
+
+	resp := map[string]interface{}{
+		"role": role,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (api *API) UpdateGuildRole(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+
+	var req UpdateRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	role, actor, ok := api.loadManageableRole(w, r, userID)
+	if !ok {
+		return
+	}
+	before := *role
+
+	isEveryone := role.Position == models.MaxRoles
+	if isEveryone && (req.Name != nil || req.Position != nil || req.Hoist != nil) {
+		http.Error(w, "Only the permissions and color of the everyone role can be changed", http.StatusBadRequest)
+		return
+	}
+
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if msg := validateRoleName(name); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		if !strings.EqualFold(name, role.Name) {
+			exists, err := api.Store.GuildRoles.RoleExistsByName(ctx, role.GuildID, name)
+			if err != nil {
+				http.Error(w, "Failed to update role", http.StatusInternalServerError)
+				return
+			}
+			if exists {
+				http.Error(w, "A role with that name already exists", http.StatusConflict)
+				return
+			}
+		}
+		role.Name = name
+	}
+
+	if req.Color != nil {
+		if !roleColorRegex.MatchString(*req.Color) {
+			http.Error(w, "Color must be a hex color like #1abc9c", http.StatusBadRequest)
+			return
+		}
+		role.Color = *req.Color
+	}
+
+	if req.Position != nil {
+		if *req.Position == 0 || *req.Position >= models.MaxRoles {
+			http.Error(w, "Position must be between 1 and 254", http.StatusBadRequest)
+			return
+		}
+		if !actor.canMoveTo(*req.Position) {
+			http.Error(w, "You cannot move a role at or above your highest role", http.StatusForbidden)
+			return
+		}
+		role.Position = *req.Position
+	}
+
+	if req.Hoist != nil {
+		role.Hoist = *req.Hoist
+	}
+
+	if req.Permissions != nil {
+		if !actor.canChangePermissions(role.Permissions, *req.Permissions) {
+			http.Error(w, "You cannot grant or revoke permissions you do not have", http.StatusForbidden)
+			return
+		}
+		role.Permissions = *req.Permissions
+	}
+
+	if err := api.Store.GuildRoles.UpdateRole(ctx, role); err != nil {
+		http.Error(w, "Failed to update role", http.StatusInternalServerError)
+		return
+	}
+
+	entry := models.NewAuditLogEntry(role.GuildID, userID, models.AuditRoleUpdate, models.AuditTargetRole, role.ID.String())
+	entry.Changes = models.DiffAuditChanges(before, role)
+	api.audit(r, entry)
+	if role.Permissions != before.Permissions {
+		api.recheckGuildAccess(ctx, accessFromContext(ctx).Guild, uuid.Nil)
+	}
+	api.MemberLists.Invalidate(role.GuildID)
+	api.emit(r, role.GuildID, models.SubscriptionEventRoleUpdate, role)
+
+	resp := map[string]interface{}{
+		"role": role,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (api *API) DeleteGuildRole(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+
+	role, _, ok := api.loadManageableRole(w, r, userID)
+	if !ok {
+		return
+	}
+
+	if role.Position == models.MaxRoles {
+		http.Error(w, "The everyone role cannot be deleted", http.StatusBadRequest)
+		return
+	}
+
+	// it goes when the bot is removed
+	if role.BotID != nil {
+		http.Error(w, "A bot's role cannot be deleted", http.StatusBadRequest)
+		return
+	}
+
+	if err := api.Store.GuildRoles.DeleteRole(ctx, role.ID); err != nil {
+		http.Error(w, "Failed to delete role", http.StatusInternalServerError)
+		return
+	}
+
+	entry := models.NewAuditLogEntry(role.GuildID, userID, models.AuditRoleDelete, models.AuditTargetRole, role.ID.String())
+	entry.Changes = models.DiffAuditChanges(role, nil)
+	api.audit(r, entry)
+	api.recheckGuildAccess(ctx, accessFromContext(ctx).Guild, uuid.Nil)
+	api.MemberLists.Invalidate(role.GuildID)
+	api.emit(r, role.GuildID, models.SubscriptionEventRoleDelete, role)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *API) AddMemberRole(w http.ResponseWriter, r *http.Request) {
+	api.setMemberRole(w, r, true)
+}
+
+func (api *API) RemoveMemberRole(w http.ResponseWriter, r *http.Request) {
+	api.setMemberRole(w, r, false)
+}
+
+func (api *API) setMemberRole(w http.ResponseWriter, r *http.Request, assign bool) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+
+	memberID, err := uuid.Parse(chi.URLParam(r, "user_id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	role, _, ok := api.loadManageableRole(w, r, userID)
+	if !ok {
+		return
+	}
+
+	// every member always holds it
+	if role.Position == models.MaxRoles {
+		http.Error(w, "The everyone role cannot be assigned or removed", http.StatusBadRequest)
+		return
+	}
+
+	// only its bot ever holds it
+	if role.BotID != nil {
+		http.Error(w, "A bot's role cannot be assigned or removed", http.StatusBadRequest)
+		return
+	}
+
+	isMember, err := api.Store.Guilds.CheckUserMemberOfGuild(ctx, role.GuildID, memberID)
+	if err != nil {
+		http.Error(w, "Failed to check membership", http.StatusInternalServerError)
+		return
+	}
+	if !isMember {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+
+	entry := models.NewAuditLogEntry(role.GuildID, userID, models.AuditMemberRoleAdd, models.AuditTargetMember, memberID.String())
+	change := models.AuditChange{Key: "role_id"}
+	event := models.SubscriptionEventMemberRoleAdd
+
+	if assign {
+		change.New = models.AuditValue(role.ID)
+		err = api.Store.GuildRoles.AssignRoleToMember(ctx, role.GuildID, memberID, role.ID)
+	} else {
+		entry.Action = models.AuditMemberRoleRemove
+		event = models.SubscriptionEventMemberRoleRemove
+		change.Old = models.AuditValue(role.ID)
+		err = api.Store.GuildRoles.RemoveRoleFromMember(ctx, role.GuildID, memberID, role.ID)
+	}
+	if err != nil {
+		http.Error(w, "Failed to update member roles", http.StatusInternalServerError)
+		return
+	}
+
+	entry.Changes = []models.AuditChange{change}
+	api.audit(r, entry)
+	if !assign {
+		api.recheckGuildAccess(ctx, accessFromContext(ctx).Guild, memberID)
+	}
+	api.MemberLists.Invalidate(role.GuildID)
+	api.emit(r, role.GuildID, event, map[string]interface{}{"user_id": memberID, "role_id": role.ID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Loads the {role_id} role and checks it sits below the caller, writing the
+// error response itself when it doesn't
+func (api *API) loadManageableRole(w http.ResponseWriter, r *http.Request, userID uuid.UUID) (*models.GuildRole, *roleActor, bool) {
+	ctx := r.Context()
+	guild := accessFromContext(ctx).Guild
+
+	roleID, err := uuid.Parse(chi.URLParam(r, "role_id"))
+	if err != nil {
+		http.Error(w, "Invalid role ID", http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	role, err := api.Store.GuildRoles.GetRoleByID(ctx, guild.ID, roleID)
+	if err != nil {
+		http.Error(w, "Failed to fetch role", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	if role == nil {
+		http.Error(w, "Role not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+
+	actor, err := api.getRoleActor(ctx, userID)
+	if err != nil {
+		http.Error(w, "Failed to resolve permissions", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+
+	if !actor.canManage(role) {
+		http.Error(w, "You cannot manage a role at or above your highest role", http.StatusForbidden)
+		return nil, nil, false
+	}
+
+	return role, actor, true
+}
+
+func validateRoleName(name string) string {
+	if len(name) < 1 || len(name) > 100 {
+		return "Role name must be between 1 and 100 characters"
+	}
+	if strings.EqualFold(name, "everyone") {
+		return "Role name is reserved"
+	}
+	return ""
+}
@@ -1,17 +1,33 @@
 package api
 
 import (
+	"mana/internal/automod"
 	"mana/internal/db"
+	"mana/internal/memberlist"
 	"mana/internal/middleware"
+	"mana/internal/models"
+	"mana/internal/permissions"
+	"mana/internal/subscriptions"
+	"mana/internal/unfurl"
+	"mana/internal/websocket"
 	"net/http"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 )
 
-func NewRouter(store *db.Store) http.Handler {
+func NewRouter(store *db.Store, hub *websocket.Hub, unfurler *unfurl.Unfurler, deliverer *subscriptions.Deliverer, memberLists *memberlist.MemberLists, automodEngine *automod.Engine) http.Handler {
 	router := chi.NewRouter()
-	api := &API{Store: store}
+	api := &API{
+		Store:                 store,
+		Hub:                   hub,
+		Unfurler:              unfurler,
+		Deliverer:             deliverer,
+		MemberLists:           memberLists,
+		Automod:               automodEngine,
+		WebhookLimiter:        middleware.NewRateLimiter(models.WebhookRateLimit, models.WebhookRateLimitWindow),
+		WebhookFailureLimiter: middleware.NewRateLimiter(models.WebhookFailureRateLimit, models.WebhookFailureRateLimitWindow),
+	}
 
 	// Middleware
 	router.Use(middleware.Recover)
@@ -19,28 +35,170 @@ func NewRouter(store *db.Store) http.Handler {
 	router.Use(middleware.CORS)
 	router.Use(middleware.SecurityHeaders)
 	router.Use(middleware.Timeout(10 * time.Second))
-	router.Use(middleware.Authenticate)
 
-	// Public routes
-	router.Get("/api/v1/health", api.Health)
-	router.Post("/api/v1/register", api.Register)
-	router.Post("/api/v1/login", api.Login)
-
-	// authenticated routes
 	router.Route("/api/v1", func(r chi.Router) {
-		// Guild
-		r.Get("/guild/{id}", api.GetGuildByID)
-		r.Get("/guilds", api.GetUserGuilds)
-		r.Post("/guilds", api.CreateGuild)
-		r.Delete("guild/{id}", api.DeleteGuild)
-		r.Post("/guilds/invites/{code}", api.JoinGuildByInvite)
-
-		// Channel
-		r.Get("/guilds/{id}/channels", api.GetGuildChannels)
-
-		// Messages
-		r.Get("/channel/{id}/messages", api.GetMessagesByChannel)
-		r.Post("/channel/{id}/messages", api.CreateMessage)
+		// Public routes
+		r.Get("/health", api.Health)
+		r.Post("/register", api.Register)
+		r.Post("/login", api.Login)
+		r.Get("/guilds/invites/{code}", api.GetInvitePreview)
+		r.Post("/webhooks/{webhook_id}/{token}", api.ExecuteWebhook)
+
+		// authenticated routes, guild and channel routes declare the permission
+		// they need. anyone who can't see the guild or channel gets a 404.
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.Authenticate(api.ValidateBotToken))
+			r.Use(readAuditReason)
+
+			isMember := api.requireGuildPermission(0)
+			canView := api.requireChannelPermission(permissions.PermissionViewChannels)
+
+			// Guild
+			r.With(isMember).Get("/guild/{id}", api.GetGuildByID)
+			r.With(api.requireGuildPermission(permissions.PermissionManageGuild)).Patch("/guild/{id}", api.UpdateGuild)
+			r.Get("/guilds", api.GetUserGuilds)
+			r.With(api.rejectBots).Post("/guilds", api.CreateGuild)
+			r.With(api.rejectBots).Post("/guilds/templates", api.CreateGuildFromTemplate)
+			r.With(isMember).Delete("/guild/{id}", api.DeleteGuild)
+			r.With(isMember).Post("/guild/{id}/restore", api.RestoreGuild)
+			r.With(isMember).Put("/guild/{id}/owner", api.TransferGuildOwnership)
+			r.With(api.requireGuildPermission(permissions.PermissionManageGuild)).Put("/guild/{id}/retention", api.UpdateGuildRetention)
+			r.With(api.requireGuildPermission(permissions.PermissionManageGuild)).Get("/guild/{id}/retention/preview", api.PreviewGuildRetention)
+			r.With(api.requireGuildPermission(permissions.PermissionManageGuild)).Get("/guild/{id}/template", api.ExportGuildTemplate)
+			r.With(isMember).Get("/guild/{id}/onboarding", api.GetGuildOnboarding)
+			r.With(api.requireGuildPermission(permissions.PermissionManageGuild)).Put("/guild/{id}/onboarding", api.UpdateGuildOnboarding)
+
+			// Audit log
+			r.With(api.requireGuildPermission(permissions.PermissionViewAudit)).Get("/guild/{id}/audit-logs", api.GetAuditLog)
+			r.With(api.requireGuildPermission(permissions.PermissionManageGuild)).Put("/guild/{id}/audit-logs/retention", api.UpdateAuditRetention)
+
+			// Automod
+			manageGuild := api.requireGuildPermission(permissions.PermissionManageGuild)
+			r.With(manageGuild).Get("/guild/{id}/automod/rules", api.GetAutomodRules)
+			r.With(manageGuild).Post("/guild/{id}/automod/rules", api.CreateAutomodRule)
+			r.With(manageGuild).Patch("/guild/{id}/automod/rules/{rule_id}", api.UpdateAutomodRule)
+			r.With(manageGuild).Delete("/guild/{id}/automod/rules/{rule_id}", api.DeleteAutomodRule)
+
+			// Insights
+			viewInsights := api.requireGuildPermission(permissions.PermissionViewInsights)
+			r.With(viewInsights).Get("/guild/{id}/insights", api.GetGuildInsights)
+			r.With(viewInsights).Get("/guild/{id}/insights/export", api.ExportGuildInsights)
+
+			// Invites
+			r.With(api.rejectBots).Post("/guilds/invites/{code}", api.JoinGuildByInvite)
+			r.With(api.requireGuildPermission(permissions.PermissionManageGuild)).Get("/guild/{id}/invites", api.GetGuildInvites)
+			r.With(api.requireGuildPermission(permissions.PermissionCreateInvite)).Post("/guild/{id}/invites", api.CreateInvite)
+			r.With(isMember).Delete("/guild/{id}/invites/{code}", api.RevokeInvite)
+
+			// Bots
+			r.With(manageGuild, api.rejectBots).Post("/guild/{id}/bots", api.AuthorizeBot)
+
+			// Members
+			r.With(isMember).Get("/guild/{id}/members", api.GetGuildMembers)
+			r.With(isMember).Get("/guild/{id}/members/{user_id}", api.GetGuildMember)
+			r.With(isMember).Patch("/guild/{id}/members/@me", api.UpdateCurrentMember)
+			r.With(isMember).Put("/guild/{id}/members/@me/onboarding", api.CompleteOnboarding)
+			r.With(api.requireGuildPermission(permissions.PermissionManageNicknames)).Patch("/guild/{id}/members/{user_id}", api.UpdateGuildMember)
+
+			// Moderation
+			banMembers := api.requireGuildPermission(permissions.PermissionBanMembers)
+			timeoutMembers := api.requireGuildPermission(permissions.PermissionTimeoutMembers)
+			r.With(api.requireGuildPermission(permissions.PermissionKickMembers)).Delete("/guild/{id}/members/{user_id}", api.KickMember)
+			r.With(banMembers).Get("/guild/{id}/bans", api.GetGuildBans)
+			r.With(banMembers).Put("/guild/{id}/bans/{user_id}", api.BanMember)
+			r.With(banMembers).Delete("/guild/{id}/bans/{user_id}", api.UnbanMember)
+			r.With(timeoutMembers).Put("/guild/{id}/members/{user_id}/timeout", api.TimeoutMember)
+			r.With(timeoutMembers).Delete("/guild/{id}/members/{user_id}/timeout", api.RemoveMemberTimeout)
+
+			// Roles
+			manageRoles := api.requireGuildPermission(permissions.PermissionManageRoles)
+			r.With(isMember).Get("/guild/{id}/roles", api.GetGuildRoles)
+			r.With(manageRoles).Post("/guild/{id}/roles", api.CreateGuildRole)
+			r.With(manageRoles).Patch("/guild/{id}/roles/{role_id}", api.UpdateGuildRole)
+			r.With(manageRoles).Delete("/guild/{id}/roles/{role_id}", api.DeleteGuildRole)
+			r.With(manageRoles).Put("/guild/{id}/members/{user_id}/roles/{role_id}", api.AddMemberRole)
+			r.With(manageRoles).Delete("/guild/{id}/members/{user_id}/roles/{role_id}", api.RemoveMemberRole)
+
+			// Channel
+			manageGuildChannels := api.requireGuildPermission(permissions.PermissionManageChannels)
+			manageChannel := api.requireChannelPermission(permissions.PermissionManageChannels)
+			r.With(isMember).Get("/guilds/{id}/channels", api.GetGuildChannels)
+			r.With(manageGuildChannels).Post("/guilds/{id}/channels", api.CreateChannel)
+			r.With(manageGuildChannels).Patch("/guilds/{id}/channels", api.ReorderChannels)
+			r.With(manageChannel).Patch("/channel/{id}", api.UpdateChannel)
+			r.With(manageChannel).Delete("/channel/{id}", api.DeleteChannel)
+			r.With(manageChannel).Put("/channel/{id}/parent", api.MoveChannel)
+			r.With(manageChannel).Put("/channel/{id}/retention", api.UpdateChannelRetention)
+			r.With(manageChannel).Delete("/channel/{id}/retention", api.DeleteChannelRetention)
+
+			// Permission overrides
+			manageChannelRoles := api.requireChannelPermission(permissions.PermissionManageRoles)
+			r.With(manageChannelRoles).Get("/channel/{id}/overrides", api.GetChannelOverrides)
+			r.With(manageChannelRoles).Put("/channel/{id}/overrides/roles/{role_id}", api.UpdateRoleOverride)
+			r.With(manageChannelRoles).Delete("/channel/{id}/overrides/roles/{role_id}", api.DeleteRoleOverride)
+			r.With(manageChannelRoles).Put("/channel/{id}/overrides/members/{user_id}", api.UpdateMemberOverride)
+			r.With(manageChannelRoles).Delete("/channel/{id}/overrides/members/{user_id}", api.DeleteMemberOverride)
+			r.With(manageChannelRoles).Get("/channel/{id}/permissions/{user_id}", api.ExplainChannelPermissions)
+			r.With(manageChannelRoles).Post("/channel/{id}/sync-permissions", api.SyncChannelPermissions)
+
+			// Messages
+			r.With(api.requireChannelPermission(permissions.PermissionReadMessageHistory), api.rejectCategory).Get("/channel/{id}/messages", api.GetMessagesByChannel)
+			r.With(api.requireChannelPermission(permissions.PermissionSendMessages), api.rejectCategory).Post("/channel/{id}/messages", api.CreateMessage)
+			r.With(api.requireChannelPermission(permissions.PermissionReadMessageHistory), api.rejectCategory).Get("/channel/{id}/messages/search", api.SearchMessages)
+			r.With(api.requireChannelPermission(permissions.PermissionManageMessages), api.rejectCategory).Post("/channel/{id}/messages/bulk-delete", api.BulkDeleteMessages)
+			r.With(api.requireChannelPermission(permissions.PermissionSendMessages), api.rejectCategory).Patch("/channel/{id}/messages/{message_id}", api.EditMessage)
+			r.With(canView, api.rejectCategory).Delete("/channel/{id}/messages/{message_id}", api.DeleteMessage)
+
+			// Webhooks
+			manageWebhooks := api.requireGuildPermission(permissions.PermissionManageWebhooks)
+			manageChannelWebhooks := api.requireChannelPermission(permissions.PermissionManageWebhooks)
+			r.With(manageChannelWebhooks).Get("/channel/{id}/webhooks", api.GetChannelWebhooks)
+			r.With(manageChannelWebhooks).Post("/channel/{id}/webhooks", api.CreateWebhook)
+			r.With(manageWebhooks).Get("/guild/{id}/webhooks", api.GetGuildWebhooks)
+			r.With(manageWebhooks).Patch("/guild/{id}/webhooks/{webhook_id}", api.UpdateWebhook)
+			r.With(manageWebhooks).Delete("/guild/{id}/webhooks/{webhook_id}", api.DeleteWebhook)
+			r.With(manageWebhooks).Post("/guild/{id}/webhooks/{webhook_id}/token", api.RegenerateWebhookToken)
+
+			// Event subscriptions
+			r.With(manageWebhooks).Get("/guild/{id}/subscriptions", api.GetGuildSubscriptions)
+			r.With(manageWebhooks).Post("/guild/{id}/subscriptions", api.CreateSubscription)
+			r.With(manageWebhooks).Patch("/guild/{id}/subscriptions/{subscription_id}", api.UpdateSubscription)
+			r.With(manageWebhooks).Delete("/guild/{id}/subscriptions/{subscription_id}", api.DeleteSubscription)
+			r.With(manageWebhooks).Post("/guild/{id}/subscriptions/{subscription_id}/secret", api.RotateSubscriptionSecret)
+			r.With(manageWebhooks).Post("/guild/{id}/subscriptions/{subscription_id}/ping", api.PingSubscription)
+			r.With(manageWebhooks).Get("/guild/{id}/subscriptions/{subscription_id}/deliveries", api.GetSubscriptionDeliveries)
+			r.With(manageWebhooks).Post("/guild/{id}/subscriptions/{subscription_id}/deliveries/{delivery_id}/redeliver", api.RedeliverEvent)
+
+			// Gateway
+			r.With(canView, api.rejectCategory).Get("/channel/{id}/ws", api.ServeGateway)
+
+			// Direct messages, recipients are checked by the handlers
+			r.Get("/users/@me/channels", api.GetUserDMChannels)
+			r.Post("/users/@me/channels", api.CreateDMChannel)
+			r.Put("/channel/{id}/recipients/{user_id}", api.AddDMRecipient)
+			r.Delete("/channel/{id}/recipients/{user_id}", api.RemoveDMRecipient)
+
+			// Users
+			r.With(api.rejectBots).Patch("/users/@me/settings", api.UpdateUserSettings)
+			r.With(api.rejectBots).Delete("/users/@me", api.DeleteCurrentUser)
+
+			// Friends
+			r.With(api.rejectBots).Get("/users/@me/friends", api.GetFriends)
+			r.With(api.rejectBots).Post("/users/@me/friends/{user_id}", api.AddFriend)
+			r.With(api.rejectBots).Delete("/users/@me/friends/{user_id}", api.RemoveFriend)
+
+			// Applications, only ever seen by their owner
+			r.Group(func(r chi.Router) {
+				r.Use(api.rejectBots)
+				r.Get("/applications", api.GetApplications)
+				r.Post("/applications", api.CreateApplication)
+				r.Get("/applications/{application_id}", api.GetApplication)
+				r.Patch("/applications/{application_id}", api.UpdateApplication)
+				r.Delete("/applications/{application_id}", api.DeleteApplication)
+				r.Post("/applications/{application_id}/token", api.RegenerateApplicationToken)
+				r.Delete("/applications/{application_id}/token", api.RevokeApplicationToken)
+			})
+		})
 	})
 
 	return router
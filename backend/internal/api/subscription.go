@@ -0,0 +1,510 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mana/internal/middleware"
+	"mana/internal/models"
+	"mana/internal/permissions"
+	"mana/internal/subscriptions"
+	"mana/internal/unfurl"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type CreateSubscriptionRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+type UpdateSubscriptionRequest struct {
+	URL     *string  `json:"url"`
+	Events  []string `json:"events"`
+	Enabled *bool    `json:"enabled"` // re-enabling clears the failure streak
+}
+
+// Queues an event for the guild's subscriptions once the change it describes
+// went through. A failure is logged rather than failing a request whose
+// change already happened.
+func (api *API) emit(r *http.Request, guildID uuid.UUID, eventType string, data any) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Failed to marshal %s subscription event: %v", eventType, err)
+		return
+	}
+
+	queued, err := api.Store.Subscriptions.EnqueueEvent(r.Context(), guildID, eventType, payload)
+	if err != nil {
+		log.Printf("Failed to queue %s subscription event for guild %s: %v", eventType, guildID, err)
+		return
+	}
+
+	if queued > 0 {
+		api.Deliverer.Notify()
+	}
+}
+
+// Like emit, for events carrying a channel's messages. A subscription only
+// gets them while its creator can read the channel, guild wide manage
+// webhooks is no way around channel permissions.
+func (api *API) emitInChannel(r *http.Request, guildID uuid.UUID, channelID uuid.UUID, eventType string, data any) {
+	ctx := r.Context()
+
+	subscribers, err := api.Store.Subscriptions.GetSubscribers(ctx, guildID, eventType)
+	if err != nil {
+		log.Printf("Failed to fetch subscribers to %s for guild %s: %v", eventType, guildID, err)
+		return
+	}
+	if len(subscribers) == 0 {
+		return
+	}
+
+	guild, err := api.Store.Guilds.GetGuildByID(ctx, guildID)
+	if err != nil || guild == nil {
+		log.Printf("Failed to fetch guild %s for %s subscription event: %v", guildID, eventType, err)
+		return
+	}
+	channel, err := api.Store.GuildChannels.GetChannelByID(ctx, channelID)
+	if err != nil || channel == nil {
+		log.Printf("Failed to fetch channel %s for %s subscription event: %v", channelID, eventType, err)
+		return
+	}
+
+	var subscriptionIDs []uuid.UUID
+	for _, subscription := range subscribers {
+		// nobody left to vouch for it once the creator's account is gone
+		if subscription.CreatorID == nil {
+			continue
+		}
+
+		perms, err := api.resolveChannelPermissions(ctx, guild, channel, *subscription.CreatorID)
+		if err != nil {
+			log.Printf("Failed to resolve permissions for subscription %s: %v", subscription.ID, err)
+			continue
+		}
+		if permissions.HasPermission(perms, permissions.PermissionViewChannels|permissions.PermissionReadMessageHistory) {
+			subscriptionIDs = append(subscriptionIDs, subscription.ID)
+		}
+	}
+	if len(subscriptionIDs) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Failed to marshal %s subscription event: %v", eventType, err)
+		return
+	}
+
+	if err := api.Store.Subscriptions.EnqueueDeliveries(ctx, guildID, eventType, payload, subscriptionIDs); err != nil {
+		log.Printf("Failed to queue %s subscription event for guild %s: %v", eventType, guildID, err)
+		return
+	}
+
+	api.Deliverer.Notify()
+}
+
+func (api *API) CreateSubscription(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	guild := accessFromContext(ctx).Guild
+
+	var req CreateSubscriptionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	req.URL = strings.TrimSpace(req.URL)
+	if msg := validateSubscriptionURL(ctx, req.URL); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	events, msg := normalizeSubscriptionEvents(req.Events)
+	if msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	count, err := api.Store.Subscriptions.CountSubscriptionsForGuild(ctx, guild.ID)
+	if err != nil {
+		http.Error(w, "Failed to create subscription", http.StatusInternalServerError)
+		return
+	}
+	if count >= models.MaxSubscriptionsPerGuild {
+		http.Error(w, fmt.Sprintf("A guild can have at most %d subscriptions", models.MaxSubscriptionsPerGuild), http.StatusBadRequest)
+		return
+	}
+
+	subscription := models.NewEventSubscription(guild.ID, userID, req.URL, events)
+	if err := api.Store.Subscriptions.CreateSubscription(ctx, subscription); err != nil {
+		http.Error(w, "Failed to create subscription", http.StatusInternalServerError)
+		return
+	}
+
+	entry := models.NewAuditLogEntry(guild.ID, userID, models.AuditSubscriptionCreate, models.AuditTargetSubscription, subscription.ID.String())
+	entry.Changes = models.DiffAuditChanges(nil, subscription)
+	api.audit(r, entry)
+
+	// the only time the secret is shown, besides rotating it
+	resp := map[string]interface{}{
+		"subscription": subscription,
+		"secret":       subscription.Secret,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (api *API) GetGuildSubscriptions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	guild := accessFromContext(ctx).Guild
+
+	subscriptions, err := api.Store.Subscriptions.GetSubscriptionsForGuild(ctx, guild.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch subscriptions", http.StatusInternalServerError)
+		return
+	}
+	if subscriptions == nil {
+		subscriptions = []*models.EventSubscription{}
+	}
+
+	resp := map[string]interface{}{
+		"subscriptions": subscriptions,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (api *API) UpdateSubscription(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+
+	subscription, ok := api.loadGuildSubscription(w, r)
+	if !ok {
+		return
+	}
+
+	var req UpdateSubscriptionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	before := *subscription
+
+	if req.URL != nil {
+		url := strings.TrimSpace(*req.URL)
+		if msg := validateSubscriptionURL(ctx, url); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+		subscription.URL = url
+	}
+
+	if req.Events != nil {
+		events, msg := normalizeSubscriptionEvents(req.Events)
+		if msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+		subscription.Events = events
+	}
+
+	if req.Enabled != nil {
+		if *req.Enabled && !subscription.Enabled {
+			subscription.ConsecutiveFailures = 0
+			subscription.DisabledReason = ""
+		}
+		subscription.Enabled = *req.Enabled
+	}
+
+	if err := api.Store.Subscriptions.UpdateSubscription(ctx, subscription); err != nil {
+		http.Error(w, "Failed to update subscription", http.StatusInternalServerError)
+		return
+	}
+
+	// deliveries held back while it was disabled can go out now
+	if subscription.Enabled && !before.Enabled {
+		api.Deliverer.Notify()
+	}
+
+	entry := models.NewAuditLogEntry(subscription.GuildID, userID, models.AuditSubscriptionUpdate, models.AuditTargetSubscription, subscription.ID.String())
+	entry.Changes = models.DiffAuditChanges(before, subscription)
+	api.audit(r, entry)
+
+	resp := map[string]interface{}{
+		"subscription": subscription,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (api *API) DeleteSubscription(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+
+	subscription, ok := api.loadGuildSubscription(w, r)
+	if !ok {
+		return
+	}
+
+	if err := api.Store.Subscriptions.DeleteSubscription(ctx, subscription.ID); err != nil {
+		http.Error(w, "Failed to delete subscription", http.StatusInternalServerError)
+		return
+	}
+
+	entry := models.NewAuditLogEntry(subscription.GuildID, userID, models.AuditSubscriptionDelete, models.AuditTargetSubscription, subscription.ID.String())
+	entry.Changes = models.DiffAuditChanges(subscription, nil)
+	api.audit(r, entry)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Replaces the signing secret, receivers have to switch over straight away
+func (api *API) RotateSubscriptionSecret(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+
+	subscription, ok := api.loadGuildSubscription(w, r)
+	if !ok {
+		return
+	}
+
+	subscription.Secret = models.GenerateSubscriptionSecret()
+	if err := api.Store.Subscriptions.UpdateSecret(ctx, subscription.ID, subscription.Secret); err != nil {
+		http.Error(w, "Failed to rotate subscription secret", http.StatusInternalServerError)
+		return
+	}
+
+	entry := models.NewAuditLogEntry(subscription.GuildID, userID, models.AuditSubscriptionUpdate, models.AuditTargetSubscription, subscription.ID.String())
+	entry.Changes = []models.AuditChange{{Key: "secret"}}
+	api.audit(r, entry)
+
+	resp := map[string]interface{}{
+		"subscription": subscription,
+		"secret":       subscription.Secret,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Queues a PING delivery so a receiver can be checked end to end, even while
+// the subscription is disabled it waits for it to be enabled
+func (api *API) PingSubscription(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+
+	subscription, ok := api.loadGuildSubscription(w, r)
+	if !ok {
+		return
+	}
+
+	payload := models.AuditValue(map[string]interface{}{
+		"subscription_id": subscription.ID,
+		"user_id":         userID,
+	})
+
+	delivery := models.NewEventDelivery(subscription.ID, subscription.GuildID, models.SubscriptionEventPing, payload)
+	if err := api.Store.Subscriptions.InsertDelivery(ctx, delivery); err != nil {
+		http.Error(w, "Failed to queue ping", http.StatusInternalServerError)
+		return
+	}
+	api.Deliverer.Notify()
+
+	resp := map[string]interface{}{
+		"delivery": delivery,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Lists deliveries newest first, filtered by status and paged with before
+// (a delivery id) and limit
+func (api *API) GetSubscriptionDeliveries(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	query := r.URL.Query()
+
+	subscription, ok := api.loadGuildSubscription(w, r)
+	if !ok {
+		return
+	}
+
+	filter := &models.DeliveryFilter{
+		Status: query.Get("status"),
+		Limit:  models.DefaultDeliveryLimit,
+	}
+
+	switch filter.Status {
+	case "", models.DeliveryStatusPending, models.DeliveryStatusSucceeded, models.DeliveryStatusFailed:
+	default:
+		http.Error(w, "Status must be pending, succeeded or failed", http.StatusBadRequest)
+		return
+	}
+
+	if beforeStr := query.Get("before"); beforeStr != "" {
+		before, err := uuid.Parse(beforeStr)
+		if err != nil {
+			http.Error(w, "Invalid before delivery ID", http.StatusBadRequest)
+			return
+		}
+		filter.Before = &before
+	}
+
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > models.MaxDeliveryLimit {
+			http.Error(w, fmt.Sprintf("Limit must be between 1 and %d", models.MaxDeliveryLimit), http.StatusBadRequest)
+			return
+		}
+		filter.Limit = limit
+	}
+
+	deliveries, err := api.Store.Subscriptions.GetDeliveries(ctx, subscription.ID, filter)
+	if err != nil {
+		http.Error(w, "Failed to fetch deliveries", http.StatusInternalServerError)
+		return
+	}
+	if deliveries == nil {
+		deliveries = []*models.EventDelivery{}
+	}
+
+	resp := map[string]interface{}{
+		"deliveries": deliveries,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Queues a finished delivery's event again as a new delivery, the original
+// stays in the log as it was
+func (api *API) RedeliverEvent(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	subscription, ok := api.loadGuildSubscription(w, r)
+	if !ok {
+		return
+	}
+
+	deliveryID, err := uuid.Parse(chi.URLParam(r, "delivery_id"))
+	if err != nil {
+		http.Error(w, "Invalid delivery ID", http.StatusBadRequest)
+		return
+	}
+
+	original, err := api.Store.Subscriptions.GetDelivery(ctx, deliveryID)
+	if err != nil {
+		http.Error(w, "Failed to fetch delivery", http.StatusInternalServerError)
+		return
+	}
+	if original == nil || original.SubscriptionID != subscription.ID {
+		http.Error(w, "Delivery not found", http.StatusNotFound)
+		return
+	}
+	if original.Status == models.DeliveryStatusPending {
+		http.Error(w, "Delivery is still being retried", http.StatusConflict)
+		return
+	}
+
+	delivery := models.NewEventDelivery(subscription.ID, subscription.GuildID, original.EventType, original.Payload)
+	if err := api.Store.Subscriptions.InsertDelivery(ctx, delivery); err != nil {
+		http.Error(w, "Failed to queue delivery", http.StatusInternalServerError)
+		return
+	}
+	api.Deliverer.Notify()
+
+	resp := map[string]interface{}{
+		"delivery": delivery,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Parses {subscription_id} and makes sure it belongs to the guild in the
+// route, writing the error response itself when it doesn't
+func (api *API) loadGuildSubscription(w http.ResponseWriter, r *http.Request) (*models.EventSubscription, bool) {
+	ctx := r.Context()
+	guild := accessFromContext(ctx).Guild
+
+	subscriptionID, err := uuid.Parse(chi.URLParam(r, "subscription_id"))
+	if err != nil {
+		http.Error(w, "Invalid subscription ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	subscription, err := api.Store.Subscriptions.GetSubscription(ctx, subscriptionID)
+	if err != nil {
+		http.Error(w, "Failed to fetch subscription", http.StatusInternalServerError)
+		return nil, false
+	}
+	if subscription == nil || subscription.GuildID != guild.ID {
+		http.Error(w, "Subscription not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	return subscription, true
+}
+
+// Any http(s) URL, plain http and local hosts included so receivers can be
+// tried out locally
+// The host has to resolve to public addresses only, deliveries to internal
+// ones are refused anyway
+func validateSubscriptionURL(ctx context.Context, rawURL string) string {
+	if rawURL == "" {
+		return "url is required"
+	}
+	if len(rawURL) > models.MaxSubscriptionURLLength {
+		return fmt.Sprintf("url must be at most %d characters", models.MaxSubscriptionURLLength)
+	}
+	if msg := validateHTTPURL("url", rawURL); msg != "" {
+		return msg
+	}
+
+	parsed, _ := url.Parse(rawURL)
+	if parsed.User != nil {
+		return "url must not contain credentials"
+	}
+	if err := unfurl.CheckHost(ctx, parsed.Hostname(), subscriptions.AllowLoopback()); err != nil {
+		return "url must point at a publicly reachable host"
+	}
+	return ""
+}
+
+// Checks every event is known and drops duplicates
+func normalizeSubscriptionEvents(events []string) ([]string, string) {
+	if len(events) == 0 {
+		return nil, "Subscribe to at least one event"
+	}
+
+	seen := make(map[string]bool, len(events))
+	normalized := make([]string, 0, len(events))
+	for _, event := range events {
+		if !models.SubscriptionEvents[event] {
+			return nil, fmt.Sprintf("Unknown event %q", event)
+		}
+		if seen[event] {
+			continue
+		}
+		seen[event] = true
+		normalized = append(normalized, event)
+	}
+
+	return normalized, ""
+}
@@ -0,0 +1,244 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"mana/internal/middleware"
+	"mana/internal/models"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+type CreateGuildFromTemplateRequest struct {
+	Name     string                `json:"name"` // defaults to the template's name
+	Template *models.GuildTemplate `json:"template"`
+}
+
+func (api *API) ExportGuildTemplate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	guild := accessFromContext(ctx).Guild
+
+	roles, err := api.Store.GuildRoles.GetRolesForGuild(ctx, guild.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch roles", http.StatusInternalServerError)
+		return
+	}
+
+	channels, err := api.Store.GuildChannels.GetChannelsForGuild(ctx, guild.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch channels", http.StatusInternalServerError)
+		return
+	}
+
+	overrides, err := api.Store.GuildChannelOverrides.GetOverridesForGuild(ctx, guild.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch overrides", http.StatusInternalServerError)
+		return
+	}
+
+	resp := map[string]interface{}{
+		"template": models.NewGuildTemplate(guild, roles, channels, overrides),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Creates a guild laid out like the template, owned by the caller
+func (api *API) CreateGuildFromTemplate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+
+	var req CreateGuildFromTemplateRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, models.MaxGuildTemplateSize)).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.Template == nil {
+		http.Error(w, "template is required", http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = strings.TrimSpace(req.Template.Name)
+	}
+	if msg := validateGuildName(name); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	if msg := validateGuildTemplate(req.Template); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	result := req.Template.Instantiate(name, userID)
+	if err := api.Store.Guilds.CreateGuild(ctx, result); err != nil {
+		http.Error(w, "Failed to create guild", http.StatusInternalServerError)
+		return
+	}
+
+	roles := append([]*models.GuildRole{result.OwnerRole}, result.Roles...)
+	roles = append(roles, result.EveryoneRole)
+
+	channels := result.Channels
+	if channels == nil {
+		channels = []*models.GuildChannel{}
+	}
+
+	resp := map[string]interface{}{
+		"guild":    result.Guild,
+		"roles":    roles,
+		"channels": channels,
+		"invite":   result.Invite,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Checks everything a template would create the way the endpoints creating
+// them one by one would, normalizing channel names and filling in defaults
+// on the way. Returns an error message, empty if it's valid.
+func validateGuildTemplate(template *models.GuildTemplate) string {
+	if template.Version != models.GuildTemplateVersion {
+		return fmt.Sprintf("Unsupported template version, expected %d", models.GuildTemplateVersion)
+	}
+
+	if err := template.Settings.Retention.Validate(); err != nil {
+		return "settings.retention: " + err.Error()
+	}
+	if days := template.Settings.AuditRetentionDays; days < 0 || days > models.MaxAuditRetentionDays {
+		return fmt.Sprintf("settings.audit_retention_days must be between 0 and %d", models.MaxAuditRetentionDays)
+	}
+
+	template.Settings.Description = strings.TrimSpace(template.Settings.Description)
+	if len(template.Settings.Description) > models.MaxGuildDescriptionLength {
+		return fmt.Sprintf("settings.description must be at most %d characters", models.MaxGuildDescriptionLength)
+	}
+	switch template.Settings.DefaultNotifications {
+	case "":
+		template.Settings.DefaultNotifications = models.NotificationsAllMessages
+	case models.NotificationsAllMessages, models.NotificationsOnlyMentions:
+	default:
+		return "settings.default_notifications must be all_messages or only_mentions"
+	}
+	if !template.Settings.VerificationLevel.Valid() {
+		return fmt.Sprintf("settings.verification_level must be between %d and %d", models.VerificationNone, models.VerificationHigh)
+	}
+	if template.Settings.VerificationLevel.NeedsVerifiedEmail() {
+		return "settings.verification_level 1 needs a verified email, which can't be verified yet"
+	}
+
+	// positions 1 to 254, the owner's role and everyone take the ends
+	if len(template.Roles) > int(models.MaxRoles)-1 {
+		return fmt.Sprintf("A template can have at most %d roles", int(models.MaxRoles)-1)
+	}
+
+	roleIDs := map[int]bool{models.TemplateEveryoneRoleID: true}
+	roleNames := map[string]bool{"owner": true}
+	for i := range template.Roles {
+		role := &template.Roles[i]
+
+		if roleIDs[role.ID] {
+			return fmt.Sprintf("Role id %d is used more than once", role.ID)
+		}
+		roleIDs[role.ID] = true
+
+		role.Name = strings.TrimSpace(role.Name)
+		if msg := validateRoleName(role.Name); msg != "" {
+			return fmt.Sprintf("Role %d: %s", role.ID, msg)
+		}
+		if roleNames[strings.ToLower(role.Name)] {
+			return fmt.Sprintf("Role %d: a role named %s already exists", role.ID, role.Name)
+		}
+		roleNames[strings.ToLower(role.Name)] = true
+
+		if role.Color == "" {
+			role.Color = "#000000"
+		}
+		if !roleColorRegex.MatchString(role.Color) {
+			return fmt.Sprintf("Role %d: color must be a hex color like #1abc9c", role.ID)
+		}
+	}
+
+	if len(template.Channels) > int(models.MaxChannels) {
+		return fmt.Sprintf("A template can have at most %d channels", models.MaxChannels)
+	}
+
+	channelTypes := make(map[int]models.ChannelType)
+	for _, channel := range template.Channels {
+		if _, ok := channelTypes[channel.ID]; ok {
+			return fmt.Sprintf("Channel id %d is used more than once", channel.ID)
+		}
+		channelTypes[channel.ID] = channel.Type
+	}
+
+	if systemChannelID := template.Settings.SystemChannelID; systemChannelID != nil && channelTypes[*systemChannelID] != models.ChannelTypeText {
+		return "settings.system_channel_id must be a text channel in the template"
+	}
+
+	channelNames := make(map[string]bool)
+	for i := range template.Channels {
+		channel := &template.Channels[i]
+
+		if channel.Type != models.ChannelTypeText && channel.Type != models.ChannelTypeVoice && channel.Type != models.ChannelTypeCategory {
+			return fmt.Sprintf("Channel %d: type must be text, voice or category", channel.ID)
+		}
+
+		name, msg := normalizeChannelName(channel.Name, channel.Type)
+		if msg != "" {
+			return fmt.Sprintf("Channel %d: %s", channel.ID, msg)
+		}
+		if channelNames[strings.ToLower(name)] {
+			return fmt.Sprintf("Channel %d: a channel named %s already exists", channel.ID, name)
+		}
+		channelNames[strings.ToLower(name)] = true
+		channel.Name = name
+
+		if msg := validateChannelFields(channel.Type, &channel.Topic, channel.Bitrate, channel.UserLimit); msg != "" {
+			return fmt.Sprintf("Channel %d: %s", channel.ID, msg)
+		}
+		if channel.Type == models.ChannelTypeVoice && channel.Bitrate == nil {
+			bitrate := models.DefaultVoiceBitrate
+			channel.Bitrate = &bitrate
+		}
+
+		if channel.Retention != nil {
+			if err := channel.Retention.Validate(); err != nil {
+				return fmt.Sprintf("Channel %d: %s", channel.ID, err.Error())
+			}
+		}
+
+		if channel.ParentID != nil {
+			if channel.Type == models.ChannelTypeCategory {
+				return fmt.Sprintf("Channel %d: categories cannot be nested", channel.ID)
+			}
+			if channelTypes[*channel.ParentID] != models.ChannelTypeCategory {
+				return fmt.Sprintf("Channel %d: parent_id must be a category in the template", channel.ID)
+			}
+		}
+
+		overridden := make(map[int]bool)
+		for _, override := range channel.Overrides {
+			if !roleIDs[override.RoleID] {
+				return fmt.Sprintf("Channel %d: override for unknown role %d", channel.ID, override.RoleID)
+			}
+			if overridden[override.RoleID] {
+				return fmt.Sprintf("Channel %d: more than one override for role %d", channel.ID, override.RoleID)
+			}
+			overridden[override.RoleID] = true
+
+			if override.Allow&override.Deny != 0 {
+				return fmt.Sprintf("Channel %d: a permission cannot be both allowed and denied", channel.ID)
+			}
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"encoding/json"
+	"mana/internal/middleware"
+	"mana/internal/models"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+type UpdateUserSettingsRequest struct {
+	DMPrivacy      *string `json:"dm_privacy"`
+	SuppressEmbeds *bool   `json:"suppress_embeds"`
+}
+
+func (api *API) UpdateUserSettings(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req UpdateUserSettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.DMPrivacy != nil {
+		if *req.DMPrivacy != models.DMPrivacyEveryone && *req.DMPrivacy != models.DMPrivacyGuildsAndFriends {
+			http.Error(w, "Invalid dm_privacy. Must be: everyone or guilds_and_friends", http.StatusBadRequest)
+			return
+		}
+
+		if err := api.Store.Users.UpdateDMPrivacy(ctx, userID, *req.DMPrivacy); err != nil {
+			http.Error(w, "Failed to update settings", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if req.SuppressEmbeds != nil {
+		if err := api.Store.Users.UpdateSuppressEmbeds(ctx, userID, *req.SuppressEmbeds); err != nil {
+			http.Error(w, "Failed to update settings", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	user, err := api.Store.Users.GetUserByID(ctx, userID)
+	if err != nil || user == nil {
+		http.Error(w, "Failed to fetch user", http.StatusInternalServerError)
+		return
+	}
+
+	resp := map[string]interface{}{
+		"dm_privacy":      user.DMPrivacy,
+		"suppress_embeds": user.SuppressEmbeds,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Deletes the caller's account for good. Guilds they own go to their highest
+// ranked member, or are frozen and scheduled for deletion if nobody's left.
+func (api *API) DeleteCurrentUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+
+	var req ConfirmPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if !api.confirmPassword(w, r, userID, req.Password) {
+		return
+	}
+
+	// looked up while they're still members, the lists are rebuilt without them
+	api.MemberLists.UserChanged(ctx, userID)
+
+	if err := api.Store.Users.DeleteUser(ctx, userID); err != nil {
+		http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
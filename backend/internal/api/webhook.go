@@ -0,0 +1,489 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"mana/internal/markdown"
+	"mana/internal/middleware"
+	"mana/internal/models"
+	"mana/internal/permissions"
+	"mana/internal/types"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type CreateWebhookRequest struct {
+	Name      string `json:"name"`
+	AvatarURL string `json:"avatar_url"`
+}
+
+type UpdateWebhookRequest struct {
+	Name      *string    `json:"name"`
+	AvatarURL *string    `json:"avatar_url"`
+	ChannelID *uuid.UUID `json:"channel_id"`
+}
+
+// Body of an execute, content and embeds can't both be empty
+type ExecuteWebhookRequest struct {
+	Content   string         `json:"content"`
+	Username  string         `json:"username"`   // overrides the webhook's name for this message
+	AvatarURL string         `json:"avatar_url"` // overrides the webhook's avatar for this message
+	Embeds    []models.Embed `json:"embeds"`
+}
+
+func (api *API) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+
+	channel := accessFromContext(ctx).Channel
+	if channel == nil || channel.Type != models.ChannelTypeText {
+		http.Error(w, "Webhooks can only post in guild text channels", http.StatusBadRequest)
+		return
+	}
+
+	var req CreateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if msg := validateWebhookName(req.Name); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	if msg := validateWebhookAvatarURL(req.AvatarURL); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	count, err := api.Store.Webhooks.CountWebhooksForChannel(ctx, channel.ID)
+	if err != nil {
+		http.Error(w, "Failed to create webhook", http.StatusInternalServerError)
+		return
+	}
+	if count >= models.MaxWebhooksPerChannel {
+		http.Error(w, fmt.Sprintf("A channel can have at most %d webhooks", models.MaxWebhooksPerChannel), http.StatusBadRequest)
+		return
+	}
+
+	webhook, token := models.NewWebhook(channel.GuildID, channel.ID, userID, req.Name, req.AvatarURL)
+	if err := api.Store.Webhooks.CreateWebhook(ctx, webhook); err != nil {
+		http.Error(w, "Failed to create webhook", http.StatusInternalServerError)
+		return
+	}
+
+	entry := models.NewAuditLogEntry(webhook.GuildID, userID, models.AuditWebhookCreate, models.AuditTargetWebhook, webhook.ID.String())
+	entry.ChannelID = &webhook.ChannelID
+	entry.Changes = models.DiffAuditChanges(nil, webhook)
+	api.audit(r, entry)
+
+	// the only time the token is shown
+	resp := map[string]interface{}{
+		"webhook": webhook,
+		"token":   token,
+		"url":     webhookURL(webhook.ID, token),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (api *API) GetChannelWebhooks(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	channel := accessFromContext(ctx).Channel
+	if channel == nil {
+		http.Error(w, "Webhooks can only post in guild text channels", http.StatusBadRequest)
+		return
+	}
+
+	webhooks, err := api.Store.Webhooks.GetWebhooksForChannel(ctx, channel.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch webhooks", http.StatusInternalServerError)
+		return
+	}
+	if webhooks == nil {
+		webhooks = []*models.Webhook{}
+	}
+
+	resp := map[string]interface{}{
+		"webhooks": webhooks,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (api *API) GetGuildWebhooks(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	guild := accessFromContext(ctx).Guild
+
+	webhooks, err := api.Store.Webhooks.GetWebhooksForGuild(ctx, guild.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch webhooks", http.StatusInternalServerError)
+		return
+	}
+	if webhooks == nil {
+		webhooks = []*models.Webhook{}
+	}
+
+	resp := map[string]interface{}{
+		"webhooks": webhooks,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Renames a webhook, changes its avatar or moves it to another text channel
+// the caller can manage webhooks in
+func (api *API) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+	guild := accessFromContext(ctx).Guild
+
+	webhook, ok := api.loadGuildWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	var req UpdateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	before := *webhook
+
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if msg := validateWebhookName(name); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+		webhook.Name = name
+	}
+
+	if req.AvatarURL != nil {
+		if msg := validateWebhookAvatarURL(*req.AvatarURL); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+		webhook.AvatarURL = *req.AvatarURL
+	}
+
+	if req.ChannelID != nil && *req.ChannelID != webhook.ChannelID {
+		channel, err := api.Store.GuildChannels.GetChannelByID(ctx, *req.ChannelID)
+		if err != nil {
+			http.Error(w, "Failed to fetch channel", http.StatusInternalServerError)
+			return
+		}
+		if channel == nil || channel.GuildID != guild.ID {
+			http.Error(w, "Channel not found", http.StatusNotFound)
+			return
+		}
+
+		perms, err := api.resolveChannelPermissions(ctx, guild, channel, userID)
+		if err != nil {
+			http.Error(w, "Failed to resolve permissions", http.StatusInternalServerError)
+			return
+		}
+		if !permissions.HasPermission(perms, permissions.PermissionViewChannels) {
+			http.Error(w, "Channel not found", http.StatusNotFound)
+			return
+		}
+		if !permissions.HasPermission(perms, permissions.PermissionManageWebhooks) {
+			http.Error(w, "Missing permissions", http.StatusForbidden)
+			return
+		}
+
+		if channel.Type != models.ChannelTypeText {
+			http.Error(w, "Webhooks can only post in guild text channels", http.StatusBadRequest)
+			return
+		}
+
+		count, err := api.Store.Webhooks.CountWebhooksForChannel(ctx, channel.ID)
+		if err != nil {
+			http.Error(w, "Failed to update webhook", http.StatusInternalServerError)
+			return
+		}
+		if count >= models.MaxWebhooksPerChannel {
+			http.Error(w, fmt.Sprintf("A channel can have at most %d webhooks", models.MaxWebhooksPerChannel), http.StatusBadRequest)
+			return
+		}
+
+		webhook.ChannelID = channel.ID
+	}
+
+	if err := api.Store.Webhooks.UpdateWebhook(ctx, webhook); err != nil {
+		http.Error(w, "Failed to update webhook", http.StatusInternalServerError)
+		return
+	}
+
+	entry := models.NewAuditLogEntry(guild.ID, userID, models.AuditWebhookUpdate, models.AuditTargetWebhook, webhook.ID.String())
+	entry.ChannelID = &webhook.ChannelID
+	entry.Changes = models.DiffAuditChanges(before, webhook)
+	api.audit(r, entry)
+
+	resp := map[string]interface{}{
+		"webhook": webhook,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Replaces a leaked token, the old URL stops working straight away
+func (api *API) RegenerateWebhookToken(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+
+	webhook, ok := api.loadGuildWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	token := models.GenerateWebhookToken()
+	if err := api.Store.Webhooks.UpdateToken(ctx, webhook.ID, models.HashWebhookToken(token)); err != nil {
+		http.Error(w, "Failed to regenerate webhook token", http.StatusInternalServerError)
+		return
+	}
+
+	entry := models.NewAuditLogEntry(webhook.GuildID, userID, models.AuditWebhookUpdate, models.AuditTargetWebhook, webhook.ID.String())
+	entry.ChannelID = &webhook.ChannelID
+	entry.Changes = []models.AuditChange{{Key: "token"}}
+	api.audit(r, entry)
+
+	resp := map[string]interface{}{
+		"webhook": webhook,
+		"token":   token,
+		"url":     webhookURL(webhook.ID, token),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (api *API) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value(middleware.UserIDKey).(uuid.UUID)
+
+	webhook, ok := api.loadGuildWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	if err := api.Store.Webhooks.DeleteWebhook(ctx, webhook.ID); err != nil {
+		http.Error(w, "Failed to delete webhook", http.StatusInternalServerError)
+		return
+	}
+
+	entry := models.NewAuditLogEntry(webhook.GuildID, userID, models.AuditWebhookDelete, models.AuditTargetWebhook, webhook.ID.String())
+	entry.ChannelID = &webhook.ChannelID
+	entry.Changes = models.DiffAuditChanges(webhook, nil)
+	api.audit(r, entry)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Public, the token in the URL is the only credential. Wrong tokens look the
+// same as webhooks that don't exist.
+func (api *API) ExecuteWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// guessing at ids and tokens is limited by address, only callers holding
+	// the token count against the webhook itself
+	clientIP := middleware.ClientIP(r)
+	if limited, retryAfter := api.WebhookFailureLimiter.Limited(clientIP); limited {
+		middleware.TooManyRequests(w, retryAfter)
+		return
+	}
+
+	webhookID, err := uuid.Parse(chi.URLParam(r, "webhook_id"))
+	if err != nil {
+		api.WebhookFailureLimiter.Allow(clientIP)
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+
+	webhook, err := api.Store.Webhooks.GetWebhookByID(ctx, webhookID)
+	if err != nil {
+		http.Error(w, "Failed to fetch webhook", http.StatusInternalServerError)
+		return
+	}
+
+	tokenHash := models.HashWebhookToken(chi.URLParam(r, "token"))
+	if webhook == nil || subtle.ConstantTimeCompare([]byte(tokenHash), []byte(webhook.TokenHash)) != 1 {
+		api.WebhookFailureLimiter.Allow(clientIP)
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+
+	if allowed, retryAfter := api.WebhookLimiter.Allow(webhook.ID.String()); !allowed {
+		middleware.TooManyRequests(w, retryAfter)
+		return
+	}
+
+	var req ExecuteWebhookRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, models.MaxMessageBodySize)).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if strings.TrimSpace(req.Content) == "" && len(req.Embeds) == 0 {
+		http.Error(w, "Message needs content or embeds", http.StatusBadRequest)
+		return
+	}
+
+	req.Username = strings.TrimSpace(req.Username)
+	if req.Username != "" {
+		if msg := validateWebhookName(req.Username); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+	}
+	if msg := validateWebhookAvatarURL(req.AvatarURL); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	if msg := validateWebhookEmbeds(req.Embeds); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	ast, err := markdown.Parse(req.Content)
+	if err != nil {
+		http.Error(w, markdownErrorMessage(err), http.StatusBadRequest)
+		return
+	}
+
+	author := models.WebhookAuthor{Name: req.Username, AvatarURL: req.AvatarURL}
+	msg := models.NewWebhookMessage(webhook, author, req.Content)
+	msg.AST = ast
+	msg.PlainText = markdown.PlainText(ast, api.mentionNameResolver(ctx, webhook.GuildID, ast))
+	msg.Embeds = req.Embeds
+
+	if violation := api.checkWebhookAutomod(ctx, webhook, msg); violation != nil {
+		http.Error(w, fmt.Sprintf("Message blocked by automod rule %q", violation.Rule.Name), http.StatusBadRequest)
+		return
+	}
+
+	if err := api.Store.Messages.InsertMessage(ctx, msg); err != nil {
+		http.Error(w, "Failed to send message", http.StatusInternalServerError)
+		return
+	}
+
+	api.dispatch(types.EventReceiveMessage, msg.ChannelID, msg)
+	api.emitInChannel(r, webhook.GuildID, msg.ChannelID, models.SubscriptionEventMessageCreate, msg)
+
+	// links are only unfurled when the webhook didn't bring its own embeds
+	if len(msg.Embeds) == 0 {
+		api.Unfurler.Enqueue(msg)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(msg)
+}
+
+// Parses {webhook_id} and makes sure it belongs to the guild in the route,
+// writing the error response itself when it doesn't
+func (api *API) loadGuildWebhook(w http.ResponseWriter, r *http.Request) (*models.Webhook, bool) {
+	ctx := r.Context()
+	guild := accessFromContext(ctx).Guild
+
+	webhookID, err := uuid.Parse(chi.URLParam(r, "webhook_id"))
+	if err != nil {
+		http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	webhook, err := api.Store.Webhooks.GetWebhookByID(ctx, webhookID)
+	if err != nil {
+		http.Error(w, "Failed to fetch webhook", http.StatusInternalServerError)
+		return nil, false
+	}
+	if webhook == nil || webhook.GuildID != guild.ID {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	return webhook, true
+}
+
+func webhookURL(webhookID uuid.UUID, token string) string {
+	return fmt.Sprintf("/api/v1/webhooks/%s/%s", webhookID, token)
+}
+
+func validateWebhookName(name string) string {
+	if name == "" || len(name) > models.MaxWebhookNameLength {
+		return fmt.Sprintf("Name must be between 1 and %d characters", models.MaxWebhookNameLength)
+	}
+	return ""
+}
+
+// Empty is allowed, same as any other URL
+func validateWebhookAvatarURL(value string) string {
+	if len(value) > models.MaxWebhookAvatarLength {
+		return fmt.Sprintf("avatar_url must be at most %d characters", models.MaxWebhookAvatarLength)
+	}
+	return validateHTTPURL("avatar_url", value)
+}
+
+// Empty is allowed, anything else has to be an absolute http(s) URL
+func validateHTTPURL(field string, value string) string {
+	if value == "" {
+		return ""
+	}
+
+	if len(value) > models.MaxEmbedURLLength {
+		return fmt.Sprintf("%s must be at most %d characters", field, models.MaxEmbedURLLength)
+	}
+
+	parsed, err := url.Parse(value)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Sprintf("%s must be an http or https URL", field)
+	}
+	return ""
+}
+
+// Webhook embeds are always rich embeds, and need something to show
+func validateWebhookEmbeds(embeds []models.Embed) string {
+	if len(embeds) > models.MaxEmbedsPerMessage {
+		return fmt.Sprintf("A message can have at most %d embeds", models.MaxEmbedsPerMessage)
+	}
+
+	for i := range embeds {
+		embed := &embeds[i]
+		embed.Type = models.EmbedTypeRich
+
+		if embed.Title == "" && embed.Description == "" && embed.ImageURL == "" {
+			return "Embeds need a title, description or image_url"
+		}
+		if len(embed.Title) > models.MaxEmbedTitleLength {
+			return fmt.Sprintf("Embed title must be at most %d characters", models.MaxEmbedTitleLength)
+		}
+		if len(embed.Description) > models.MaxEmbedDescriptionLength {
+			return fmt.Sprintf("Embed description must be at most %d characters", models.MaxEmbedDescriptionLength)
+		}
+		if len(embed.SiteName) > models.MaxEmbedFieldLength ||
+			len(embed.ProviderName) > models.MaxEmbedFieldLength ||
+			len(embed.AuthorName) > models.MaxEmbedFieldLength {
+			return fmt.Sprintf("Embed names must be at most %d characters", models.MaxEmbedFieldLength)
+		}
+		if msg := validateHTTPURL("Embed url", embed.URL); msg != "" {
+			return msg
+		}
+		if msg := validateHTTPURL("Embed image_url", embed.ImageURL); msg != "" {
+			return msg
+		}
+	}
+
+	return ""
+}
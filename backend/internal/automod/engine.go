@@ -0,0 +1,420 @@
+package automod
+
+import (
+	"context"
+	"fmt"
+	"mana/internal/db"
+	"mana/internal/markdown"
+	"mana/internal/models"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+const pruneInterval = time.Minute
+
+var linkRegex = regexp.MustCompile(`(?i)https?://[^\s<>]+`)
+
+// Checks messages against their guild's automod rules. Each guild's rules
+// are loaded and compiled once and kept until Invalidate, and members'
+// recent messages are kept in memory for the repeated and flood triggers.
+type Engine struct {
+	Store *db.Store
+
+	mutex       sync.Mutex
+	rules       map[uuid.UUID][]*compiledRule
+	invalidated int // bumped by Invalidate, rules loaded across it aren't cached
+	history     map[memberKey][]recentMessage
+}
+
+// What gets checked, a message along with where and by whom it was sent
+type Subject struct {
+	Channel *models.GuildChannel
+	RoleIDs []uuid.UUID
+	Message *models.Message
+	Edit    bool // repeated and flood only look at new messages from members
+}
+
+// A rule a message tripped
+type Violation struct {
+	Rule   *models.AutomodRule
+	Reason string // what was caught, for alerts
+
+	// the member's earlier messages that make up the repeat or flood,
+	// some may have been blocked and never saved
+	BurstIDs []uuid.UUID
+}
+
+type memberKey struct {
+	GuildID uuid.UUID
+	UserID  uuid.UUID
+}
+
+type recentMessage struct {
+	ID      uuid.UUID
+	Content string // normalized, see normalize
+	SentAt  time.Time
+}
+
+type compiledRule struct {
+	*models.AutomodRule
+	keywords *regexp.Regexp // nil without keywords
+	patterns []*regexp.Regexp
+}
+
+func NewEngine(store *db.Store) *Engine {
+	return &Engine{
+		Store:   store,
+		rules:   make(map[uuid.UUID][]*compiledRule),
+		history: make(map[memberKey][]recentMessage),
+	}
+}
+
+// Run forgets messages too old for any window until ctx is done
+func (engine *Engine) Run(ctx context.Context) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			engine.prune(time.Now())
+		}
+	}
+}
+
+// Drops the guild's compiled rules, they're loaded again on the next message
+func (engine *Engine) Invalidate(guildID uuid.UUID) {
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+
+	delete(engine.rules, guildID)
+	engine.invalidated++
+}
+
+// Returns the rules the message trips, in the order the rules were created.
+// New messages are remembered for the repeated and flood triggers whatever
+// happens to them, so spam that keeps getting blocked stays blocked.
+func (engine *Engine) Check(ctx context.Context, subject *Subject) ([]*Violation, error) {
+	rules, err := engine.loadRules(ctx, subject.Channel.GuildID)
+	if err != nil {
+		return nil, err
+	}
+
+	var history []recentMessage
+	if !subject.Edit && subject.Message.AuthorID != nil && tracksHistory(rules) {
+		history = engine.remember(subject.Channel.GuildID, *subject.Message.AuthorID, subject.Message)
+	}
+
+	var violations []*Violation
+	for _, rule := range rules {
+		if !rule.Enabled || rule.Exempt(subject.Channel, subject.RoleIDs) {
+			continue
+		}
+		if (subject.Edit || subject.Message.AuthorID == nil) && rule.TracksHistory() {
+			continue
+		}
+
+		if violation := rule.check(subject.Message, history); violation != nil {
+			violations = append(violations, violation)
+		}
+	}
+
+	return violations, nil
+}
+
+// Whether any of the violations keeps the message from being sent
+func Blocks(violations []*Violation) bool {
+	for _, violation := range violations {
+		if violation.Rule.Action(models.AutomodActionBlock) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (engine *Engine) loadRules(ctx context.Context, guildID uuid.UUID) ([]*compiledRule, error) {
+	engine.mutex.Lock()
+	rules, ok := engine.rules[guildID]
+	invalidated := engine.invalidated
+	engine.mutex.Unlock()
+	if ok {
+		return rules, nil
+	}
+
+	stored, err := engine.Store.Automod.GetRulesForGuild(ctx, guildID)
+	if err != nil {
+		return nil, err
+	}
+
+	rules = make([]*compiledRule, 0, len(stored))
+	for _, rule := range stored {
+		compiled, err := compile(rule)
+		if err != nil {
+			return nil, fmt.Errorf("automod rule %s: %w", rule.ID, err)
+		}
+		rules = append(rules, compiled)
+	}
+
+	engine.mutex.Lock()
+	if engine.invalidated == invalidated {
+		engine.rules[guildID] = rules
+	}
+	engine.mutex.Unlock()
+
+	return rules, nil
+}
+
+// Adds the message to the member's history, returning what came before it
+// within models.MaxAutomodWindow
+func (engine *Engine) remember(guildID uuid.UUID, userID uuid.UUID, msg *models.Message) []recentMessage {
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+
+	key := memberKey{GuildID: guildID, UserID: userID}
+	history := recent(engine.history[key], msg.CreatedAt)
+	engine.history[key] = append(history, recentMessage{
+		ID:      msg.ID,
+		Content: normalize(msg),
+		SentAt:  msg.CreatedAt,
+	})
+
+	// the stored slice keeps growing, hand out a copy
+	return append([]recentMessage(nil), history...)
+}
+
+func (engine *Engine) prune(now time.Time) {
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+
+	for key, history := range engine.history {
+		history = recent(history, now)
+		if len(history) == 0 {
+			delete(engine.history, key)
+			continue
+		}
+		engine.history[key] = history
+	}
+}
+
+// Drops messages older than models.MaxAutomodWindow, history is in the order it was sent
+func recent(history []recentMessage, now time.Time) []recentMessage {
+	cutoff := now.Add(-models.MaxAutomodWindow)
+	for i, msg := range history {
+		if msg.SentAt.After(cutoff) {
+			return history[i:]
+		}
+	}
+	return nil
+}
+
+func tracksHistory(rules []*compiledRule) bool {
+	for _, rule := range rules {
+		if rule.Enabled && rule.TracksHistory() {
+			return true
+		}
+	}
+	return false
+}
+
+func compile(rule *models.AutomodRule) (*compiledRule, error) {
+	compiled := &compiledRule{AutomodRule: rule}
+
+	if len(rule.Config.Keywords) > 0 {
+		keywords, err := compileKeywords(rule.Config.Keywords)
+		if err != nil {
+			return nil, err
+		}
+		compiled.keywords = keywords
+	}
+
+	for _, pattern := range rule.Config.RegexPatterns {
+		regex, err := regexp.Compile(`(?i)` + pattern)
+		if err != nil {
+			return nil, err
+		}
+		compiled.patterns = append(compiled.patterns, regex)
+	}
+
+	return compiled, nil
+}
+
+// Builds one case insensitive regex out of every keyword. Keywords match
+// whole words unless they start or end with a *, word boundaries are left
+// off next to characters that can't form one.
+func compileKeywords(keywords []string) (*regexp.Regexp, error) {
+	alternatives := make([]string, 0, len(keywords))
+	for _, keyword := range keywords {
+		prefix, suffix := true, true
+		if strings.HasPrefix(keyword, "*") {
+			keyword, prefix = keyword[1:], false
+		}
+		if strings.HasSuffix(keyword, "*") {
+			keyword, suffix = keyword[:len(keyword)-1], false
+		}
+		if keyword == "" {
+			continue
+		}
+
+		alternative := regexp.QuoteMeta(keyword)
+		if first, _ := utf8.DecodeRuneInString(keyword); prefix && isWordRune(first) {
+			alternative = `\b` + alternative
+		}
+		if last, _ := utf8.DecodeLastRuneInString(keyword); suffix && isWordRune(last) {
+			alternative += `\b`
+		}
+		alternatives = append(alternatives, alternative)
+	}
+
+	if len(alternatives) == 0 {
+		return nil, nil
+	}
+
+	return regexp.Compile(`(?i)(?:` + strings.Join(alternatives, "|") + `)`)
+}
+
+// \b only knows ASCII word characters
+func isWordRune(r rune) bool {
+	return r < utf8.RuneSelf && (r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r))
+}
+
+func (rule *compiledRule) check(msg *models.Message, history []recentMessage) *Violation {
+	switch rule.Trigger {
+	case models.AutomodTriggerKeyword:
+		return rule.checkKeywords(msg)
+	case models.AutomodTriggerMentionSpam:
+		return rule.checkMentions(msg)
+	case models.AutomodTriggerLinks:
+		return rule.checkLinks(msg)
+	case models.AutomodTriggerRepeated:
+		return rule.checkRepeated(msg, history)
+	case models.AutomodTriggerFlood:
+		return rule.checkFlood(msg, history)
+	}
+	return nil
+}
+
+// Looks at the content as written and as plain text, so markup splitting
+// a word up doesn't get it through
+func (rule *compiledRule) checkKeywords(msg *models.Message) *Violation {
+	texts := []string{msg.Content, msg.PlainText}
+
+	for _, text := range texts {
+		if rule.keywords != nil {
+			if match := rule.keywords.FindString(text); match != "" {
+				return &Violation{Rule: rule.AutomodRule, Reason: fmt.Sprintf("keyword %q", match)}
+			}
+		}
+		for _, regex := range rule.patterns {
+			if match := regex.FindString(text); match != "" {
+				return &Violation{Rule: rule.AutomodRule, Reason: fmt.Sprintf("pattern match %q", match)}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (rule *compiledRule) checkMentions(msg *models.Message) *Violation {
+	mentioned := make(map[string]bool)
+	for _, id := range markdown.MentionIDs(msg.AST, markdown.NodeUserMention) {
+		mentioned["user:"+id] = true
+	}
+	for _, id := range markdown.MentionIDs(msg.AST, markdown.NodeRoleMention) {
+		mentioned["role:"+id] = true
+	}
+	if len(markdown.MentionIDs(msg.AST, markdown.NodeEveryoneMention)) > 0 {
+		mentioned["everyone"] = true
+	}
+	if len(markdown.MentionIDs(msg.AST, markdown.NodeHereMention)) > 0 {
+		mentioned["here"] = true
+	}
+
+	if len(mentioned) <= rule.Config.MentionLimit {
+		return nil
+	}
+	return &Violation{Rule: rule.AutomodRule, Reason: fmt.Sprintf("%d mentions", len(mentioned))}
+}
+
+func (rule *compiledRule) checkLinks(msg *models.Message) *Violation {
+	for _, link := range linkRegex.FindAllString(msg.Content, -1) {
+		parsed, err := url.Parse(link)
+		if err != nil || parsed.Hostname() == "" {
+			continue
+		}
+
+		host := strings.ToLower(strings.TrimSuffix(parsed.Hostname(), "."))
+		listed := matchesDomain(host, rule.Config.Domains)
+		if listed == (rule.Config.LinkMode == models.AutomodLinksDeny) {
+			return &Violation{Rule: rule.AutomodRule, Reason: "link to " + host}
+		}
+	}
+	return nil
+}
+
+func matchesDomain(host string, domains []string) bool {
+	for _, domain := range domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rule *compiledRule) checkRepeated(msg *models.Message, history []recentMessage) *Violation {
+	content := normalize(msg)
+	cutoff := msg.CreatedAt.Add(-rule.Config.Window())
+
+	var burst []uuid.UUID
+	for _, earlier := range history {
+		if earlier.SentAt.After(cutoff) && earlier.Content == content {
+			burst = append(burst, earlier.ID)
+		}
+	}
+
+	if len(burst)+1 <= rule.Config.MaxMessages {
+		return nil
+	}
+	return &Violation{
+		Rule:     rule.AutomodRule,
+		Reason:   fmt.Sprintf("same message %d times in %s", len(burst)+1, rule.Config.Window()),
+		BurstIDs: burst,
+	}
+}
+
+func (rule *compiledRule) checkFlood(msg *models.Message, history []recentMessage) *Violation {
+	cutoff := msg.CreatedAt.Add(-rule.Config.Window())
+
+	var burst []uuid.UUID
+	for _, earlier := range history {
+		if earlier.SentAt.After(cutoff) {
+			burst = append(burst, earlier.ID)
+		}
+	}
+
+	if len(burst)+1 <= rule.Config.MaxMessages {
+		return nil
+	}
+	return &Violation{
+		Rule:     rule.AutomodRule,
+		Reason:   fmt.Sprintf("%d messages in %s", len(burst)+1, rule.Config.Window()),
+		BurstIDs: burst,
+	}
+}
+
+// Case and spacing don't make a message different
+func normalize(msg *models.Message) string {
+	text := msg.PlainText
+	if text == "" {
+		text = msg.Content
+	}
+	return strings.ToLower(strings.Join(strings.Fields(text), " "))
+}
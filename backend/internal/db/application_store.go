@@ -0,0 +1,177 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"mana/internal/models"
+
+	"github.com/google/uuid"
+)
+
+type ApplicationStore struct {
+	DB *sql.DB
+}
+
+func NewApplicationStore(db *sql.DB) *ApplicationStore {
+	return &ApplicationStore{DB: db}
+}
+
+// columns scanApplication expects, in order. Needs applications as a joined
+// with the bot's user as u.
+const applicationColumns = `a.id, a.owner_id, a.bot_id, u.username, a.description, a.public, a.token_hash, a.created_at`
+
+func scanApplication(row rowScanner) (*models.Application, error) {
+	var application models.Application
+	err := row.Scan(
+		&application.ID,
+		&application.OwnerID,
+		&application.BotID,
+		&application.Name,
+		&application.Description,
+		&application.Public,
+		&application.TokenHash,
+		&application.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	application.HasToken = application.TokenHash != ""
+	return &application, nil
+}
+
+// Creates the bot's user along with the application
+func (applicationStore *ApplicationStore) CreateApplication(ctx context.Context, application *models.Application, bot *models.User) error {
+	tx, err := applicationStore.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertUser(ctx, tx, bot); err != nil {
+		return err
+	}
+
+	insertApplicationSQL := `
+		INSERT INTO applications (id, owner_id, bot_id, description, public, token_hash, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+	_, err = tx.ExecContext(ctx, insertApplicationSQL,
+		application.ID,
+		application.OwnerID,
+		application.BotID,
+		application.Description,
+		application.Public,
+		application.TokenHash,
+		application.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (applicationStore *ApplicationStore) GetApplication(ctx context.Context, applicationID uuid.UUID) (*models.Application, error) {
+	getApplicationSQL := `
+		SELECT ` + applicationColumns + `
+		FROM applications a
+		JOIN users u ON u.id = a.bot_id
+		WHERE a.id = $1
+	`
+
+	application, err := scanApplication(applicationStore.DB.QueryRowContext(ctx, getApplicationSQL, applicationID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return application, err
+}
+
+func (applicationStore *ApplicationStore) GetApplicationByBot(ctx context.Context, botID uuid.UUID) (*models.Application, error) {
+	getApplicationSQL := `
+		SELECT ` + applicationColumns + `
+		FROM applications a
+		JOIN users u ON u.id = a.bot_id
+		WHERE a.bot_id = $1
+	`
+
+	application, err := scanApplication(applicationStore.DB.QueryRowContext(ctx, getApplicationSQL, botID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return application, err
+}
+
+// Oldest first
+func (applicationStore *ApplicationStore) GetApplicationsForOwner(ctx context.Context, ownerID uuid.UUID) ([]*models.Application, error) {
+	getApplicationsSQL := `
+		SELECT ` + applicationColumns + `
+		FROM applications a
+		JOIN users u ON u.id = a.bot_id
+		WHERE a.owner_id = $1
+		ORDER BY a.created_at ASC
+	`
+
+	rows, err := applicationStore.DB.QueryContext(ctx, getApplicationsSQL, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var applications []*models.Application
+	for rows.Next() {
+		application, err := scanApplication(rows)
+		if err != nil {
+			return nil, err
+		}
+		applications = append(applications, application)
+	}
+
+	return applications, rows.Err()
+}
+
+func (applicationStore *ApplicationStore) CountApplicationsForOwner(ctx context.Context, ownerID uuid.UUID) (int, error) {
+	countApplicationsSQL := `SELECT COUNT(*) FROM applications WHERE owner_id = $1`
+
+	var count int
+	err := applicationStore.DB.QueryRowContext(ctx, countApplicationsSQL, ownerID).Scan(&count)
+	return count, err
+}
+
+// Saves the name, which is the bot's username, the description and
+// whether it's public
+func (applicationStore *ApplicationStore) UpdateApplication(ctx context.Context, application *models.Application) error {
+	tx, err := applicationStore.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	updateBotNameSQL := `UPDATE users SET username = $1 WHERE id = $2`
+	if _, err := tx.ExecContext(ctx, updateBotNameSQL, application.Name, application.BotID); err != nil {
+		return err
+	}
+
+	updateApplicationSQL := `UPDATE applications SET description = $1, public = $2 WHERE id = $3`
+	if _, err := tx.ExecContext(ctx, updateApplicationSQL, application.Description, application.Public, application.ID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Replaces the token hash, which revokes the previous token. An empty hash
+// leaves the bot without a working token.
+func (applicationStore *ApplicationStore) SetTokenHash(ctx context.Context, applicationID uuid.UUID, tokenHash string) error {
+	setTokenHashSQL := `UPDATE applications SET token_hash = $1 WHERE id = $2`
+	_, err := applicationStore.DB.ExecContext(ctx, setTokenHashSQL, tokenHash, applicationID)
+	return err
+}
+
+// Deletes the bot's user, which takes the application, its memberships and
+// managed roles with it
+func (applicationStore *ApplicationStore) DeleteApplication(ctx context.Context, application *models.Application) error {
+	deleteBotSQL := `DELETE FROM users WHERE id = $1 AND bot`
+	_, err := applicationStore.DB.ExecContext(ctx, deleteBotSQL, application.BotID)
+	return err
+}
@@ -0,0 +1,152 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"mana/internal/models"
+
+	"github.com/google/uuid"
+)
+
+type AuditLogStore struct {
+	DB *sql.DB
+}
+
+func NewAuditLogStore(db *sql.DB) *AuditLogStore {
+	return &AuditLogStore{DB: db}
+}
+
+// Entries are never updated, only pruned once they pass the guild's retention
+func (auditLogStore *AuditLogStore) InsertEntry(ctx context.Context, entry *models.AuditLogEntry) error {
+	insertEntrySQL := `
+		INSERT INTO audit_log_entries (id, guild_id, actor_id, action, target_type, target_id, channel_id, changes, reason, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`
+
+	var changes []byte
+	if len(entry.Changes) > 0 {
+		var err error
+		changes, err = json.Marshal(entry.Changes)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := auditLogStore.DB.ExecContext(ctx, insertEntrySQL,
+		entry.ID,
+		entry.GuildID,
+		entry.ActorID,
+		entry.Action,
+		entry.TargetType,
+		entry.TargetID,
+		entry.ChannelID,
+		changes,
+		entry.Reason,
+		entry.CreatedAt,
+	)
+	return err
+}
+
+// Newest first, paging back from filter.Before when it's set
+func (auditLogStore *AuditLogStore) GetEntries(ctx context.Context, guildID uuid.UUID, filter *models.AuditLogFilter) ([]*models.AuditLogEntry, error) {
+	getEntriesSQL := `
+		SELECT id, guild_id, actor_id, action, target_type, target_id, channel_id, changes, reason, created_at
+		FROM audit_log_entries
+		WHERE guild_id = $1
+	`
+
+	args := []interface{}{guildID}
+	paramIndex := 2
+
+	if filter.ActorID != nil {
+		getEntriesSQL += fmt.Sprintf(" AND actor_id = $%d", paramIndex)
+		args = append(args, *filter.ActorID)
+		paramIndex++
+	}
+
+	if filter.Action != "" {
+		getEntriesSQL += fmt.Sprintf(" AND action = $%d", paramIndex)
+		args = append(args, filter.Action)
+		paramIndex++
+	}
+
+	if filter.TargetID != "" {
+		getEntriesSQL += fmt.Sprintf(" AND target_id = $%d", paramIndex)
+		args = append(args, filter.TargetID)
+		paramIndex++
+	}
+
+	if filter.Before != nil {
+		getEntriesSQL += fmt.Sprintf(
+			" AND (created_at, id) < (SELECT created_at, id FROM audit_log_entries WHERE id = $%d AND guild_id = $1)",
+			paramIndex,
+		)
+		args = append(args, *filter.Before)
+		paramIndex++
+	}
+
+	getEntriesSQL += fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d", paramIndex)
+	args = append(args, filter.Limit)
+
+	rows, err := auditLogStore.DB.QueryContext(ctx, getEntriesSQL, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*models.AuditLogEntry
+	for rows.Next() {
+		var entry models.AuditLogEntry
+		var changes []byte
+		if err := rows.Scan(
+			&entry.ID,
+			&entry.GuildID,
+			&entry.ActorID,
+			&entry.Action,
+			&entry.TargetType,
+			&entry.TargetID,
+			&entry.ChannelID,
+			&changes,
+			&entry.Reason,
+			&entry.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+
+		if changes != nil {
+			if err := json.Unmarshal(changes, &entry.Changes); err != nil {
+				return nil, err
+			}
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// Deletes one batch of entries older than their guild's audit retention,
+// returning how many went. Guilds with a retention of 0 keep everything.
+func (auditLogStore *AuditLogStore) PruneExpiredEntries(ctx context.Context, batchSize int) (int, error) {
+	pruneEntriesSQL := `
+		DELETE FROM audit_log_entries
+		WHERE id IN (
+			SELECT a.id FROM audit_log_entries a
+			JOIN guilds g ON g.id = a.guild_id
+			WHERE g.audit_retention_days > 0
+			AND a.created_at < now() - make_interval(days => g.audit_retention_days)
+			LIMIT $1
+			FOR UPDATE OF a SKIP LOCKED
+		)
+	`
+
+	result, err := auditLogStore.DB.ExecContext(ctx, pruneEntriesSQL, batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted, err := result.RowsAffected()
+	return int(deleted), err
+}
@@ -0,0 +1,178 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"mana/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+type AutomodStore struct {
+	DB *sql.DB
+}
+
+func NewAutomodStore(db *sql.DB) *AutomodStore {
+	return &AutomodStore{DB: db}
+}
+
+const automodRuleColumns = `id, guild_id, name, enabled, trigger, config, actions, exempt_role_ids, exempt_channel_ids, creator_id, created_at`
+
+func scanAutomodRule(row rowScanner) (*models.AutomodRule, error) {
+	var rule models.AutomodRule
+	var config, actions []byte
+	err := row.Scan(
+		&rule.ID,
+		&rule.GuildID,
+		&rule.Name,
+		&rule.Enabled,
+		&rule.Trigger,
+		&config,
+		&actions,
+		pq.Array(&rule.ExemptRoleIDs),
+		pq.Array(&rule.ExemptChannelIDs),
+		&rule.CreatorID,
+		&rule.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(config, &rule.Config); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(actions, &rule.Actions); err != nil {
+		return nil, err
+	}
+
+	if rule.Actions == nil {
+		rule.Actions = []models.AutomodAction{}
+	}
+	if rule.ExemptRoleIDs == nil {
+		rule.ExemptRoleIDs = []uuid.UUID{}
+	}
+	if rule.ExemptChannelIDs == nil {
+		rule.ExemptChannelIDs = []uuid.UUID{}
+	}
+
+	return &rule, nil
+}
+
+func (automodStore *AutomodStore) CreateRule(ctx context.Context, rule *models.AutomodRule) error {
+	insertRuleSQL := `
+		INSERT INTO automod_rules (id, guild_id, name, enabled, trigger, config, actions, exempt_role_ids, exempt_channel_ids, creator_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`
+
+	config, actions, err := marshalAutomodRule(rule)
+	if err != nil {
+		return err
+	}
+
+	_, err = automodStore.DB.ExecContext(ctx, insertRuleSQL,
+		rule.ID,
+		rule.GuildID,
+		rule.Name,
+		rule.Enabled,
+		rule.Trigger,
+		config,
+		actions,
+		pq.Array(rule.ExemptRoleIDs),
+		pq.Array(rule.ExemptChannelIDs),
+		rule.CreatorID,
+		rule.CreatedAt,
+	)
+	return err
+}
+
+func (automodStore *AutomodStore) GetRule(ctx context.Context, ruleID uuid.UUID) (*models.AutomodRule, error) {
+	getRuleSQL := `SELECT ` + automodRuleColumns + ` FROM automod_rules WHERE id = $1`
+
+	rule, err := scanAutomodRule(automodStore.DB.QueryRowContext(ctx, getRuleSQL, ruleID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return rule, err
+}
+
+// Oldest first, disabled rules included
+func (automodStore *AutomodStore) GetRulesForGuild(ctx context.Context, guildID uuid.UUID) ([]*models.AutomodRule, error) {
+	getRulesSQL := `
+		SELECT ` + automodRuleColumns + `
+		FROM automod_rules
+		WHERE guild_id = $1
+		ORDER BY created_at ASC
+	`
+
+	rows, err := automodStore.DB.QueryContext(ctx, getRulesSQL, guildID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []*models.AutomodRule
+	for rows.Next() {
+		rule, err := scanAutomodRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, rows.Err()
+}
+
+func (automodStore *AutomodStore) CountRulesForGuild(ctx context.Context, guildID uuid.UUID) (int, error) {
+	countRulesSQL := `SELECT COUNT(*) FROM automod_rules WHERE guild_id = $1`
+
+	var count int
+	err := automodStore.DB.QueryRowContext(ctx, countRulesSQL, guildID).Scan(&count)
+	return count, err
+}
+
+// Saves everything but the guild, trigger and creator, which never change
+func (automodStore *AutomodStore) UpdateRule(ctx context.Context, rule *models.AutomodRule) error {
+	updateRuleSQL := `
+		UPDATE automod_rules
+		SET name = $1, enabled = $2, config = $3, actions = $4, exempt_role_ids = $5, exempt_channel_ids = $6
+		WHERE id = $7
+	`
+
+	config, actions, err := marshalAutomodRule(rule)
+	if err != nil {
+		return err
+	}
+
+	_, err = automodStore.DB.ExecContext(ctx, updateRuleSQL,
+		rule.Name,
+		rule.Enabled,
+		config,
+		actions,
+		pq.Array(rule.ExemptRoleIDs),
+		pq.Array(rule.ExemptChannelIDs),
+		rule.ID,
+	)
+	return err
+}
+
+func (automodStore *AutomodStore) DeleteRule(ctx context.Context, ruleID uuid.UUID) error {
+	deleteRuleSQL := `DELETE FROM automod_rules WHERE id = $1`
+	_, err := automodStore.DB.ExecContext(ctx, deleteRuleSQL, ruleID)
+	return err
+}
+
+func marshalAutomodRule(rule *models.AutomodRule) ([]byte, []byte, error) {
+	config, err := json.Marshal(rule.Config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	actions, err := json.Marshal(rule.Actions)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return config, actions, nil
+}
@@ -18,6 +18,19 @@ type Store struct {
 	GuildChannels         *GuildChannelStore
 	GuildChannelOverrides *GuildChannelOverrideStore
 	Messages              *MessageStore
+	DMChannels            *DMChannelStore
+	Friends               *FriendStore
+	Embeds                *EmbedStore
+	Moderation            *ModerationStore
+	Retention             *RetentionStore
+	Invites               *InviteStore
+	AuditLog              *AuditLogStore
+	Insights              *InsightsStore
+	Webhooks              *WebhookStore
+	Subscriptions         *SubscriptionStore
+	Onboarding            *OnboardingStore
+	Automod               *AutomodStore
+	Applications          *ApplicationStore
 }
 
 func NewStore() (*Store, error) {
@@ -55,6 +68,19 @@ func NewStore() (*Store, error) {
 		GuildChannels:         NewGuildChannelStore(db),
 		GuildChannelOverrides: NewGuildChannelOverrideStore(db),
 		Messages:              NewMessageStore(db),
+		DMChannels:            NewDMChannelStore(db),
+		Friends:               NewFriendStore(db),
+		Embeds:                NewEmbedStore(db),
+		Moderation:            NewModerationStore(db),
+		Retention:             NewRetentionStore(db),
+		Invites:               NewInviteStore(db),
+		AuditLog:              NewAuditLogStore(db),
+		Insights:              NewInsightsStore(db),
+		Webhooks:              NewWebhookStore(db),
+		Subscriptions:         NewSubscriptionStore(db),
+		Onboarding:            NewOnboardingStore(db),
+		Automod:               NewAutomodStore(db),
+		Applications:          NewApplicationStore(db),
 	}
 
 	log.Println("Connected to PostgreSQL.")
@@ -91,10 +117,14 @@ func (store *Store) createTables() error {
 		CREATE TABLE IF NOT EXISTS users (
 			id UUID PRIMARY KEY,
 			username TEXT NOT NULL UNIQUE,
-			email TEXT NOT NULL UNIQUE,
-			password TEXT NOT NULL,
+			email TEXT UNIQUE, -- null for bots
+			password TEXT NOT NULL, -- empty for bots, they use their application's token
 			activity_status TEXT DEFAULT 'offline',
 			account_status TEXT DEFAULT 'active',
+			dm_privacy TEXT NOT NULL DEFAULT 'guilds_and_friends',
+			suppress_embeds BOOLEAN NOT NULL DEFAULT false,
+			email_verified BOOLEAN NOT NULL DEFAULT false,
+			bot BOOLEAN NOT NULL DEFAULT false,
 			created_at TIMESTAMPTZ NOT NULL
 		);
 	`
@@ -102,10 +132,19 @@ func (store *Store) createTables() error {
 		CREATE TABLE guilds (
 			id UUID PRIMARY KEY,
 			name TEXT NOT NULL,
-			owner_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
-			invite_code TEXT NOT NULL,
-			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
-			CONSTRAINT guilds_invite_code_key UNIQUE (invite_code)
+			owner_id UUID REFERENCES users(id) ON DELETE SET NULL, -- null while frozen
+			retention_mode TEXT NOT NULL DEFAULT 'forever',
+			retention_value INT NOT NULL DEFAULT 0,
+			audit_retention_days INT NOT NULL DEFAULT 90, -- 0 keeps entries forever
+			deletes_at TIMESTAMPTZ, -- set while a deletion is pending
+			description TEXT NOT NULL DEFAULT '',
+			icon_url TEXT NOT NULL DEFAULT '',
+			banner_url TEXT NOT NULL DEFAULT '',
+			system_channel_id UUID, -- references guild_channels, added along with that table
+			default_notifications TEXT NOT NULL DEFAULT 'all_messages',
+			explicit_content_filter BOOLEAN NOT NULL DEFAULT false,
+			verification_level SMALLINT NOT NULL DEFAULT 0,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
 		);
 	`
 
@@ -113,7 +152,13 @@ func (store *Store) createTables() error {
 		CREATE TABLE guild_members (
 			guild_id UUID NOT NULL REFERENCES guilds(id) ON DELETE CASCADE,
 			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			temporary BOOLEAN NOT NULL DEFAULT false,
+			pending BOOLEAN NOT NULL DEFAULT false, -- hasn't passed screening, holds no roles yet
+			timeout_until TIMESTAMPTZ,
 			joined_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			nickname TEXT,
+			avatar_url TEXT,
+			bio TEXT,
 			PRIMARY KEY (guild_id, user_id)
 		);
 	`
@@ -126,6 +171,8 @@ func (store *Store) createTables() error {
 			position SMALLINT NOT NULL CHECK (position BETWEEN 0 AND 255),
 			permissions BIGINT NOT NULL,
 			color TEXT NOT NULL,
+			hoist BOOLEAN NOT NULL DEFAULT false, -- shown as its own group in the member list
+			bot_id UUID REFERENCES users(id) ON DELETE CASCADE, -- managed role of a bot, removed when it leaves
 			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
 		);
 	`
@@ -135,13 +182,14 @@ func (store *Store) createTables() error {
 			guild_id UUID NOT NULL,
 			user_id UUID NOT NULL,
 			role_id UUID NOT NULL REFERENCES guild_roles(id) ON DELETE CASCADE,
+			self_assigned BOOLEAN NOT NULL DEFAULT false, -- picked by the member in onboarding
 			PRIMARY KEY (guild_id, user_id, role_id),
 			FOREIGN KEY (guild_id, user_id) REFERENCES guild_members(guild_id, user_id) ON DELETE CASCADE
 		);
 	`
 
 	createGuildChannelsTableSQL := `
-		CREATE TYPE channel_type AS ENUM ('text', 'voice');
+		CREATE TYPE channel_type AS ENUM ('text', 'voice', 'category');
 
 		CREATE TABLE guild_channels (
 			id UUID PRIMARY KEY,
@@ -152,34 +200,329 @@ func (store *Store) createTables() error {
 			topic TEXT,
 			bitrate INT, 	 -- only for voice chan
 			user_limit INT,  -- only for voice chan
+			retention_mode TEXT,  -- null inherits the guild default
+			retention_value INT,
+			parent_id UUID REFERENCES guild_channels(id) ON DELETE SET NULL,  -- category
 			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
 		);
+
+		-- deferred, a new guild is inserted before its #general
+		ALTER TABLE guilds ADD CONSTRAINT guilds_system_channel_id_fkey
+			FOREIGN KEY (system_channel_id) REFERENCES guild_channels(id)
+			ON DELETE SET NULL DEFERRABLE INITIALLY DEFERRED;
 	`
 
 	createGuildChannelPermissionOverridesTableSQL := `
-		CREATE TABLE channel_permission_overrides (
+		CREATE TABLE guild_channel_permission_overrides (
 			channel_id UUID NOT NULL REFERENCES guild_channels(id) ON DELETE CASCADE,
-			user_id UUID,
-			role_id UUID,
+			user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+			role_id UUID REFERENCES guild_roles(id) ON DELETE CASCADE,
 			allow BIGINT NOT NULL,
 			deny BIGINT NOT NULL,
 			CHECK (
 				(user_id IS NOT NULL AND role_id IS NULL) OR
 				(user_id IS NULL AND role_id IS NOT NULL)
-			),
-			PRIMARY KEY (channel_id, COALESCE(user_id, role_id))
+			)
+		);
+
+		-- one override per user and per role in a channel
+		CREATE UNIQUE INDEX guild_channel_overrides_user_key
+			ON guild_channel_permission_overrides (channel_id, user_id) WHERE user_id IS NOT NULL;
+		CREATE UNIQUE INDEX guild_channel_overrides_role_key
+			ON guild_channel_permission_overrides (channel_id, role_id) WHERE role_id IS NOT NULL;
+	`
+
+	createFriendshipsTableSQL := `
+		CREATE TABLE IF NOT EXISTS friendships (
+			requester_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			addressee_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			status TEXT NOT NULL CHECK (status IN ('pending', 'accepted')),
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			PRIMARY KEY (requester_id, addressee_id),
+			CHECK (requester_id <> addressee_id)
+		);
+	`
+
+	createDMChannelsTableSQL := `
+		CREATE TABLE IF NOT EXISTS dm_channels (
+			id UUID PRIMARY KEY,
+			type TEXT NOT NULL CHECK (type IN ('dm', 'group_dm')),
+			name TEXT,
+			owner_id UUID REFERENCES users(id) ON DELETE SET NULL, -- only for group dms
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
 		);
 	`
 
+	createDMChannelRecipientsTableSQL := `
+		CREATE TABLE IF NOT EXISTS dm_channel_recipients (
+			channel_id UUID NOT NULL REFERENCES dm_channels(id) ON DELETE CASCADE,
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			added_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			PRIMARY KEY (channel_id, user_id)
+		);
+	`
+
+	createChannelWebhooksTableSQL := `
+		CREATE TABLE IF NOT EXISTS channel_webhooks (
+			id UUID PRIMARY KEY,
+			guild_id UUID NOT NULL REFERENCES guilds(id) ON DELETE CASCADE,
+			channel_id UUID NOT NULL REFERENCES guild_channels(id) ON DELETE CASCADE,
+			name TEXT NOT NULL,
+			avatar_url TEXT NOT NULL DEFAULT '',
+			token_hash TEXT NOT NULL,
+			creator_id UUID REFERENCES users(id) ON DELETE SET NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+
+		CREATE INDEX IF NOT EXISTS channel_webhooks_channel_idx
+			ON channel_webhooks (channel_id);
+
+		CREATE INDEX IF NOT EXISTS channel_webhooks_guild_idx
+			ON channel_webhooks (guild_id);
+	`
+
 	createMessagesTableSQL := `
 		CREATE TABLE messages (
 			id UUID PRIMARY KEY,
 			channel_id UUID REFERENCES guild_channels(id) ON DELETE CASCADE,
+			dm_channel_id UUID REFERENCES dm_channels(id) ON DELETE CASCADE,
+			type TEXT NOT NULL DEFAULT 'default',
 			author_id UUID REFERENCES users(id) ON DELETE CASCADE,
+			webhook_id UUID REFERENCES channel_webhooks(id) ON DELETE SET NULL,
+			webhook_name TEXT,
+			webhook_avatar_url TEXT,
+			bot BOOLEAN NOT NULL DEFAULT false, -- author_id is a bot account
 			content TEXT NOT NULL,
+			ast JSONB NOT NULL DEFAULT '[]',
+			plain_text TEXT NOT NULL DEFAULT '',
+			embeds JSONB NOT NULL DEFAULT '[]',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			edited_at TIMESTAMPTZ,
+			CHECK ((channel_id IS NULL) <> (dm_channel_id IS NULL)),
+			-- sent by either a user or a webhook, which keeps its name after it's deleted
+			CHECK ((author_id IS NULL) <> (webhook_name IS NULL))
+		);
+
+		CREATE INDEX IF NOT EXISTS messages_channel_created_idx
+			ON messages (channel_id, created_at);
+
+		CREATE INDEX IF NOT EXISTS messages_plain_text_search_idx
+			ON messages USING GIN (to_tsvector('simple', plain_text));
+
+	`
+
+	createModerationActionsTableSQL := `
+		CREATE TABLE IF NOT EXISTS moderation_actions (
+			id UUID PRIMARY KEY,
+			guild_id UUID NOT NULL REFERENCES guilds(id) ON DELETE CASCADE,
+			moderator_id UUID REFERENCES users(id) ON DELETE SET NULL,
+			action TEXT NOT NULL,
+			target_user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+			channel_id UUID REFERENCES guild_channels(id) ON DELETE SET NULL,
+			reason TEXT NOT NULL DEFAULT '',
+			metadata JSONB,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+
+		CREATE INDEX IF NOT EXISTS moderation_actions_guild_idx
+			ON moderation_actions (guild_id, created_at DESC);
+	`
+
+	createAuditLogTableSQL := `
+		CREATE TABLE IF NOT EXISTS audit_log_entries (
+			id UUID PRIMARY KEY,
+			guild_id UUID NOT NULL REFERENCES guilds(id) ON DELETE CASCADE,
+			actor_id UUID REFERENCES users(id) ON DELETE SET NULL,
+			action TEXT NOT NULL,
+			target_type TEXT NOT NULL,
+			target_id TEXT NOT NULL DEFAULT '',
+			channel_id UUID REFERENCES guild_channels(id) ON DELETE SET NULL,
+			changes JSONB,
+			reason TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+
+		CREATE INDEX IF NOT EXISTS audit_log_entries_guild_idx
+			ON audit_log_entries (guild_id, created_at DESC, id DESC);
+	`
+
+	createInsightsTablesSQL := `
+		-- raw joins and leaves, rolled up into guild_insights_daily
+		CREATE TABLE IF NOT EXISTS guild_member_events (
+			guild_id UUID NOT NULL REFERENCES guilds(id) ON DELETE CASCADE,
+			user_id UUID NOT NULL,
+			kind TEXT NOT NULL CHECK (kind IN ('join', 'leave')),
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+
+		CREATE INDEX IF NOT EXISTS guild_member_events_created_idx
+			ON guild_member_events (created_at, guild_id);
+
+		CREATE TABLE IF NOT EXISTS guild_insights_daily (
+			guild_id UUID NOT NULL REFERENCES guilds(id) ON DELETE CASCADE,
+			day DATE NOT NULL,
+			messages INT NOT NULL DEFAULT 0,
+			active_members INT NOT NULL DEFAULT 0,
+			joins INT NOT NULL DEFAULT 0,
+			leaves INT NOT NULL DEFAULT 0,
+			invite_uses INT NOT NULL DEFAULT 0,
+			peak_connections INT NOT NULL DEFAULT 0,
+			PRIMARY KEY (guild_id, day)
+		);
+
+		CREATE TABLE IF NOT EXISTS guild_channel_insights_daily (
+			channel_id UUID NOT NULL REFERENCES guild_channels(id) ON DELETE CASCADE,
+			guild_id UUID NOT NULL REFERENCES guilds(id) ON DELETE CASCADE,
+			day DATE NOT NULL,
+			messages INT NOT NULL DEFAULT 0,
+			PRIMARY KEY (channel_id, day)
+		);
+
+		CREATE INDEX IF NOT EXISTS guild_channel_insights_daily_guild_idx
+			ON guild_channel_insights_daily (guild_id, day);
+
+		-- the rollup counts a day's messages across every channel at once
+		CREATE INDEX IF NOT EXISTS messages_created_idx
+			ON messages (created_at);
+	`
+
+	createEventSubscriptionsTablesSQL := `
+		CREATE TABLE IF NOT EXISTS guild_event_subscriptions (
+			id UUID PRIMARY KEY,
+			guild_id UUID NOT NULL REFERENCES guilds(id) ON DELETE CASCADE,
+			url TEXT NOT NULL,
+			events TEXT[] NOT NULL,
+			secret TEXT NOT NULL,
+			enabled BOOLEAN NOT NULL DEFAULT true,
+			consecutive_failures INT NOT NULL DEFAULT 0,
+			disabled_reason TEXT NOT NULL DEFAULT '',
+			creator_id UUID REFERENCES users(id) ON DELETE SET NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+
+		CREATE INDEX IF NOT EXISTS guild_event_subscriptions_guild_idx
+			ON guild_event_subscriptions (guild_id);
+
+		-- the delivery queue and log in one, rows leave the queue once they
+		-- succeed or run out of attempts
+		CREATE TABLE IF NOT EXISTS guild_event_deliveries (
+			id UUID PRIMARY KEY,
+			subscription_id UUID NOT NULL REFERENCES guild_event_subscriptions(id) ON DELETE CASCADE,
+			guild_id UUID NOT NULL REFERENCES guilds(id) ON DELETE CASCADE,
+			event_type TEXT NOT NULL,
+			payload JSONB NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'succeeded', 'failed')),
+			attempts INT NOT NULL DEFAULT 0,
+			next_attempt_at TIMESTAMPTZ,
+			last_attempt_at TIMESTAMPTZ,
+			response_status INT,
+			error TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			delivered_at TIMESTAMPTZ
+		);
+
+		CREATE INDEX IF NOT EXISTS guild_event_deliveries_due_idx
+			ON guild_event_deliveries (next_attempt_at)
+			WHERE status = 'pending';
+
+		CREATE INDEX IF NOT EXISTS guild_event_deliveries_subscription_idx
+			ON guild_event_deliveries (subscription_id, created_at DESC, id DESC);
+
+		CREATE INDEX IF NOT EXISTS guild_event_deliveries_created_idx
+			ON guild_event_deliveries (created_at);
+	`
+
+	createGuildBansTableSQL := `
+		CREATE TABLE IF NOT EXISTS guild_bans (
+			guild_id UUID NOT NULL REFERENCES guilds(id) ON DELETE CASCADE,
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			moderator_id UUID REFERENCES users(id) ON DELETE SET NULL,
+			reason TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			PRIMARY KEY (guild_id, user_id)
+		);
+	`
+
+	createLinkEmbedsTableSQL := `
+		CREATE TABLE IF NOT EXISTS link_embeds (
+			url TEXT PRIMARY KEY,
+			embed JSONB, -- null when the link could not be unfurled
+			fetched_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+	`
+
+	createGuildInvitesTableSQL := `
+		CREATE TABLE IF NOT EXISTS guild_invites (
+			code TEXT PRIMARY KEY,
+			guild_id UUID NOT NULL REFERENCES guilds(id) ON DELETE CASCADE,
+			channel_id UUID REFERENCES guild_channels(id) ON DELETE CASCADE,
+			creator_id UUID REFERENCES users(id) ON DELETE SET NULL,
+			max_uses INT NOT NULL DEFAULT 0,  -- 0 is unlimited
+			uses INT NOT NULL DEFAULT 0,
+			temporary BOOLEAN NOT NULL DEFAULT false,
+			expires_at TIMESTAMPTZ,  -- null never expires
+			revoked_at TIMESTAMPTZ,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+
+		CREATE INDEX IF NOT EXISTS guild_invites_guild_idx
+			ON guild_invites (guild_id, created_at DESC);
+
+		-- who joined with which invite, kept after they leave
+		CREATE TABLE IF NOT EXISTS guild_invite_uses (
+			code TEXT NOT NULL REFERENCES guild_invites(code) ON DELETE CASCADE,
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			used_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+
+		CREATE INDEX IF NOT EXISTS guild_invite_uses_code_idx
+			ON guild_invite_uses (code, used_at DESC);
+	`
+
+	createGuildOnboardingTableSQL := `
+		CREATE TABLE IF NOT EXISTS guild_onboarding (
+			guild_id UUID PRIMARY KEY REFERENCES guilds(id) ON DELETE CASCADE,
+			screening_enabled BOOLEAN NOT NULL DEFAULT false,
+			rules TEXT NOT NULL DEFAULT '',
+			prompts JSONB NOT NULL DEFAULT '[]',  -- options hold role ids, deleted roles are skipped when used
+			welcome_channel_id UUID REFERENCES guild_channels(id) ON DELETE SET NULL,
+			welcome_message TEXT NOT NULL DEFAULT '',
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+	`
+
+	createAutomodRulesTableSQL := `
+		CREATE TABLE IF NOT EXISTS automod_rules (
+			id UUID PRIMARY KEY,
+			guild_id UUID NOT NULL REFERENCES guilds(id) ON DELETE CASCADE,
+			name TEXT NOT NULL,
+			enabled BOOLEAN NOT NULL DEFAULT true,
+			trigger TEXT NOT NULL,
+			config JSONB NOT NULL DEFAULT '{}',
+			actions JSONB NOT NULL DEFAULT '[]',  -- alert channels are checked when the alert is posted
+			exempt_role_ids UUID[] NOT NULL DEFAULT '{}',
+			exempt_channel_ids UUID[] NOT NULL DEFAULT '{}',
+			creator_id UUID REFERENCES users(id) ON DELETE SET NULL,
 			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
 		);
 
+		CREATE INDEX IF NOT EXISTS automod_rules_guild_idx
+			ON automod_rules (guild_id, created_at);
+	`
+
+	createApplicationsTableSQL := `
+		CREATE TABLE IF NOT EXISTS applications (
+			id UUID PRIMARY KEY,
+			owner_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			bot_id UUID NOT NULL UNIQUE REFERENCES users(id) ON DELETE CASCADE,
+			description TEXT NOT NULL DEFAULT '',
+			public BOOLEAN NOT NULL DEFAULT false,
+			token_hash TEXT NOT NULL DEFAULT '', -- empty once the token is revoked
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+
+		CREATE INDEX IF NOT EXISTS applications_owner_idx
+			ON applications (owner_id, created_at);
 	`
 
 	var err error
@@ -226,12 +569,96 @@ func (store *Store) createTables() error {
 	}
 	log.Println("Guild channel permission overrides table ready.")
 
+	_, err = store.db.Exec(createFriendshipsTableSQL)
+	if err != nil {
+		return err
+	}
+	log.Println("Friendships table ready.")
+
+	_, err = store.db.Exec(createDMChannelsTableSQL)
+	if err != nil {
+		return err
+	}
+	log.Println("DM channels table ready.")
+
+	_, err = store.db.Exec(createDMChannelRecipientsTableSQL)
+	if err != nil {
+		return err
+	}
+	log.Println("DM channel recipients table ready.")
+
+	_, err = store.db.Exec(createChannelWebhooksTableSQL)
+	if err != nil {
+		return err
+	}
+	log.Println("Channel webhooks table ready.")
+
 	_, err = store.db.Exec(createMessagesTableSQL)
 	if err != nil {
 		return err
 	}
 	log.Println("Messages table ready.")
 
+	_, err = store.db.Exec(createModerationActionsTableSQL)
+	if err != nil {
+		return err
+	}
+	log.Println("Moderation actions table ready.")
+
+	_, err = store.db.Exec(createAuditLogTableSQL)
+	if err != nil {
+		return err
+	}
+	log.Println("Audit log table ready.")
+
+	_, err = store.db.Exec(createInsightsTablesSQL)
+	if err != nil {
+		return err
+	}
+	log.Println("Insights tables ready.")
+
+	_, err = store.db.Exec(createEventSubscriptionsTablesSQL)
+	if err != nil {
+		return err
+	}
+	log.Println("Event subscription tables ready.")
+
+	_, err = store.db.Exec(createGuildBansTableSQL)
+	if err != nil {
+		return err
+	}
+	log.Println("Guild bans table ready.")
+
+	_, err = store.db.Exec(createLinkEmbedsTableSQL)
+	if err != nil {
+		return err
+	}
+	log.Println("Link embeds table ready.")
+
+	_, err = store.db.Exec(createGuildInvitesTableSQL)
+	if err != nil {
+		return err
+	}
+	log.Println("Guild invites table ready.")
+
+	_, err = store.db.Exec(createGuildOnboardingTableSQL)
+	if err != nil {
+		return err
+	}
+	log.Println("Guild onboarding table ready.")
+
+	_, err = store.db.Exec(createAutomodRulesTableSQL)
+	if err != nil {
+		return err
+	}
+	log.Println("Automod rules table ready.")
+
+	_, err = store.db.Exec(createApplicationsTableSQL)
+	if err != nil {
+		return err
+	}
+	log.Println("Applications table ready.")
+
 	log.Println("All tables ready.")
 	return nil
 }
@@ -0,0 +1,244 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"mana/internal/models"
+
+	"github.com/google/uuid"
+)
+
+var ErrGroupDMFull = errors.New("group dm is full")
+
+type DMChannelStore struct {
+	DB *sql.DB
+}
+
+func NewDMChannelStore(db *sql.DB) *DMChannelStore {
+	return &DMChannelStore{DB: db}
+}
+
+func (dmChannelStore *DMChannelStore) CreateDMChannel(ctx context.Context, channel *models.DMChannel) error {
+	tx, err := dmChannelStore.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	insertDMChannelSQL := `
+		INSERT INTO dm_channels (id, type, name, owner_id, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+	if _, err := tx.ExecContext(ctx, insertDMChannelSQL,
+		channel.ID,
+		channel.Type,
+		channel.Name,
+		channel.OwnerID,
+		channel.CreatedAt,
+	); err != nil {
+		return err
+	}
+
+	insertRecipientSQL := `
+		INSERT INTO dm_channel_recipients (channel_id, user_id, added_at)
+		VALUES ($1, $2, $3)
+	`
+	for _, userID := range channel.RecipientIDs {
+		if _, err := tx.ExecContext(ctx, insertRecipientSQL, channel.ID, userID, channel.CreatedAt); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (dmChannelStore *DMChannelStore) GetDMChannelByID(ctx context.Context, channelID uuid.UUID) (*models.DMChannel, error) {
+	selectDMChannelSQL := `
+		SELECT id, type, COALESCE(name, ''), owner_id, created_at
+		FROM dm_channels
+		WHERE id = $1
+	`
+
+	var channel models.DMChannel
+	var ownerID uuid.NullUUID
+	err := dmChannelStore.DB.QueryRowContext(ctx, selectDMChannelSQL, channelID).Scan(
+		&channel.ID,
+		&channel.Type,
+		&channel.Name,
+		&ownerID,
+		&channel.CreatedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if ownerID.Valid {
+		channel.OwnerID = &ownerID.UUID
+	}
+
+	channel.RecipientIDs, err = dmChannelStore.GetRecipients(ctx, channel.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &channel, nil
+}
+
+func (dmChannelStore *DMChannelStore) GetDMChannelsForUser(ctx context.Context, userID uuid.UUID) ([]*models.DMChannel, error) {
+	selectUserDMChannelsSQL := `
+		SELECT c.id, c.type, COALESCE(c.name, ''), c.owner_id, c.created_at
+		FROM dm_channels c
+		JOIN dm_channel_recipients r ON r.channel_id = c.id
+		WHERE r.user_id = $1
+		ORDER BY c.created_at DESC
+	`
+
+	rows, err := dmChannelStore.DB.QueryContext(ctx, selectUserDMChannelsSQL, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var channels []*models.DMChannel
+	for rows.Next() {
+		var channel models.DMChannel
+		var ownerID uuid.NullUUID
+		if err := rows.Scan(
+			&channel.ID,
+			&channel.Type,
+			&channel.Name,
+			&ownerID,
+			&channel.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+
+		if ownerID.Valid {
+			channel.OwnerID = &ownerID.UUID
+		}
+		channels = append(channels, &channel)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, channel := range channels {
+		channel.RecipientIDs, err = dmChannelStore.GetRecipients(ctx, channel.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return channels, nil
+}
+
+// Finds the existing 1:1 dm between two users, nil if they never talked
+func (dmChannelStore *DMChannelStore) FindDMChannelBetween(ctx context.Context, userID uuid.UUID, otherUserID uuid.UUID) (*models.DMChannel, error) {
+	selectDMBetweenSQL := `
+		SELECT c.id
+		FROM dm_channels c
+		JOIN dm_channel_recipients a ON a.channel_id = c.id AND a.user_id = $1
+		JOIN dm_channel_recipients b ON b.channel_id = c.id AND b.user_id = $2
+		WHERE c.type = 'dm'
+		LIMIT 1
+	`
+
+	var channelID uuid.UUID
+	err := dmChannelStore.DB.QueryRowContext(ctx, selectDMBetweenSQL, userID, otherUserID).Scan(&channelID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return dmChannelStore.GetDMChannelByID(ctx, channelID)
+}
+
+func (dmChannelStore *DMChannelStore) GetRecipients(ctx context.Context, channelID uuid.UUID) ([]uuid.UUID, error) {
+	selectRecipientsSQL := `
+		SELECT user_id
+		FROM dm_channel_recipients
+		WHERE channel_id = $1
+		ORDER BY added_at ASC
+	`
+
+	rows, err := dmChannelStore.DB.QueryContext(ctx, selectRecipientsSQL, channelID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recipients []uuid.UUID
+	for rows.Next() {
+		var userID uuid.UUID
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, userID)
+	}
+
+	return recipients, rows.Err()
+}
+
+// Adds the user unless the group already has models.MaxGroupDMRecipients,
+// ErrGroupDMFull then. The channel row stays locked until the insert so
+// concurrent adds can't both take the last spot.
+func (dmChannelStore *DMChannelStore) AddRecipient(ctx context.Context, channelID uuid.UUID, userID uuid.UUID) error {
+	tx, err := dmChannelStore.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	lockChannelSQL := `SELECT id FROM dm_channels WHERE id = $1 FOR UPDATE`
+	if _, err := tx.ExecContext(ctx, lockChannelSQL, channelID); err != nil {
+		return err
+	}
+
+	countRecipientsSQL := `SELECT COUNT(*) FROM dm_channel_recipients WHERE channel_id = $1`
+	var count int
+	if err := tx.QueryRowContext(ctx, countRecipientsSQL, channelID).Scan(&count); err != nil {
+		return err
+	}
+	if count >= models.MaxGroupDMRecipients {
+		return ErrGroupDMFull
+	}
+
+	insertRecipientSQL := `
+		INSERT INTO dm_channel_recipients (channel_id, user_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`
+	if _, err := tx.ExecContext(ctx, insertRecipientSQL, channelID, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (dmChannelStore *DMChannelStore) RemoveRecipient(ctx context.Context, channelID uuid.UUID, userID uuid.UUID) error {
+	deleteRecipientSQL := `
+		DELETE FROM dm_channel_recipients
+		WHERE channel_id = $1 AND user_id = $2
+	`
+	_, err := dmChannelStore.DB.ExecContext(ctx, deleteRecipientSQL, channelID, userID)
+	return err
+}
+
+func (dmChannelStore *DMChannelStore) UpdateOwner(ctx context.Context, channelID uuid.UUID, ownerID uuid.UUID) error {
+	updateOwnerSQL := `UPDATE dm_channels SET owner_id = $1 WHERE id = $2`
+	_, err := dmChannelStore.DB.ExecContext(ctx, updateOwnerSQL, ownerID, channelID)
+	return err
+}
+
+func (dmChannelStore *DMChannelStore) DeleteDMChannel(ctx context.Context, channelID uuid.UUID) error {
+	deleteDMChannelSQL := `DELETE FROM dm_channels WHERE id = $1`
+	_, err := dmChannelStore.DB.ExecContext(ctx, deleteDMChannelSQL, channelID)
+	return err
+}
@@ -0,0 +1,69 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"mana/internal/models"
+	"time"
+)
+
+type EmbedStore struct {
+	DB *sql.DB
+}
+
+func NewEmbedStore(db *sql.DB) *EmbedStore {
+	return &EmbedStore{DB: db}
+}
+
+// Gets a cached embed fetched within maxAge. The bool reports a cache hit,
+// a hit with a nil embed means the link failed to unfurl last time.
+func (embedStore *EmbedStore) GetCachedEmbed(ctx context.Context, url string, maxAge time.Duration) (*models.Embed, bool, error) {
+	selectCachedEmbedSQL := `
+		SELECT embed
+		FROM link_embeds
+		WHERE url = $1 AND fetched_at > $2
+	`
+
+	var raw []byte
+	err := embedStore.DB.QueryRowContext(ctx, selectCachedEmbedSQL, url, time.Now().UTC().Add(-maxAge)).Scan(&raw)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if raw == nil {
+		return nil, true, nil
+	}
+
+	var embed models.Embed
+	if err := json.Unmarshal(raw, &embed); err != nil {
+		return nil, false, err
+	}
+
+	return &embed, true, nil
+}
+
+// Caches the embed for a url, a nil embed records a failed fetch
+func (embedStore *EmbedStore) UpsertCachedEmbed(ctx context.Context, url string, embed *models.Embed) error {
+	upsertCachedEmbedSQL := `
+		INSERT INTO link_embeds (url, embed, fetched_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (url)
+		DO UPDATE SET embed = EXCLUDED.embed, fetched_at = EXCLUDED.fetched_at
+	`
+
+	var raw []byte
+	if embed != nil {
+		var err error
+		raw, err = json.Marshal(embed)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := embedStore.DB.ExecContext(ctx, upsertCachedEmbedSQL, url, raw, time.Now().UTC())
+	return err
+}
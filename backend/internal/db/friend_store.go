@@ -0,0 +1,119 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"mana/internal/models"
+
+	"github.com/google/uuid"
+)
+
+type FriendStore struct {
+	DB *sql.DB
+}
+
+func NewFriendStore(db *sql.DB) *FriendStore {
+	return &FriendStore{DB: db}
+}
+
+func (friendStore *FriendStore) InsertFriendRequest(ctx context.Context, friendship *models.Friendship) error {
+	insertFriendshipSQL := `
+		INSERT INTO friendships (requester_id, addressee_id, status, created_at)
+		VALUES ($1, $2, $3, $4)
+	`
+	_, err := friendStore.DB.ExecContext(ctx, insertFriendshipSQL,
+		friendship.RequesterID,
+		friendship.AddresseeID,
+		friendship.Status,
+		friendship.CreatedAt,
+	)
+	return err
+}
+
+// Gets the friendship between two users regardless of who sent the request
+func (friendStore *FriendStore) GetFriendship(ctx context.Context, userID uuid.UUID, otherUserID uuid.UUID) (*models.Friendship, error) {
+	selectFriendshipSQL := `
+		SELECT requester_id, addressee_id, status, created_at
+		FROM friendships
+		WHERE (requester_id = $1 AND addressee_id = $2)
+		   OR (requester_id = $2 AND addressee_id = $1)
+	`
+
+	var friendship models.Friendship
+	err := friendStore.DB.QueryRowContext(ctx, selectFriendshipSQL, userID, otherUserID).Scan(
+		&friendship.RequesterID,
+		&friendship.AddresseeID,
+		&friendship.Status,
+		&friendship.CreatedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return &friendship, err
+}
+
+func (friendStore *FriendStore) AcceptFriendRequest(ctx context.Context, requesterID uuid.UUID, addresseeID uuid.UUID) error {
+	updateFriendshipSQL := `
+		UPDATE friendships SET status = 'accepted'
+		WHERE requester_id = $1 AND addressee_id = $2
+	`
+	_, err := friendStore.DB.ExecContext(ctx, updateFriendshipSQL, requesterID, addresseeID)
+	return err
+}
+
+func (friendStore *FriendStore) DeleteFriendship(ctx context.Context, userID uuid.UUID, otherUserID uuid.UUID) error {
+	deleteFriendshipSQL := `
+		DELETE FROM friendships
+		WHERE (requester_id = $1 AND addressee_id = $2)
+		   OR (requester_id = $2 AND addressee_id = $1)
+	`
+	_, err := friendStore.DB.ExecContext(ctx, deleteFriendshipSQL, userID, otherUserID)
+	return err
+}
+
+func (friendStore *FriendStore) GetFriendshipsForUser(ctx context.Context, userID uuid.UUID) ([]*models.Friendship, error) {
+	selectFriendshipsSQL := `
+		SELECT requester_id, addressee_id, status, created_at
+		FROM friendships
+		WHERE requester_id = $1 OR addressee_id = $1
+		ORDER BY created_at DESC
+	`
+
+	rows, err := friendStore.DB.QueryContext(ctx, selectFriendshipsSQL, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var friendships []*models.Friendship
+	for rows.Next() {
+		var friendship models.Friendship
+		if err := rows.Scan(
+			&friendship.RequesterID,
+			&friendship.AddresseeID,
+			&friendship.Status,
+			&friendship.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		friendships = append(friendships, &friendship)
+	}
+
+	return friendships, rows.Err()
+}
+
+func (friendStore *FriendStore) AreFriends(ctx context.Context, userID uuid.UUID, otherUserID uuid.UUID) (bool, error) {
+	selectAreFriendsSQL := `
+		SELECT EXISTS (
+			SELECT 1 FROM friendships
+			WHERE status = 'accepted'
+			  AND ((requester_id = $1 AND addressee_id = $2) OR (requester_id = $2 AND addressee_id = $1))
+		)
+	`
+
+	var exists bool
+	err := friendStore.DB.QueryRowContext(ctx, selectAreFriendsSQL, userID, otherUserID).Scan(&exists)
+	return exists, err
+}
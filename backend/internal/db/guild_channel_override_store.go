@@ -17,18 +17,32 @@ func NewGuildChannelOverrideStore(db *sql.DB) *GuildChannelOverrideStore {
 }
 
 func (guildChannelOverrideStore *GuildChannelOverrideStore) UpsertOverride(ctx context.Context, override *models.GuildChannelPermissionOverride) error {
+	return insertOverride(ctx, guildChannelOverrideStore.DB, override)
+}
+
+func insertOverride(ctx context.Context, exec execer, override *models.GuildChannelPermissionOverride) error {
 	insertGuildChannelOverrideSQL := `
 		INSERT INTO guild_channel_permission_overrides (channel_id, user_id, role_id, allow, deny)
 		VALUES ($1, $2, $3, $4, $5)
-		ON CONFLICT (channel_id, user_id, role_id)
+		ON CONFLICT (channel_id, role_id) WHERE role_id IS NOT NULL
 		DO UPDATE SET allow = EXCLUDED.allow, deny = EXCLUDED.deny
 	`
-	_, err := guildChannelOverrideStore.DB.ExecContext(ctx, insertGuildChannelOverrideSQL,
+
+	// each kind of override has its own unique index to conflict on
+	if override.UserID != nil {
+		insertGuildChannelOverrideSQL = `
+			INSERT INTO guild_channel_permission_overrides (channel_id, user_id, role_id, allow, deny)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (channel_id, user_id) WHERE user_id IS NOT NULL
+			DO UPDATE SET allow = EXCLUDED.allow, deny = EXCLUDED.deny
+		`
+	}
+	_, err := exec.ExecContext(ctx, insertGuildChannelOverrideSQL,
 		override.ChannelID,
 		override.UserID,
 		override.RoleID,
-		override.Allow,
-		override.Deny,
+		int64(override.Allow), // same bits, see scanRole
+		int64(override.Deny),
 	)
 	return err
 }
@@ -57,9 +71,11 @@ func (guildChannelOverrideStore *GuildChannelOverrideStore) GetOverridesForChann
 	var overrides []*models.GuildChannelPermissionOverride
 	for rows.Next() {
 		var o models.GuildChannelPermissionOverride
-		if err := rows.Scan(&o.ChannelID, &o.UserID, &o.RoleID, &o.Allow, &o.Deny); err != nil {
+		var allow, deny int64
+		if err := rows.Scan(&o.ChannelID, &o.UserID, &o.RoleID, &allow, &deny); err != nil {
 			return nil, err
 		}
+		o.Allow, o.Deny = uint64(allow), uint64(deny)
 		overrides = append(overrides, &o)
 	}
 	return overrides, rows.Err()
@@ -68,3 +84,56 @@ func (guildChannelOverrideStore *GuildChannelOverrideStore) GetOverridesForChann
 func (guildChannelOverrideStore *GuildChannelOverrideStore) GetChannelOverrides(ctx context.Context, channelID uuid.UUID) ([]*models.GuildChannelPermissionOverride, error) {
 	return guildChannelOverrideStore.GetOverridesForChannel(ctx, channelID)
 }
+
+// Replaces a channel's overrides with a copy of its category's
+func (guildChannelOverrideStore *GuildChannelOverrideStore) SyncOverrides(ctx context.Context, channelID uuid.UUID, parentID uuid.UUID) error {
+	tx, err := guildChannelOverrideStore.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	deleteChannelOverridesSQL := `DELETE FROM guild_channel_permission_overrides WHERE channel_id = $1`
+	if _, err := tx.ExecContext(ctx, deleteChannelOverridesSQL, channelID); err != nil {
+		return err
+	}
+
+	copyParentOverridesSQL := `
+		INSERT INTO guild_channel_permission_overrides (channel_id, user_id, role_id, allow, deny)
+		SELECT $1, user_id, role_id, allow, deny
+		FROM guild_channel_permission_overrides
+		WHERE channel_id = $2
+	`
+	if _, err := tx.ExecContext(ctx, copyParentOverridesSQL, channelID, parentID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Gets the overrides of every channel in a guild, keyed by channel
+func (guildChannelOverrideStore *GuildChannelOverrideStore) GetOverridesForGuild(ctx context.Context, guildID uuid.UUID) (map[uuid.UUID][]*models.GuildChannelPermissionOverride, error) {
+	getGuildOverridesSQL := `
+		SELECT o.channel_id, o.user_id, o.role_id, o.allow, o.deny
+		FROM guild_channel_permission_overrides o
+		JOIN guild_channels c ON c.id = o.channel_id
+		WHERE c.guild_id = $1
+	`
+	rows, err := guildChannelOverrideStore.DB.QueryContext(ctx, getGuildOverridesSQL, guildID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	overrides := make(map[uuid.UUID][]*models.GuildChannelPermissionOverride)
+	for rows.Next() {
+		var o models.GuildChannelPermissionOverride
+		var allow, deny int64
+		if err := rows.Scan(&o.ChannelID, &o.UserID, &o.RoleID, &allow, &deny); err != nil {
+			return nil, err
+		}
+		o.Allow, o.Deny = uint64(allow), uint64(deny)
+		overrides[o.ChannelID] = append(overrides[o.ChannelID], &o)
+	}
+	return overrides, rows.Err()
+}
@@ -6,6 +6,7 @@ import (
 	"mana/internal/models"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 type GuildChannelStore struct {
@@ -17,11 +18,22 @@ func NewGuildChannelStore(db *sql.DB) *GuildChannelStore {
 }
 
 func (guildChannelStore *GuildChannelStore) CreateChannel(ctx context.Context, ch *models.GuildChannel) error {
+	return insertGuildChannel(ctx, guildChannelStore.DB, ch)
+}
+
+func insertGuildChannel(ctx context.Context, exec execer, ch *models.GuildChannel) error {
 	insertChannelSQL := `
-		INSERT INTO guild_channels (id, guild_id, name, type, position, topic, bitrate, user_limit, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		INSERT INTO guild_channels (id, guild_id, name, type, position, topic, bitrate, user_limit, retention_mode, retention_value, parent_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
 	`
-	_, err := guildChannelStore.DB.ExecContext(ctx, insertChannelSQL,
+
+	var retentionMode *string
+	var retentionValue *int
+	if ch.Retention != nil {
+		retentionMode, retentionValue = &ch.Retention.Mode, &ch.Retention.Value
+	}
+
+	_, err := exec.ExecContext(ctx, insertChannelSQL,
 		ch.ID,
 		ch.GuildID,
 		ch.Name,
@@ -30,6 +42,9 @@ func (guildChannelStore *GuildChannelStore) CreateChannel(ctx context.Context, c
 		ch.Topic,
 		ch.Bitrate,
 		ch.UserLimit,
+		retentionMode,
+		retentionValue,
+		ch.ParentID,
 		ch.CreatedAt,
 	)
 	return err
@@ -37,7 +52,7 @@ func (guildChannelStore *GuildChannelStore) CreateChannel(ctx context.Context, c
 
 func (guildChannelStore *GuildChannelStore) GetChannelsForGuild(ctx context.Context, guildID uuid.UUID) ([]*models.GuildChannel, error) {
 	getGuildChannelsSQL := `
-		SELECT id, guild_id, name, type, position, topic, bitrate, user_limit, created_at
+		SELECT ` + channelColumns + `
 		FROM guild_channels
 		WHERE guild_id = $1
 		ORDER BY position ASC
@@ -51,23 +66,108 @@ func (guildChannelStore *GuildChannelStore) GetChannelsForGuild(ctx context.Cont
 
 	var channels []*models.GuildChannel
 	for rows.Next() {
-		var ch models.GuildChannel
-		if err := rows.Scan(
-			&ch.ID,
-			&ch.GuildID,
-			&ch.Name,
-			&ch.Type,
-			&ch.Position,
-			&ch.Topic,
-			&ch.Bitrate,
-			&ch.UserLimit,
-			&ch.CreatedAt,
-		); err != nil {
+		ch, err := scanChannel(rows)
+		if err != nil {
 			return nil, err
 		}
-		channels = append(channels, &ch)
+		channels = append(channels, ch)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return nestChannels(channels), nil
+}
+
+// Orders channels the way the sidebar shows them: uncategorized channels
+// first, then each category followed by its own channels. Expects channels
+// already sorted by position.
+func nestChannels(channels []*models.GuildChannel) []*models.GuildChannel {
+	children := make(map[uuid.UUID][]*models.GuildChannel)
+	var uncategorized, categories []*models.GuildChannel
+
+	for _, ch := range channels {
+		switch {
+		case ch.Type == models.ChannelTypeCategory:
+			categories = append(categories, ch)
+		case ch.ParentID != nil:
+			children[*ch.ParentID] = append(children[*ch.ParentID], ch)
+		default:
+			uncategorized = append(uncategorized, ch)
+		}
+	}
+
+	nested := make([]*models.GuildChannel, 0, len(channels))
+	nested = append(nested, uncategorized...)
+	for _, category := range categories {
+		nested = append(nested, category)
+		nested = append(nested, children[category.ID]...)
+	}
+
+	return nested
+}
+
+func (guildChannelStore *GuildChannelStore) GetChannelByID(ctx context.Context, channelID uuid.UUID) (*models.GuildChannel, error) {
+	getGuildChannelSQL := `SELECT ` + channelColumns + ` FROM guild_channels WHERE id = $1`
+
+	ch, err := scanChannel(guildChannelStore.DB.QueryRowContext(ctx, getGuildChannelSQL, channelID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return ch, err
+}
+
+// Channel names by ID, limited to the guild's own channels
+func (guildChannelStore *GuildChannelStore) GetChannelNames(ctx context.Context, guildID uuid.UUID, channelIDs []uuid.UUID) (map[uuid.UUID]string, error) {
+	selectChannelNamesSQL := `SELECT id, name FROM guild_channels WHERE id = ANY($1) AND guild_id = $2`
+
+	rows, err := guildChannelStore.DB.QueryContext(ctx, selectChannelNamesSQL, pq.Array(channelIDs), guildID)
+	if err != nil {
+		return nil, err
 	}
-	return channels, rows.Err()
+	return scanNames(rows)
+}
+
+func (guildChannelStore *GuildChannelStore) UpdateChannel(ctx context.Context, ch *models.GuildChannel) error {
+	updateGuildChannelSQL := `
+		UPDATE guild_channels
+		SET name = $1, topic = $2, bitrate = $3, user_limit = $4
+		WHERE id = $5
+	`
+	_, err := guildChannelStore.DB.ExecContext(ctx, updateGuildChannelSQL,
+		ch.Name,
+		ch.Topic,
+		ch.Bitrate,
+		ch.UserLimit,
+		ch.ID,
+	)
+	return err
+}
+
+// Moves a channel into a category, or out of one when parentID is nil
+func (guildChannelStore *GuildChannelStore) SetParent(ctx context.Context, channelID uuid.UUID, parentID *uuid.UUID) error {
+	updateChannelParentSQL := `UPDATE guild_channels SET parent_id = $1 WHERE id = $2`
+	_, err := guildChannelStore.DB.ExecContext(ctx, updateChannelParentSQL, parentID, channelID)
+	return err
+}
+
+func (guildChannelStore *GuildChannelStore) CountChannelsForGuild(ctx context.Context, guildID uuid.UUID) (int, error) {
+	countGuildChannelsSQL := `SELECT COUNT(*) FROM guild_channels WHERE guild_id = $1`
+
+	var count int
+	err := guildChannelStore.DB.QueryRowContext(ctx, countGuildChannelsSQL, guildID).Scan(&count)
+	return count, err
+}
+
+// The position after the guild's bottom channel, 0 if it has none. Deleted
+// channels leave gaps, so this can be past the channel count.
+func (guildChannelStore *GuildChannelStore) NextChannelPosition(ctx context.Context, guildID uuid.UUID) (int, error) {
+	nextChannelPositionSQL := `SELECT COALESCE(MAX(position) + 1, 0) FROM guild_channels WHERE guild_id = $1`
+
+	var position int
+	err := guildChannelStore.DB.QueryRowContext(ctx, nextChannelPositionSQL, guildID).Scan(&position)
+	return position, err
 }
 
 func (guildChannelStore *GuildChannelStore) DeleteChannel(ctx context.Context, channelID uuid.UUID) error {
@@ -105,3 +205,49 @@ func (guildChannelStore *GuildChannelStore) ChannelExistsByName(ctx context.Cont
 	}
 	return true, err
 }
+
+// A nil policy makes the channel inherit the guild default
+func (guildChannelStore *GuildChannelStore) UpdateRetention(ctx context.Context, channelID uuid.UUID, policy *models.RetentionPolicy) error {
+	updateChannelRetentionSQL := `UPDATE guild_channels SET retention_mode = $1, retention_value = $2 WHERE id = $3`
+
+	var mode *string
+	var value *int
+	if policy != nil {
+		mode, value = &policy.Mode, &policy.Value
+	}
+
+	_, err := guildChannelStore.DB.ExecContext(ctx, updateChannelRetentionSQL, mode, value, channelID)
+	return err
+}
+
+// columns scanChannel expects, in order
+const channelColumns = `id, guild_id, name, type, position, topic, bitrate, user_limit, retention_mode, retention_value, parent_id, created_at`
+
+func scanChannel(row rowScanner) (*models.GuildChannel, error) {
+	var ch models.GuildChannel
+	var retentionMode sql.NullString
+	var retentionValue sql.NullInt64
+	err := row.Scan(
+		&ch.ID,
+		&ch.GuildID,
+		&ch.Name,
+		&ch.Type,
+		&ch.Position,
+		&ch.Topic,
+		&ch.Bitrate,
+		&ch.UserLimit,
+		&retentionMode,
+		&retentionValue,
+		&ch.ParentID,
+		&ch.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if retentionMode.Valid {
+		ch.Retention = &models.RetentionPolicy{Mode: retentionMode.String, Value: int(retentionValue.Int64)}
+	}
+
+	return &ch, nil
+}
@@ -6,6 +6,7 @@ import (
 	"mana/internal/models"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 type GuildRoleStore struct {
@@ -17,18 +18,24 @@ func NewGuildRoleStore(db *sql.DB) *GuildRoleStore {
 }
 
 func (guildRoleStore *GuildRoleStore) CreateGuildRole(ctx context.Context, role *models.GuildRole) error {
+	return insertGuildRole(ctx, guildRoleStore.DB, role)
+}
+
+func insertGuildRole(ctx context.Context, exec execer, role *models.GuildRole) error {
 	insertGuildRoleSQL := `
-		INSERT INTO guild_roles (id, guild_id, name, position, permissions, color, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO guild_roles (id, guild_id, name, position, permissions, color, hoist, bot_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 	`
-	_, err := guildRoleStore.DB.ExecContext(ctx,
+	_, err := exec.ExecContext(ctx,
 		insertGuildRoleSQL,
 		role.ID,
 		role.GuildID,
 		role.Name,
 		role.Position,
-		role.Permissions,
+		int64(role.Permissions),
 		role.Color,
+		role.Hoist,
+		role.BotID,
 		role.CreatedAt,
 	)
 
@@ -37,7 +44,7 @@ func (guildRoleStore *GuildRoleStore) CreateGuildRole(ctx context.Context, role
 
 func (guildRoleStore *GuildRoleStore) GetRolesForGuild(ctx context.Context, guildID uuid.UUID) ([]*models.GuildRole, error) {
 	GetGuildRolesSQL := `
-		SELECT id, guild_id, name, position, permissions, color, created_at
+		SELECT id, guild_id, name, position, permissions, color, hoist, bot_id, created_at
 		FROM guild_roles
 		WHERE guild_id = $1
 		ORDER BY position ASC
@@ -50,31 +57,67 @@ func (guildRoleStore *GuildRoleStore) GetRolesForGuild(ctx context.Context, guil
 
 	var roles []*models.GuildRole
 	for rows.Next() {
-		var role models.GuildRole
-		if err := rows.Scan(
-			&role.ID,
-			&role.GuildID,
-			&role.Name,
-			&role.Position,
-			&role.Permissions,
-			&role.Color,
-			&role.CreatedAt,
-		); err != nil {
+		role, err := scanRole(rows)
+		if err != nil {
 			return nil, err
 		}
-		roles = append(roles, &role)
+		roles = append(roles, role)
 	}
 
 	return roles, rows.Err()
 }
 
+// A role someone else gave the member, it counts even if they had already
+// picked it themselves
 func (guildRoleStore *GuildRoleStore) AssignRoleToMember(ctx context.Context, guildID, userID, roleID uuid.UUID) error {
+	return assignRoleToMember(ctx, guildRoleStore.DB, guildID, userID, roleID)
+}
+
+// A role the member picked in onboarding, which doesn't get them past the
+// verification level. Left alone if they already hold it, returns whether
+// it was added.
+func (guildRoleStore *GuildRoleStore) SelfAssignRoleToMember(ctx context.Context, guildID, userID, roleID uuid.UUID) (bool, error) {
+	insertGuildMemberRoleSQL := `
+		INSERT INTO guild_member_roles (guild_id, user_id, role_id, self_assigned)
+		VALUES ($1, $2, $3, true)
+		ON CONFLICT DO NOTHING
+	`
+	res, err := guildRoleStore.DB.ExecContext(ctx,
+		insertGuildMemberRoleSQL,
+		guildID,
+		userID,
+		roleID)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Takes back a role the member picked in onboarding, roles someone else
+// gave them stay. Returns whether it was removed.
+func (guildRoleStore *GuildRoleStore) RemoveSelfAssignedRole(ctx context.Context, guildID, userID, roleID uuid.UUID) (bool, error) {
+	deleteSelfAssignedRoleSQL := `
+		DELETE FROM guild_member_roles
+		WHERE guild_id = $1 AND user_id = $2 AND role_id = $3 AND self_assigned
+	`
+	res, err := guildRoleStore.DB.ExecContext(ctx, deleteSelfAssignedRoleSQL, guildID, userID, roleID)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func assignRoleToMember(ctx context.Context, exec execer, guildID, userID, roleID uuid.UUID) error {
 	insertGuildMemberRoleSQL := `
 		INSERT INTO guild_member_roles (guild_id, user_id, role_id)
 		VALUES ($1, $2, $3)
-		ON CONFLICT DO NOTHING
+		ON CONFLICT (guild_id, user_id, role_id) DO UPDATE SET self_assigned = false
 	`
-	_, err := guildRoleStore.DB.ExecContext(ctx,
+	_, err := exec.ExecContext(ctx,
 		insertGuildMemberRoleSQL,
 		guildID,
 		userID,
@@ -96,7 +139,7 @@ func (guildRoleStore *GuildRoleStore) RemoveRoleFromMember(ctx context.Context,
 func (guildRoleStore *GuildRoleStore) GetRolesForMember(ctx context.Context, guildID, userID uuid.UUID) ([]*models.GuildRole, error) {
 
 	getGuildMemberRolesSQL := `
-		SELECT gr.id, gr.guild_id, gr.name, gr.position, gr.permissions, gr.color, gr.created_at
+		SELECT gr.id, gr.guild_id, gr.name, gr.position, gr.permissions, gr.color, gr.hoist, gr.bot_id, gr.created_at
 		FROM guild_roles gr
 		JOIN guild_member_roles gmr ON gr.id = gmr.role_id
 		WHERE gmr.guild_id = $1 AND gmr.user_id = $2
@@ -110,19 +153,11 @@ func (guildRoleStore *GuildRoleStore) GetRolesForMember(ctx context.Context, gui
 
 	var roles []*models.GuildRole
 	for rows.Next() {
-		var role models.GuildRole
-		if err := rows.Scan(
-			&role.ID,
-			&role.GuildID,
-			&role.Name,
-			&role.Position,
-			&role.Permissions,
-			&role.Color,
-			&role.CreatedAt,
-		); err != nil {
+		role, err := scanRole(rows)
+		if err != nil {
 			return nil, err
 		}
-		roles = append(roles, &role)
+		roles = append(roles, role)
 	}
 
 	return roles, rows.Err()
@@ -166,3 +201,95 @@ func (guildRoleStore *GuildRoleStore) RoleExistsByName(ctx context.Context, guil
 	}
 	return true, err
 }
+
+func (guildRoleStore *GuildRoleStore) GetRoleByID(ctx context.Context, guildID uuid.UUID, roleID uuid.UUID) (*models.GuildRole, error) {
+	getGuildRoleSQL := `
+		SELECT id, guild_id, name, position, permissions, color, hoist, bot_id, created_at
+		FROM guild_roles
+		WHERE guild_id = $1 AND id = $2
+	`
+
+	role, err := scanRole(guildRoleStore.DB.QueryRowContext(ctx, getGuildRoleSQL, guildID, roleID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return role, err
+}
+
+// Role names by ID, limited to the guild's own roles
+func (guildRoleStore *GuildRoleStore) GetRoleNames(ctx context.Context, guildID uuid.UUID, roleIDs []uuid.UUID) (map[uuid.UUID]string, error) {
+	selectRoleNamesSQL := `SELECT id, name FROM guild_roles WHERE id = ANY($1) AND guild_id = $2`
+
+	rows, err := guildRoleStore.DB.QueryContext(ctx, selectRoleNamesSQL, pq.Array(roleIDs), guildID)
+	if err != nil {
+		return nil, err
+	}
+	return scanNames(rows)
+}
+
+// The role a bot was given when it was added to the guild, nil if it has none
+func (guildRoleStore *GuildRoleStore) GetManagedRole(ctx context.Context, guildID uuid.UUID, botID uuid.UUID) (*models.GuildRole, error) {
+	getManagedRoleSQL := `
+		SELECT id, guild_id, name, position, permissions, color, hoist, bot_id, created_at
+		FROM guild_roles
+		WHERE guild_id = $1 AND bot_id = $2
+	`
+
+	role, err := scanRole(guildRoleStore.DB.QueryRowContext(ctx, getManagedRoleSQL, guildID, botID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return role, err
+}
+
+func (guildRoleStore *GuildRoleStore) UpdateRole(ctx context.Context, role *models.GuildRole) error {
+	updateGuildRoleSQL := `
+		UPDATE guild_roles
+		SET name = $1, position = $2, permissions = $3, color = $4, hoist = $5
+		WHERE id = $6 AND guild_id = $7
+	`
+	_, err := guildRoleStore.DB.ExecContext(ctx, updateGuildRoleSQL,
+		role.Name,
+		role.Position,
+		int64(role.Permissions),
+		role.Color,
+		role.Hoist,
+		role.ID,
+		role.GuildID,
+	)
+	return err
+}
+
+func (guildRoleStore *GuildRoleStore) CountRolesForGuild(ctx context.Context, guildID uuid.UUID) (int, error) {
+	countGuildRolesSQL := `SELECT COUNT(*) FROM guild_roles WHERE guild_id = $1`
+
+	var count int
+	err := guildRoleStore.DB.QueryRowContext(ctx, countGuildRolesSQL, guildID).Scan(&count)
+	return count, err
+}
+
+// permissions are stored as the int64 with the same bits, BIGINT has no
+// unsigned form and the sql driver rejects uint64s with the top bit set
+func scanRole(row rowScanner) (*models.GuildRole, error) {
+	var role models.GuildRole
+	var perms int64
+	err := row.Scan(
+		&role.ID,
+		&role.GuildID,
+		&role.Name,
+		&role.Position,
+		&perms,
+		&role.Color,
+		&role.Hoist,
+		&role.BotID,
+		&role.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	role.Permissions = uint64(perms)
+	return &role, nil
+}
@@ -4,10 +4,14 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"mana/internal/models"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgconn"
+	"github.com/lib/pq"
 )
 
 type GuildStore struct {
@@ -18,39 +22,126 @@ func NewGuildStore(db *sql.DB) *GuildStore {
 	return &GuildStore{DB: db}
 }
 
-func (guildStore *GuildStore) insertGuildOnce(ctx context.Context, guild *models.Guild) error {
+func insertGuild(ctx context.Context, exec execer, guild *models.Guild) error {
 	insertGuildSQL := `
-		INSERT INTO guilds (id, name, owner_id, invite_code, created_at)
-		VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO guilds (id, name, owner_id, retention_mode, retention_value, audit_retention_days, created_at,
+			description, icon_url, banner_url, system_channel_id, default_notifications, explicit_content_filter, verification_level)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
 	`
 
-	_, err := guildStore.DB.ExecContext(
+	_, err := exec.ExecContext(
 		ctx,
 		insertGuildSQL,
 		guild.ID,
 		guild.Name,
 		guild.OwnerID,
-		guild.InviteCode,
+		guild.Retention.Mode,
+		guild.Retention.Value,
+		guild.AuditRetentionDays,
 		guild.CreatedAt,
+		guild.Description,
+		guild.IconURL,
+		guild.BannerURL,
+		guild.SystemChannelID,
+		guild.DefaultNotifications,
+		guild.ExplicitContentFilter,
+		guild.VerificationLevel,
 	)
 
 	return err
 }
 
 func (guildStore *GuildStore) InsertGuild(ctx context.Context, guild *models.Guild) error {
+	return insertGuild(ctx, guildStore.DB, guild)
+}
 
+// Persists a guild with its roles, owner membership, channels and invite in
+// one transaction, so a guild is never left half created
+func (guildStore *GuildStore) CreateGuild(ctx context.Context, result *models.GuildCreateResult) error {
 	for i := 0; i < 3; i++ {
-		err := guildStore.insertGuildOnce(ctx, guild)
+		err := guildStore.createGuildOnce(ctx, result)
 
-		if isUniqueViolation(err, "guilds_invite_code_key") {
-			guild.InviteCode = models.GenerateInviteCode()
+		// a failed statement aborts the whole transaction, so retry all of it
+		if isUniqueViolation(err, "guild_invites_pkey") {
+			result.Invite.Code = models.GenerateInviteCode()
 			continue
 		}
 
 		return err
 	}
 
-	return errors.New("failed to insert guild after 3 attempts due to invite code uniqueness")
+	return errors.New("failed to create guild after 3 attempts due to invite code uniqueness")
+}
+
+func (guildStore *GuildStore) createGuildOnce(ctx context.Context, result *models.GuildCreateResult) error {
+	tx, err := guildStore.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertGuild(ctx, tx, result.Guild); err != nil {
+		return err
+	}
+
+	if err := insertGuildRole(ctx, tx, result.EveryoneRole); err != nil {
+		return err
+	}
+
+	if err := insertGuildRole(ctx, tx, result.OwnerRole); err != nil {
+		return err
+	}
+
+	if err := insertGuildMember(ctx, tx, result.OwnerMember); err != nil {
+		return err
+	}
+
+	binding := result.OwnerBinding
+	if err := assignRoleToMember(ctx, tx, binding.GuildID, binding.UserID, binding.RoleID); err != nil {
+		return err
+	}
+
+	if err := bindEveryoneRole(ctx, tx, result.Guild.ID, result.OwnerMember.UserID); err != nil {
+		return err
+	}
+
+	for _, role := range result.Roles {
+		if err := insertGuildRole(ctx, tx, role); err != nil {
+			return err
+		}
+	}
+
+	if result.GeneralChannel != nil {
+		if err := insertGuildChannel(ctx, tx, result.GeneralChannel); err != nil {
+			return err
+		}
+	}
+
+	// categories first, their channels reference them
+	for _, categories := range []bool{true, false} {
+		for _, channel := range result.Channels {
+			if (channel.Type == models.ChannelTypeCategory) != categories {
+				continue
+			}
+			if err := insertGuildChannel(ctx, tx, channel); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, override := range result.Overrides {
+		if err := insertOverride(ctx, tx, override); err != nil {
+			return err
+		}
+	}
+
+	if result.Invite != nil {
+		if err := insertInvite(ctx, tx, result.Invite); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
 func (guildStore *GuildStore) DeleteGuild(ctx context.Context, guildID uuid.UUID) error {
@@ -63,119 +154,470 @@ func (guildStore *GuildStore) DeleteGuild(ctx context.Context, guildID uuid.UUID
 	return err
 }
 
-func (guildStore *GuildStore) GetGuildByID(ctx context.Context, guildID uuid.UUID) (*models.Guild, error) {
-	selectGuildSQL := `
-		SELECT id, name, owner_id, invite_code, created_at
-		FROM guilds
-		WHERE id = $1
+// Marks the guild for deletion at deletesAt, nil cancels a pending deletion
+func (guildStore *GuildStore) ScheduleDeletion(ctx context.Context, guildID uuid.UUID, deletesAt *time.Time) error {
+	scheduleDeletionSQL := `UPDATE guilds SET deletes_at = $1 WHERE id = $2`
+	_, err := guildStore.DB.ExecContext(ctx, scheduleDeletionSQL, deletesAt, guildID)
+	return err
+}
+
+// Deletes up to limit guilds whose deletion came due, returning their ids
+func (guildStore *GuildStore) PurgeDeletedGuilds(ctx context.Context, limit int) ([]uuid.UUID, error) {
+	purgeGuildsSQL := `
+		DELETE FROM guilds
+		WHERE id IN (
+			SELECT id FROM guilds
+			WHERE deletes_at <= now()
+			ORDER BY deletes_at ASC
+			LIMIT $1
+		)
+		RETURNING id
 	`
 
-	guildRow := guildStore.DB.QueryRowContext(ctx, selectGuildSQL, guildID)
+	rows, err := guildStore.DB.QueryContext(ctx, purgeGuildsSQL, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-	var guild models.Guild
-	err := guildRow.Scan(
-		&guild.ID,
-		&guild.Name,
-		&guild.OwnerID,
-		&guild.InviteCode,
-		&guild.CreatedAt,
-	)
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
 
-	if err == sql.ErrNoRows {
-		return nil, nil
+	return ids, rows.Err()
+}
+
+// Hands the guild to another member, only if ownerID still owns it, along
+// with the owner's role. Returns false when it changed hands in the meantime.
+func (guildStore *GuildStore) TransferOwnership(ctx context.Context, guildID uuid.UUID, ownerID uuid.UUID, newOwnerID uuid.UUID) (bool, error) {
+	tx, err := guildStore.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	transferOwnershipSQL := `
+		UPDATE guilds SET owner_id = $3
+		WHERE id = $1 AND owner_id = $2
+	`
+
+	res, err := tx.ExecContext(ctx, transferOwnershipSQL, guildID, ownerID, newOwnerID)
+	if err != nil {
+		return false, err
 	}
 
-	return &guild, err
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+
+	moveOwnerRoleSQL := `
+		WITH moved AS (
+			DELETE FROM guild_member_roles gmr
+			USING guild_roles gr
+			WHERE gr.id = gmr.role_id AND gr.position = 0
+				AND gmr.guild_id = $1 AND gmr.user_id = $2
+			RETURNING gmr.role_id
+		)
+		INSERT INTO guild_member_roles (guild_id, user_id, role_id)
+		SELECT $1, $3, role_id FROM moved
+		ON CONFLICT (guild_id, user_id, role_id) DO UPDATE SET self_assigned = false
+	`
+
+	if _, err := tx.ExecContext(ctx, moveOwnerRoleSQL, guildID, ownerID, newOwnerID); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
 }
 
-func (guildStore *GuildStore) GetGuildByInviteCode(ctx context.Context, inviteCode string) (*models.Guild, error) {
-	selectGuildSQL := `
-		SELECT id, name, owner_id, invite_code, created_at
-		FROM guilds
-		WHERE invite_code = $1
+// Passes every guild the user owns to its highest ranked remaining member,
+// earliest joined first among equals. Temporary members and members who
+// haven't passed screening don't count. Guilds with nobody left are frozen
+// without an owner and scheduled for deletion.
+func handOverOwnedGuilds(ctx context.Context, exec execer, userID uuid.UUID) error {
+	handOverGuildsSQL := `
+		WITH successors AS (
+			SELECT g.id AS guild_id, (
+				SELECT gm.user_id
+				FROM guild_members gm
+				JOIN users u ON u.id = gm.user_id
+				LEFT JOIN guild_member_roles gmr ON gmr.guild_id = gm.guild_id AND gmr.user_id = gm.user_id
+				LEFT JOIN guild_roles gr ON gr.id = gmr.role_id
+				WHERE gm.guild_id = g.id AND gm.user_id <> $1 AND NOT gm.temporary AND NOT gm.pending AND NOT u.bot
+				GROUP BY gm.user_id, gm.joined_at
+				ORDER BY MIN(gr.position) ASC NULLS LAST, gm.joined_at ASC
+				LIMIT 1
+			) AS user_id
+			FROM guilds g
+			WHERE g.owner_id = $1
+		), handed_over AS (
+			UPDATE guilds g
+			SET owner_id = s.user_id,
+				deletes_at = CASE WHEN s.user_id IS NULL THEN COALESCE(g.deletes_at, $2) ELSE g.deletes_at END
+			FROM successors s
+			WHERE g.id = s.guild_id
+			RETURNING g.id, g.owner_id
+		)
+		-- the successor takes the owner's role, the old owner's goes with them
+		INSERT INTO guild_member_roles (guild_id, user_id, role_id)
+		SELECT h.id, h.owner_id, gr.id
+		FROM handed_over h
+		JOIN guild_roles gr ON gr.guild_id = h.id AND gr.position = 0
+		WHERE h.owner_id IS NOT NULL
+		ON CONFLICT (guild_id, user_id, role_id) DO UPDATE SET self_assigned = false
 	`
 
-	guildRow := guildStore.DB.QueryRowContext(ctx, selectGuildSQL, inviteCode)
+	deletesAt := time.Now().UTC().Add(models.GuildDeletionGracePeriod)
+	_, err := exec.ExecContext(ctx, handOverGuildsSQL, userID, deletesAt)
+	return err
+}
+
+func (guildStore *GuildStore) GetGuildByID(ctx context.Context, guildID uuid.UUID) (*models.Guild, error) {
+	selectGuildSQL := `SELECT ` + guildColumns + ` FROM guilds WHERE id = $1`
 
-	var guild models.Guild
-	err := guildRow.Scan(
-		&guild.ID,
-		&guild.Name,
-		&guild.OwnerID,
-		&guild.InviteCode,
-		&guild.CreatedAt,
-	)
+	guildRow := guildStore.DB.QueryRowContext(ctx, selectGuildSQL, guildID)
 
+	guild, err := scanGuild(guildRow)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 
-	return &guild, err
+	return guild, err
 }
 
+// Adds a member and binds them to the guild's everyone role
 func (guildStore *GuildStore) AddUserToGuild(ctx context.Context, guildMember *models.GuildMember) error {
+	tx, err := guildStore.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertGuildMember(ctx, tx, guildMember); err != nil {
+		return err
+	}
+
+	if err := bindEveryoneRole(ctx, tx, guildMember.GuildID, guildMember.UserID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Adds a bot along with the managed role holding the permissions it was
+// granted. Bots skip screening, like members they can't get in while banned.
+func (guildStore *GuildStore) AddBotToGuild(ctx context.Context, guildMember *models.GuildMember, role *models.GuildRole) error {
+	tx, err := guildStore.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	isBannedSQL := `SELECT EXISTS (SELECT 1 FROM guild_bans WHERE guild_id = $1 AND user_id = $2)`
+
+	var isBanned bool
+	if err := tx.QueryRowContext(ctx, isBannedSQL, guildMember.GuildID, guildMember.UserID).Scan(&isBanned); err != nil {
+		return err
+	}
+	if isBanned {
+		return ErrBanned
+	}
+
+	err = insertGuildMember(ctx, tx, guildMember)
+	if isUniqueViolation(err, "guild_members_pkey") {
+		return ErrAlreadyMember
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := bindEveryoneRole(ctx, tx, guildMember.GuildID, guildMember.UserID); err != nil {
+		return err
+	}
+
+	if err := insertGuildRole(ctx, tx, role); err != nil {
+		return err
+	}
+
+	if err := assignRoleToMember(ctx, tx, guildMember.GuildID, guildMember.UserID, role.ID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Also records the join for insights
+func insertGuildMember(ctx context.Context, exec execer, guildMember *models.GuildMember) error {
 	insertUserIntoGuildSQL := `
-		INSERT INTO guild_members (guild_id, user_id, joined_at)
-		VALUES ($1, $2, $3)
+		WITH joined AS (
+			INSERT INTO guild_members (guild_id, user_id, temporary, pending, joined_at)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING guild_id, user_id, joined_at
+		)
+		INSERT INTO guild_member_events (guild_id, user_id, kind, created_at)
+		SELECT guild_id, user_id, 'join', joined_at FROM joined
 	`
 
-	_, err := guildStore.DB.ExecContext(
+	_, err := exec.ExecContext(
 		ctx,
 		insertUserIntoGuildSQL,
-		guildMember.UserID,
 		guildMember.GuildID,
+		guildMember.UserID,
+		guildMember.Temporary,
+		guildMember.Pending,
 		guildMember.JoinedAt,
 	)
 
 	return err
 }
 
+// every member holds the everyone role, it's what @everyone permissions hang off
+func bindEveryoneRole(ctx context.Context, exec execer, guildID uuid.UUID, userID uuid.UUID) error {
+	insertEveryoneBindingSQL := `
+		INSERT INTO guild_member_roles (guild_id, user_id, role_id)
+		SELECT guild_id, $2, id FROM guild_roles
+		WHERE guild_id = $1 AND position = $3
+		ON CONFLICT DO NOTHING
+	`
+
+	_, err := exec.ExecContext(ctx, insertEveryoneBindingSQL, guildID, userID, models.MaxRoles)
+	return err
+}
+
+// Lets a pending member in by handing them the everyone role. False if
+// they weren't pending, or aren't a member at all.
+func (guildStore *GuildStore) AdmitMember(ctx context.Context, guildID uuid.UUID, userID uuid.UUID) (bool, error) {
+	tx, err := guildStore.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	admitMemberSQL := `UPDATE guild_members SET pending = false WHERE guild_id = $1 AND user_id = $2 AND pending`
+
+	result, err := tx.ExecContext(ctx, admitMemberSQL, guildID, userID)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil || affected == 0 {
+		return false, err
+	}
+
+	if err := bindEveryoneRole(ctx, tx, guildID, userID); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
 func (guildStore *GuildStore) RemoveUserFromGuild(ctx context.Context, guildID uuid.UUID, userID uuid.UUID) error {
+	return deleteGuildMember(ctx, guildStore.DB, guildID, userID)
+}
+
+// Also records the leave for insights, if they were a member at all, and
+// drops the managed role of a bot
+func deleteGuildMember(ctx context.Context, exec execer, guildID uuid.UUID, userID uuid.UUID) error {
 	deleteUserFromGuildSQL := `
-		DELETE FROM guild_members
-		WHERE guild_id = $1 AND user_id = $2
+		WITH left_guild AS (
+			DELETE FROM guild_members
+			WHERE guild_id = $1 AND user_id = $2
+			RETURNING guild_id, user_id
+		), managed_role AS (
+			DELETE FROM guild_roles
+			WHERE guild_id = $1 AND bot_id = $2
+		)
+		INSERT INTO guild_member_events (guild_id, user_id, kind)
+		SELECT guild_id, user_id, 'leave' FROM left_guild
 	`
-	_, err := guildStore.DB.ExecContext(ctx, deleteUserFromGuildSQL, guildID, userID)
+	_, err := exec.ExecContext(ctx, deleteUserFromGuildSQL, guildID, userID)
 	return err
 }
 
-func (guildStore *GuildStore) GetGuildMembers(ctx context.Context, guildID uuid.UUID) ([]*models.GuildMember, error) {
-	getUsersFromGuildSQL := `
-		SELECT user_id, guild_id, joined_at
-		FROM guild_members
-		WHERE guild_id = $1
+func (guildStore *GuildStore) GetGuildMembers(ctx context.Context, guildID uuid.UUID) ([]*models.GuildMemberProfile, error) {
+	getGuildMembersSQL := `
+		SELECT ` + memberProfileColumns + `
+		FROM guild_members gm
+		JOIN users u ON u.id = gm.user_id
+		WHERE gm.guild_id = $1
+		ORDER BY gm.joined_at ASC, gm.user_id ASC
 	`
 
-	rows, err := guildStore.DB.QueryContext(ctx, getUsersFromGuildSQL, guildID)
+	rows, err := guildStore.DB.QueryContext(ctx, getGuildMembersSQL, guildID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var members []*models.GuildMember
-
+	var members []*models.GuildMemberProfile
 	for rows.Next() {
-		var member models.GuildMember
-		if err := rows.Scan(
-			&member.UserID,
-			&member.GuildID,
-			&member.JoinedAt,
-		); err != nil {
+		member, err := scanMemberProfile(rows)
+		if err != nil {
 			return nil, err
 		}
+		members = append(members, member)
+	}
+
+	return members, rows.Err()
+}
+
+// One page of members sorted by join date, see MemberFilter
+func (guildStore *GuildStore) ListGuildMembers(ctx context.Context, guildID uuid.UUID, filter *models.MemberFilter) ([]*models.GuildMemberProfile, error) {
+	listGuildMembersSQL := `
+		SELECT ` + memberProfileColumns + `
+		FROM guild_members gm
+		JOIN users u ON u.id = gm.user_id
+		WHERE gm.guild_id = $1
+	`
+
+	args := []interface{}{guildID}
+	paramIndex := 2
+
+	if filter.Query != "" {
+		listGuildMembersSQL += fmt.Sprintf(" AND (u.username ILIKE $%d OR gm.nickname ILIKE $%d)", paramIndex, paramIndex)
+		args = append(args, escapeLikePattern(filter.Query)+"%")
+		paramIndex++
+	}
+
+	if filter.RoleID != nil {
+		listGuildMembersSQL += fmt.Sprintf(
+			" AND EXISTS (SELECT 1 FROM guild_member_roles gmr WHERE gmr.guild_id = gm.guild_id AND gmr.user_id = gm.user_id AND gmr.role_id = $%d)",
+			paramIndex,
+		)
+		args = append(args, *filter.RoleID)
+		paramIndex++
+	}
+
+	order, comparison := "ASC", ">"
+	if filter.Sort == models.MemberSortJoinedDesc {
+		order, comparison = "DESC", "<"
+	}
+
+	if filter.After != nil {
+		listGuildMembersSQL += fmt.Sprintf(
+			" AND (gm.joined_at, gm.user_id) %s (SELECT joined_at, user_id FROM guild_members WHERE guild_id = $1 AND user_id = $%d)",
+			comparison,
+			paramIndex,
+		)
+		args = append(args, *filter.After)
+		paramIndex++
+	}
+
+	listGuildMembersSQL += fmt.Sprintf(" ORDER BY gm.joined_at %s, gm.user_id %s LIMIT $%d", order, order, paramIndex)
+	args = append(args, filter.Limit)
+
+	rows, err := guildStore.DB.QueryContext(ctx, listGuildMembersSQL, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-		members = append(members, &member)
+	var members []*models.GuildMemberProfile
+	for rows.Next() {
+		member, err := scanMemberProfile(rows)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member)
 	}
 
 	return members, rows.Err()
 }
 
+// Returns nil if they aren't a member
+func (guildStore *GuildStore) GetGuildMember(ctx context.Context, guildID uuid.UUID, userID uuid.UUID) (*models.GuildMemberProfile, error) {
+	getGuildMemberSQL := `
+		SELECT ` + memberProfileColumns + `
+		FROM guild_members gm
+		JOIN users u ON u.id = gm.user_id
+		WHERE gm.guild_id = $1 AND gm.user_id = $2
+	`
+
+	member, err := scanMemberProfile(guildStore.DB.QueryRowContext(ctx, getGuildMemberSQL, guildID, userID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return member, err
+}
+
+// Writes the member's nickname, avatar and bio, empty ones are cleared
+func (guildStore *GuildStore) UpdateMemberProfile(ctx context.Context, member *models.GuildMember) error {
+	updateMemberProfileSQL := `
+		UPDATE guild_members
+		SET nickname = NULLIF($3, ''), avatar_url = NULLIF($4, ''), bio = NULLIF($5, '')
+		WHERE guild_id = $1 AND user_id = $2
+	`
+
+	_, err := guildStore.DB.ExecContext(
+		ctx,
+		updateMemberProfileSQL,
+		member.GuildID,
+		member.UserID,
+		member.Nickname,
+		member.AvatarURL,
+		member.Bio,
+	)
+
+	return err
+}
+
+// Gets what the guild's verification level looks at, nil if they aren't a member
+func (guildStore *GuildStore) GetMemberStanding(ctx context.Context, guildID uuid.UUID, userID uuid.UUID) (*models.MemberStanding, error) {
+	getMemberStandingSQL := `
+		SELECT u.email_verified, u.created_at, gm.joined_at,
+			EXISTS (
+				SELECT 1 FROM guild_member_roles gmr
+				JOIN guild_roles gr ON gr.id = gmr.role_id
+				WHERE gmr.guild_id = gm.guild_id AND gmr.user_id = gm.user_id AND NOT gmr.self_assigned
+					AND gr.position <> 255 -- models.MaxRoles, the everyone role
+			)
+		FROM guild_members gm
+		JOIN users u ON u.id = gm.user_id
+		WHERE gm.guild_id = $1 AND gm.user_id = $2
+	`
+
+	var standing models.MemberStanding
+	err := guildStore.DB.QueryRowContext(ctx, getMemberStandingSQL, guildID, userID).Scan(
+		&standing.EmailVerified,
+		&standing.AccountCreatedAt,
+		&standing.JoinedAt,
+		&standing.HasRoles,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &standing, nil
+}
+
+// Gets when the member's timeout ends, nil if they were never timed out or aren't a member
+func (guildStore *GuildStore) GetMemberTimeout(ctx context.Context, guildID uuid.UUID, userID uuid.UUID) (*time.Time, error) {
+	getMemberTimeoutSQL := `SELECT timeout_until FROM guild_members WHERE guild_id = $1 AND user_id = $2`
+
+	var timeoutUntil *time.Time
+	err := guildStore.DB.QueryRowContext(ctx, getMemberTimeoutSQL, guildID, userID).Scan(&timeoutUntil)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return timeoutUntil, err
+}
+
 func (guildStore *GuildStore) GetGuildsForUserID(ctx context.Context, userID uuid.UUID) ([]*models.Guild, error) {
 	getUserGuildsSQL := `
-		SELECT g.id, g.name, g.owner_id, g.invite_code g.created_at
-		FROM guilds g
-		JOIN guild_members gm ON g.id = gm.guild_id
-		WHERE gm.user_id = $1
+		SELECT ` + guildColumns + `
+		FROM guilds
+		WHERE id IN (SELECT guild_id FROM guild_members WHERE user_id = $1)
 	`
 
 	rows, err := guildStore.DB.QueryContext(ctx, getUserGuildsSQL, userID)
@@ -187,27 +629,244 @@ func (guildStore *GuildStore) GetGuildsForUserID(ctx context.Context, userID uui
 	var guilds []*models.Guild
 
 	for rows.Next() {
-		var guild models.Guild
-		if err := rows.Scan(
-			&guild.ID,
-			&guild.Name,
-			&guild.OwnerID,
-			&guild.InviteCode,
-			&guild.CreatedAt,
-		); err != nil {
+		guild, err := scanGuild(rows)
+		if err != nil {
 			return nil, err
 		}
-		guilds = append(guilds, &guild)
+		guilds = append(guilds, guild)
 	}
 
 	return guilds, rows.Err()
 }
 
 func (guildStore *GuildStore) CheckUserMemberOfGuild(ctx context.Context, guildID uuid.UUID, userID uuid.UUID) (bool, error) {
+	selectGuildMemberSQL := `
+		SELECT EXISTS (
+			SELECT 1 FROM guild_members
+			WHERE guild_id = $1 AND user_id = $2
+		)
+	`
+
+	var exists bool
+	err := guildStore.DB.QueryRowContext(ctx, selectGuildMemberSQL, guildID, userID).Scan(&exists)
+	return exists, err
+}
 
-	return false, nil
+// Removes a user from every guild they joined temporarily and were not
+// given a role in since
+func (guildStore *GuildStore) RemoveTemporaryMemberships(ctx context.Context, userID uuid.UUID) error {
+	deleteTemporaryMembersSQL := `
+		WITH left_guild AS (
+			DELETE FROM guild_members m
+			WHERE m.user_id = $1 AND m.temporary
+			AND NOT EXISTS (
+				SELECT 1 FROM guild_member_roles mr
+				JOIN guild_roles r ON r.id = mr.role_id
+				WHERE mr.guild_id = m.guild_id AND mr.user_id = m.user_id AND r.position <> $2
+			)
+			RETURNING m.guild_id, m.user_id
+		)
+		INSERT INTO guild_member_events (guild_id, user_id, kind)
+		SELECT guild_id, user_id, 'leave' FROM left_guild
+	`
+
+	_, err := guildStore.DB.ExecContext(ctx, deleteTemporaryMembersSQL, userID, models.MaxRoles)
+	return err
+}
+
+// Removes temporary members without a role who joined before joinedBefore
+// and aren't online, for those who never connected or whose last connection
+// closed while the server was down. Returns the guilds that lost members.
+func (guildStore *GuildStore) RemoveStaleTemporaryMemberships(ctx context.Context, online []uuid.UUID, joinedBefore time.Time) ([]uuid.UUID, error) {
+	deleteStaleTemporaryMembersSQL := `
+		WITH left_guild AS (
+			DELETE FROM guild_members m
+			WHERE m.temporary AND m.joined_at < $2 AND NOT (m.user_id = ANY($1::uuid[]))
+			AND NOT EXISTS (
+				SELECT 1 FROM guild_member_roles mr
+				JOIN guild_roles r ON r.id = mr.role_id
+				WHERE mr.guild_id = m.guild_id AND mr.user_id = m.user_id AND r.position <> $3
+			)
+			RETURNING m.guild_id, m.user_id
+		), recorded AS (
+			INSERT INTO guild_member_events (guild_id, user_id, kind)
+			SELECT guild_id, user_id, 'leave' FROM left_guild
+		)
+		SELECT DISTINCT guild_id FROM left_guild
+	`
+
+	rows, err := guildStore.DB.QueryContext(ctx, deleteStaleTemporaryMembersSQL, pq.Array(online), joinedBefore, models.MaxRoles)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var guildIDs []uuid.UUID
+	for rows.Next() {
+		var guildID uuid.UUID
+		if err := rows.Scan(&guildID); err != nil {
+			return nil, err
+		}
+		guildIDs = append(guildIDs, guildID)
+	}
+
+	return guildIDs, rows.Err()
+}
+
+func (guildStore *GuildStore) UsersShareGuild(ctx context.Context, userID uuid.UUID, otherUserID uuid.UUID) (bool, error) {
+	selectSharedGuildSQL := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM guild_members a
+			JOIN guild_members b ON a.guild_id = b.guild_id
+			WHERE a.user_id = $1 AND b.user_id = $2
+		)
+	`
+
+	var exists bool
+	err := guildStore.DB.QueryRowContext(ctx, selectSharedGuildSQL, userID, otherUserID).Scan(&exists)
+	return exists, err
+}
+
+func (guildStore *GuildStore) UpdateRetention(ctx context.Context, guildID uuid.UUID, policy models.RetentionPolicy) error {
+	updateGuildRetentionSQL := `UPDATE guilds SET retention_mode = $1, retention_value = $2 WHERE id = $3`
+	_, err := guildStore.DB.ExecContext(ctx, updateGuildRetentionSQL, policy.Mode, policy.Value, guildID)
+	return err
+}
+
+// Saves the name and everything the guild settings endpoint can change
+func (guildStore *GuildStore) UpdateGuildSettings(ctx context.Context, guild *models.Guild) error {
+	updateGuildSettingsSQL := `
+		UPDATE guilds
+		SET name = $1, description = $2, icon_url = $3, banner_url = $4, system_channel_id = $5,
+			default_notifications = $6, explicit_content_filter = $7, verification_level = $8
+		WHERE id = $9
+	`
+
+	_, err := guildStore.DB.ExecContext(
+		ctx,
+		updateGuildSettingsSQL,
+		guild.Name,
+		guild.Description,
+		guild.IconURL,
+		guild.BannerURL,
+		guild.SystemChannelID,
+		guild.DefaultNotifications,
+		guild.ExplicitContentFilter,
+		guild.VerificationLevel,
+		guild.ID,
+	)
+
+	return err
+}
+
+func (guildStore *GuildStore) UpdateAuditRetention(ctx context.Context, guildID uuid.UUID, days int) error {
+	updateAuditRetentionSQL := `UPDATE guilds SET audit_retention_days = $1 WHERE id = $2`
+	_, err := guildStore.DB.ExecContext(ctx, updateAuditRetentionSQL, days, guildID)
+	return err
+}
+
+// *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// Collects (id, name) rows into a map
+func scanNames(rows *sql.Rows) (map[uuid.UUID]string, error) {
+	defer rows.Close()
+
+	names := make(map[uuid.UUID]string)
+	for rows.Next() {
+		var id uuid.UUID
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		names[id] = name
+	}
+	return names, rows.Err()
+}
+
+// columns scanGuild expects, in order
+const guildColumns = `id, name, owner_id, retention_mode, retention_value, audit_retention_days, deletes_at, created_at,
+	description, icon_url, banner_url, system_channel_id, default_notifications, explicit_content_filter, verification_level`
+
+// columns scanMemberProfile expects, in order. Needs guild_members as gm
+// joined with users as u.
+const memberProfileColumns = `gm.guild_id, gm.user_id, gm.temporary, gm.pending, gm.timeout_until, gm.joined_at,
+	COALESCE(gm.nickname, ''), COALESCE(gm.avatar_url, ''), COALESCE(gm.bio, ''),
+	u.username, u.activity_status, u.bot,
+	ARRAY(
+		SELECT gr.id FROM guild_member_roles gmr
+		JOIN guild_roles gr ON gr.id = gmr.role_id
+		WHERE gmr.guild_id = gm.guild_id AND gmr.user_id = gm.user_id AND gr.position <> 255 -- models.MaxRoles, the everyone role
+		ORDER BY gr.position ASC
+	)`
+
+func scanMemberProfile(row rowScanner) (*models.GuildMemberProfile, error) {
+	var member models.GuildMemberProfile
+	var user models.PublicUser
+	err := row.Scan(
+		&member.GuildID,
+		&member.UserID,
+		&member.Temporary,
+		&member.Pending,
+		&member.TimeoutUntil,
+		&member.JoinedAt,
+		&member.Nickname,
+		&member.AvatarURL,
+		&member.Bio,
+		&user.Username,
+		&user.ActivityStatus,
+		&user.Bot,
+		pq.Array(&member.Roles),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	user.ID = member.UserID
+	member.User = &user
+	if member.Roles == nil {
+		member.Roles = []uuid.UUID{}
+	}
+
+	return &member, nil
+}
+
+func scanGuild(row rowScanner) (*models.Guild, error) {
+	var guild models.Guild
+	err := row.Scan(
+		&guild.ID,
+		&guild.Name,
+		&guild.OwnerID,
+		&guild.Retention.Mode,
+		&guild.Retention.Value,
+		&guild.AuditRetentionDays,
+		&guild.DeletesAt,
+		&guild.CreatedAt,
+		&guild.Description,
+		&guild.IconURL,
+		&guild.BannerURL,
+		&guild.SystemChannelID,
+		&guild.DefaultNotifications,
+		&guild.ExplicitContentFilter,
+		&guild.VerificationLevel,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &guild, nil
+}
+
+// Escapes LIKE wildcards so user input only ever matches literally
+func escapeLikePattern(value string) string {
+	return likeEscaper.Replace(value)
 }
 
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
 func isUniqueViolation(err error, constraintName string) bool {
 	var pgErr *pgconn.PgError
 	if errors.As(err, &pgErr) {
@@ -0,0 +1,191 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"mana/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+type InsightsStore struct {
+	DB *sql.DB
+}
+
+func NewInsightsStore(db *sql.DB) *InsightsStore {
+	return &InsightsStore{DB: db}
+}
+
+// Recomputes every guild's and channel's totals for the UTC day starting at
+// day. Totals only ever go up, so messages pruned or deleted after an
+// earlier rollup still count.
+func (insightsStore *InsightsStore) RollupDay(ctx context.Context, day time.Time) error {
+	rollupGuildsSQL := `
+		INSERT INTO guild_insights_daily (guild_id, day, messages, active_members, joins, leaves, invite_uses)
+		SELECT g.id, $3,
+			COALESCE(m.messages, 0),
+			COALESCE(m.active_members, 0),
+			COALESCE(e.joins, 0),
+			COALESCE(e.leaves, 0),
+			COALESCE(i.invite_uses, 0)
+		FROM guilds g
+		LEFT JOIN (
+			SELECT c.guild_id, COUNT(*) AS messages, COUNT(DISTINCT msg.author_id) AS active_members
+			FROM messages msg
+			JOIN guild_channels c ON c.id = msg.channel_id
+			WHERE msg.created_at >= $1 AND msg.created_at < $2
+			GROUP BY c.guild_id
+		) m ON m.guild_id = g.id
+		LEFT JOIN (
+			SELECT guild_id,
+				COUNT(*) FILTER (WHERE kind = 'join') AS joins,
+				COUNT(*) FILTER (WHERE kind = 'leave') AS leaves
+			FROM guild_member_events
+			WHERE created_at >= $1 AND created_at < $2
+			GROUP BY guild_id
+		) e ON e.guild_id = g.id
+		LEFT JOIN (
+			SELECT inv.guild_id, COUNT(*) AS invite_uses
+			FROM guild_invite_uses u
+			JOIN guild_invites inv ON inv.code = u.code
+			WHERE u.used_at >= $1 AND u.used_at < $2
+			GROUP BY inv.guild_id
+		) i ON i.guild_id = g.id
+		ON CONFLICT (guild_id, day) DO UPDATE SET
+			messages = GREATEST(guild_insights_daily.messages, EXCLUDED.messages),
+			active_members = GREATEST(guild_insights_daily.active_members, EXCLUDED.active_members),
+			joins = GREATEST(guild_insights_daily.joins, EXCLUDED.joins),
+			leaves = GREATEST(guild_insights_daily.leaves, EXCLUDED.leaves),
+			invite_uses = GREATEST(guild_insights_daily.invite_uses, EXCLUDED.invite_uses)
+	`
+
+	rollupChannelsSQL := `
+		INSERT INTO guild_channel_insights_daily (channel_id, guild_id, day, messages)
+		SELECT c.id, c.guild_id, $3, COUNT(*)
+		FROM messages msg
+		JOIN guild_channels c ON c.id = msg.channel_id
+		WHERE msg.created_at >= $1 AND msg.created_at < $2
+		GROUP BY c.id, c.guild_id
+		ON CONFLICT (channel_id, day) DO UPDATE SET
+			messages = GREATEST(guild_channel_insights_daily.messages, EXCLUDED.messages)
+	`
+
+	start := models.InsightsDay(day)
+	end := start.Add(24 * time.Hour)
+	dayStr := start.Format(models.InsightsDateLayout)
+
+	tx, err := insightsStore.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, rollupGuildsSQL, start, end, dayStr); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, rollupChannelsSQL, start, end, dayStr); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Raises each guild's peak for the day to its current connection count,
+// summed over the channels in counts
+func (insightsStore *InsightsStore) RecordConnectionPeaks(ctx context.Context, day time.Time, counts map[uuid.UUID]int) error {
+	if len(counts) == 0 {
+		return nil
+	}
+
+	recordPeaksSQL := `
+		INSERT INTO guild_insights_daily (guild_id, day, peak_connections)
+		SELECT c.guild_id, $1, SUM(s.connections)
+		FROM unnest($2::uuid[], $3::int[]) AS s(channel_id, connections)
+		JOIN guild_channels c ON c.id = s.channel_id
+		GROUP BY c.guild_id
+		ON CONFLICT (guild_id, day) DO UPDATE SET
+			peak_connections = GREATEST(guild_insights_daily.peak_connections, EXCLUDED.peak_connections)
+	`
+
+	channelIDs := make([]string, 0, len(counts))
+	connections := make([]int64, 0, len(counts))
+	for channelID, count := range counts {
+		channelIDs = append(channelIDs, channelID.String())
+		connections = append(connections, int64(count))
+	}
+
+	dayStr := models.InsightsDay(day).Format(models.InsightsDateLayout)
+	_, err := insightsStore.DB.ExecContext(ctx, recordPeaksSQL, dayStr, pq.Array(channelIDs), pq.Array(connections))
+	return err
+}
+
+// Oldest first, from and to are inclusive days
+func (insightsStore *InsightsStore) GetGuildInsights(ctx context.Context, guildID uuid.UUID, from time.Time, to time.Time) ([]*models.GuildInsightsDay, error) {
+	getGuildInsightsSQL := `
+		SELECT day, messages, active_members, joins, leaves, invite_uses, peak_connections
+		FROM guild_insights_daily
+		WHERE guild_id = $1 AND day >= $2 AND day <= $3
+		ORDER BY day ASC
+	`
+
+	rows, err := insightsStore.DB.QueryContext(ctx, getGuildInsightsSQL, guildID,
+		from.Format(models.InsightsDateLayout), to.Format(models.InsightsDateLayout))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var days []*models.GuildInsightsDay
+	for rows.Next() {
+		var insights models.GuildInsightsDay
+		var day time.Time
+		if err := rows.Scan(
+			&day,
+			&insights.Messages,
+			&insights.ActiveMembers,
+			&insights.Joins,
+			&insights.Leaves,
+			&insights.InviteUses,
+			&insights.PeakConnections,
+		); err != nil {
+			return nil, err
+		}
+		insights.Day = day.Format(models.InsightsDateLayout)
+		days = append(days, &insights)
+	}
+
+	return days, rows.Err()
+}
+
+// Oldest first, from and to are inclusive days. Days without messages are left out.
+func (insightsStore *InsightsStore) GetChannelInsights(ctx context.Context, guildID uuid.UUID, from time.Time, to time.Time) ([]*models.ChannelInsightsDay, error) {
+	getChannelInsightsSQL := `
+		SELECT channel_id, day, messages
+		FROM guild_channel_insights_daily
+		WHERE guild_id = $1 AND day >= $2 AND day <= $3
+		ORDER BY day ASC, channel_id ASC
+	`
+
+	rows, err := insightsStore.DB.QueryContext(ctx, getChannelInsightsSQL, guildID,
+		from.Format(models.InsightsDateLayout), to.Format(models.InsightsDateLayout))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var days []*models.ChannelInsightsDay
+	for rows.Next() {
+		var insights models.ChannelInsightsDay
+		var day time.Time
+		if err := rows.Scan(&insights.ChannelID, &day, &insights.Messages); err != nil {
+			return nil, err
+		}
+		insights.Day = day.Format(models.InsightsDateLayout)
+		days = append(days, &insights)
+	}
+
+	return days, rows.Err()
+}
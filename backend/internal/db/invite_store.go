@@ -0,0 +1,256 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"mana/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInviteUnusable = errors.New("invite is revoked, expired or used up")
+	ErrAlreadyMember  = errors.New("user is already a member of the guild")
+	ErrBanned         = errors.New("user is banned from the guild")
+)
+
+type InviteStore struct {
+	DB *sql.DB
+}
+
+func NewInviteStore(db *sql.DB) *InviteStore {
+	return &InviteStore{DB: db}
+}
+
+const inviteColumns = `code, guild_id, channel_id, creator_id, max_uses, uses, temporary, expires_at, revoked_at, created_at`
+
+func scanInvite(row rowScanner) (*models.Invite, error) {
+	var invite models.Invite
+	err := row.Scan(
+		&invite.Code,
+		&invite.GuildID,
+		&invite.ChannelID,
+		&invite.CreatorID,
+		&invite.MaxUses,
+		&invite.Uses,
+		&invite.Temporary,
+		&invite.ExpiresAt,
+		&invite.RevokedAt,
+		&invite.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &invite, nil
+}
+
+func (inviteStore *InviteStore) CreateInvite(ctx context.Context, invite *models.Invite) error {
+	for i := 0; i < 3; i++ {
+		err := insertInvite(ctx, inviteStore.DB, invite)
+
+		if isUniqueViolation(err, "guild_invites_pkey") {
+			invite.Code = models.GenerateInviteCode()
+			continue
+		}
+
+		return err
+	}
+
+	return errors.New("failed to create invite after 3 attempts due to invite code uniqueness")
+}
+
+func insertInvite(ctx context.Context, exec execer, invite *models.Invite) error {
+	insertInviteSQL := `
+		INSERT INTO guild_invites (code, guild_id, channel_id, creator_id, max_uses, uses, temporary, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`
+
+	_, err := exec.ExecContext(ctx, insertInviteSQL,
+		invite.Code,
+		invite.GuildID,
+		invite.ChannelID,
+		invite.CreatorID,
+		invite.MaxUses,
+		invite.Uses,
+		invite.Temporary,
+		invite.ExpiresAt,
+		invite.CreatedAt,
+	)
+	return err
+}
+
+func (inviteStore *InviteStore) GetInvite(ctx context.Context, code string) (*models.Invite, error) {
+	getInviteSQL := `SELECT ` + inviteColumns + ` FROM guild_invites WHERE code = $1`
+
+	invite, err := scanInvite(inviteStore.DB.QueryRowContext(ctx, getInviteSQL, code))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return invite, err
+}
+
+// Newest first, revoked and expired invites included
+func (inviteStore *InviteStore) GetInvitesForGuild(ctx context.Context, guildID uuid.UUID) ([]*models.Invite, error) {
+	getInvitesSQL := `
+		SELECT ` + inviteColumns + `
+		FROM guild_invites
+		WHERE guild_id = $1
+		ORDER BY created_at DESC
+	`
+
+	rows, err := inviteStore.DB.QueryContext(ctx, getInvitesSQL, guildID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invites []*models.Invite
+	for rows.Next() {
+		invite, err := scanInvite(rows)
+		if err != nil {
+			return nil, err
+		}
+		invites = append(invites, invite)
+	}
+
+	return invites, rows.Err()
+}
+
+// Only counts invites that can still be used
+func (inviteStore *InviteStore) CountActiveInvitesForGuild(ctx context.Context, guildID uuid.UUID) (int, error) {
+	countInvitesSQL := `
+		SELECT COUNT(*) FROM guild_invites
+		WHERE guild_id = $1
+		AND revoked_at IS NULL
+		AND (expires_at IS NULL OR expires_at > now())
+		AND (max_uses = 0 OR uses < max_uses)
+	`
+
+	var count int
+	err := inviteStore.DB.QueryRowContext(ctx, countInvitesSQL, guildID).Scan(&count)
+	return count, err
+}
+
+// Revoked invites are kept so their uses stay attributable
+func (inviteStore *InviteStore) RevokeInvite(ctx context.Context, code string) error {
+	revokeInviteSQL := `
+		UPDATE guild_invites SET revoked_at = now()
+		WHERE code = $1 AND revoked_at IS NULL
+	`
+
+	_, err := inviteStore.DB.ExecContext(ctx, revokeInviteSQL, code)
+	return err
+}
+
+// Gets what the invite page shows, nil if the invite can't be used anymore
+func (inviteStore *InviteStore) GetInvitePreview(ctx context.Context, code string) (*models.InvitePreview, error) {
+	getInvitePreviewSQL := `
+		SELECT i.code, g.id, g.name, COALESCE(c.name, ''), i.expires_at,
+			(SELECT COUNT(*) FROM guild_members m WHERE m.guild_id = g.id)
+		FROM guild_invites i
+		JOIN guilds g ON g.id = i.guild_id
+		LEFT JOIN guild_channels c ON c.id = i.channel_id
+		WHERE i.code = $1
+		AND i.revoked_at IS NULL
+		AND (i.expires_at IS NULL OR i.expires_at > now())
+		AND (i.max_uses = 0 OR i.uses < i.max_uses)
+	`
+
+	var preview models.InvitePreview
+	err := inviteStore.DB.QueryRowContext(ctx, getInvitePreviewSQL, code).Scan(
+		&preview.Code,
+		&preview.GuildID,
+		&preview.GuildName,
+		&preview.ChannelName,
+		&preview.ExpiresAt,
+		&preview.MemberCount,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &preview, nil
+}
+
+// Uses up one use of the invite and adds the user to its guild in one go, so
+// concurrent joins can't push an invite past its max uses. Returns the invite
+// as it was used and the new member, pending if the guild screens members.
+func (inviteStore *InviteStore) JoinWithInvite(ctx context.Context, code string, userID uuid.UUID) (*models.Invite, *models.GuildMember, error) {
+	tx, err := inviteStore.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	useInviteSQL := `
+		UPDATE guild_invites SET uses = uses + 1
+		WHERE code = $1
+		AND revoked_at IS NULL
+		AND (expires_at IS NULL OR expires_at > now())
+		AND (max_uses = 0 OR uses < max_uses)
+		RETURNING ` + inviteColumns
+
+	invite, err := scanInvite(tx.QueryRowContext(ctx, useInviteSQL, code))
+	if err == sql.ErrNoRows {
+		return nil, nil, ErrInviteUnusable
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	isBannedSQL := `SELECT EXISTS (SELECT 1 FROM guild_bans WHERE guild_id = $1 AND user_id = $2)`
+
+	var isBanned bool
+	if err := tx.QueryRowContext(ctx, isBannedSQL, invite.GuildID, userID).Scan(&isBanned); err != nil {
+		return nil, nil, err
+	}
+	if isBanned {
+		return nil, nil, ErrBanned
+	}
+
+	screeningSQL := `SELECT EXISTS (SELECT 1 FROM guild_onboarding WHERE guild_id = $1 AND screening_enabled)`
+
+	var screening bool
+	if err := tx.QueryRowContext(ctx, screeningSQL, invite.GuildID).Scan(&screening); err != nil {
+		return nil, nil, err
+	}
+
+	member := &models.GuildMember{
+		GuildID:   invite.GuildID,
+		UserID:    userID,
+		Temporary: invite.Temporary,
+		Pending:   screening,
+		JoinedAt:  time.Now().UTC(),
+	}
+
+	err = insertGuildMember(ctx, tx, member)
+	if isUniqueViolation(err, "guild_members_pkey") {
+		return nil, nil, ErrAlreadyMember
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// pending members get it once they pass screening, see GuildStore.AdmitMember
+	if !member.Pending {
+		if err := bindEveryoneRole(ctx, tx, invite.GuildID, userID); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	insertInviteUseSQL := `INSERT INTO guild_invite_uses (code, user_id, used_at) VALUES ($1, $2, $3)`
+	if _, err := tx.ExecContext(ctx, insertInviteUseSQL, invite.Code, userID, member.JoinedAt); err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+
+	return invite, member, nil
+}
@@ -3,11 +3,14 @@ package db
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"mana/internal/models"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 type MessageStore struct {
@@ -18,22 +21,55 @@ func NewMessageStore(db *sql.DB) *MessageStore {
 	return &MessageStore{DB: db}
 }
 
+const messageColumns = `id, COALESCE(channel_id, dm_channel_id), dm_channel_id IS NOT NULL, type, author_id,
+	webhook_id, webhook_name, webhook_avatar_url, bot, content, ast, plain_text, embeds, created_at, edited_at`
+
 func (messageStore *MessageStore) InsertMessage(ctx context.Context, message *models.Message) error {
 	insertMessageSQL := `
-		INSERT INTO messages (id, channel_id, author_id, content, created_at)
-		VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO messages (id, channel_id, dm_channel_id, type, author_id, webhook_id, webhook_name, webhook_avatar_url, bot, content, ast, plain_text, embeds, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
 	`
-	_, err := messageStore.DB.ExecContext(ctx, insertMessageSQL,
-		message.ID, message.ChannelID, message.AuthorID, message.Content, message.CreatedAt,
+
+	// messages live in either a guild channel or a dm channel, never both
+	var guildChannelID, dmChannelID *uuid.UUID
+	if message.Direct {
+		dmChannelID = &message.ChannelID
+	} else {
+		guildChannelID = &message.ChannelID
+	}
+
+	var webhookName, webhookAvatarURL *string
+	if message.Webhook != nil {
+		webhookName = &message.Webhook.Name
+		webhookAvatarURL = &message.Webhook.AvatarURL
+	}
+
+	ast, err := json.Marshal(message.AST)
+	if err != nil {
+		return err
+	}
+
+	// webhooks can send embeds of their own, anything else starts with none
+	embeds := []byte("[]")
+	if len(message.Embeds) > 0 {
+		embeds, err = json.Marshal(message.Embeds)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = messageStore.DB.ExecContext(ctx, insertMessageSQL,
+		message.ID, guildChannelID, dmChannelID, message.Type, message.AuthorID, message.WebhookID, webhookName, webhookAvatarURL, message.Bot,
+		message.Content, ast, message.PlainText, embeds, message.CreatedAt,
 	)
 	return err
 }
 
 func (messageStore *MessageStore) GetMessagesByChannel(ctx context.Context, channelID uuid.UUID, limit int, before *time.Time) ([]*models.Message, error) {
 	selectMessagesFromChannelSQL := `
-		SELECT id, channel_id, author_id, content, created_at
+		SELECT ` + messageColumns + `
 		FROM messages
-		WHERE channel_id = $1
+		WHERE (channel_id = $1 OR dm_channel_id = $1)
 	`
 
 	// create slice of all our arguments
@@ -59,26 +95,249 @@ func (messageStore *MessageStore) GetMessagesByChannel(ctx context.Context, chan
 	defer rows.Close()
 
 	// convert rows -> messages
+	messages, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	// reverse to chronological order
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+
+	return messages, nil
+}
+
+func (messageStore *MessageStore) UpdateMessageEmbeds(ctx context.Context, messageID uuid.UUID, embeds []models.Embed) error {
+	updateMessageEmbedsSQL := `UPDATE messages SET embeds = $1 WHERE id = $2`
+
+	raw, err := json.Marshal(embeds)
+	if err != nil {
+		return err
+	}
+
+	_, err = messageStore.DB.ExecContext(ctx, updateMessageEmbedsSQL, raw, messageID)
+	return err
+}
+
+// Saves edited content along with its parsed forms, embeds are resolved again afterwards
+func (messageStore *MessageStore) UpdateMessageContent(ctx context.Context, message *models.Message) error {
+	updateMessageContentSQL := `
+		UPDATE messages
+		SET content = $1, ast = $2, plain_text = $3, embeds = '[]', edited_at = $4
+		WHERE id = $5
+	`
+
+	ast, err := json.Marshal(message.AST)
+	if err != nil {
+		return err
+	}
+
+	_, err = messageStore.DB.ExecContext(ctx, updateMessageContentSQL, message.Content, ast, message.PlainText, message.EditedAt, message.ID)
+	return err
+}
+
+func (messageStore *MessageStore) GetMessageByID(ctx context.Context, messageID uuid.UUID) (*models.Message, error) {
+	selectMessageSQL := `
+		SELECT ` + messageColumns + `
+		FROM messages
+		WHERE id = $1
+	`
+
+	rows, err := messageStore.DB.QueryContext(ctx, selectMessageSQL, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages, err := scanMessages(rows)
+	if err != nil || len(messages) == 0 {
+		return nil, err
+	}
+
+	return messages[0], nil
+}
+
+func (messageStore *MessageStore) DeleteMessage(ctx context.Context, messageID uuid.UUID) error {
+	deleteMessageSQL := `DELETE FROM messages WHERE id = $1`
+	_, err := messageStore.DB.ExecContext(ctx, deleteMessageSQL, messageID)
+	return err
+}
+
+// Deletes the messages that still exist out of messageIDs, returning their ids by channel
+func (messageStore *MessageStore) DeleteMessages(ctx context.Context, messageIDs []uuid.UUID) (map[uuid.UUID][]uuid.UUID, error) {
+	deleteMessagesSQL := `
+		DELETE FROM messages
+		WHERE id = ANY($1)
+		RETURNING id, COALESCE(channel_id, dm_channel_id)
+	`
+
+	rows, err := messageStore.DB.QueryContext(ctx, deleteMessagesSQL, pq.Array(messageIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deleted := make(map[uuid.UUID][]uuid.UUID)
+	for rows.Next() {
+		var messageID, channelID uuid.UUID
+		if err := rows.Scan(&messageID, &channelID); err != nil {
+			return nil, err
+		}
+		deleted[channelID] = append(deleted[channelID], messageID)
+	}
+
+	return deleted, rows.Err()
+}
+
+// Deletes up to models.MaxBulkDeleteMessages of the newest messages in a guild
+// channel matching filter, and records action in the same transaction.
+// Returns the ids of the deleted messages.
+func (messageStore *MessageStore) BulkDeleteMessages(ctx context.Context, channelID uuid.UUID, filter *models.MessageFilter, action *models.ModerationAction) ([]uuid.UUID, error) {
+	bulkDeleteMessagesSQL := `
+		DELETE FROM messages
+		WHERE id IN (
+			SELECT id FROM messages
+			WHERE channel_id = $1
+	`
+
+	args := []interface{}{channelID}
+	paramIndex := 2
+
+	if len(filter.MessageIDs) > 0 {
+		bulkDeleteMessagesSQL += fmt.Sprintf(" AND id = ANY($%d)", paramIndex)
+		args = append(args, pq.Array(filter.MessageIDs))
+		paramIndex++
+	}
+
+	if filter.AuthorID != nil {
+		bulkDeleteMessagesSQL += fmt.Sprintf(" AND author_id = $%d", paramIndex)
+		args = append(args, *filter.AuthorID)
+		paramIndex++
+	}
+
+	if filter.After != nil {
+		bulkDeleteMessagesSQL += fmt.Sprintf(" AND created_at > $%d", paramIndex)
+		args = append(args, *filter.After)
+		paramIndex++
+	}
+
+	if filter.Before != nil {
+		bulkDeleteMessagesSQL += fmt.Sprintf(" AND created_at < $%d", paramIndex)
+		args = append(args, *filter.Before)
+		paramIndex++
+	}
+
+	if filter.Contains != "" {
+		bulkDeleteMessagesSQL += fmt.Sprintf(" AND content ILIKE $%d ESCAPE '\\'", paramIndex)
+		args = append(args, "%"+escapeLike(filter.Contains)+"%")
+		paramIndex++
+	}
+
+	bulkDeleteMessagesSQL += fmt.Sprintf(" ORDER BY created_at DESC LIMIT $%d FOR UPDATE) RETURNING id", paramIndex)
+	args = append(args, models.MaxBulkDeleteMessages)
+
+	tx, err := messageStore.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, bulkDeleteMessagesSQL, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var deletedIDs []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		deletedIDs = append(deletedIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(deletedIDs) == 0 {
+		return nil, nil
+	}
+
+	if action != nil {
+		if err := insertModerationAction(ctx, tx, action); err != nil {
+			return nil, err
+		}
+	}
+
+	return deletedIDs, tx.Commit()
+}
+
+// Full text search over the plain text projection, newest first
+func (messageStore *MessageStore) SearchMessages(ctx context.Context, channelID uuid.UUID, query string, limit int) ([]*models.Message, error) {
+	searchMessagesSQL := `
+		SELECT ` + messageColumns + `
+		FROM messages
+		WHERE (channel_id = $1 OR dm_channel_id = $1)
+		  AND to_tsvector('simple', plain_text) @@ plainto_tsquery('simple', $2)
+		ORDER BY created_at DESC
+		LIMIT $3
+	`
+
+	rows, err := messageStore.DB.QueryContext(ctx, searchMessagesSQL, channelID, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanMessages(rows)
+}
+
+func scanMessages(rows *sql.Rows) ([]*models.Message, error) {
 	var messages []*models.Message
 	for rows.Next() {
 		var msg models.Message
+		var ast, embeds []byte
+		var webhookName, webhookAvatarURL sql.NullString
 		if err := rows.Scan(
 			&msg.ID,
 			&msg.ChannelID,
+			&msg.Direct,
+			&msg.Type,
 			&msg.AuthorID,
+			&msg.WebhookID,
+			&webhookName,
+			&webhookAvatarURL,
+			&msg.Bot,
 			&msg.Content,
+			&ast,
+			&msg.PlainText,
+			&embeds,
 			&msg.CreatedAt,
+			&msg.EditedAt,
 		); err != nil {
 			return nil, err
 		}
 
+		if err := json.Unmarshal(ast, &msg.AST); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(embeds, &msg.Embeds); err != nil {
+			return nil, err
+		}
+		if webhookName.Valid {
+			msg.Webhook = &models.WebhookAuthor{Name: webhookName.String, AvatarURL: webhookAvatarURL.String}
+		}
+
 		messages = append(messages, &msg)
 	}
 
-	// reverse to chronological order
-	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
-		messages[i], messages[j] = messages[j], messages[i]
-	}
+	return messages, rows.Err()
+}
 
-	return messages, nil
+// escapes LIKE wildcards so user input matches literally
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
 }
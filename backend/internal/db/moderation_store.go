@@ -0,0 +1,207 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"mana/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ModerationStore struct {
+	DB *sql.DB
+}
+
+func NewModerationStore(db *sql.DB) *ModerationStore {
+	return &ModerationStore{DB: db}
+}
+
+// *sql.DB and *sql.Tx, lets other stores record actions inside their own transaction
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func (moderationStore *ModerationStore) InsertAction(ctx context.Context, action *models.ModerationAction) error {
+	return insertModerationAction(ctx, moderationStore.DB, action)
+}
+
+func insertModerationAction(ctx context.Context, exec execer, action *models.ModerationAction) error {
+	insertModerationActionSQL := `
+		INSERT INTO moderation_actions (id, guild_id, moderator_id, action, target_user_id, channel_id, reason, metadata, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`
+
+	var metadata []byte
+	if len(action.Metadata) > 0 {
+		metadata = action.Metadata
+	}
+
+	_, err := exec.ExecContext(ctx, insertModerationActionSQL,
+		action.ID,
+		action.GuildID,
+		action.ModeratorID,
+		action.Action,
+		action.TargetUserID,
+		action.ChannelID,
+		action.Reason,
+		metadata,
+		action.CreatedAt,
+	)
+	return err
+}
+
+func (moderationStore *ModerationStore) KickMember(ctx context.Context, guildID uuid.UUID, userID uuid.UUID, action *models.ModerationAction) error {
+	tx, err := moderationStore.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := deleteGuildMember(ctx, tx, guildID, userID); err != nil {
+		return err
+	}
+
+	if err := insertModerationAction(ctx, tx, action); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Bans the user, removing them from the guild if they're in it. Their
+// messages in the guild since purgeSince are deleted too, returned by channel.
+func (moderationStore *ModerationStore) BanMember(ctx context.Context, ban *models.GuildBan, purgeSince *time.Time, action *models.ModerationAction) (map[uuid.UUID][]uuid.UUID, error) {
+	tx, err := moderationStore.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// banning again just updates the reason
+	upsertBanSQL := `
+		INSERT INTO guild_bans (guild_id, user_id, moderator_id, reason, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (guild_id, user_id) DO UPDATE SET
+			moderator_id = EXCLUDED.moderator_id,
+			reason = EXCLUDED.reason
+	`
+	_, err = tx.ExecContext(ctx, upsertBanSQL, ban.GuildID, ban.UserID, ban.ModeratorID, ban.Reason, ban.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := deleteGuildMember(ctx, tx, ban.GuildID, ban.UserID); err != nil {
+		return nil, err
+	}
+
+	deleted := make(map[uuid.UUID][]uuid.UUID)
+	if purgeSince != nil {
+		purgeMessagesSQL := `
+			DELETE FROM messages
+			WHERE author_id = $1 AND created_at >= $2
+			AND channel_id IN (SELECT id FROM guild_channels WHERE guild_id = $3)
+			RETURNING id, channel_id
+		`
+
+		rows, err := tx.QueryContext(ctx, purgeMessagesSQL, ban.UserID, *purgeSince, ban.GuildID)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var messageID, channelID uuid.UUID
+			if err := rows.Scan(&messageID, &channelID); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			deleted[channelID] = append(deleted[channelID], messageID)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := insertModerationAction(ctx, tx, action); err != nil {
+		return nil, err
+	}
+
+	return deleted, tx.Commit()
+}
+
+// Returns false if the user wasn't banned, nothing is recorded then
+func (moderationStore *ModerationStore) UnbanMember(ctx context.Context, guildID uuid.UUID, userID uuid.UUID, action *models.ModerationAction) (bool, error) {
+	tx, err := moderationStore.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	deleteBanSQL := `DELETE FROM guild_bans WHERE guild_id = $1 AND user_id = $2`
+	result, err := tx.ExecContext(ctx, deleteBanSQL, guildID, userID)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if affected == 0 {
+		return false, nil
+	}
+
+	if err := insertModerationAction(ctx, tx, action); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// Newest first
+func (moderationStore *ModerationStore) GetBansForGuild(ctx context.Context, guildID uuid.UUID) ([]*models.GuildBan, error) {
+	getBansSQL := `
+		SELECT guild_id, user_id, moderator_id, reason, created_at
+		FROM guild_bans
+		WHERE guild_id = $1
+		ORDER BY created_at DESC
+	`
+
+	rows, err := moderationStore.DB.QueryContext(ctx, getBansSQL, guildID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bans []*models.GuildBan
+	for rows.Next() {
+		var ban models.GuildBan
+		if err := rows.Scan(&ban.GuildID, &ban.UserID, &ban.ModeratorID, &ban.Reason, &ban.CreatedAt); err != nil {
+			return nil, err
+		}
+		bans = append(bans, &ban)
+	}
+
+	return bans, rows.Err()
+}
+
+// A nil until lifts the timeout
+func (moderationStore *ModerationStore) SetMemberTimeout(ctx context.Context, guildID uuid.UUID, userID uuid.UUID, until *time.Time, action *models.ModerationAction) error {
+	tx, err := moderationStore.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	setTimeoutSQL := `UPDATE guild_members SET timeout_until = $3 WHERE guild_id = $1 AND user_id = $2`
+	if _, err := tx.ExecContext(ctx, setTimeoutSQL, guildID, userID, until); err != nil {
+		return err
+	}
+
+	if err := insertModerationAction(ctx, tx, action); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
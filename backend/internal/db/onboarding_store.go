@@ -0,0 +1,89 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"mana/internal/models"
+
+	"github.com/google/uuid"
+)
+
+type OnboardingStore struct {
+	DB *sql.DB
+}
+
+func NewOnboardingStore(db *sql.DB) *OnboardingStore {
+	return &OnboardingStore{DB: db}
+}
+
+// Gets the guild's onboarding, nil if it was never set up
+func (onboardingStore *OnboardingStore) GetOnboarding(ctx context.Context, guildID uuid.UUID) (*models.GuildOnboarding, error) {
+	getOnboardingSQL := `
+		SELECT guild_id, screening_enabled, rules, prompts, welcome_channel_id, welcome_message, updated_at
+		FROM guild_onboarding
+		WHERE guild_id = $1
+	`
+
+	var onboarding models.GuildOnboarding
+	var prompts []byte
+	err := onboardingStore.DB.QueryRowContext(ctx, getOnboardingSQL, guildID).Scan(
+		&onboarding.GuildID,
+		&onboarding.ScreeningEnabled,
+		&onboarding.Rules,
+		&prompts,
+		&onboarding.WelcomeChannelID,
+		&onboarding.WelcomeMessage,
+		&onboarding.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(prompts, &onboarding.Prompts); err != nil {
+		return nil, err
+	}
+	if onboarding.Prompts == nil {
+		onboarding.Prompts = []models.OnboardingPrompt{}
+	}
+
+	return &onboarding, nil
+}
+
+// Replaces the guild's onboarding. Turning screening off doesn't let
+// members who are already pending in, they still accept the rules they saw.
+func (onboardingStore *OnboardingStore) SetOnboarding(ctx context.Context, onboarding *models.GuildOnboarding) error {
+	setOnboardingSQL := `
+		INSERT INTO guild_onboarding (guild_id, screening_enabled, rules, prompts, welcome_channel_id, welcome_message, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (guild_id) DO UPDATE SET
+			screening_enabled = EXCLUDED.screening_enabled,
+			rules = EXCLUDED.rules,
+			prompts = EXCLUDED.prompts,
+			welcome_channel_id = EXCLUDED.welcome_channel_id,
+			welcome_message = EXCLUDED.welcome_message,
+			updated_at = EXCLUDED.updated_at
+	`
+
+	prompts, err := json.Marshal(onboarding.Prompts)
+	if err != nil {
+		return err
+	}
+
+	_, err = onboardingStore.DB.ExecContext(
+		ctx,
+		setOnboardingSQL,
+		onboarding.GuildID,
+		onboarding.ScreeningEnabled,
+		onboarding.Rules,
+		prompts,
+		onboarding.WelcomeChannelID,
+		onboarding.WelcomeMessage,
+		onboarding.UpdatedAt,
+	)
+
+	return err
+}
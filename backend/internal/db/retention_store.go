@@ -0,0 +1,182 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"mana/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type RetentionStore struct {
+	DB *sql.DB
+}
+
+func NewRetentionStore(db *sql.DB) *RetentionStore {
+	return &RetentionStore{DB: db}
+}
+
+const selectChannelRetentionSQL = `
+	SELECT c.id, c.guild_id, c.name,
+		COALESCE(c.retention_mode, g.retention_mode),
+		COALESCE(c.retention_value, g.retention_value),
+		c.retention_mode IS NULL
+	FROM guild_channels c
+	JOIN guilds g ON g.id = c.guild_id
+`
+
+// Gets every channel whose effective policy deletes messages
+func (retentionStore *RetentionStore) GetChannelsWithRetention(ctx context.Context) ([]*models.ChannelRetention, error) {
+	query := selectChannelRetentionSQL + ` WHERE COALESCE(c.retention_mode, g.retention_mode) <> 'forever'`
+	return retentionStore.queryChannelRetentions(ctx, query)
+}
+
+// Gets the effective policy of every channel in a guild
+func (retentionStore *RetentionStore) GetChannelRetentionsForGuild(ctx context.Context, guildID uuid.UUID) ([]*models.ChannelRetention, error) {
+	query := selectChannelRetentionSQL + ` WHERE c.guild_id = $1 ORDER BY c.position ASC`
+	return retentionStore.queryChannelRetentions(ctx, query, guildID)
+}
+
+func (retentionStore *RetentionStore) queryChannelRetentions(ctx context.Context, query string, args ...any) ([]*models.ChannelRetention, error) {
+	rows, err := retentionStore.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var retentions []*models.ChannelRetention
+	for rows.Next() {
+		var retention models.ChannelRetention
+		if err := rows.Scan(
+			&retention.ChannelID,
+			&retention.GuildID,
+			&retention.ChannelName,
+			&retention.Policy.Mode,
+			&retention.Policy.Value,
+			&retention.Inherited,
+		); err != nil {
+			return nil, err
+		}
+		retentions = append(retentions, &retention)
+	}
+
+	return retentions, rows.Err()
+}
+
+// Deletes one batch of messages older than cutoff, returning their ids.
+// Rows another transaction holds are skipped rather than waited on.
+func (retentionStore *RetentionStore) PruneMessagesBefore(ctx context.Context, channelID uuid.UUID, cutoff time.Time, batchSize int) ([]uuid.UUID, error) {
+	pruneMessagesBeforeSQL := `
+		DELETE FROM messages
+		WHERE id IN (
+			SELECT id FROM messages
+			WHERE channel_id = $1 AND created_at < $2
+			ORDER BY created_at ASC
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id
+	`
+	return retentionStore.pruneBatch(ctx, pruneMessagesBeforeSQL, channelID, cutoff, batchSize)
+}
+
+// Deletes one batch of messages beyond the newest keep, returning their ids.
+// The boundary is found without skipping locked rows, so a message someone
+// else holds still counts towards the ones kept.
+func (retentionStore *RetentionStore) PruneMessagesBeyondCount(ctx context.Context, channelID uuid.UUID, keep int, batchSize int) ([]uuid.UUID, error) {
+	getBoundarySQL := `
+		SELECT created_at, id FROM messages
+		WHERE channel_id = $1
+		ORDER BY created_at DESC, id DESC
+		OFFSET $2
+		LIMIT 1
+	`
+
+	var boundaryCreatedAt time.Time
+	var boundaryID uuid.UUID
+	err := retentionStore.DB.QueryRowContext(ctx, getBoundarySQL, channelID, keep-1).Scan(&boundaryCreatedAt, &boundaryID)
+	if err == sql.ErrNoRows {
+		return nil, nil // no more than keep messages
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pruneMessagesBeyondCountSQL := `
+		DELETE FROM messages
+		WHERE id IN (
+			SELECT id FROM messages
+			WHERE channel_id = $1 AND (created_at, id) < ($2, $3)
+			ORDER BY created_at ASC, id ASC
+			LIMIT $4
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id
+	`
+	return retentionStore.pruneBatch(ctx, pruneMessagesBeyondCountSQL, channelID, boundaryCreatedAt, boundaryID, batchSize)
+}
+
+func (retentionStore *RetentionStore) pruneBatch(ctx context.Context, query string, args ...any) ([]uuid.UUID, error) {
+	rows, err := retentionStore.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// Counts what a prune would remove from a channel without deleting anything
+func (retentionStore *RetentionStore) PreviewPrune(ctx context.Context, channelID uuid.UUID, policy models.RetentionPolicy, now time.Time) (int, *time.Time, *time.Time, error) {
+	var query string
+	var args []any
+
+	switch policy.Mode {
+	case models.RetentionModeDays:
+		query = `
+			SELECT COUNT(*), MIN(created_at), MAX(created_at)
+			FROM messages
+			WHERE channel_id = $1 AND created_at < $2
+		`
+		args = []any{channelID, policy.Cutoff(now)}
+	case models.RetentionModeMessages:
+		query = `
+			SELECT COUNT(*), MIN(created_at), MAX(created_at)
+			FROM (
+				SELECT created_at FROM messages
+				WHERE channel_id = $1
+				ORDER BY created_at DESC, id DESC
+				OFFSET $2
+			) expired
+		`
+		args = []any{channelID, policy.Value}
+	default:
+		return 0, nil, nil, nil
+	}
+
+	var count int
+	var oldest, newest sql.NullTime
+	if err := retentionStore.DB.QueryRowContext(ctx, query, args...).Scan(&count, &oldest, &newest); err != nil {
+		return 0, nil, nil, err
+	}
+
+	var oldestPtr, newestPtr *time.Time
+	if oldest.Valid {
+		oldestPtr = &oldest.Time
+	}
+	if newest.Valid {
+		newestPtr = &newest.Time
+	}
+
+	return count, oldestPtr, newestPtr, nil
+}
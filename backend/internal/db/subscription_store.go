@@ -0,0 +1,464 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"mana/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+type SubscriptionStore struct {
+	DB *sql.DB
+}
+
+func NewSubscriptionStore(db *sql.DB) *SubscriptionStore {
+	return &SubscriptionStore{DB: db}
+}
+
+const subscriptionColumns = `id, guild_id, url, events, secret, enabled, consecutive_failures, disabled_reason, creator_id, created_at`
+
+func scanSubscription(row rowScanner) (*models.EventSubscription, error) {
+	var subscription models.EventSubscription
+	err := row.Scan(
+		&subscription.ID,
+		&subscription.GuildID,
+		&subscription.URL,
+		pq.Array(&subscription.Events),
+		&subscription.Secret,
+		&subscription.Enabled,
+		&subscription.ConsecutiveFailures,
+		&subscription.DisabledReason,
+		&subscription.CreatorID,
+		&subscription.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+const deliveryColumns = `id, subscription_id, guild_id, event_type, payload, status, attempts, next_attempt_at,
+	last_attempt_at, response_status, error, created_at, delivered_at`
+
+func scanDelivery(row rowScanner, extra ...interface{}) (*models.EventDelivery, error) {
+	var delivery models.EventDelivery
+	var payload []byte
+	dest := []interface{}{
+		&delivery.ID,
+		&delivery.SubscriptionID,
+		&delivery.GuildID,
+		&delivery.EventType,
+		&payload,
+		&delivery.Status,
+		&delivery.Attempts,
+		&delivery.NextAttemptAt,
+		&delivery.LastAttemptAt,
+		&delivery.ResponseStatus,
+		&delivery.Error,
+		&delivery.CreatedAt,
+		&delivery.DeliveredAt,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	delivery.Payload = payload
+	return &delivery, nil
+}
+
+func (subscriptionStore *SubscriptionStore) CreateSubscription(ctx context.Context, subscription *models.EventSubscription) error {
+	insertSubscriptionSQL := `
+		INSERT INTO guild_event_subscriptions (id, guild_id, url, events, secret, enabled, creator_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+
+	_, err := subscriptionStore.DB.ExecContext(ctx, insertSubscriptionSQL,
+		subscription.ID,
+		subscription.GuildID,
+		subscription.URL,
+		pq.Array(subscription.Events),
+		subscription.Secret,
+		subscription.Enabled,
+		subscription.CreatorID,
+		subscription.CreatedAt,
+	)
+	return err
+}
+
+func (subscriptionStore *SubscriptionStore) GetSubscription(ctx context.Context, subscriptionID uuid.UUID) (*models.EventSubscription, error) {
+	getSubscriptionSQL := `SELECT ` + subscriptionColumns + ` FROM guild_event_subscriptions WHERE id = $1`
+
+	subscription, err := scanSubscription(subscriptionStore.DB.QueryRowContext(ctx, getSubscriptionSQL, subscriptionID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return subscription, err
+}
+
+func (subscriptionStore *SubscriptionStore) GetSubscriptionsForGuild(ctx context.Context, guildID uuid.UUID) ([]*models.EventSubscription, error) {
+	getSubscriptionsSQL := `
+		SELECT ` + subscriptionColumns + `
+		FROM guild_event_subscriptions
+		WHERE guild_id = $1
+		ORDER BY created_at ASC
+	`
+
+	rows, err := subscriptionStore.DB.QueryContext(ctx, getSubscriptionsSQL, guildID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subscriptions []*models.EventSubscription
+	for rows.Next() {
+		subscription, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+
+	return subscriptions, rows.Err()
+}
+
+func (subscriptionStore *SubscriptionStore) CountSubscriptionsForGuild(ctx context.Context, guildID uuid.UUID) (int, error) {
+	countSubscriptionsSQL := `SELECT COUNT(*) FROM guild_event_subscriptions WHERE guild_id = $1`
+
+	var count int
+	err := subscriptionStore.DB.QueryRowContext(ctx, countSubscriptionsSQL, guildID).Scan(&count)
+	return count, err
+}
+
+// Saves url, events and whether it's enabled along with its failure state,
+// the secret only changes through UpdateSecret
+func (subscriptionStore *SubscriptionStore) UpdateSubscription(ctx context.Context, subscription *models.EventSubscription) error {
+	updateSubscriptionSQL := `
+		UPDATE guild_event_subscriptions
+		SET url = $1, events = $2, enabled = $3, consecutive_failures = $4, disabled_reason = $5
+		WHERE id = $6
+	`
+
+	_, err := subscriptionStore.DB.ExecContext(ctx, updateSubscriptionSQL,
+		subscription.URL,
+		pq.Array(subscription.Events),
+		subscription.Enabled,
+		subscription.ConsecutiveFailures,
+		subscription.DisabledReason,
+		subscription.ID,
+	)
+	return err
+}
+
+// Deliveries still queued are signed with the new secret from now on
+func (subscriptionStore *SubscriptionStore) UpdateSecret(ctx context.Context, subscriptionID uuid.UUID, secret string) error {
+	updateSecretSQL := `UPDATE guild_event_subscriptions SET secret = $1 WHERE id = $2`
+	_, err := subscriptionStore.DB.ExecContext(ctx, updateSecretSQL, secret, subscriptionID)
+	return err
+}
+
+// Takes its queued deliveries and delivery log with it
+func (subscriptionStore *SubscriptionStore) DeleteSubscription(ctx context.Context, subscriptionID uuid.UUID) error {
+	deleteSubscriptionSQL := `DELETE FROM guild_event_subscriptions WHERE id = $1`
+	_, err := subscriptionStore.DB.ExecContext(ctx, deleteSubscriptionSQL, subscriptionID)
+	return err
+}
+
+// Queues a delivery of the event for every enabled subscription in the guild
+// that wants it, returning how many were queued
+func (subscriptionStore *SubscriptionStore) EnqueueEvent(ctx context.Context, guildID uuid.UUID, eventType string, payload json.RawMessage) (int, error) {
+	subscribers, err := subscriptionStore.GetSubscribers(ctx, guildID, eventType)
+	if err != nil {
+		return 0, err
+	}
+
+	subscriptionIDs := make([]uuid.UUID, 0, len(subscribers))
+	for _, subscription := range subscribers {
+		subscriptionIDs = append(subscriptionIDs, subscription.ID)
+	}
+
+	return len(subscriptionIDs), subscriptionStore.EnqueueDeliveries(ctx, guildID, eventType, payload, subscriptionIDs)
+}
+
+// The enabled subscriptions in the guild that want eventType
+func (subscriptionStore *SubscriptionStore) GetSubscribers(ctx context.Context, guildID uuid.UUID, eventType string) ([]*models.EventSubscription, error) {
+	getSubscribersSQL := `
+		SELECT ` + subscriptionColumns + ` FROM guild_event_subscriptions
+		WHERE guild_id = $1 AND enabled AND $2 = ANY(events)
+	`
+
+	rows, err := subscriptionStore.DB.QueryContext(ctx, getSubscribersSQL, guildID, eventType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subscriptions []*models.EventSubscription
+	for rows.Next() {
+		subscription, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+
+	return subscriptions, rows.Err()
+}
+
+// Queues one delivery of the event per subscription, all or none of them
+func (subscriptionStore *SubscriptionStore) EnqueueDeliveries(ctx context.Context, guildID uuid.UUID, eventType string, payload json.RawMessage, subscriptionIDs []uuid.UUID) error {
+	if len(subscriptionIDs) == 0 {
+		return nil
+	}
+
+	tx, err := subscriptionStore.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, subscriptionID := range subscriptionIDs {
+		delivery := models.NewEventDelivery(subscriptionID, guildID, eventType, payload)
+		if err := insertDelivery(ctx, tx, delivery); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Queues a single delivery, for pings and redeliveries
+func (subscriptionStore *SubscriptionStore) InsertDelivery(ctx context.Context, delivery *models.EventDelivery) error {
+	return insertDelivery(ctx, subscriptionStore.DB, delivery)
+}
+
+func insertDelivery(ctx context.Context, exec execer, delivery *models.EventDelivery) error {
+	insertDeliverySQL := `
+		INSERT INTO guild_event_deliveries (id, subscription_id, guild_id, event_type, payload, status, attempts, next_attempt_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`
+
+	_, err := exec.ExecContext(ctx, insertDeliverySQL,
+		delivery.ID,
+		delivery.SubscriptionID,
+		delivery.GuildID,
+		delivery.EventType,
+		[]byte(delivery.Payload),
+		delivery.Status,
+		delivery.Attempts,
+		delivery.NextAttemptAt,
+		delivery.CreatedAt,
+	)
+	return err
+}
+
+func (subscriptionStore *SubscriptionStore) GetDelivery(ctx context.Context, deliveryID uuid.UUID) (*models.EventDelivery, error) {
+	getDeliverySQL := `SELECT ` + deliveryColumns + ` FROM guild_event_deliveries WHERE id = $1`
+
+	delivery, err := scanDelivery(subscriptionStore.DB.QueryRowContext(ctx, getDeliverySQL, deliveryID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return delivery, err
+}
+
+// Newest first, paging back from filter.Before when it's set
+func (subscriptionStore *SubscriptionStore) GetDeliveries(ctx context.Context, subscriptionID uuid.UUID, filter *models.DeliveryFilter) ([]*models.EventDelivery, error) {
+	getDeliveriesSQL := `
+		SELECT ` + deliveryColumns + `
+		FROM guild_event_deliveries
+		WHERE subscription_id = $1
+	`
+
+	args := []interface{}{subscriptionID}
+	paramIndex := 2
+
+	if filter.Status != "" {
+		getDeliveriesSQL += fmt.Sprintf(" AND status = $%d", paramIndex)
+		args = append(args, filter.Status)
+		paramIndex++
+	}
+
+	if filter.Before != nil {
+		getDeliveriesSQL += fmt.Sprintf(
+			" AND (created_at, id) < (SELECT created_at, id FROM guild_event_deliveries WHERE id = $%d AND subscription_id = $1)",
+			paramIndex,
+		)
+		args = append(args, *filter.Before)
+		paramIndex++
+	}
+
+	getDeliveriesSQL += fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d", paramIndex)
+	args = append(args, filter.Limit)
+
+	rows, err := subscriptionStore.DB.QueryContext(ctx, getDeliveriesSQL, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []*models.EventDelivery
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// Claims up to limit due deliveries of enabled subscriptions by pushing their
+// next attempt back by lease, so no other worker picks them up meanwhile. A
+// worker that dies mid delivery just leaves it to be retried after the lease.
+func (subscriptionStore *SubscriptionStore) ClaimDueDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*models.PendingDelivery, error) {
+	claimDeliveriesSQL := `
+		UPDATE guild_event_deliveries d
+		SET next_attempt_at = now() + make_interval(secs => $2)
+		FROM guild_event_subscriptions s
+		WHERE s.id = d.subscription_id
+		AND d.id IN (
+			SELECT due.id FROM guild_event_deliveries due
+			JOIN guild_event_subscriptions sub ON sub.id = due.subscription_id
+			WHERE due.status = 'pending' AND due.next_attempt_at <= now() AND sub.enabled
+			ORDER BY due.next_attempt_at ASC
+			LIMIT $1
+			FOR UPDATE OF due SKIP LOCKED
+		)
+		RETURNING d.id, d.subscription_id, d.guild_id, d.event_type, d.payload, d.status, d.attempts, d.next_attempt_at,
+			d.last_attempt_at, d.response_status, d.error, d.created_at, d.delivered_at, s.url, s.secret
+	`
+
+	rows, err := subscriptionStore.DB.QueryContext(ctx, claimDeliveriesSQL, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pending []*models.PendingDelivery
+	for rows.Next() {
+		var claimed models.PendingDelivery
+		delivery, err := scanDelivery(rows, &claimed.URL, &claimed.Secret)
+		if err != nil {
+			return nil, err
+		}
+		claimed.Delivery = delivery
+		pending = append(pending, &claimed)
+	}
+
+	return pending, rows.Err()
+}
+
+// Finishes a delivery the receiver accepted, and clears its subscription's
+// failure streak
+func (subscriptionStore *SubscriptionStore) MarkDelivered(ctx context.Context, delivery *models.EventDelivery, responseStatus int) error {
+	markDeliveredSQL := `
+		UPDATE guild_event_deliveries
+		SET status = 'succeeded', attempts = attempts + 1, next_attempt_at = NULL,
+			last_attempt_at = now(), delivered_at = now(), response_status = $1, error = ''
+		WHERE id = $2
+	`
+
+	resetFailuresSQL := `UPDATE guild_event_subscriptions SET consecutive_failures = 0 WHERE id = $1`
+
+	tx, err := subscriptionStore.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, markDeliveredSQL, responseStatus, delivery.ID); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, resetFailuresSQL, delivery.SubscriptionID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Records a failed attempt, scheduling a retry with backoff or giving up once
+// it's out of attempts. Returns true when this failure is the one that
+// disabled the subscription.
+func (subscriptionStore *SubscriptionStore) MarkDeliveryFailed(ctx context.Context, delivery *models.EventDelivery, responseStatus *int, message string) (bool, error) {
+	markFailedSQL := `
+		UPDATE guild_event_deliveries
+		SET status = $1, attempts = $2, next_attempt_at = $3, last_attempt_at = now(), response_status = $4, error = $5
+		WHERE id = $6
+	`
+
+	recordFailureSQL := `
+		UPDATE guild_event_subscriptions
+		SET consecutive_failures = consecutive_failures + 1,
+			enabled = enabled AND consecutive_failures + 1 < $2,
+			disabled_reason = CASE
+				WHEN enabled AND consecutive_failures + 1 >= $2 THEN $3
+				ELSE disabled_reason
+			END
+		WHERE id = $1
+		RETURNING enabled, consecutive_failures
+	`
+
+	if len(message) > models.MaxDeliveryErrorLength {
+		message = message[:models.MaxDeliveryErrorLength]
+	}
+
+	attempts := delivery.Attempts + 1
+	status := models.DeliveryStatusPending
+	var nextAttemptAt *time.Time
+	if attempts >= models.MaxDeliveryAttempts {
+		status = models.DeliveryStatusFailed
+	} else {
+		next := time.Now().UTC().Add(models.DeliveryBackoff(attempts))
+		nextAttemptAt = &next
+	}
+
+	tx, err := subscriptionStore.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, markFailedSQL, status, attempts, nextAttemptAt, responseStatus, message, delivery.ID); err != nil {
+		return false, err
+	}
+
+	disabledReason := fmt.Sprintf("Disabled after %d failed deliveries in a row", models.SubscriptionDisableAfterFailures)
+
+	var enabled bool
+	var failures int
+	err = tx.QueryRowContext(ctx, recordFailureSQL, delivery.SubscriptionID, models.SubscriptionDisableAfterFailures, disabledReason).Scan(&enabled, &failures)
+	if err != nil {
+		return false, err
+	}
+
+	return !enabled && failures == models.SubscriptionDisableAfterFailures, tx.Commit()
+}
+
+// Deletes one batch of deliveries older than models.DeliveryRetention,
+// returning how many went
+func (subscriptionStore *SubscriptionStore) PruneDeliveries(ctx context.Context, batchSize int) (int, error) {
+	pruneDeliveriesSQL := `
+		DELETE FROM guild_event_deliveries
+		WHERE id IN (
+			SELECT id FROM guild_event_deliveries
+			WHERE created_at < $1
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+	`
+
+	cutoff := time.Now().UTC().Add(-models.DeliveryRetention)
+	result, err := subscriptionStore.DB.ExecContext(ctx, pruneDeliveriesSQL, cutoff, batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted, err := result.RowsAffected()
+	return int(deleted), err
+}
@@ -7,6 +7,7 @@ import (
 	"mana/internal/models"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 type UserStore struct {
@@ -18,12 +19,16 @@ func NewUserStore(db *sql.DB) *UserStore {
 }
 
 func (userStore *UserStore) InsertUser(ctx context.Context, user *models.User) error {
+	return insertUser(ctx, userStore.DB, user)
+}
+
+func insertUser(ctx context.Context, exec execer, user *models.User) error {
 	insertUserSQL := `
-		INSERT INTO users (id, username, email, password, activity_status, account_status, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO users (id, username, email, password, activity_status, account_status, dm_privacy, suppress_embeds, bot, created_at)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, $7, $8, $9, $10)
 	`
 
-	_, err := userStore.DB.ExecContext(
+	_, err := exec.ExecContext(
 		ctx,
 		insertUserSQL,
 		user.ID,
@@ -32,6 +37,9 @@ func (userStore *UserStore) InsertUser(ctx context.Context, user *models.User) e
 		user.Password,
 		user.ActivityStatus,
 		user.AccountStatus,
+		user.DMPrivacy,
+		user.SuppressEmbeds,
+		user.Bot,
 		user.CreatedAt,
 	)
 
@@ -40,7 +48,7 @@ func (userStore *UserStore) InsertUser(ctx context.Context, user *models.User) e
 
 func (userStore *UserStore) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
 	selectUserSQL := `
-		SELECT id, username, email, password, activity_status, account_status, created_at
+		SELECT id, username, COALESCE(email, ''), password, activity_status, account_status, dm_privacy, suppress_embeds, email_verified, bot, created_at
 		FROM users
 		WHERE email = $1
 	`
@@ -55,6 +63,10 @@ func (userStore *UserStore) GetUserByEmail(ctx context.Context, email string) (*
 		&user.Password,
 		&user.ActivityStatus,
 		&user.AccountStatus,
+		&user.DMPrivacy,
+		&user.SuppressEmbeds,
+		&user.EmailVerified,
+		&user.Bot,
 		&user.CreatedAt,
 	)
 
@@ -67,7 +79,7 @@ func (userStore *UserStore) GetUserByEmail(ctx context.Context, email string) (*
 
 func (userStore *UserStore) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
 	selectUserSQL := `
-		SELECT id, username, email, password, activity_status, account_status, created_at
+		SELECT id, username, COALESCE(email, ''), password, activity_status, account_status, dm_privacy, suppress_embeds, email_verified, bot, created_at
 		FROM users
 		WHERE username = $1
 	`
@@ -82,6 +94,10 @@ func (userStore *UserStore) GetUserByUsername(ctx context.Context, username stri
 		&user.Password,
 		&user.ActivityStatus,
 		&user.AccountStatus,
+		&user.DMPrivacy,
+		&user.SuppressEmbeds,
+		&user.EmailVerified,
+		&user.Bot,
 		&user.CreatedAt,
 	)
 
@@ -94,7 +110,7 @@ func (userStore *UserStore) GetUserByUsername(ctx context.Context, username stri
 
 func (userStore *UserStore) GetUserByID(ctx context.Context, ID uuid.UUID) (*models.User, error) {
 	selectUserSQL := `
-		SELECT id, username, email, password, activity_status, account_status, created_at
+		SELECT id, username, COALESCE(email, ''), password, activity_status, account_status, dm_privacy, suppress_embeds, email_verified, bot, created_at
 		FROM users
 		WHERE id = $1
 	`
@@ -109,6 +125,10 @@ func (userStore *UserStore) GetUserByID(ctx context.Context, ID uuid.UUID) (*mod
 		&user.Password,
 		&user.ActivityStatus,
 		&user.AccountStatus,
+		&user.DMPrivacy,
+		&user.SuppressEmbeds,
+		&user.EmailVerified,
+		&user.Bot,
 		&user.CreatedAt,
 	)
 
@@ -119,6 +139,17 @@ func (userStore *UserStore) GetUserByID(ctx context.Context, ID uuid.UUID) (*mod
 	return &user, err
 }
 
+// Usernames by user ID, IDs that don't exist are left out
+func (userStore *UserStore) GetUsernames(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]string, error) {
+	selectUsernamesSQL := `SELECT id, username FROM users WHERE id = ANY($1)`
+
+	rows, err := userStore.DB.QueryContext(ctx, selectUsernamesSQL, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	return scanNames(rows)
+}
+
 func (userStore *UserStore) CheckUserExistsByEmail(ctx context.Context, email string) (bool, error) {
 	selectUserEmailSQL := `SELECT EXISTS (SELECT 1 FROM users WHERE email = $1)`
 
@@ -154,3 +185,43 @@ func (userStore *UserStore) UpdateAccountStatus(ctx context.Context, id uuid.UUI
 	_, err := userStore.DB.ExecContext(ctx, updateAccountStatusSQL, status, id)
 	return err
 }
+
+func (userStore *UserStore) UpdateDMPrivacy(ctx context.Context, id uuid.UUID, privacy string) error {
+	updateDMPrivacySQL := `UPDATE users SET dm_privacy = $1 WHERE id = $2`
+
+	_, err := userStore.DB.ExecContext(ctx, updateDMPrivacySQL, privacy, id)
+	return err
+}
+
+func (userStore *UserStore) UpdateSuppressEmbeds(ctx context.Context, id uuid.UUID, suppress bool) error {
+	updateSuppressEmbedsSQL := `UPDATE users SET suppress_embeds = $1 WHERE id = $2`
+
+	_, err := userStore.DB.ExecContext(ctx, updateSuppressEmbedsSQL, suppress, id)
+	return err
+}
+
+// Deletes the account along with the bots of its applications, handing the
+// guilds it owns over first so they aren't lost with it
+func (userStore *UserStore) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	tx, err := userStore.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := handOverOwnedGuilds(ctx, tx, id); err != nil {
+		return err
+	}
+
+	deleteBotsSQL := `DELETE FROM users WHERE id IN (SELECT bot_id FROM applications WHERE owner_id = $1)`
+	if _, err := tx.ExecContext(ctx, deleteBotsSQL, id); err != nil {
+		return err
+	}
+
+	deleteUserSQL := `DELETE FROM users WHERE id = $1`
+	if _, err := tx.ExecContext(ctx, deleteUserSQL, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
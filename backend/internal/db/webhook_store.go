@@ -0,0 +1,141 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"mana/internal/models"
+
+	"github.com/google/uuid"
+)
+
+type WebhookStore struct {
+	DB *sql.DB
+}
+
+func NewWebhookStore(db *sql.DB) *WebhookStore {
+	return &WebhookStore{DB: db}
+}
+
+const webhookColumns = `id, guild_id, channel_id, name, avatar_url, token_hash, creator_id, created_at`
+
+func scanWebhook(row rowScanner) (*models.Webhook, error) {
+	var webhook models.Webhook
+	err := row.Scan(
+		&webhook.ID,
+		&webhook.GuildID,
+		&webhook.ChannelID,
+		&webhook.Name,
+		&webhook.AvatarURL,
+		&webhook.TokenHash,
+		&webhook.CreatorID,
+		&webhook.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+func (webhookStore *WebhookStore) CreateWebhook(ctx context.Context, webhook *models.Webhook) error {
+	insertWebhookSQL := `
+		INSERT INTO channel_webhooks (id, guild_id, channel_id, name, avatar_url, token_hash, creator_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+
+	_, err := webhookStore.DB.ExecContext(ctx, insertWebhookSQL,
+		webhook.ID,
+		webhook.GuildID,
+		webhook.ChannelID,
+		webhook.Name,
+		webhook.AvatarURL,
+		webhook.TokenHash,
+		webhook.CreatorID,
+		webhook.CreatedAt,
+	)
+	return err
+}
+
+func (webhookStore *WebhookStore) GetWebhookByID(ctx context.Context, webhookID uuid.UUID) (*models.Webhook, error) {
+	getWebhookSQL := `SELECT ` + webhookColumns + ` FROM channel_webhooks WHERE id = $1`
+
+	webhook, err := scanWebhook(webhookStore.DB.QueryRowContext(ctx, getWebhookSQL, webhookID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return webhook, err
+}
+
+func (webhookStore *WebhookStore) GetWebhooksForGuild(ctx context.Context, guildID uuid.UUID) ([]*models.Webhook, error) {
+	getWebhooksSQL := `
+		SELECT ` + webhookColumns + `
+		FROM channel_webhooks
+		WHERE guild_id = $1
+		ORDER BY created_at ASC
+	`
+
+	return webhookStore.queryWebhooks(ctx, getWebhooksSQL, guildID)
+}
+
+func (webhookStore *WebhookStore) GetWebhooksForChannel(ctx context.Context, channelID uuid.UUID) ([]*models.Webhook, error) {
+	getWebhooksSQL := `
+		SELECT ` + webhookColumns + `
+		FROM channel_webhooks
+		WHERE channel_id = $1
+		ORDER BY created_at ASC
+	`
+
+	return webhookStore.queryWebhooks(ctx, getWebhooksSQL, channelID)
+}
+
+func (webhookStore *WebhookStore) queryWebhooks(ctx context.Context, query string, args ...interface{}) ([]*models.Webhook, error) {
+	rows, err := webhookStore.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var webhooks []*models.Webhook
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+
+	return webhooks, rows.Err()
+}
+
+func (webhookStore *WebhookStore) CountWebhooksForChannel(ctx context.Context, channelID uuid.UUID) (int, error) {
+	countWebhooksSQL := `SELECT COUNT(*) FROM channel_webhooks WHERE channel_id = $1`
+
+	var count int
+	err := webhookStore.DB.QueryRowContext(ctx, countWebhooksSQL, channelID).Scan(&count)
+	return count, err
+}
+
+// Saves name, avatar and channel, the token only changes through UpdateToken
+func (webhookStore *WebhookStore) UpdateWebhook(ctx context.Context, webhook *models.Webhook) error {
+	updateWebhookSQL := `
+		UPDATE channel_webhooks
+		SET name = $1, avatar_url = $2, channel_id = $3
+		WHERE id = $4
+	`
+
+	_, err := webhookStore.DB.ExecContext(ctx, updateWebhookSQL, webhook.Name, webhook.AvatarURL, webhook.ChannelID, webhook.ID)
+	return err
+}
+
+// Swaps in a new token hash, the old token stops working straight away
+func (webhookStore *WebhookStore) UpdateToken(ctx context.Context, webhookID uuid.UUID, tokenHash string) error {
+	updateTokenSQL := `UPDATE channel_webhooks SET token_hash = $1 WHERE id = $2`
+	_, err := webhookStore.DB.ExecContext(ctx, updateTokenSQL, tokenHash, webhookID)
+	return err
+}
+
+// Messages it sent stay, keeping the name and avatar they were sent with
+func (webhookStore *WebhookStore) DeleteWebhook(ctx context.Context, webhookID uuid.UUID) error {
+	deleteWebhookSQL := `DELETE FROM channel_webhooks WHERE id = $1`
+	_, err := webhookStore.DB.ExecContext(ctx, deleteWebhookSQL, webhookID)
+	return err
+}
@@ -0,0 +1,80 @@
+package insights
+
+import (
+	"context"
+	"log"
+	"mana/internal/db"
+	"mana/internal/models"
+	"mana/internal/types"
+	"time"
+)
+
+const (
+	defaultSampleInterval = time.Minute
+	defaultRollupInterval = time.Hour
+)
+
+// Keeps the daily insights tables up to date. Gateway connections are
+// sampled often so the day's peak is close to the real one, the heavier
+// totals are recomputed less often.
+type Rollup struct {
+	Store *db.Store
+	Hub   types.HubInterface
+
+	SampleInterval time.Duration
+	RollupInterval time.Duration
+}
+
+func NewRollup(store *db.Store, hub types.HubInterface) *Rollup {
+	return &Rollup{
+		Store:          store,
+		Hub:            hub,
+		SampleInterval: defaultSampleInterval,
+		RollupInterval: defaultRollupInterval,
+	}
+}
+
+// Run samples and rolls up once straight away, then on their intervals
+// until ctx is done
+func (rollup *Rollup) Run(ctx context.Context) {
+	sampleTicker := time.NewTicker(rollup.SampleInterval)
+	defer sampleTicker.Stop()
+
+	rollupTicker := time.NewTicker(rollup.RollupInterval)
+	defer rollupTicker.Stop()
+
+	rollup.sample(ctx)
+	rollup.rollup(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sampleTicker.C:
+			rollup.sample(ctx)
+		case <-rollupTicker.C:
+			rollup.rollup(ctx)
+		}
+	}
+}
+
+func (rollup *Rollup) sample(ctx context.Context) {
+	counts := rollup.Hub.ConnectionCounts()
+	if err := rollup.Store.Insights.RecordConnectionPeaks(ctx, time.Now(), counts); err != nil {
+		log.Printf("Insights failed to record connection peaks: %v", err)
+	}
+}
+
+// Yesterday is redone too so activity from just before midnight isn't lost
+func (rollup *Rollup) rollup(ctx context.Context) {
+	today := models.InsightsDay(time.Now())
+
+	for _, day := range []time.Time{today.Add(-24 * time.Hour), today} {
+		if err := rollup.Store.Insights.RollupDay(ctx, day); err != nil {
+			log.Printf("Insights failed to roll up %s: %v", day.Format(models.InsightsDateLayout), err)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
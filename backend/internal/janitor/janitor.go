@@ -0,0 +1,247 @@
+package janitor
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"mana/internal/db"
+	"mana/internal/memberlist"
+	"mana/internal/models"
+	"mana/internal/types"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultInterval   = time.Hour
+	defaultBatchSize  = 500
+	defaultBatchPause = 100 * time.Millisecond
+
+	// a guild takes all its channels, messages and members with it
+	guildPurgeBatchSize = 10
+)
+
+// Periodically removes messages that have outlived their channel's
+// retention policy, audit log entries past their guild's audit retention,
+// old event deliveries, temporary members who are gone and guilds whose
+// deletion came due. Deletes run in small batches so no table is locked
+// for long.
+type Janitor struct {
+	Store       *db.Store
+	Hub         types.HubInterface
+	MemberLists *memberlist.MemberLists
+
+	Interval   time.Duration
+	BatchSize  int
+	BatchPause time.Duration // gives other writers room between batches
+}
+
+func NewJanitor(store *db.Store, hub types.HubInterface, memberLists *memberlist.MemberLists) *Janitor {
+	return &Janitor{
+		Store:       store,
+		Hub:         hub,
+		MemberLists: memberLists,
+		Interval:    defaultInterval,
+		BatchSize:   defaultBatchSize,
+		BatchPause:  defaultBatchPause,
+	}
+}
+
+// Run sweeps once straight away, then every Interval until ctx is done
+func (janitor *Janitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(janitor.Interval)
+	defer ticker.Stop()
+
+	for {
+		janitor.sweep(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (janitor *Janitor) sweep(ctx context.Context) {
+	janitor.pruneMessages(ctx)
+	janitor.pruneAuditLog(ctx)
+	janitor.pruneDeliveries(ctx)
+	janitor.removeTemporaryMembers(ctx)
+	janitor.purgeGuilds(ctx)
+}
+
+func (janitor *Janitor) pruneMessages(ctx context.Context) {
+	retentions, err := janitor.Store.Retention.GetChannelsWithRetention(ctx)
+	if err != nil {
+		log.Printf("Janitor failed to list channels with retention: %v", err)
+		return
+	}
+
+	for _, retention := range retentions {
+		deleted, err := janitor.pruneChannel(ctx, retention)
+		if err != nil {
+			log.Printf("Janitor failed to prune channel %s: %v", retention.ChannelID, err)
+		}
+		if deleted > 0 {
+			log.Printf("Janitor pruned %d messages from channel %s", deleted, retention.ChannelID)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// Deletes a channel's expired messages batch by batch, returning how many went
+func (janitor *Janitor) pruneChannel(ctx context.Context, retention *models.ChannelRetention) (int, error) {
+	// fixed for the whole run so a busy channel can't keep us here forever
+	cutoff := retention.Policy.Cutoff(time.Now())
+	total := 0
+
+	for {
+		var ids []uuid.UUID
+		var err error
+
+		switch retention.Policy.Mode {
+		case models.RetentionModeDays:
+			ids, err = janitor.Store.Retention.PruneMessagesBefore(ctx, retention.ChannelID, cutoff, janitor.BatchSize)
+		case models.RetentionModeMessages:
+			ids, err = janitor.Store.Retention.PruneMessagesBeyondCount(ctx, retention.ChannelID, retention.Policy.Value, janitor.BatchSize)
+		default:
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+
+		if len(ids) > 0 {
+			total += len(ids)
+			janitor.dispatchDeleted(retention.ChannelID, ids)
+		}
+		if len(ids) < janitor.BatchSize {
+			return total, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return total, ctx.Err()
+		case <-time.After(janitor.BatchPause):
+		}
+	}
+}
+
+func (janitor *Janitor) pruneAuditLog(ctx context.Context) {
+	total := 0
+	defer func() {
+		if total > 0 {
+			log.Printf("Janitor pruned %d audit log entries", total)
+		}
+	}()
+
+	for {
+		deleted, err := janitor.Store.AuditLog.PruneExpiredEntries(ctx, janitor.BatchSize)
+		if err != nil {
+			log.Printf("Janitor failed to prune audit log: %v", err)
+			return
+		}
+
+		total += deleted
+		if deleted < janitor.BatchSize {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(janitor.BatchPause):
+		}
+	}
+}
+
+func (janitor *Janitor) pruneDeliveries(ctx context.Context) {
+	total := 0
+	defer func() {
+		if total > 0 {
+			log.Printf("Janitor pruned %d event deliveries", total)
+		}
+	}()
+
+	for {
+		deleted, err := janitor.Store.Subscriptions.PruneDeliveries(ctx, janitor.BatchSize)
+		if err != nil {
+			log.Printf("Janitor failed to prune event deliveries: %v", err)
+			return
+		}
+
+		total += deleted
+		if deleted < janitor.BatchSize {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(janitor.BatchPause):
+		}
+	}
+}
+
+// Temporary members normally leave when their last connection closes, this
+// catches the ones that never connected or went offline while we were down
+func (janitor *Janitor) removeTemporaryMembers(ctx context.Context) {
+	online := make([]uuid.UUID, 0)
+	for userID := range janitor.Hub.OnlineUsers() {
+		online = append(online, userID)
+	}
+
+	joinedBefore := time.Now().UTC().Add(-models.TemporaryMemberGracePeriod)
+	guildIDs, err := janitor.Store.Guilds.RemoveStaleTemporaryMemberships(ctx, online, joinedBefore)
+	if err != nil {
+		log.Printf("Janitor failed to remove temporary members: %v", err)
+		return
+	}
+
+	for _, guildID := range guildIDs {
+		janitor.MemberLists.Invalidate(guildID)
+	}
+	if len(guildIDs) > 0 {
+		log.Printf("Janitor removed temporary members from %d guilds", len(guildIDs))
+	}
+}
+
+func (janitor *Janitor) purgeGuilds(ctx context.Context) {
+	for {
+		ids, err := janitor.Store.Guilds.PurgeDeletedGuilds(ctx, guildPurgeBatchSize)
+		if err != nil {
+			log.Printf("Janitor failed to purge deleted guilds: %v", err)
+			return
+		}
+
+		for _, id := range ids {
+			log.Printf("Janitor purged deleted guild %s", id)
+		}
+		if len(ids) < guildPurgeBatchSize {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(janitor.BatchPause):
+		}
+	}
+}
+
+func (janitor *Janitor) dispatchDeleted(channelID uuid.UUID, ids []uuid.UUID) {
+	data, err := json.Marshal(types.MessageDeleteBulkPayload{IDs: ids, ChannelID: channelID})
+	if err != nil {
+		return
+	}
+
+	janitor.Hub.BroadcastMessage(types.Event{
+		Type:      types.EventMessageDeleteBulk,
+		ChannelID: channelID,
+		Data:      data,
+	})
+}
@@ -0,0 +1,91 @@
+// Package markdown parses the Mana markdown dialect into a sanitized AST
+// that clients can render without interpreting raw content themselves.
+package markdown
+
+import "errors"
+
+const (
+	MaxContentLength  = 4000 // raw characters, markup included
+	MaxRenderedLength = 2000 // visible characters once markup is stripped
+	MaxNodes          = 1000
+	maxDepth          = 16
+)
+
+var (
+	ErrContentTooLong  = errors.New("markdown: content is too long")
+	ErrRenderedTooLong = errors.New("markdown: rendered message is too long")
+	ErrTooComplex      = errors.New("markdown: message has too much formatting")
+)
+
+type NodeType string
+
+const (
+	NodeText            NodeType = "text"
+	NodeBold            NodeType = "bold"
+	NodeItalic          NodeType = "italic"
+	NodeUnderline       NodeType = "underline"
+	NodeStrikethrough   NodeType = "strikethrough"
+	NodeSpoiler         NodeType = "spoiler"
+	NodeCode            NodeType = "code"
+	NodeCodeBlock       NodeType = "code_block"
+	NodeQuote           NodeType = "quote"
+	NodeUserMention     NodeType = "user_mention"
+	NodeRoleMention     NodeType = "role_mention"
+	NodeChannelMention  NodeType = "channel_mention"
+	NodeEveryoneMention NodeType = "everyone_mention"
+	NodeHereMention     NodeType = "here_mention"
+	NodeEmote           NodeType = "emote"
+)
+
+type Node struct {
+	Type     NodeType `json:"type"`
+	Content  string   `json:"content,omitempty"`  // text, code and code blocks
+	Language string   `json:"language,omitempty"` // code blocks only
+	ID       string   `json:"id,omitempty"`       // mentions and emotes
+	Name     string   `json:"name,omitempty"`     // emotes
+	Animated bool     `json:"animated,omitempty"` // emotes
+	Children []*Node  `json:"children,omitempty"`
+}
+
+// Parses and validates content, returning the AST
+func Parse(content string) ([]*Node, error) {
+	if len([]rune(content)) > MaxContentLength {
+		return nil, ErrContentTooLong
+	}
+
+	nodes := parseDocument(content)
+
+	if countNodes(nodes) > MaxNodes {
+		return nil, ErrTooComplex
+	}
+
+	if len([]rune(PlainText(nodes, nil))) > MaxRenderedLength {
+		return nil, ErrRenderedTooLong
+	}
+
+	return nodes, nil
+}
+
+// Collects the ids of every node of the given mention type
+func MentionIDs(nodes []*Node, nodeType NodeType) []string {
+	var ids []string
+	walk(nodes, func(node *Node) {
+		if node.Type == nodeType {
+			ids = append(ids, node.ID)
+		}
+	})
+	return ids
+}
+
+func countNodes(nodes []*Node) int {
+	count := 0
+	walk(nodes, func(*Node) { count++ })
+	return count
+}
+
+func walk(nodes []*Node, visit func(*Node)) {
+	for _, node := range nodes {
+		visit(node)
+		walk(node.Children, visit)
+	}
+}
@@ -0,0 +1,285 @@
+package markdown
+
+import (
+	"regexp"
+	"strings"
+)
+
+const uuidPattern = `[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`
+
+var (
+	userMentionRegex    = regexp.MustCompile(`^<@(` + uuidPattern + `)>`)
+	roleMentionRegex    = regexp.MustCompile(`^<@&(` + uuidPattern + `)>`)
+	channelMentionRegex = regexp.MustCompile(`^<#(` + uuidPattern + `)>`)
+	emoteRegex          = regexp.MustCompile(`^<(a?):([A-Za-z0-9_]{2,32}):(` + uuidPattern + `)>`)
+	languageRegex       = regexp.MustCompile(`^[A-Za-z0-9_+#.-]{1,32}$`)
+)
+
+// checked longest first so ** wins over *
+var inlineDelimiters = []struct {
+	marker   string
+	nodeType NodeType
+}{
+	{"**", NodeBold},
+	{"__", NodeUnderline},
+	{"~~", NodeStrikethrough},
+	{"||", NodeSpoiler},
+	{"*", NodeItalic},
+	{"_", NodeItalic},
+}
+
+// code blocks first, they swallow any markup inside them
+func parseDocument(content string) []*Node {
+	var nodes []*Node
+	rest := content
+
+	for rest != "" {
+		start := strings.Index(rest, "```")
+		if start == -1 {
+			break
+		}
+
+		end := strings.Index(rest[start+3:], "```")
+		if end == -1 {
+			break
+		}
+
+		nodes = append(nodes, parseBlocks(rest[:start])...)
+
+		language, code := splitLanguage(rest[start+3 : start+3+end])
+		nodes = append(nodes, &Node{Type: NodeCodeBlock, Language: language, Content: code})
+
+		rest = rest[start+3+end+3:]
+	}
+
+	nodes = append(nodes, parseBlocks(rest)...)
+	return mergeText(nodes)
+}
+
+// ```go\ncode``` has a language, ```code``` does not
+func splitLanguage(body string) (string, string) {
+	newline := strings.IndexByte(body, '\n')
+	if newline == -1 {
+		return "", body
+	}
+
+	language := strings.TrimSpace(body[:newline])
+	if language == "" {
+		return "", body[newline+1:]
+	}
+	if !languageRegex.MatchString(language) {
+		return "", body
+	}
+
+	return strings.ToLower(language), body[newline+1:]
+}
+
+// groups "> " lines into quotes, everything else is inline
+func parseBlocks(text string) []*Node {
+	if text == "" {
+		return nil
+	}
+
+	var nodes []*Node
+	var plain, quoted []string
+
+	flushPlain := func() {
+		if len(plain) > 0 {
+			nodes = append(nodes, parseInline(strings.Join(plain, "\n"), 0)...)
+			plain = nil
+		}
+	}
+	flushQuote := func() {
+		if len(quoted) > 0 {
+			nodes = append(nodes, &Node{Type: NodeQuote, Children: mergeText(parseInline(strings.Join(quoted, "\n"), 1))})
+			quoted = nil
+		}
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		if quote, ok := strings.CutPrefix(line, "> "); ok {
+			if len(plain) > 0 {
+				// keep the line break that started the quote
+				plain = append(plain, "")
+			}
+			flushPlain()
+			quoted = append(quoted, quote)
+			continue
+		}
+
+		if len(quoted) > 0 {
+			flushQuote()
+			// keep the line break that ended the quote
+			plain = append(plain, "")
+		}
+
+		plain = append(plain, line)
+	}
+
+	flushQuote()
+	flushPlain()
+
+	return nodes
+}
+
+func parseInline(text string, depth int) []*Node {
+	var nodes []*Node
+	var buffer strings.Builder
+
+	flush := func() {
+		if buffer.Len() > 0 {
+			nodes = append(nodes, &Node{Type: NodeText, Content: buffer.String()})
+			buffer.Reset()
+		}
+	}
+
+	for i := 0; i < len(text); {
+		rest := text[i:]
+
+		// escapes
+		if rest[0] == '\\' && len(rest) > 1 && isMarkupChar(rest[1]) {
+			buffer.WriteByte(rest[1])
+			i += 2
+			continue
+		}
+
+		// inline code, no markup inside
+		if rest[0] == '`' {
+			if end := strings.IndexByte(rest[1:], '`'); end > 0 {
+				flush()
+				nodes = append(nodes, &Node{Type: NodeCode, Content: rest[1 : end+1]})
+				i += end + 2
+				continue
+			}
+		}
+
+		if rest[0] == '<' {
+			if node, length := parseToken(rest); node != nil {
+				flush()
+				nodes = append(nodes, node)
+				i += length
+				continue
+			}
+		}
+
+		if rest[0] == '@' {
+			if strings.HasPrefix(rest, "@everyone") {
+				flush()
+				nodes = append(nodes, &Node{Type: NodeEveryoneMention})
+				i += len("@everyone")
+				continue
+			}
+			if strings.HasPrefix(rest, "@here") {
+				flush()
+				nodes = append(nodes, &Node{Type: NodeHereMention})
+				i += len("@here")
+				continue
+			}
+		}
+
+		// snake_case_names aren't italics
+		if rest[0] == '_' && !strings.HasPrefix(rest, "__") && i > 0 && isWordChar(text[i-1]) {
+			buffer.WriteByte(rest[0])
+			i++
+			continue
+		}
+
+		if depth < maxDepth {
+			if node, length := parseDelimited(rest, depth); node != nil {
+				flush()
+				nodes = append(nodes, node)
+				i += length
+				continue
+			}
+		}
+
+		buffer.WriteByte(rest[0])
+		i++
+	}
+
+	flush()
+	return nodes
+}
+
+// mentions and emotes: <@id> <@&id> <#id> <:name:id> <a:name:id>
+func parseToken(text string) (*Node, int) {
+	if match := roleMentionRegex.FindStringSubmatch(text); match != nil {
+		return &Node{Type: NodeRoleMention, ID: strings.ToLower(match[1])}, len(match[0])
+	}
+	if match := userMentionRegex.FindStringSubmatch(text); match != nil {
+		return &Node{Type: NodeUserMention, ID: strings.ToLower(match[1])}, len(match[0])
+	}
+	if match := channelMentionRegex.FindStringSubmatch(text); match != nil {
+		return &Node{Type: NodeChannelMention, ID: strings.ToLower(match[1])}, len(match[0])
+	}
+	if match := emoteRegex.FindStringSubmatch(text); match != nil {
+		return &Node{Type: NodeEmote, Animated: match[1] == "a", Name: match[2], ID: strings.ToLower(match[3])}, len(match[0])
+	}
+	return nil, 0
+}
+
+func parseDelimited(text string, depth int) (*Node, int) {
+	for _, delimiter := range inlineDelimiters {
+		if !strings.HasPrefix(text, delimiter.marker) {
+			continue
+		}
+
+		size := len(delimiter.marker)
+		end := findClosing(text[size:], delimiter.marker)
+		if end <= 0 {
+			continue
+		}
+
+		inner := text[size : size+end]
+		return &Node{Type: delimiter.nodeType, Children: mergeText(parseInline(inner, depth+1))}, size + end + size
+	}
+	return nil, 0
+}
+
+// Finds the closing marker, skipping escapes and code spans. A single
+// character marker doesn't close on a doubled one, so *a **b** c* nests.
+func findClosing(text string, marker string) int {
+	for i := 0; i < len(text); i++ {
+		switch {
+		case text[i] == '\\':
+			i++
+		case text[i] == '`':
+			if end := strings.IndexByte(text[i+1:], '`'); end >= 0 {
+				i += end + 1
+			}
+		case strings.HasPrefix(text[i:], marker):
+			if len(marker) == 1 && i+1 < len(text) && text[i+1] == marker[0] {
+				// skip the whole doubled marker and its contents
+				if end := strings.Index(text[i+2:], marker+marker); end >= 0 {
+					i += end + 3
+				} else {
+					i++
+				}
+				continue
+			}
+			return i
+		}
+	}
+	return -1
+}
+
+func isMarkupChar(char byte) bool {
+	return strings.IndexByte("\\*_~|`<>@#:", char) >= 0
+}
+
+func isWordChar(char byte) bool {
+	return char == '_' || ('a' <= char && char <= 'z') || ('A' <= char && char <= 'Z') || ('0' <= char && char <= '9')
+}
+
+// joins neighbouring text nodes so the tree stays small
+func mergeText(nodes []*Node) []*Node {
+	var merged []*Node
+	for _, node := range nodes {
+		if node.Type == NodeText && len(merged) > 0 && merged[len(merged)-1].Type == NodeText {
+			merged[len(merged)-1].Content += node.Content
+			continue
+		}
+		merged = append(merged, node)
+	}
+	return merged
+}
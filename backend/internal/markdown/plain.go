@@ -0,0 +1,48 @@
+package markdown
+
+import "strings"
+
+// Looks up a display name for a mention or emote node, "" falls back to a generic label
+type NameResolver func(node *Node) string
+
+// Projects the AST to plain text for search and notifications.
+// Markup is dropped and spoilers are hidden.
+func PlainText(nodes []*Node, resolve NameResolver) string {
+	var builder strings.Builder
+	writePlain(&builder, nodes, resolve)
+	return builder.String()
+}
+
+func writePlain(builder *strings.Builder, nodes []*Node, resolve NameResolver) {
+	for _, node := range nodes {
+		switch node.Type {
+		case NodeText, NodeCode, NodeCodeBlock:
+			builder.WriteString(node.Content)
+		case NodeSpoiler:
+			builder.WriteString("[spoiler]")
+		case NodeUserMention:
+			builder.WriteString("@" + resolveName(node, resolve, "user"))
+		case NodeRoleMention:
+			builder.WriteString("@" + resolveName(node, resolve, "role"))
+		case NodeChannelMention:
+			builder.WriteString("#" + resolveName(node, resolve, "channel"))
+		case NodeEveryoneMention:
+			builder.WriteString("@everyone")
+		case NodeHereMention:
+			builder.WriteString("@here")
+		case NodeEmote:
+			builder.WriteString(":" + node.Name + ":")
+		default:
+			writePlain(builder, node.Children, resolve)
+		}
+	}
+}
+
+func resolveName(node *Node, resolve NameResolver, fallback string) string {
+	if resolve != nil {
+		if name := resolve(node); name != "" {
+			return name
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,223 @@
+package memberlist
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"mana/internal/db"
+	"mana/internal/models"
+	"mana/internal/types"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultFlushInterval = time.Second
+	buildTimeout         = 10 * time.Second
+)
+
+// Keeps clients' member list ranges up to date. Changes only mark the guild
+// dirty, each dirty guild's list is rebuilt once per FlushInterval and synced
+// to whoever follows it, so bursts of joins or presence changes in a large
+// guild cost one rebuild. New subscribers are served the last list built.
+type MemberLists struct {
+	Store *db.Store
+	Hub   types.HubInterface
+
+	FlushInterval time.Duration
+
+	mutex sync.Mutex
+	dirty map[uuid.UUID]bool
+	lists map[uuid.UUID]*models.MemberList // last built, only for guilds someone follows
+}
+
+func NewMemberLists(store *db.Store, hub types.HubInterface) *MemberLists {
+	return &MemberLists{
+		Store:         store,
+		Hub:           hub,
+		FlushInterval: defaultFlushInterval,
+		dirty:         make(map[uuid.UUID]bool),
+		lists:         make(map[uuid.UUID]*models.MemberList),
+	}
+}
+
+// Run resyncs dirty guilds every FlushInterval until ctx is done
+func (lists *MemberLists) Run(ctx context.Context) {
+	ticker := time.NewTicker(lists.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			lists.flush(ctx)
+		}
+	}
+}
+
+// Marks the guild's member list as changed, never blocks on the database
+func (lists *MemberLists) Invalidate(guildID uuid.UUID) {
+	lists.mutex.Lock()
+	defer lists.mutex.Unlock()
+
+	lists.dirty[guildID] = true
+}
+
+// Marks every guild the user is in, for when they come online, go offline
+// or leave for good. Call it before removing memberships, the lists are
+// rebuilt later and won't have the user anymore.
+func (lists *MemberLists) UserChanged(ctx context.Context, userID uuid.UUID) {
+	guilds, err := lists.Store.Guilds.GetGuildsForUserID(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to fetch guilds of %s for member lists: %v", userID, err)
+		return
+	}
+
+	for _, guild := range guilds {
+		lists.Invalidate(guild.ID)
+	}
+}
+
+// Follows the ranges of the member list of the guild the client's channel
+// belongs to, and sends them straight away. Invalid requests and DM
+// channels are ignored.
+func (lists *MemberLists) Subscribe(client *types.Client, ranges []types.MemberListRange) {
+	if len(ranges) == 0 || len(ranges) > models.MaxMemberListRanges {
+		return
+	}
+	for _, r := range ranges {
+		if r[0] < 0 || r[1] < r[0] || r[1]-r[0] >= models.MaxMemberListRangeSize {
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), buildTimeout)
+	defer cancel()
+
+	channel, err := lists.Store.GuildChannels.GetChannelByID(ctx, client.ChannelID)
+	if err != nil {
+		log.Printf("Failed to fetch channel %s for member list: %v", client.ChannelID, err)
+		return
+	}
+	if channel == nil {
+		return
+	}
+
+	if !lists.Hub.SetMemberListSubscription(client, channel.GuildID, ranges) {
+		return
+	}
+
+	list, err := lists.cached(ctx, channel.GuildID)
+	if err != nil {
+		log.Printf("Failed to build member list for guild %s: %v", channel.GuildID, err)
+		return
+	}
+
+	lists.send(client, channel.GuildID, list, ranges)
+}
+
+// The guild's last built list, built now if nobody followed it yet. A stale
+// list is fine, the next flush syncs the changes.
+func (lists *MemberLists) cached(ctx context.Context, guildID uuid.UUID) (*models.MemberList, error) {
+	lists.mutex.Lock()
+	list, ok := lists.lists[guildID]
+	lists.mutex.Unlock()
+	if ok {
+		return list, nil
+	}
+
+	list, err := lists.build(ctx, guildID)
+	if err != nil {
+		return nil, err
+	}
+
+	// a flush that finished meanwhile built a newer one
+	lists.mutex.Lock()
+	if current, ok := lists.lists[guildID]; ok {
+		list = current
+	} else {
+		lists.lists[guildID] = list
+	}
+	lists.mutex.Unlock()
+
+	return list, nil
+}
+
+func (lists *MemberLists) flush(ctx context.Context) {
+	lists.mutex.Lock()
+	dirty := lists.dirty
+	lists.dirty = make(map[uuid.UUID]bool)
+	lists.mutex.Unlock()
+
+	for guildID := range dirty {
+		if ctx.Err() != nil {
+			return
+		}
+		lists.resync(ctx, guildID)
+	}
+}
+
+func (lists *MemberLists) resync(ctx context.Context, guildID uuid.UUID) {
+	// the cached list is out of date either way
+	lists.mutex.Lock()
+	delete(lists.lists, guildID)
+	lists.mutex.Unlock()
+
+	subscribers := lists.Hub.MemberListSubscribers(guildID)
+	if len(subscribers) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, buildTimeout)
+	defer cancel()
+
+	list, err := lists.build(ctx, guildID)
+	if err != nil {
+		log.Printf("Failed to build member list for guild %s: %v", guildID, err)
+		return
+	}
+
+	lists.mutex.Lock()
+	lists.lists[guildID] = list
+	lists.mutex.Unlock()
+
+	for client, ranges := range subscribers {
+		lists.send(client, guildID, list, ranges)
+	}
+}
+
+func (lists *MemberLists) build(ctx context.Context, guildID uuid.UUID) (*models.MemberList, error) {
+	members, err := lists.Store.Guilds.GetGuildMembers(ctx, guildID)
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := lists.Store.GuildRoles.GetRolesForGuild(ctx, guildID)
+	if err != nil {
+		return nil, err
+	}
+
+	return models.BuildMemberList(members, roles, lists.Hub.OnlineUsers()), nil
+}
+
+func (lists *MemberLists) send(client *types.Client, guildID uuid.UUID, list *models.MemberList, ranges []types.MemberListRange) {
+	syncRanges := make([][2]int, len(ranges))
+	for i, r := range ranges {
+		syncRanges[i] = r
+	}
+
+	data, err := json.Marshal(list.Sync(guildID, syncRanges))
+	if err != nil {
+		log.Printf("Failed to marshal member list for guild %s: %v", guildID, err)
+		return
+	}
+
+	lists.Hub.SendToClient(client, types.Event{
+		Type:      types.EventMemberListUpdate,
+		ChannelID: client.ChannelID,
+		Data:      data,
+	})
+}
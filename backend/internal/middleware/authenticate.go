@@ -6,38 +6,72 @@ import (
 	"strings"
 
 	"mana/internal/auth"
+
+	"github.com/google/uuid"
 )
 
 // key type avoids collisions in context
 type contextKey string
 
-const UserIDKey contextKey = "userID"
+const (
+	UserIDKey contextKey = "userID"
+	BotKey    contextKey = "bot" // true when the request came in with a bot token
+)
+
+// Checks a bot token, returning the bot it belongs to. Tokens are looked up
+// in the store, which the middleware doesn't know about.
+type BotTokenValidator func(ctx context.Context, token string) (uuid.UUID, error)
+
+// Accepts either a login token as "Bearer <token>" or a bot token as
+// "Bot <token>"
+func Authenticate(validateBotToken BotTokenValidator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-func Authenticate(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authHeader := r.Header.Get("Authorization")
 
-		if strings.HasSuffix(r.URL.String(), "register") || strings.HasSuffix(r.URL.String(), "login") {
-			next.ServeHTTP(w, r)
-			return
-		}
+			if botToken, ok := strings.CutPrefix(authHeader, "Bot "); ok {
+				botID, err := validateBotToken(r.Context(), botToken)
+				if err != nil {
+					http.Error(w, `{ "error": "invalid bot token" }`, http.StatusUnauthorized)
+					return
+				}
 
-		authHeader := r.Header.Get("Authorization")
+				ctx := context.WithValue(r.Context(), UserIDKey, botID)
+				ctx = context.WithValue(ctx, BotKey, true)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
 
-		if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
-			http.Error(w, `{ "error": "missing or invalid authorization header" }`, http.StatusUnauthorized)
-			return
-		}
+			if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
+				http.Error(w, `{ "error": "missing or invalid authorization header" }`, http.StatusUnauthorized)
+				return
+			}
 
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+			tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 
-		userID, err := auth.ValidateToken(tokenString)
-		if err != nil {
-			http.Error(w, `{ "error": "invalid or expired token" }`, http.StatusUnauthorized)
-			return
-		}
+			userIDStr, err := auth.ValidateToken(tokenString)
+			if err != nil {
+				http.Error(w, `{ "error": "invalid or expired token" }`, http.StatusUnauthorized)
+				return
+			}
+
+			// handlers expect a uuid, not the raw claim
+			userID, err := uuid.Parse(userIDStr)
+			if err != nil {
+				http.Error(w, `{ "error": "invalid user id in token" }`, http.StatusUnauthorized)
+				return
+			}
+
+			// Store userID in context for downstream access
+			ctx := context.WithValue(r.Context(), UserIDKey, userID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
 
-		// Store userID in context for downstream access
-		ctx := context.WithValue(r.Context(), UserIDKey, userID)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+// Whether the request was made by a bot
+func IsBot(ctx context.Context) bool {
+	bot, _ := ctx.Value(BotKey).(bool)
+	return bot
 }
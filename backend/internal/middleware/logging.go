@@ -1,7 +1,10 @@
 package middleware
 
 import (
+	"bufio"
+	"errors"
 	"log"
+	"net"
 	"net/http"
 	"time"
 )
@@ -32,7 +35,17 @@ type loggingResponseWriter struct {
 	statusCode int
 }
 
-func (lrw *loggingResponseWriter) WriterHeader(code int) {
+func (lrw *loggingResponseWriter) WriteHeader(code int) {
 	lrw.statusCode = code
 	lrw.ResponseWriter.WriteHeader(code)
 }
+
+// the gateway upgrades its connection to a websocket through this
+func (lrw *loggingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := lrw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	lrw.statusCode = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Fixed window limiter, allows limit hits per key in each window. State is
+// in memory, so every server instance counts on its own.
+type RateLimiter struct {
+	limit  int
+	window time.Duration
+
+	mutex     sync.Mutex
+	windows   map[string]*rateWindow
+	lastSweep time.Time
+}
+
+type rateWindow struct {
+	start time.Time
+	hits  int
+}
+
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		limit:     limit,
+		window:    window,
+		windows:   make(map[string]*rateWindow),
+		lastSweep: time.Now(),
+	}
+}
+
+// Counts a hit for key, returning whether it's allowed and, when it isn't,
+// how long until the window resets
+func (limiter *RateLimiter) Allow(key string) (bool, time.Duration) {
+	now := time.Now()
+
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	// drop finished windows now and then so idle keys don't pile up
+	if now.Sub(limiter.lastSweep) > limiter.window*10 {
+		for k, w := range limiter.windows {
+			if now.Sub(w.start) >= limiter.window {
+				delete(limiter.windows, k)
+			}
+		}
+		limiter.lastSweep = now
+	}
+
+	w, ok := limiter.windows[key]
+	if !ok || now.Sub(w.start) >= limiter.window {
+		limiter.windows[key] = &rateWindow{start: now, hits: 1}
+		return true, 0
+	}
+
+	if w.hits >= limiter.limit {
+		return false, w.start.Add(limiter.window).Sub(now)
+	}
+
+	w.hits++
+	return true, 0
+}
+
+// Whether key is already over the limit, without counting a hit, and how
+// long until the window resets
+func (limiter *RateLimiter) Limited(key string) (bool, time.Duration) {
+	now := time.Now()
+
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	w, ok := limiter.windows[key]
+	if !ok || now.Sub(w.start) >= limiter.window || w.hits < limiter.limit {
+		return false, 0
+	}
+
+	return true, w.start.Add(limiter.window).Sub(now)
+}
+
+// Rejects requests over limiter's limit with a 429 and Retry-After, key picks
+// what the limit applies to
+func RateLimit(limiter *RateLimiter, key func(r *http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			allowed, retryAfter := limiter.Allow(key(r))
+			if !allowed {
+				TooManyRequests(w, retryAfter)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Writes a 429 telling the client when to retry
+func TooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	http.Error(w, "Too many requests", http.StatusTooManyRequests)
+}
+
+// The address the request came from, proxy headers aren't trusted
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,96 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	MaxApplicationsPerUser          = 25
+	MaxApplicationDescriptionLength = 400
+	botTokenBytes                   = 48
+)
+
+// A bot account and the user who owns it. The bot is a user of its own,
+// without an email or password, that signs in with the application's token.
+type Application struct {
+	ID          uuid.UUID `json:"id"`
+	OwnerID     uuid.UUID `json:"owner_id"`
+	BotID       uuid.UUID `json:"bot_id"`
+	Name        string    `json:"name"` // the bot's username
+	Description string    `json:"description,omitempty"`
+	Public      bool      `json:"public"` // anyone can add the bot to their guilds, not just the owner
+	HasToken    bool      `json:"has_token"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	// only the hash is stored, the token itself is shown once. Empty once
+	// the token is revoked.
+	TokenHash string `json:"-"`
+}
+
+// A bot's user, it has no password to log in with
+func NewBotUser(name string) *User {
+	user := NewUser(name, "", "")
+	user.Bot = true
+	return user
+}
+
+// Returns the application with a fresh token, which only the caller ever sees
+func NewApplication(ownerID uuid.UUID, bot *User, description string) (*Application, string) {
+	application := &Application{
+		ID:          uuid.New(),
+		OwnerID:     ownerID,
+		BotID:       bot.ID,
+		Name:        bot.Username,
+		Description: description,
+		HasToken:    true,
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	token := GenerateBotToken(bot.ID)
+	application.TokenHash = HashBotToken(token)
+
+	return application, token
+}
+
+// Tokens lead with the bot's id so they can be checked without searching
+// every application, the rest comes from crypto/rand. Unlike login tokens
+// they never expire, they're only ever revoked.
+func GenerateBotToken(botID uuid.UUID) string {
+	buf := make([]byte, botTokenBytes)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err) // crypto/rand never fails on supported platforms
+	}
+	return base64.RawURLEncoding.EncodeToString(botID[:]) + "." + base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// The bot a token claims to belong to, false if it isn't shaped like one
+func ParseBotToken(token string) (uuid.UUID, bool) {
+	encodedID, secret, found := strings.Cut(token, ".")
+	if !found || secret == "" {
+		return uuid.Nil, false
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(encodedID)
+	if err != nil {
+		return uuid.Nil, false
+	}
+
+	botID, err := uuid.FromBytes(raw)
+	if err != nil {
+		return uuid.Nil, false
+	}
+
+	return botID, true
+}
+
+func HashBotToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
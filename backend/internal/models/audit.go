@@ -0,0 +1,153 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	AuditGuildUpdate         = "guild_update"
+	AuditChannelCreate       = "channel_create"
+	AuditChannelUpdate       = "channel_update"
+	AuditChannelDelete       = "channel_delete"
+	AuditChannelSync         = "channel_permissions_sync"
+	AuditOverrideUpdate      = "channel_override_update"
+	AuditOverrideDelete      = "channel_override_delete"
+	AuditRoleCreate          = "role_create"
+	AuditRoleUpdate          = "role_update"
+	AuditRoleDelete          = "role_delete"
+	AuditMemberUpdate        = "member_update"
+	AuditMemberRoleAdd       = "member_role_add"
+	AuditMemberRoleRemove    = "member_role_remove"
+	AuditMemberKick          = "member_kick"
+	AuditMemberBan           = "member_ban"
+	AuditMemberUnban         = "member_unban"
+	AuditMemberTimeout       = "member_timeout"
+	AuditMemberTimeoutRemove = "member_timeout_remove"
+	AuditInviteCreate        = "invite_create"
+	AuditInviteRevoke        = "invite_revoke"
+	AuditMessageBulkDelete   = "message_bulk_delete"
+	AuditWebhookCreate       = "webhook_create"
+	AuditWebhookUpdate       = "webhook_update"
+	AuditWebhookDelete       = "webhook_delete"
+	AuditSubscriptionCreate  = "subscription_create"
+	AuditSubscriptionUpdate  = "subscription_update"
+	AuditSubscriptionDelete  = "subscription_delete"
+	AuditOnboardingUpdate    = "onboarding_update"
+	AuditAutomodRuleCreate   = "automod_rule_create"
+	AuditAutomodRuleUpdate   = "automod_rule_update"
+	AuditAutomodRuleDelete   = "automod_rule_delete"
+	AuditBotAdd              = "bot_add"
+
+	AuditTargetGuild        = "guild"
+	AuditTargetChannel      = "channel"
+	AuditTargetRole         = "role"
+	AuditTargetMember       = "member"
+	AuditTargetInvite       = "invite"
+	AuditTargetWebhook      = "webhook"
+	AuditTargetSubscription = "subscription"
+	AuditTargetAutomodRule  = "automod_rule"
+
+	DefaultAuditLogLimit      = 50
+	MaxAuditLogLimit          = 100
+	DefaultAuditRetentionDays = 90
+	MaxAuditRetentionDays     = 365 // 0 keeps entries forever
+	AuditLogReasonHeader      = "X-Audit-Log-Reason"
+	MaxAuditLogReasonLength   = MaxModerationReasonLength
+)
+
+// One field that a change touched, Old is unset for creates and New for deletes
+type AuditChange struct {
+	Key string          `json:"key"`
+	Old json.RawMessage `json:"old,omitempty"`
+	New json.RawMessage `json:"new,omitempty"`
+}
+
+// An append-only record of a change made to a guild
+type AuditLogEntry struct {
+	ID         uuid.UUID     `json:"id"`
+	GuildID    uuid.UUID     `json:"guild_id"`
+	ActorID    *uuid.UUID    `json:"actor_id,omitempty"` // nil once the actor's account is gone
+	Action     string        `json:"action"`
+	TargetType string        `json:"target_type"`
+	TargetID   string        `json:"target_id,omitempty"`  // invites are targeted by code
+	ChannelID  *uuid.UUID    `json:"channel_id,omitempty"` // where it happened, for overrides and messages
+	Changes    []AuditChange `json:"changes,omitempty"`
+	Reason     string        `json:"reason,omitempty"`
+	CreatedAt  time.Time     `json:"created_at"`
+}
+
+// Narrows down an audit log listing, zero values match everything
+type AuditLogFilter struct {
+	ActorID  *uuid.UUID
+	Action   string
+	TargetID string
+	Before   *uuid.UUID // entry id to page back from
+	Limit    int
+}
+
+func NewAuditLogEntry(guildID uuid.UUID, actorID uuid.UUID, action string, targetType string, targetID string) *AuditLogEntry {
+	return &AuditLogEntry{
+		ID:         uuid.New(),
+		GuildID:    guildID,
+		ActorID:    &actorID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		CreatedAt:  time.Now().UTC(),
+	}
+}
+
+// Compares the JSON forms of before and after field by field. Either can be
+// nil, for creates and deletes.
+func DiffAuditChanges(before any, after any) []AuditChange {
+	oldFields := auditFields(before)
+	newFields := auditFields(after)
+
+	keys := make([]string, 0, len(oldFields)+len(newFields))
+	for key := range oldFields {
+		keys = append(keys, key)
+	}
+	for key := range newFields {
+		if _, ok := oldFields[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var changes []AuditChange
+	for _, key := range keys {
+		if bytes.Equal(oldFields[key], newFields[key]) {
+			continue
+		}
+		changes = append(changes, AuditChange{Key: key, Old: oldFields[key], New: newFields[key]})
+	}
+
+	return changes
+}
+
+// Marshals one value for an AuditChange made by hand
+func AuditValue(value any) json.RawMessage {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	return raw
+}
+
+func auditFields(value any) map[string]json.RawMessage {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil
+	}
+	return fields
+}
@@ -0,0 +1,152 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// What an automod rule looks for
+const (
+	AutomodTriggerKeyword     = "keyword"      // keywords and regex patterns
+	AutomodTriggerMentionSpam = "mention_spam" // too many user and role mentions in one message
+	AutomodTriggerLinks       = "links"        // links to domains outside the allow list or on the deny list
+	AutomodTriggerRepeated    = "repeated"     // the same message sent over and over
+	AutomodTriggerFlood       = "flood"        // too many messages in a short time
+)
+
+var AutomodTriggers = map[string]bool{
+	AutomodTriggerKeyword:     true,
+	AutomodTriggerMentionSpam: true,
+	AutomodTriggerLinks:       true,
+	AutomodTriggerRepeated:    true,
+	AutomodTriggerFlood:       true,
+}
+
+// What happens once a rule matches
+const (
+	AutomodActionBlock   = "block"   // the message is refused
+	AutomodActionDelete  = "delete"  // the message, and the burst it belongs to, is deleted after it's sent
+	AutomodActionTimeout = "timeout" // the author is timed out for DurationSeconds
+	AutomodActionAlert   = "alert"   // a notice is posted in ChannelID
+)
+
+var AutomodActions = map[string]bool{
+	AutomodActionBlock:   true,
+	AutomodActionDelete:  true,
+	AutomodActionTimeout: true,
+	AutomodActionAlert:   true,
+}
+
+const (
+	AutomodLinksAllow = "allow" // only links to Domains are let through
+	AutomodLinksDeny  = "deny"  // links to Domains are caught
+
+	MaxAutomodRulesPerGuild      = 20
+	MaxAutomodRuleNameLength     = 100
+	MaxAutomodKeywords           = 1000
+	MaxAutomodKeywordLength      = 60
+	MaxAutomodRegexPatterns      = 10
+	MaxAutomodRegexLength        = 260
+	MaxAutomodDomains            = 100
+	MaxAutomodDomainLength       = 253
+	MaxAutomodMentionLimit       = 50
+	MaxAutomodMessages           = 50
+	MaxAutomodWindow             = 5 * time.Minute
+	MaxAutomodExemptRoles        = 20
+	MaxAutomodExemptChannels     = 50
+	MaxAutomodAlertExcerptLength = 200
+)
+
+// A per-guild rule checked against every message members send or edit
+type AutomodRule struct {
+	ID               uuid.UUID       `json:"id"`
+	GuildID          uuid.UUID       `json:"guild_id"`
+	Name             string          `json:"name"`
+	Enabled          bool            `json:"enabled"`
+	Trigger          string          `json:"trigger"`
+	Config           AutomodConfig   `json:"config"`
+	Actions          []AutomodAction `json:"actions"`
+	ExemptRoleIDs    []uuid.UUID     `json:"exempt_role_ids"`
+	ExemptChannelIDs []uuid.UUID     `json:"exempt_channel_ids"`   // categories exempt the channels in them
+	CreatorID        *uuid.UUID      `json:"creator_id,omitempty"` // nil once the creator's account is gone
+	CreatedAt        time.Time       `json:"created_at"`
+}
+
+// Only the fields of the rule's trigger are used, the rest stay empty
+type AutomodConfig struct {
+	// keyword. Keywords match whole words case insensitively, a leading or
+	// trailing * lets them match inside longer words.
+	Keywords      []string `json:"keywords,omitempty"`
+	RegexPatterns []string `json:"regex_patterns,omitempty"`
+
+	// mention_spam, distinct users and roles mentioned, @everyone and @here count as one each
+	MentionLimit int `json:"mention_limit,omitempty"`
+
+	// links, subdomains of a listed domain are covered by it
+	LinkMode string   `json:"link_mode,omitempty"`
+	Domains  []string `json:"domains,omitempty"`
+
+	// repeated and flood, more than MaxMessages within WindowSeconds matches
+	MaxMessages   int `json:"max_messages,omitempty"`
+	WindowSeconds int `json:"window_seconds,omitempty"`
+}
+
+type AutomodAction struct {
+	Type            string     `json:"type"`
+	ChannelID       *uuid.UUID `json:"channel_id,omitempty"`       // alert
+	DurationSeconds int        `json:"duration_seconds,omitempty"` // timeout
+}
+
+func NewAutomodRule(guildID uuid.UUID, creatorID uuid.UUID, name string, trigger string) *AutomodRule {
+	return &AutomodRule{
+		ID:               uuid.New(),
+		GuildID:          guildID,
+		Name:             name,
+		Enabled:          true,
+		Trigger:          trigger,
+		Actions:          []AutomodAction{},
+		ExemptRoleIDs:    []uuid.UUID{},
+		ExemptChannelIDs: []uuid.UUID{},
+		CreatorID:        &creatorID,
+		CreatedAt:        time.Now().UTC(),
+	}
+}
+
+// Whether the rule skips messages sent in channel by a member holding roleIDs
+func (rule *AutomodRule) Exempt(channel *GuildChannel, roleIDs []uuid.UUID) bool {
+	for _, exemptID := range rule.ExemptChannelIDs {
+		if exemptID == channel.ID || (channel.ParentID != nil && exemptID == *channel.ParentID) {
+			return true
+		}
+	}
+
+	for _, exemptID := range rule.ExemptRoleIDs {
+		for _, roleID := range roleIDs {
+			if exemptID == roleID {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (rule *AutomodRule) Action(actionType string) *AutomodAction {
+	for i := range rule.Actions {
+		if rule.Actions[i].Type == actionType {
+			return &rule.Actions[i]
+		}
+	}
+	return nil
+}
+
+// Whether the trigger looks at the member's recent messages rather than
+// just the one being sent. Those aren't checked again on edits.
+func (rule *AutomodRule) TracksHistory() bool {
+	return rule.Trigger == AutomodTriggerRepeated || rule.Trigger == AutomodTriggerFlood
+}
+
+func (config *AutomodConfig) Window() time.Duration {
+	return time.Duration(config.WindowSeconds) * time.Second
+}
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Max participants in a group dm, owner included
+const MaxGroupDMRecipients = 10
+
+const (
+	ChannelTypeDM      ChannelType = "dm"
+	ChannelTypeGroupDM ChannelType = "group_dm"
+)
+
+// Who is allowed to open a dm with a user
+const (
+	DMPrivacyEveryone         = "everyone"
+	DMPrivacyGuildsAndFriends = "guilds_and_friends"
+)
+
+type DMChannel struct {
+	ID           uuid.UUID   `json:"id"`
+	Type         ChannelType `json:"type"`
+	Name         string      `json:"name,omitempty"`
+	OwnerID      *uuid.UUID  `json:"owner_id,omitempty"` // only for group dms
+	RecipientIDs []uuid.UUID `json:"recipient_ids"`
+	CreatedAt    time.Time   `json:"created_at"`
+}
+
+func NewDMChannel(userID uuid.UUID, recipientID uuid.UUID) *DMChannel {
+	return &DMChannel{
+		ID:           uuid.New(),
+		Type:         ChannelTypeDM,
+		RecipientIDs: []uuid.UUID{userID, recipientID},
+		CreatedAt:    time.Now().UTC(),
+	}
+}
+
+func NewGroupDMChannel(ownerID uuid.UUID, recipientIDs []uuid.UUID, name string) *DMChannel {
+	return &DMChannel{
+		ID:           uuid.New(),
+		Type:         ChannelTypeGroupDM,
+		Name:         name,
+		OwnerID:      &ownerID,
+		RecipientIDs: append([]uuid.UUID{ownerID}, recipientIDs...),
+		CreatedAt:    time.Now().UTC(),
+	}
+}
+
+func (channel *DMChannel) HasRecipient(userID uuid.UUID) bool {
+	for _, id := range channel.RecipientIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
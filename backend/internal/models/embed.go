@@ -0,0 +1,31 @@
+package models
+
+// Max links unfurled per message, also the most a webhook can send
+const MaxEmbedsPerMessage = 5
+
+// Limits on rich embeds sent by webhooks
+const (
+	MaxEmbedTitleLength       = 256
+	MaxEmbedDescriptionLength = 4096
+	MaxEmbedFieldLength       = 256 // site, provider and author names
+	MaxEmbedURLLength         = 2048
+)
+
+const (
+	EmbedTypeLink  = "link"
+	EmbedTypeImage = "image"
+	EmbedTypeVideo = "video"
+	EmbedTypeRich  = "rich"
+)
+
+// Metadata for a link, resolved from OpenGraph/oEmbed
+type Embed struct {
+	URL          string `json:"url"`
+	Type         string `json:"type"`
+	Title        string `json:"title,omitempty"`
+	Description  string `json:"description,omitempty"`
+	SiteName     string `json:"site_name,omitempty"`
+	ImageURL     string `json:"image_url,omitempty"`
+	ProviderName string `json:"provider_name,omitempty"`
+	AuthorName   string `json:"author_name,omitempty"`
+}
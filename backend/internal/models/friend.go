@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	FriendshipStatusPending  = "pending"
+	FriendshipStatusAccepted = "accepted"
+)
+
+type Friendship struct {
+	RequesterID uuid.UUID `json:"requester_id"`
+	AddresseeID uuid.UUID `json:"addressee_id"`
+	Status      string    `json:"status"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func NewFriendRequest(requesterID uuid.UUID, addresseeID uuid.UUID) *Friendship {
+	return &Friendship{
+		RequesterID: requesterID,
+		AddresseeID: addresseeID,
+		Status:      FriendshipStatusPending,
+		CreatedAt:   time.Now().UTC(),
+	}
+}
@@ -1,7 +1,6 @@
 package models
 
 import (
-	"math/rand"
 	"time"
 
 	"github.com/google/uuid"
@@ -10,25 +9,85 @@ import (
 const MaxChannels uint8 = 255
 const MaxRoles uint8 = 255 // 0 - 254 are free, 255 is default/'everyone' role
 
+const (
+	MaxChannelNameLength  = 100
+	MaxChannelTopicLength = 1024
+	MinVoiceBitrate       = 8000
+	MaxVoiceBitrate       = 96000
+	DefaultVoiceBitrate   = 64000
+	MaxVoiceUserLimit     = 99 // 0 is unlimited
+
+	MaxNicknameLength  = 32
+	MaxMemberBioLength = 190
+
+	DefaultMemberListLimit = 100
+	MaxMemberListLimit     = 1000
+	MemberSortJoinedAsc    = "joined_asc"
+	MemberSortJoinedDesc   = "joined_desc"
+
+	// how long a deleted guild lingers, and can be restored, before it's purged
+	GuildDeletionGracePeriod = 7 * 24 * time.Hour
+)
+
 type ChannelType string
 
 const (
-	ChannelTypeText  ChannelType = "text"
-	ChannelTypeVoice ChannelType = "voice"
+	ChannelTypeText     ChannelType = "text"
+	ChannelTypeVoice    ChannelType = "voice"
+	ChannelTypeCategory ChannelType = "category" // groups other channels, holds no messages
 )
 
 type Guild struct {
-	ID         uuid.UUID `json:"id"`
-	Name       string    `json:"name"`
-	OwnerID    uuid.UUID `json:"owner_id"`
-	InviteCode string    `json:"invite_code"`
-	CreatedAt  time.Time `json:"created_at"`
+	ID                 uuid.UUID       `json:"id"`
+	Name               string          `json:"name"`
+	OwnerID            *uuid.UUID      `json:"owner_id,omitempty"`   // nil while frozen, the owner left with nobody to take over
+	Retention          RetentionPolicy `json:"retention"`            // default for channels without their own
+	AuditRetentionDays int             `json:"audit_retention_days"` // 0 keeps audit log entries forever
+	DeletesAt          *time.Time      `json:"deletes_at,omitempty"` // when a pending deletion purges it
+	CreatedAt          time.Time       `json:"created_at"`
+
+	// settings, changed with the manage guild permission
+	Description           string            `json:"description,omitempty"`
+	IconURL               string            `json:"icon_url,omitempty"`
+	BannerURL             string            `json:"banner_url,omitempty"`
+	SystemChannelID       *uuid.UUID        `json:"system_channel_id,omitempty"` // where join messages go, nil turns them off
+	DefaultNotifications  string            `json:"default_notifications"`
+	ExplicitContentFilter bool              `json:"explicit_content_filter"`
+	VerificationLevel     VerificationLevel `json:"verification_level"`
+}
+
+func (guild *Guild) IsOwner(userID uuid.UUID) bool {
+	return guild.OwnerID != nil && *guild.OwnerID == userID
 }
 
 type GuildMember struct {
-	GuildID  uuid.UUID `json:"guild_id"`
-	UserID   uuid.UUID `json:"user_id"`
-	JoinedAt time.Time `json:"joined_at"`
+	GuildID      uuid.UUID  `json:"guild_id"`
+	UserID       uuid.UUID  `json:"user_id"`
+	Temporary    bool       `json:"temporary,omitempty"`     // joined through a temporary invite
+	Pending      bool       `json:"pending,omitempty"`       // hasn't passed member screening yet
+	TimeoutUntil *time.Time `json:"timeout_until,omitempty"` // can't talk, react or use voice until then
+	JoinedAt     time.Time  `json:"joined_at"`
+
+	// per guild overrides of the user's profile, empty falls back to it
+	Nickname  string `json:"nickname,omitempty"`
+	AvatarURL string `json:"avatar_url,omitempty"`
+	Bio       string `json:"bio,omitempty"`
+}
+
+// A member as the API shows them, with who they are and what roles they hold
+type GuildMemberProfile struct {
+	GuildMember
+	User  *PublicUser `json:"user"`
+	Roles []uuid.UUID `json:"roles"` // highest first, everyone is implied and left out
+}
+
+// Narrows down a member listing, zero values match everything
+type MemberFilter struct {
+	Query  string     // prefix of the username or nickname, case insensitive
+	RoleID *uuid.UUID // members holding the role
+	Sort   string     // MemberSortJoinedAsc or MemberSortJoinedDesc
+	After  *uuid.UUID // user id of the member to page on from, in sort order
+	Limit  int
 }
 
 type GuildRole struct {
@@ -39,6 +98,11 @@ type GuildRole struct {
 	Permissions uint64    `json:"permissions"`
 	CreatedAt   time.Time `json:"created_at"`
 	Color       string    `json:"color"`
+	Hoist       bool      `json:"hoist"` // members with it are listed apart from everyone else
+
+	// set on the role a bot gets when it's added to the guild; the role
+	// belongs to the bot and goes away with it
+	BotID *uuid.UUID `json:"bot_id,omitempty"`
 }
 
 type GuildMemberRole struct {
@@ -48,15 +112,20 @@ type GuildMemberRole struct {
 }
 
 type GuildChannel struct {
-	ID        uuid.UUID   `json:"id"`
-	GuildID   uuid.UUID   `json:"guild_id"`
-	Name      string      `json:"name"`
-	Type      ChannelType `json:"type"`
-	Position  uint8       `json:"position"`
-	Topic     string      `json:"topic,omitempty"`
-	Bitrate   *int        `json:"bitrate,omitempty"`
-	UserLimit *int        `json:"user_limit,omitempty"`
-	CreatedAt time.Time   `json:"created_at"`
+	ID        uuid.UUID        `json:"id"`
+	GuildID   uuid.UUID        `json:"guild_id"`
+	Name      string           `json:"name"`
+	Type      ChannelType      `json:"type"`
+	Position  uint8            `json:"position"`
+	Topic     string           `json:"topic,omitempty"`
+	Bitrate   *int             `json:"bitrate,omitempty"`
+	UserLimit *int             `json:"user_limit,omitempty"`
+	Retention *RetentionPolicy `json:"retention,omitempty"` // nil inherits the guild default
+	ParentID  *uuid.UUID       `json:"parent_id,omitempty"` // category the channel sits in
+	CreatedAt time.Time        `json:"created_at"`
+
+	// whether the overrides still match the category's, only set on listing
+	PermissionsSynced *bool `json:"permissions_synced,omitempty"`
 }
 
 type GuildChannelPermissionOverride struct {
@@ -67,23 +136,65 @@ type GuildChannelPermissionOverride struct {
 	Deny      uint64     `json:"deny"`
 }
 
+// Reports whether two channels have the same overrides, ignoring order
+func OverridesMatch(a []*GuildChannelPermissionOverride, b []*GuildChannelPermissionOverride) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	type target struct {
+		userID uuid.UUID
+		roleID uuid.UUID
+	}
+	key := func(override *GuildChannelPermissionOverride) target {
+		var t target
+		if override.UserID != nil {
+			t.userID = *override.UserID
+		}
+		if override.RoleID != nil {
+			t.roleID = *override.RoleID
+		}
+		return t
+	}
+
+	bits := make(map[target][2]uint64, len(a))
+	for _, override := range a {
+		bits[key(override)] = [2]uint64{override.Allow, override.Deny}
+	}
+	for _, override := range b {
+		if current, ok := bits[key(override)]; !ok || current != [2]uint64{override.Allow, override.Deny} {
+			return false
+		}
+	}
+	return true
+}
+
 type GuildCreateResult struct {
 	Guild          *Guild
-	InviteCode     string
+	Invite         *Invite // never expires, points at #general
 	EveryoneRole   *GuildRole
 	OwnerRole      *GuildRole
 	OwnerMember    *GuildMember
 	OwnerBinding   *GuildMemberRole
 	GeneralChannel *GuildChannel
+
+	// the rest of the structure when created from a template, which has no
+	// #general and might not have an invite
+	Roles     []*GuildRole
+	Channels  []*GuildChannel
+	Overrides []*GuildChannelPermissionOverride
 }
 
 func NewGuild(name string, ownerID uuid.UUID) *Guild {
 	return &Guild{
-		ID:         uuid.New(),
-		Name:       name,
-		OwnerID:    ownerID,
-		InviteCode: GenerateInviteCode(),
-		CreatedAt:  time.Now().UTC(),
+		ID:                 uuid.New(),
+		Name:               name,
+		OwnerID:            &ownerID,
+		Retention:          RetentionPolicy{Mode: RetentionModeForever},
+		AuditRetentionDays: DefaultAuditRetentionDays,
+		CreatedAt:          time.Now().UTC(),
+
+		DefaultNotifications: NotificationsAllMessages,
 	}
 }
 
@@ -148,10 +259,11 @@ func CreateGuild(name string, ownerID uuid.UUID) *GuildCreateResult {
 	member := NewGuildMember(guild.ID, ownerID)
 	generalChannel := NewGuildChannel(guild.ID, "general", ChannelTypeText, 0, "General channel", nil, nil)
 	memberRole := NewGuildMemberRole(guild.ID, ownerID, ownerRole.ID)
+	guild.SystemChannelID = &generalChannel.ID
 
 	return &GuildCreateResult{
 		Guild:          guild,
-		InviteCode:     guild.InviteCode,
+		Invite:         NewInvite(guild.ID, &generalChannel.ID, ownerID, 0, 0, false),
 		EveryoneRole:   everyoneRole,
 		OwnerRole:      ownerRole,
 		OwnerMember:    member,
@@ -159,16 +271,3 @@ func CreateGuild(name string, ownerID uuid.UUID) *GuildCreateResult {
 		GeneralChannel: generalChannel,
 	}
 }
-
-func GenerateInviteCode() string {
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-
-	const charset = "abcdefghijklmnopqrstuvwxyz0123456789"
-	codeBytes := make([]byte, 8)
-
-	for i := range codeBytes {
-		codeBytes[i] = charset[r.Intn(len(charset))]
-	}
-
-	return string(codeBytes)
-}
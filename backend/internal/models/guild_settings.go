@@ -0,0 +1,67 @@
+package models
+
+import (
+	"time"
+)
+
+const (
+	MaxGuildNameLength        = 100
+	MinGuildNameLength        = 2
+	MaxGuildDescriptionLength = 300
+
+	// what members get notified about unless they choose otherwise
+	NotificationsAllMessages  = "all_messages"
+	NotificationsOnlyMentions = "only_mentions"
+)
+
+// What a member without any role has to meet before they can send messages
+type VerificationLevel int
+
+const (
+	VerificationNone   VerificationLevel = 0
+	VerificationLow    VerificationLevel = 1 // verified email
+	VerificationMedium VerificationLevel = 2 // registered for VerificationAccountAge
+	VerificationHigh   VerificationLevel = 3 // and a member for VerificationMembershipAge
+
+	VerificationAccountAge    = 5 * time.Minute
+	VerificationMembershipAge = 10 * time.Minute
+)
+
+// What the verification level is checked against, see GuildStore.GetMemberStanding
+type MemberStanding struct {
+	EmailVerified    bool
+	AccountCreatedAt time.Time
+	JoinedAt         time.Time
+	HasRoles         bool // holds a role besides everyone that they didn't pick themselves
+}
+
+func (level VerificationLevel) Valid() bool {
+	return level >= VerificationNone && level <= VerificationHigh
+}
+
+// Nothing verifies emails yet, so guilds can't pick the level that needs one
+// or members without a role could never talk
+func (level VerificationLevel) NeedsVerifiedEmail() bool {
+	return level == VerificationLow
+}
+
+// Reports whether the member can talk under the level at now. Being given a
+// role by someone else skips verification altogether, picking one in
+// onboarding doesn't.
+func (level VerificationLevel) Satisfied(standing *MemberStanding, now time.Time) bool {
+	if standing.HasRoles {
+		return true
+	}
+
+	if level == VerificationLow && !standing.EmailVerified {
+		return false
+	}
+	if level >= VerificationMedium && now.Sub(standing.AccountCreatedAt) < VerificationAccountAge {
+		return false
+	}
+	if level >= VerificationHigh && now.Sub(standing.JoinedAt) < VerificationMembershipAge {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	MemberEventJoin  = "join"
+	MemberEventLeave = "leave"
+
+	InsightsDateLayout   = "2006-01-02"
+	DefaultInsightsRange = 30 * 24 * time.Hour
+	MaxInsightsRange     = 366 * 24 * time.Hour
+)
+
+// A guild's activity over one UTC day
+type GuildInsightsDay struct {
+	Day             string `json:"day"`
+	Messages        int    `json:"messages"`
+	ActiveMembers   int    `json:"active_members"` // sent at least one message
+	Joins           int    `json:"joins"`
+	Leaves          int    `json:"leaves"`
+	InviteUses      int    `json:"invite_uses"` // joins that came through an invite
+	PeakConnections int    `json:"peak_connections"`
+}
+
+// Messages sent in one channel over one UTC day
+type ChannelInsightsDay struct {
+	ChannelID uuid.UUID `json:"channel_id"`
+	Day       string    `json:"day"`
+	Messages  int       `json:"messages"`
+}
+
+// Start of the UTC day t falls on
+func InsightsDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
@@ -0,0 +1,101 @@
+package models
+
+import (
+	"crypto/rand"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	InviteCodeLength     = 10
+	MaxInviteAge         = 7 * 24 * time.Hour
+	DefaultInviteAge     = 24 * time.Hour
+	MaxInviteUses        = 100 // 0 is unlimited
+	MaxInvitesPerGuild   = 1000
+	inviteCodeCharset    = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	inviteCodeRejectOver = 256 - 256%len(inviteCodeCharset) // keeps the pick uniform
+
+	// how long a temporary member has to connect before the janitor counts
+	// them as gone
+	TemporaryMemberGracePeriod = 10 * time.Minute
+)
+
+type Invite struct {
+	Code      string     `json:"code"`
+	GuildID   uuid.UUID  `json:"guild_id"`
+	ChannelID *uuid.UUID `json:"channel_id,omitempty"` // where the invite lands new members
+	CreatorID *uuid.UUID `json:"creator_id,omitempty"` // nil once the creator's account is gone
+	MaxUses   int        `json:"max_uses"`
+	Uses      int        `json:"uses"`
+	Temporary bool       `json:"temporary"` // members leave again when they go offline without a role
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// What anyone with the code can see before joining
+type InvitePreview struct {
+	Code        string     `json:"code"`
+	GuildID     uuid.UUID  `json:"guild_id"`
+	GuildName   string     `json:"guild_name"`
+	ChannelName string     `json:"channel_name,omitempty"`
+	MemberCount int        `json:"member_count"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+// A maxAge of 0 never expires, a maxUses of 0 is unlimited
+func NewInvite(guildID uuid.UUID, channelID *uuid.UUID, creatorID uuid.UUID, maxAge time.Duration, maxUses int, temporary bool) *Invite {
+	now := time.Now().UTC()
+
+	invite := &Invite{
+		Code:      GenerateInviteCode(),
+		GuildID:   guildID,
+		ChannelID: channelID,
+		CreatorID: &creatorID,
+		MaxUses:   maxUses,
+		Temporary: temporary,
+		CreatedAt: now,
+	}
+
+	if maxAge > 0 {
+		expiresAt := now.Add(maxAge)
+		invite.ExpiresAt = &expiresAt
+	}
+
+	return invite
+}
+
+// Reports whether the invite can still be used to join
+func (invite *Invite) Usable(now time.Time) bool {
+	if invite.RevokedAt != nil {
+		return false
+	}
+	if invite.ExpiresAt != nil && !now.Before(*invite.ExpiresAt) {
+		return false
+	}
+	return invite.MaxUses == 0 || invite.Uses < invite.MaxUses
+}
+
+// Codes come from crypto/rand, they're the only thing keeping a guild private
+func GenerateInviteCode() string {
+	code := make([]byte, 0, InviteCodeLength)
+	buf := make([]byte, InviteCodeLength*2)
+
+	for len(code) < InviteCodeLength {
+		if _, err := rand.Read(buf); err != nil {
+			panic(err) // crypto/rand never fails on supported platforms
+		}
+		for _, b := range buf {
+			if int(b) >= inviteCodeRejectOver {
+				continue
+			}
+			code = append(code, inviteCodeCharset[int(b)%len(inviteCodeCharset)])
+			if len(code) == InviteCodeLength {
+				break
+			}
+		}
+	}
+
+	return string(code)
+}
@@ -0,0 +1,164 @@
+package models
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// groups after the hoisted roles
+	MemberListGroupOnline  = "online"
+	MemberListGroupOffline = "offline"
+
+	PresenceOnline  = "online"
+	PresenceOffline = "offline"
+
+	MemberListOpSync = "SYNC" // replaces the items in the range
+
+	MaxMemberListRanges    = 5
+	MaxMemberListRangeSize = 100 // items, headers included
+)
+
+// A group header, ID is the hoisted role's id or one of online/offline
+type MemberListGroup struct {
+	ID    string `json:"id"`
+	Count int    `json:"count"`
+}
+
+// Either a group header or a member under the last header
+type MemberListItem struct {
+	Group    *MemberListGroup    `json:"group,omitempty"`
+	Member   *GuildMemberProfile `json:"member,omitempty"`
+	Presence string              `json:"presence,omitempty"`
+}
+
+type MemberListOp struct {
+	Op    string           `json:"op"`
+	Range [2]int           `json:"range"`
+	Items []MemberListItem `json:"items"`
+}
+
+// Sent to clients following a guild's member list, only for the ranges they follow
+type MemberListUpdate struct {
+	GuildID     uuid.UUID         `json:"guild_id"`
+	MemberCount int               `json:"member_count"`
+	OnlineCount int               `json:"online_count"`
+	Groups      []MemberListGroup `json:"groups"`
+	Ops         []MemberListOp    `json:"ops"`
+}
+
+// A guild's whole member list, flattened the way clients show it
+type MemberList struct {
+	MemberCount int
+	OnlineCount int
+	Groups      []MemberListGroup
+	Items       []MemberListItem
+}
+
+// Lays members out under their highest hoisted role (by role position) while
+// online, then the rest of the online members, then everyone offline. Members
+// are sorted by display name inside each group, empty groups are left out.
+func BuildMemberList(members []*GuildMemberProfile, roles []*GuildRole, online map[uuid.UUID]bool) *MemberList {
+	hoisted := make(map[uuid.UUID]bool)
+	for _, role := range roles {
+		if role.Hoist {
+			hoisted[role.ID] = true
+		}
+	}
+
+	grouped := make(map[string][]*GuildMemberProfile)
+	list := &MemberList{MemberCount: len(members)}
+
+	for _, member := range members {
+		group := MemberListGroupOffline
+		if online[member.UserID] {
+			list.OnlineCount++
+			group = MemberListGroupOnline
+
+			// member roles come highest first
+			for _, roleID := range member.Roles {
+				if hoisted[roleID] {
+					group = roleID.String()
+					break
+				}
+			}
+		}
+		grouped[group] = append(grouped[group], member)
+	}
+
+	var order []string
+	for _, role := range roles {
+		if role.Hoist {
+			order = append(order, role.ID.String())
+		}
+	}
+	order = append(order, MemberListGroupOnline, MemberListGroupOffline)
+
+	for _, id := range order {
+		groupMembers := grouped[id]
+		if len(groupMembers) == 0 {
+			continue
+		}
+
+		sort.Slice(groupMembers, func(i, j int) bool {
+			a, b := strings.ToLower(groupMembers[i].DisplayName()), strings.ToLower(groupMembers[j].DisplayName())
+			if a != b {
+				return a < b
+			}
+			return groupMembers[i].UserID.String() < groupMembers[j].UserID.String()
+		})
+
+		group := MemberListGroup{ID: id, Count: len(groupMembers)}
+		list.Groups = append(list.Groups, group)
+		list.Items = append(list.Items, MemberListItem{Group: &group})
+
+		presence := PresenceOnline
+		if id == MemberListGroupOffline {
+			presence = PresenceOffline
+		}
+		for _, member := range groupMembers {
+			list.Items = append(list.Items, MemberListItem{Member: member, Presence: presence})
+		}
+	}
+
+	if list.Groups == nil {
+		list.Groups = []MemberListGroup{}
+	}
+
+	return list
+}
+
+// SYNC ops for the ranges, clamped to the list. Ranges past the end come
+// back empty so clients know to drop what they had there.
+func (list *MemberList) Sync(guildID uuid.UUID, ranges [][2]int) *MemberListUpdate {
+	update := &MemberListUpdate{
+		GuildID:     guildID,
+		MemberCount: list.MemberCount,
+		OnlineCount: list.OnlineCount,
+		Groups:      list.Groups,
+		Ops:         []MemberListOp{},
+	}
+
+	for _, r := range ranges {
+		items := []MemberListItem{}
+		if r[0] < len(list.Items) {
+			items = list.Items[r[0]:min(r[1]+1, len(list.Items))]
+		}
+		update.Ops = append(update.Ops, MemberListOp{Op: MemberListOpSync, Range: r, Items: items})
+	}
+
+	return update
+}
+
+// The nickname if set, the username otherwise
+func (member *GuildMemberProfile) DisplayName() string {
+	if member.Nickname != "" {
+		return member.Nickname
+	}
+	if member.User != nil {
+		return member.User.Username
+	}
+	return ""
+}
@@ -1,26 +1,106 @@
 package models
 
 import (
+	"mana/internal/markdown"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// Max messages removed by a single bulk delete
+const MaxBulkDeleteMessages = 100
+
+// Bytes of JSON accepted when sending a message, webhooks included
+const MaxMessageBodySize = 64 << 10
+
+type MessageType string
+
+const (
+	MessageTypeDefault MessageType = "default"
+
+	// posted in the guild's system channel, AuthorID is who joined
+	MessageTypeMemberJoin MessageType = "member_join"
+
+	// the guild's welcome message, posted in the welcome channel once the
+	// member is let in. AuthorID is the member.
+	MessageTypeMemberWelcome MessageType = "member_welcome"
+
+	// posted in the channel an automod rule alerts, AuthorID is who tripped
+	// the rule and Content describes what was caught
+	MessageTypeAutomodAlert MessageType = "automod_alert"
+)
+
 type Message struct {
-	ID        uuid.UUID `json:"id"`
-	ChannelID uuid.UUID `json:"channel_id"`
-	AuthorID  uuid.UUID `json:"author_id"`
-	Content   string    `json:"content"`
-	CreatedAt time.Time `json:"created_at"`
+	ID        uuid.UUID        `json:"id"`
+	ChannelID uuid.UUID        `json:"channel_id"`
+	Type      MessageType      `json:"type"`
+	AuthorID  *uuid.UUID       `json:"author_id"` // nil when a webhook sent it
+	WebhookID *uuid.UUID       `json:"webhook_id,omitempty"`
+	Bot       bool             `json:"bot,omitempty"` // the author is a bot account
+	Webhook   *WebhookAuthor   `json:"webhook,omitempty"`
+	Content   string           `json:"content"`
+	AST       []*markdown.Node `json:"ast,omitempty"`
+	Embeds    []Embed          `json:"embeds,omitempty"`
+	CreatedAt time.Time        `json:"created_at"`
+	EditedAt  *time.Time       `json:"edited_at,omitempty"`
+
+	// markup stripped, for search and notifications
+	PlainText string `json:"-"`
+
+	// true when ChannelID points at a dm channel instead of a guild channel
+	Direct bool `json:"-"`
 }
 
 func NewMessage(channelID uuid.UUID, authorID uuid.UUID, content string) *Message {
 	return &Message{
 		ID:        uuid.New(),
 		ChannelID: channelID,
-		AuthorID:  authorID,
+		Type:      MessageTypeDefault,
+		AuthorID:  &authorID,
 		Content:   content,
 		CreatedAt: time.Now().UTC(),
 	}
 }
 
+// A message the server posts about userID, clients render it from the type
+func NewSystemMessage(channelID uuid.UUID, userID uuid.UUID, messageType MessageType) *Message {
+	message := NewMessage(channelID, userID, "")
+	message.Type = messageType
+	message.AST = []*markdown.Node{}
+	return message
+}
+
+func NewDirectMessage(channelID uuid.UUID, authorID uuid.UUID, content string) *Message {
+	message := NewMessage(channelID, authorID, content)
+	message.Direct = true
+	return message
+}
+
+// The webhook's own name and avatar are used unless author overrides them
+func NewWebhookMessage(webhook *Webhook, author WebhookAuthor, content string) *Message {
+	if author.Name == "" {
+		author.Name = webhook.Name
+	}
+	if author.AvatarURL == "" {
+		author.AvatarURL = webhook.AvatarURL
+	}
+
+	return &Message{
+		ID:        uuid.New(),
+		ChannelID: webhook.ChannelID,
+		Type:      MessageTypeDefault,
+		WebhookID: &webhook.ID,
+		Webhook:   &author,
+		Content:   content,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// Narrows which messages a bulk delete removes, unset fields match everything
+type MessageFilter struct {
+	MessageIDs []uuid.UUID
+	AuthorID   *uuid.UUID
+	After      *time.Time
+	Before     *time.Time
+	Contains   string
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	ModerationActionMessageBulkDelete = "message_bulk_delete"
+	ModerationActionKick              = "member_kick"
+	ModerationActionBan               = "member_ban"
+	ModerationActionUnban             = "member_unban"
+	ModerationActionTimeout           = "member_timeout"
+	ModerationActionTimeoutRemove     = "member_timeout_remove"
+
+	MaxModerationReasonLength = 512
+	MaxBanPurgeWindow         = 7 * 24 * time.Hour
+	MaxTimeoutDuration        = 28 * 24 * time.Hour
+)
+
+// An entry in a guild's moderation history
+type ModerationAction struct {
+	ID           uuid.UUID       `json:"id"`
+	GuildID      uuid.UUID       `json:"guild_id"`
+	ModeratorID  *uuid.UUID      `json:"moderator_id,omitempty"` // nil when automod acted, or the moderator's account is gone
+	Action       string          `json:"action"`
+	TargetUserID *uuid.UUID      `json:"target_user_id,omitempty"`
+	ChannelID    *uuid.UUID      `json:"channel_id,omitempty"`
+	Reason       string          `json:"reason,omitempty"`
+	Metadata     json.RawMessage `json:"metadata,omitempty"`
+	CreatedAt    time.Time       `json:"created_at"`
+}
+
+func NewModerationAction(guildID uuid.UUID, moderatorID uuid.UUID, action string, reason string) *ModerationAction {
+	return &ModerationAction{
+		ID:          uuid.New(),
+		GuildID:     guildID,
+		ModeratorID: &moderatorID,
+		Action:      action,
+		Reason:      reason,
+		CreatedAt:   time.Now().UTC(),
+	}
+}
+
+// An action automod took on its own, there's no moderator behind it
+func NewAutomodAction(guildID uuid.UUID, action string, reason string) *ModerationAction {
+	return &ModerationAction{
+		ID:        uuid.New(),
+		GuildID:   guildID,
+		Action:    action,
+		Reason:    reason,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// Keeps a user out of a guild, invites can't get them back in
+type GuildBan struct {
+	GuildID     uuid.UUID  `json:"guild_id"`
+	UserID      uuid.UUID  `json:"user_id"`
+	ModeratorID *uuid.UUID `json:"moderator_id,omitempty"` // nil once the moderator's account is gone
+	Reason      string     `json:"reason,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+func NewGuildBan(guildID uuid.UUID, userID uuid.UUID, moderatorID uuid.UUID, reason string) *GuildBan {
+	return &GuildBan{
+		GuildID:     guildID,
+		UserID:      userID,
+		ModeratorID: &moderatorID,
+		Reason:      reason,
+		CreatedAt:   time.Now().UTC(),
+	}
+}
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	MaxOnboardingRulesLength       = 4000
+	MaxOnboardingPrompts           = 5
+	MaxOnboardingPromptOptions     = 10
+	MaxOnboardingTitleLength       = 100
+	MaxOnboardingOptionLabelLength = 50
+	MaxOnboardingOptionDescLength  = 100
+	MaxOnboardingOptionRoles       = 5
+	MaxWelcomeMessageLength        = 1000
+
+	// replaced with a mention of the new member in the welcome message
+	WelcomeMessageUserPlaceholder = "{user}"
+)
+
+// How new members are let into a guild. Without screening they're in
+// straight away, with it they're pending and hold no roles, not even
+// everyone, until they accept the rules.
+type GuildOnboarding struct {
+	GuildID          uuid.UUID          `json:"guild_id"`
+	ScreeningEnabled bool               `json:"screening_enabled"`
+	Rules            string             `json:"rules"`
+	Prompts          []OnboardingPrompt `json:"prompts"`
+	WelcomeChannelID *uuid.UUID         `json:"welcome_channel_id,omitempty"` // nil posts no welcome message
+	WelcomeMessage   string             `json:"welcome_message,omitempty"`
+	UpdatedAt        time.Time          `json:"updated_at"`
+}
+
+// A question new members answer by picking options, each option hands out roles
+type OnboardingPrompt struct {
+	ID           uuid.UUID          `json:"id"`
+	Title        string             `json:"title"`
+	SingleSelect bool               `json:"single_select"`
+	Required     bool               `json:"required"`
+	Options      []OnboardingOption `json:"options"`
+}
+
+type OnboardingOption struct {
+	ID          uuid.UUID   `json:"id"`
+	Label       string      `json:"label"`
+	Description string      `json:"description,omitempty"`
+	RoleIDs     []uuid.UUID `json:"role_ids"`
+}
+
+// Guilds that never set onboarding up let everyone in and ask nothing
+func NewGuildOnboarding(guildID uuid.UUID) *GuildOnboarding {
+	return &GuildOnboarding{
+		GuildID:   guildID,
+		Prompts:   []OnboardingPrompt{},
+		UpdatedAt: time.Now().UTC(),
+	}
+}
+
+// The welcome message for userID, empty if none is set up
+func (onboarding *GuildOnboarding) RenderWelcome(userID uuid.UUID) string {
+	if onboarding.WelcomeChannelID == nil {
+		return ""
+	}
+	return strings.ReplaceAll(onboarding.WelcomeMessage, WelcomeMessageUserPlaceholder, "<@"+userID.String()+">")
+}
+
+// Works out which onboarding roles a member's answers add and take away.
+// Answered prompts are settled in full: roles of the picked options are
+// added, roles of the other options are removed unless a picked option
+// also grants them. Prompts left unanswered aren't touched.
+func (onboarding *GuildOnboarding) ResolveRoles(responses map[uuid.UUID][]uuid.UUID) (add []uuid.UUID, remove []uuid.UUID) {
+	granted := make(map[uuid.UUID]bool)
+	offered := make(map[uuid.UUID]bool)
+
+	for _, prompt := range onboarding.Prompts {
+		picked, answered := responses[prompt.ID]
+		if !answered {
+			continue
+		}
+
+		pickedIDs := make(map[uuid.UUID]bool, len(picked))
+		for _, optionID := range picked {
+			pickedIDs[optionID] = true
+		}
+
+		for _, option := range prompt.Options {
+			for _, roleID := range option.RoleIDs {
+				if pickedIDs[option.ID] {
+					if !granted[roleID] {
+						add = append(add, roleID)
+					}
+					granted[roleID] = true
+				} else {
+					offered[roleID] = true
+				}
+			}
+		}
+	}
+
+	for _, prompt := range onboarding.Prompts {
+		for _, option := range prompt.Options {
+			for _, roleID := range option.RoleIDs {
+				if offered[roleID] && !granted[roleID] {
+					remove = append(remove, roleID)
+					offered[roleID] = false
+				}
+			}
+		}
+	}
+
+	return add, remove
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	RetentionModeForever  = "forever"
+	RetentionModeDays     = "days"
+	RetentionModeMessages = "messages"
+)
+
+const (
+	MaxRetentionDays     = 3650
+	MaxRetentionMessages = 1_000_000
+)
+
+// How long messages in a channel are kept before the janitor removes them
+type RetentionPolicy struct {
+	Mode  string `json:"mode"`
+	Value int    `json:"value,omitempty"` // days or message count, unused for forever
+}
+
+func (policy RetentionPolicy) Validate() error {
+	switch policy.Mode {
+	case RetentionModeForever:
+		if policy.Value != 0 {
+			return errors.New("value must be empty when keeping messages forever")
+		}
+	case RetentionModeDays:
+		if policy.Value < 1 || policy.Value > MaxRetentionDays {
+			return errors.New("days must be between 1 and 3650")
+		}
+	case RetentionModeMessages:
+		if policy.Value < 1 || policy.Value > MaxRetentionMessages {
+			return errors.New("message count must be between 1 and 1000000")
+		}
+	default:
+		return errors.New("mode must be one of: forever, days, messages")
+	}
+	return nil
+}
+
+// Messages created before this are expired under a days policy
+func (policy RetentionPolicy) Cutoff(now time.Time) time.Time {
+	return now.AddDate(0, 0, -policy.Value)
+}
+
+// The channel's own policy, or the guild default when it has none
+func EffectiveRetention(guild *Guild, channel *GuildChannel) RetentionPolicy {
+	if channel.Retention != nil {
+		return *channel.Retention
+	}
+	return guild.Retention
+}
+
+// A guild channel and the retention policy it resolves to
+type ChannelRetention struct {
+	ChannelID   uuid.UUID       `json:"channel_id"`
+	GuildID     uuid.UUID       `json:"guild_id"`
+	ChannelName string          `json:"channel_name"`
+	Policy      RetentionPolicy `json:"policy"`
+	Inherited   bool            `json:"inherited"` // policy comes from the guild default
+}
+
+// What the janitor would remove from a channel on its next run
+type RetentionReport struct {
+	ChannelRetention
+	MessagesToDelete int        `json:"messages_to_delete"`
+	OldestToDelete   *time.Time `json:"oldest_to_delete,omitempty"`
+	NewestToDelete   *time.Time `json:"newest_to_delete,omitempty"`
+}
@@ -0,0 +1,196 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Events a guild can subscribe a URL to
+const (
+	SubscriptionEventMessageCreate     = "MESSAGE_CREATE"
+	SubscriptionEventMessageUpdate     = "MESSAGE_UPDATE" // edited
+	SubscriptionEventMessageDelete     = "MESSAGE_DELETE"
+	SubscriptionEventMessageDeleteBulk = "MESSAGE_DELETE_BULK"
+	SubscriptionEventMemberJoin        = "MEMBER_JOIN"
+	SubscriptionEventMemberRemove      = "MEMBER_REMOVE" // kicked
+	SubscriptionEventMemberBan         = "MEMBER_BAN"
+	SubscriptionEventMemberUnban       = "MEMBER_UNBAN"
+	SubscriptionEventMemberUpdate      = "MEMBER_UPDATE" // nickname or profile changed
+	SubscriptionEventMemberRoleAdd     = "MEMBER_ROLE_ADD"
+	SubscriptionEventMemberRoleRemove  = "MEMBER_ROLE_REMOVE"
+	SubscriptionEventRoleCreate        = "ROLE_CREATE"
+	SubscriptionEventRoleUpdate        = "ROLE_UPDATE"
+	SubscriptionEventRoleDelete        = "ROLE_DELETE"
+	SubscriptionEventChannelCreate     = "CHANNEL_CREATE"
+	SubscriptionEventChannelUpdate     = "CHANNEL_UPDATE"
+	SubscriptionEventChannelDelete     = "CHANNEL_DELETE"
+
+	// sent on request to check a receiver works, whatever it's subscribed to
+	SubscriptionEventPing = "PING"
+)
+
+var SubscriptionEvents = map[string]bool{
+	SubscriptionEventMessageCreate:     true,
+	SubscriptionEventMessageUpdate:     true,
+	SubscriptionEventMessageDelete:     true,
+	SubscriptionEventMessageDeleteBulk: true,
+	SubscriptionEventMemberJoin:        true,
+	SubscriptionEventMemberRemove:      true,
+	SubscriptionEventMemberBan:         true,
+	SubscriptionEventMemberUnban:       true,
+	SubscriptionEventMemberUpdate:      true,
+	SubscriptionEventMemberRoleAdd:     true,
+	SubscriptionEventMemberRoleRemove:  true,
+	SubscriptionEventRoleCreate:        true,
+	SubscriptionEventRoleUpdate:        true,
+	SubscriptionEventRoleDelete:        true,
+	SubscriptionEventChannelCreate:     true,
+	SubscriptionEventChannelUpdate:     true,
+	SubscriptionEventChannelDelete:     true,
+}
+
+const (
+	DeliveryStatusPending   = "pending"
+	DeliveryStatusSucceeded = "succeeded"
+	DeliveryStatusFailed    = "failed" // gave up after MaxDeliveryAttempts
+
+	MaxSubscriptionsPerGuild = 10
+	MaxSubscriptionURLLength = 2048
+	subscriptionSecretBytes  = 32
+
+	MaxDeliveryAttempts    = 8
+	deliveryBackoffBase    = 15 * time.Second
+	deliveryBackoffMax     = time.Hour
+	DeliveryRetention      = 7 * 24 * time.Hour // finished deliveries are pruned after this
+	DefaultDeliveryLimit   = 50
+	MaxDeliveryLimit       = 100
+	MaxDeliveryErrorLength = 512
+
+	// failed attempts in a row, across deliveries, before a subscription is disabled
+	SubscriptionDisableAfterFailures = 20
+
+	SubscriptionSignatureHeader = "X-Mana-Signature"
+	SubscriptionTimestampHeader = "X-Mana-Timestamp"
+	SubscriptionEventHeader     = "X-Mana-Event"
+	SubscriptionDeliveryHeader  = "X-Mana-Delivery"
+)
+
+// A URL that gets POSTed the guild's events it's subscribed to
+type EventSubscription struct {
+	ID                  uuid.UUID  `json:"id"`
+	GuildID             uuid.UUID  `json:"guild_id"`
+	URL                 string     `json:"url"`
+	Events              []string   `json:"events"`
+	Enabled             bool       `json:"enabled"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	DisabledReason      string     `json:"disabled_reason,omitempty"` // set when failures turned it off
+	CreatorID           *uuid.UUID `json:"creator_id,omitempty"`      // nil once the creator's account is gone
+	CreatedAt           time.Time  `json:"created_at"`
+
+	// signs every delivery, only shown when created or rotated
+	Secret string `json:"-"`
+}
+
+// One event on its way to one subscription, kept as the delivery log
+type EventDelivery struct {
+	ID             uuid.UUID       `json:"id"`
+	SubscriptionID uuid.UUID       `json:"subscription_id"`
+	GuildID        uuid.UUID       `json:"guild_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"` // unset once finished
+	LastAttemptAt  *time.Time      `json:"last_attempt_at,omitempty"`
+	ResponseStatus *int            `json:"response_status,omitempty"`
+	Error          string          `json:"error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+}
+
+// A claimed delivery together with where it goes and how to sign it
+type PendingDelivery struct {
+	Delivery *EventDelivery
+	URL      string
+	Secret   string
+}
+
+// Narrows down a delivery log listing, zero values match everything
+type DeliveryFilter struct {
+	Status string
+	Before *uuid.UUID // delivery id to page back from
+	Limit  int
+}
+
+// The body POSTed to a subscription's URL
+type DeliveryEnvelope struct {
+	ID        uuid.UUID       `json:"id"` // the delivery, stays the same across retries
+	Type      string          `json:"type"`
+	GuildID   uuid.UUID       `json:"guild_id"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Returns the subscription with a fresh secret, which only the caller ever sees
+func NewEventSubscription(guildID uuid.UUID, creatorID uuid.UUID, url string, events []string) *EventSubscription {
+	return &EventSubscription{
+		ID:        uuid.New(),
+		GuildID:   guildID,
+		URL:       url,
+		Events:    events,
+		Enabled:   true,
+		CreatorID: &creatorID,
+		Secret:    GenerateSubscriptionSecret(),
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+func NewEventDelivery(subscriptionID uuid.UUID, guildID uuid.UUID, eventType string, payload json.RawMessage) *EventDelivery {
+	now := time.Now().UTC()
+	return &EventDelivery{
+		ID:             uuid.New(),
+		SubscriptionID: subscriptionID,
+		GuildID:        guildID,
+		EventType:      eventType,
+		Payload:        payload,
+		Status:         DeliveryStatusPending,
+		NextAttemptAt:  &now,
+		CreatedAt:      now,
+	}
+}
+
+// Secrets come from crypto/rand, anyone holding one can forge deliveries
+func GenerateSubscriptionSecret() string {
+	buf := make([]byte, subscriptionSecretBytes)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err) // crypto/rand never fails on supported platforms
+	}
+	return hex.EncodeToString(buf)
+}
+
+// Wait before the next attempt once attempts have failed, doubling each
+// time up to an hour
+func DeliveryBackoff(attempts int) time.Duration {
+	backoff := deliveryBackoffBase
+	for i := 1; i < attempts && backoff < deliveryBackoffMax; i++ {
+		backoff *= 2
+	}
+	return min(backoff, deliveryBackoffMax)
+}
+
+// "sha256=" and the hex HMAC-SHA256 of "<timestamp>.<body>" keyed with the
+// secret. Receivers recompute it, and reject old timestamps to stop replays.
+func SignDelivery(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,226 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	GuildTemplateVersion = 1
+	MaxGuildTemplateSize = 1 << 20 // bytes of JSON accepted on import
+
+	// roles and channels reference each other by template ids, 0 is always
+	// the everyone role
+	TemplateEveryoneRoleID = 0
+)
+
+// A guild's structure without its members, messages or invites, for setting
+// up new guilds the same way. Template ids only mean something inside the
+// template, every role and channel gets a fresh id when it's used.
+type GuildTemplate struct {
+	Version             int                    `json:"version"`
+	Name                string                 `json:"name"`
+	Settings            GuildTemplateSettings  `json:"settings"`
+	EveryonePermissions uint64                 `json:"everyone_permissions"`
+	Roles               []GuildTemplateRole    `json:"roles"`    // highest first, without the owner's role
+	Channels            []GuildTemplateChannel `json:"channels"` // in sidebar order
+	ExportedAt          time.Time              `json:"exported_at"`
+}
+
+type GuildTemplateSettings struct {
+	Retention             RetentionPolicy   `json:"retention"`
+	AuditRetentionDays    int               `json:"audit_retention_days"`
+	Description           string            `json:"description,omitempty"`
+	SystemChannelID       *int              `json:"system_channel_id,omitempty"` // template id of a text channel
+	DefaultNotifications  string            `json:"default_notifications,omitempty"`
+	ExplicitContentFilter bool              `json:"explicit_content_filter,omitempty"`
+	VerificationLevel     VerificationLevel `json:"verification_level,omitempty"`
+}
+
+type GuildTemplateRole struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Permissions uint64 `json:"permissions"`
+	Color       string `json:"color"`
+	Hoist       bool   `json:"hoist,omitempty"`
+}
+
+type GuildTemplateChannel struct {
+	ID        int                     `json:"id"`
+	Name      string                  `json:"name"`
+	Type      ChannelType             `json:"type"`
+	Topic     string                  `json:"topic,omitempty"`
+	Bitrate   *int                    `json:"bitrate,omitempty"`
+	UserLimit *int                    `json:"user_limit,omitempty"`
+	Retention *RetentionPolicy        `json:"retention,omitempty"`
+	ParentID  *int                    `json:"parent_id,omitempty"` // template id of the category
+	Overrides []GuildTemplateOverride `json:"overrides,omitempty"`
+}
+
+// Only role overrides are kept, members don't carry over
+type GuildTemplateOverride struct {
+	RoleID int    `json:"role_id"`
+	Allow  uint64 `json:"allow"`
+	Deny   uint64 `json:"deny"`
+}
+
+// Builds a template from a guild's roles, channels (in sidebar order) and
+// their overrides. The owner's role at position 0 is left out, whoever uses
+// the template gets a fresh one.
+func NewGuildTemplate(guild *Guild, roles []*GuildRole, channels []*GuildChannel, overrides map[uuid.UUID][]*GuildChannelPermissionOverride) *GuildTemplate {
+	template := &GuildTemplate{
+		Version: GuildTemplateVersion,
+		Name:    guild.Name,
+		Settings: GuildTemplateSettings{
+			Retention:             guild.Retention,
+			AuditRetentionDays:    guild.AuditRetentionDays,
+			Description:           guild.Description,
+			DefaultNotifications:  guild.DefaultNotifications,
+			ExplicitContentFilter: guild.ExplicitContentFilter,
+			VerificationLevel:     guild.VerificationLevel,
+		},
+		Roles:      []GuildTemplateRole{},
+		Channels:   []GuildTemplateChannel{},
+		ExportedAt: time.Now().UTC(),
+	}
+
+	roleIDs := make(map[uuid.UUID]int)
+	for _, role := range roles {
+		// bots bring their own when they're added
+		if role.BotID != nil {
+			continue
+		}
+
+		switch role.Position {
+		case 0:
+			continue
+		case MaxRoles:
+			template.EveryonePermissions = role.Permissions
+			roleIDs[role.ID] = TemplateEveryoneRoleID
+			continue
+		}
+
+		roleIDs[role.ID] = len(template.Roles) + 1
+		template.Roles = append(template.Roles, GuildTemplateRole{
+			ID:          roleIDs[role.ID],
+			Name:        role.Name,
+			Permissions: role.Permissions,
+			Color:       role.Color,
+			Hoist:       role.Hoist,
+		})
+	}
+
+	channelIDs := make(map[uuid.UUID]int)
+	for i, channel := range channels {
+		channelIDs[channel.ID] = i + 1
+	}
+	if guild.SystemChannelID != nil {
+		if systemChannelID, ok := channelIDs[*guild.SystemChannelID]; ok {
+			template.Settings.SystemChannelID = &systemChannelID
+		}
+	}
+
+	for _, channel := range channels {
+		entry := GuildTemplateChannel{
+			ID:        channelIDs[channel.ID],
+			Name:      channel.Name,
+			Type:      channel.Type,
+			Topic:     channel.Topic,
+			Bitrate:   channel.Bitrate,
+			UserLimit: channel.UserLimit,
+			Retention: channel.Retention,
+		}
+		if channel.ParentID != nil {
+			if parentID, ok := channelIDs[*channel.ParentID]; ok {
+				entry.ParentID = &parentID
+			}
+		}
+
+		for _, override := range overrides[channel.ID] {
+			if override.RoleID == nil {
+				continue
+			}
+			roleID, ok := roleIDs[*override.RoleID]
+			if !ok {
+				continue
+			}
+			entry.Overrides = append(entry.Overrides, GuildTemplateOverride{RoleID: roleID, Allow: override.Allow, Deny: override.Deny})
+		}
+
+		template.Channels = append(template.Channels, entry)
+	}
+
+	return template
+}
+
+// Lays the template out as a new guild owned by ownerID, remapping every
+// template id to a fresh one. The template has to be valid, see
+// api.validateGuildTemplate. The invite points at the first text channel,
+// there's none if the template has no text channels.
+func (template *GuildTemplate) Instantiate(name string, ownerID uuid.UUID) *GuildCreateResult {
+	guild := NewGuild(name, ownerID)
+	guild.Retention = template.Settings.Retention
+	guild.AuditRetentionDays = template.Settings.AuditRetentionDays
+	guild.Description = template.Settings.Description
+	guild.DefaultNotifications = template.Settings.DefaultNotifications
+	guild.ExplicitContentFilter = template.Settings.ExplicitContentFilter
+	guild.VerificationLevel = template.Settings.VerificationLevel
+
+	everyoneRole := newEveryoneRole(guild.ID)
+	everyoneRole.Permissions = template.EveryonePermissions
+	ownerRole := NewGuildRole(guild.ID, "Owner", 0, 0xFFFFFFFFFFFFFFFF, "#000000")
+
+	result := &GuildCreateResult{
+		Guild:        guild,
+		EveryoneRole: everyoneRole,
+		OwnerRole:    ownerRole,
+		OwnerMember:  NewGuildMember(guild.ID, ownerID),
+		OwnerBinding: NewGuildMemberRole(guild.ID, ownerID, ownerRole.ID),
+	}
+
+	roleIDs := map[int]uuid.UUID{TemplateEveryoneRoleID: everyoneRole.ID}
+	for i, entry := range template.Roles {
+		// right below the owner's role, in template order
+		role := NewGuildRole(guild.ID, entry.Name, uint8(i+1), entry.Permissions, entry.Color)
+		role.Hoist = entry.Hoist
+		roleIDs[entry.ID] = role.ID
+		result.Roles = append(result.Roles, role)
+	}
+
+	channelIDs := make(map[int]uuid.UUID)
+	for _, entry := range template.Channels {
+		channelIDs[entry.ID] = uuid.New()
+	}
+	if template.Settings.SystemChannelID != nil {
+		systemChannelID := channelIDs[*template.Settings.SystemChannelID]
+		guild.SystemChannelID = &systemChannelID
+	}
+
+	for i, entry := range template.Channels {
+		channel := NewGuildChannel(guild.ID, entry.Name, entry.Type, uint8(i), entry.Topic, entry.Bitrate, entry.UserLimit)
+		channel.ID = channelIDs[entry.ID]
+		channel.Retention = entry.Retention
+		if entry.ParentID != nil {
+			parentID := channelIDs[*entry.ParentID]
+			channel.ParentID = &parentID
+		}
+		result.Channels = append(result.Channels, channel)
+
+		for _, override := range entry.Overrides {
+			roleID := roleIDs[override.RoleID]
+			result.Overrides = append(result.Overrides, &GuildChannelPermissionOverride{
+				ChannelID: channel.ID,
+				RoleID:    &roleID,
+				Allow:     override.Allow,
+				Deny:      override.Deny,
+			})
+		}
+
+		if result.Invite == nil && channel.Type == ChannelTypeText {
+			result.Invite = NewInvite(guild.ID, &channel.ID, ownerID, 0, 0, false)
+		}
+	}
+
+	return result
+}
@@ -9,10 +9,14 @@ import (
 type User struct {
 	ID             uuid.UUID `json:"id"`                        // primary key, unique, UUIDv4
 	Username       string    `json:"username"`                  // unique
-	Email          string    `json:"email"`                     // unique
+	Email          string    `json:"email,omitempty"`           // unique, bots have none
 	Password       string    `json:"-"`                         // hashed, not over API
 	ActivityStatus string    `json:"activity_status,omitempty"` // "online", "offline", "away", etc.
 	AccountStatus  string    `json:"account_status,omitempty"`  // "active", "suspended", "banned"
+	DMPrivacy      string    `json:"dm_privacy,omitempty"`      // "everyone", "guilds_and_friends"
+	SuppressEmbeds bool      `json:"suppress_embeds"`           // don't unfurl links in this user's messages
+	EmailVerified  bool      `json:"email_verified"`            // checked by guild verification levels
+	Bot            bool      `json:"bot"`                       // an application's bot account
 	CreatedAt      time.Time `json:"created_at"`                // ISO timestamp
 }
 
@@ -24,6 +28,7 @@ func NewUser(username string, email string, hashedPassword string) *User {
 		Password:       hashedPassword,
 		ActivityStatus: "offline",
 		AccountStatus:  "active",
+		DMPrivacy:      DMPrivacyGuildsAndFriends,
 		CreatedAt:      time.Now().UTC(),
 	}
 }
@@ -41,6 +46,7 @@ type PublicUser struct {
 	ID             uuid.UUID `json:"id"`
 	Username       string    `json:"username"`
 	ActivityStatus string    `json:"activity_status,omitempty"`
+	Bot            bool      `json:"bot,omitempty"`
 }
 
 // Convert User to PublicUser
@@ -49,6 +55,7 @@ func (user *User) ToPublicUser() *PublicUser {
 		ID:             user.ID,
 		Username:       user.Username,
 		ActivityStatus: user.ActivityStatus,
+		Bot:            user.Bot,
 	}
 }
 
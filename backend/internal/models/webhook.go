@@ -0,0 +1,80 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	MaxWebhooksPerChannel  = 10
+	MaxWebhookNameLength   = 80
+	MaxWebhookAvatarLength = 2048
+	webhookTokenBytes      = 48
+
+	// per webhook, executions past this get a 429 until the window resets
+	WebhookRateLimit       = 5
+	WebhookRateLimitWindow = 2 * time.Second
+
+	// per client address, executions with an unknown webhook or the wrong
+	// token past this get a 429 until the window resets
+	WebhookFailureRateLimit       = 10
+	WebhookFailureRateLimitWindow = time.Minute
+)
+
+// Posts messages into a channel for anyone holding its token
+type Webhook struct {
+	ID        uuid.UUID  `json:"id"`
+	GuildID   uuid.UUID  `json:"guild_id"`
+	ChannelID uuid.UUID  `json:"channel_id"`
+	Name      string     `json:"name"`
+	AvatarURL string     `json:"avatar_url,omitempty"`
+	CreatorID *uuid.UUID `json:"creator_id,omitempty"` // nil once the creator's account is gone
+	CreatedAt time.Time  `json:"created_at"`
+
+	// only the hash is stored, the token itself is shown once
+	TokenHash string `json:"-"`
+}
+
+// Who a webhook message shows as sent by, the name and avatar can be
+// overridden per message and outlive the webhook itself
+type WebhookAuthor struct {
+	Name      string `json:"name"`
+	AvatarURL string `json:"avatar_url,omitempty"`
+}
+
+// Returns the webhook with a fresh token, which only the caller ever sees
+func NewWebhook(guildID uuid.UUID, channelID uuid.UUID, creatorID uuid.UUID, name string, avatarURL string) (*Webhook, string) {
+	webhook := &Webhook{
+		ID:        uuid.New(),
+		GuildID:   guildID,
+		ChannelID: channelID,
+		Name:      name,
+		AvatarURL: avatarURL,
+		CreatorID: &creatorID,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	token := GenerateWebhookToken()
+	webhook.TokenHash = HashWebhookToken(token)
+
+	return webhook, token
+}
+
+// Tokens come from crypto/rand, holding one is all it takes to post
+func GenerateWebhookToken() string {
+	buf := make([]byte, webhookTokenBytes)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err) // crypto/rand never fails on supported platforms
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func HashWebhookToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -11,12 +11,14 @@ const (
 	PermissionViewInsights   uint64 = 1 << 5
 	PermissionManageWebhooks uint64 = 1 << 6
 	PermissionManageGuild    uint64 = 1 << 7
+	PermissionCreateInvite   uint64 = 1 << 8
 
 	// General Member Permissions
-	PermissionChangeNickname uint64 = 1 << 10
-	PermissionKickMembers    uint64 = 1 << 11
-	PermissionBanMembers     uint64 = 1 << 12
-	PermissionTimeoutMembers uint64 = 1 << 13
+	PermissionChangeNickname  uint64 = 1 << 10
+	PermissionKickMembers     uint64 = 1 << 11
+	PermissionBanMembers      uint64 = 1 << 12
+	PermissionTimeoutMembers  uint64 = 1 << 13
+	PermissionManageNicknames uint64 = 1 << 14
 
 	// Text Channel Permissions
 	PermissionSendMessages       uint64 = 1 << 20
@@ -41,6 +43,48 @@ const (
 	PermissionAdministrator uint64 = 1 << 63
 )
 
+// What the everyone role starts with in a new guild
+const DefaultEveryonePermissions = PermissionViewChannels |
+	PermissionCreateInvite |
+	PermissionChangeNickname |
+	PermissionSendMessages |
+	PermissionEmbedLinks |
+	PermissionAttachFiles |
+	PermissionAddReaction |
+	PermissionUseExternalEmotes |
+	PermissionReadMessageHistory |
+	PermissionConnect |
+	PermissionSpeak |
+	PermissionVideo |
+	PermissionVoiceActivity
+
+// What a timed out member loses until their timeout ends
+const TimeoutDeniedPermissions = PermissionSendMessages |
+	PermissionAddReaction |
+	PermissionConnect |
+	PermissionSpeak |
+	PermissionVideo |
+	PermissionVoiceActivity
+
+// Strips what a timeout takes away, administrators can't be timed out
+func ApplyTimeout(current uint64) uint64 {
+	if HasPermission(current, PermissionAdministrator) {
+		return current
+	}
+	return current &^ TimeoutDeniedPermissions
+}
+
+// What a member loses until they meet the guild's verification level
+const UnverifiedDeniedPermissions = PermissionSendMessages
+
+// Strips what failing verification takes away, administrators are never held back
+func ApplyVerification(current uint64) uint64 {
+	if HasPermission(current, PermissionAdministrator) {
+		return current
+	}
+	return current &^ UnverifiedDeniedPermissions
+}
+
 func HasPermission(current uint64, check uint64) bool {
 	return current&check == check
 }
@@ -0,0 +1,117 @@
+package permissions
+
+import (
+	"mana/internal/models"
+
+	"github.com/google/uuid"
+)
+
+const (
+	OverrideStepEveryone = "everyone"
+	OverrideStepRoles    = "roles"
+	OverrideStepMember   = "member"
+)
+
+// What one of the member's roles adds to their base permissions
+type RoleContribution struct {
+	RoleID      uuid.UUID `json:"role_id"`
+	Name        string    `json:"name"`
+	Position    uint8     `json:"position"`
+	Permissions uint64    `json:"permissions"`
+}
+
+// One round of channel overrides and the permissions left after it
+type OverrideStep struct {
+	Step      string                                   `json:"step"`
+	Overrides []*models.GuildChannelPermissionOverride `json:"overrides"`
+	Allow     uint64                                   `json:"allow"`
+	Deny      uint64                                   `json:"deny"`
+	Result    uint64                                   `json:"result"`
+}
+
+// How a member's permissions in a channel came about
+type Explanation struct {
+	Owner           bool               `json:"owner"`
+	Roles           []RoleContribution `json:"roles"`
+	BasePermissions uint64             `json:"base_permissions"`
+	Administrator   bool               `json:"administrator"` // overrides are skipped
+	Steps           []OverrideStep     `json:"steps"`
+	TimedOut        bool               `json:"timed_out"`  // TimeoutDeniedPermissions were taken away
+	Unverified      bool               `json:"unverified"` // UnverifiedDeniedPermissions were taken away
+	Permissions     uint64             `json:"permissions"`
+}
+
+// Base permissions are the union of every role. Overrides are then applied
+// in three rounds, each one's deny before its allow: the everyone role's,
+// the rest of the member's roles combined, and finally the member's own.
+func explain(memberRoles []*models.GuildRole, channelOverrides []*models.GuildChannelPermissionOverride, userID uuid.UUID) *Explanation {
+	explanation := &Explanation{Roles: []RoleContribution{}, Steps: []OverrideStep{}}
+
+	roleIDs := make(map[uuid.UUID]bool, len(memberRoles))
+	var everyoneRoleID uuid.UUID
+	for _, role := range memberRoles {
+		explanation.Roles = append(explanation.Roles, RoleContribution{
+			RoleID:      role.ID,
+			Name:        role.Name,
+			Position:    role.Position,
+			Permissions: role.Permissions,
+		})
+		explanation.BasePermissions |= role.Permissions
+		roleIDs[role.ID] = true
+
+		if role.Position == models.MaxRoles {
+			everyoneRoleID = role.ID
+		}
+	}
+
+	// if admin, then can do whatever they want, ignore overrides
+	if HasPermission(explanation.BasePermissions, PermissionAdministrator) {
+		explanation.Administrator = true
+		explanation.Permissions = ^uint64(0)
+		return explanation
+	}
+
+	var everyone, roles, member []*models.GuildChannelPermissionOverride
+	for _, override := range channelOverrides {
+		switch {
+		case override.RoleID != nil && *override.RoleID == everyoneRoleID:
+			everyone = append(everyone, override)
+		case override.RoleID != nil && roleIDs[*override.RoleID]:
+			roles = append(roles, override)
+		case override.UserID != nil && *override.UserID == userID:
+			member = append(member, override)
+		}
+	}
+
+	perms := explanation.BasePermissions
+	for _, step := range []struct {
+		name      string
+		overrides []*models.GuildChannelPermissionOverride
+	}{
+		{OverrideStepEveryone, everyone},
+		{OverrideStepRoles, roles},
+		{OverrideStepMember, member},
+	} {
+		if len(step.overrides) == 0 {
+			continue
+		}
+
+		var allow, deny uint64
+		for _, override := range step.overrides {
+			allow |= override.Allow
+			deny |= override.Deny
+		}
+
+		perms = (perms &^ deny) | allow
+		explanation.Steps = append(explanation.Steps, OverrideStep{
+			Step:      step.name,
+			Overrides: step.overrides,
+			Allow:     allow,
+			Deny:      deny,
+			Result:    perms,
+		})
+	}
+
+	explanation.Permissions = perms
+	return explanation
+}
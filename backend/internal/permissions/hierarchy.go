@@ -0,0 +1,35 @@
+package permissions
+
+import "mana/internal/models"
+
+// Roles rank by Position, lower is higher. The highest position a member
+// holds decides which roles they can manage.
+func HighestPosition(roles []*models.GuildRole) uint8 {
+	highest := models.MaxRoles
+	for _, role := range roles {
+		if role.Position < highest {
+			highest = role.Position
+		}
+	}
+	return highest
+}
+
+// Only roles strictly below your own highest role can be managed
+func CanManageRole(highest uint8, role *models.GuildRole) bool {
+	return role.Position > highest
+}
+
+// You can't place a role at or above your own highest role
+func CanMoveRoleTo(highest uint8, position uint8) bool {
+	return position > highest
+}
+
+// Kicking, banning or timing out a member needs a higher role than theirs
+func CanModerate(highest uint8, targetHighest uint8) bool {
+	return targetHighest > highest
+}
+
+// Going from current to updated may only flip bits the actor has themselves
+func CanChangePermissions(actor uint64, current uint64, updated uint64) bool {
+	return (current^updated)&^actor == 0
+}
@@ -32,55 +32,28 @@ func ResolveBasePermissions(ctx context.Context, permissionStore PermissionStore
 }
 
 func ResolveChannelPermissions(ctx context.Context, permissionStore PermissionStore, guildID uuid.UUID, channelID uuid.UUID, userID uuid.UUID) (uint64, error) {
-	// Get our users base permissions based on role
-	basePermissions, err := ResolveBasePermissions(ctx, permissionStore, guildID, userID)
+	explanation, err := ExplainChannelPermissions(ctx, permissionStore, guildID, channelID, userID)
 	if err != nil {
-		return 0, nil
+		return 0, err
 	}
 
+	return explanation.Permissions, nil
+}
+
+// Resolves channel permissions like ResolveChannelPermissions, keeping every
+// step along the way
+func ExplainChannelPermissions(ctx context.Context, permissionStore PermissionStore, guildID uuid.UUID, channelID uuid.UUID, userID uuid.UUID) (*Explanation, error) {
 	// Get our users roles
 	memberRoles, err := permissionStore.GetRolesForMember(ctx, guildID, userID)
 	if err != nil {
-		return 0, err
-	}
-
-	// if admin, then can do whatever they want, ignore overrides
-	if HasPermission(basePermissions, PermissionAdministrator) {
-		return ^uint64(0), nil
+		return nil, err
 	}
 
 	// get channel overrides
 	channelOverrides, err := permissionStore.GetChannelOverrides(ctx, channelID)
 	if err != nil {
-		return 0, err
-	}
-
-	var allow, deny uint64
-
-	// role override
-	for _, override := range channelOverrides {
-		if override.RoleID != nil {
-			for _, role := range memberRoles {
-				if *override.RoleID == role.ID {
-					deny |= override.Deny
-					allow |= override.Allow
-					break
-				}
-			}
-		}
+		return nil, err
 	}
 
-	// User overrides
-	for _, override := range channelOverrides {
-		if override.UserID != nil && *override.UserID == userID {
-			deny |= override.Deny
-			allow |= override.Allow
-			break // Only one user override can exist
-		}
-	}
-
-	perms := basePermissions &^ deny
-	perms |= allow
-
-	return perms, nil
+	return explain(memberRoles, channelOverrides, userID), nil
 }
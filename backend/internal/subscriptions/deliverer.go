@@ -0,0 +1,199 @@
+package subscriptions
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mana/internal/db"
+	"mana/internal/models"
+	"mana/internal/unfurl"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPollInterval = 5 * time.Second
+	defaultBatchSize    = 50
+	defaultWorkers      = 8
+	defaultLease        = time.Minute
+	requestTimeout      = 10 * time.Second
+	maxResponseBody     = 4 << 10
+	userAgent           = "Mana-Webhooks/1.0"
+)
+
+// POSTs queued subscription events to their URLs. The queue lives in the
+// database, so nothing is lost across restarts, and claims are leased so
+// several servers can share it.
+type Deliverer struct {
+	Store  *db.Store
+	Client *http.Client
+
+	PollInterval time.Duration
+	BatchSize    int
+	Workers      int
+	Lease        time.Duration // longer than a delivery can take
+
+	notify chan struct{}
+}
+
+func NewDeliverer(store *db.Store) *Deliverer {
+	return &Deliverer{
+		Store: store,
+		Client: &http.Client{
+			// receivers are user supplied, internal addresses are off limits
+			Transport: unfurl.NewSafeTransport(requestTimeout, AllowLoopback()),
+			Timeout:   requestTimeout,
+			// a redirect could point the signed body anywhere, receivers get it as a failure
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		PollInterval: defaultPollInterval,
+		BatchSize:    defaultBatchSize,
+		Workers:      defaultWorkers,
+		Lease:        defaultLease,
+		notify:       make(chan struct{}, 1),
+	}
+}
+
+// Receivers on loopback are only allowed in development, where they run
+// next to the server
+func AllowLoopback() bool {
+	return os.Getenv("ENV") == "dev"
+}
+
+// Wakes the deliverer up early after new events were queued, never blocks
+func (deliverer *Deliverer) Notify() {
+	select {
+	case deliverer.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers what's due straight away, then every PollInterval or when
+// notified, until ctx is done
+func (deliverer *Deliverer) Run(ctx context.Context) {
+	ticker := time.NewTicker(deliverer.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		deliverer.deliverDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-deliverer.notify:
+		}
+	}
+}
+
+// Keeps claiming batches until nothing is due
+func (deliverer *Deliverer) deliverDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		pending, err := deliverer.Store.Subscriptions.ClaimDueDeliveries(ctx, deliverer.BatchSize, deliverer.Lease)
+		if err != nil {
+			log.Printf("Failed to claim event deliveries: %v", err)
+			return
+		}
+
+		var wg sync.WaitGroup
+		slots := make(chan struct{}, deliverer.Workers)
+		for _, claimed := range pending {
+			wg.Add(1)
+			slots <- struct{}{}
+			go func(claimed *models.PendingDelivery) {
+				defer wg.Done()
+				defer func() { <-slots }()
+				deliverer.deliver(ctx, claimed)
+			}(claimed)
+		}
+		wg.Wait()
+
+		if len(pending) < deliverer.BatchSize {
+			return
+		}
+	}
+}
+
+func (deliverer *Deliverer) deliver(ctx context.Context, claimed *models.PendingDelivery) {
+	delivery := claimed.Delivery
+
+	status, err := deliverer.post(ctx, claimed)
+	if err == nil {
+		if err := deliverer.Store.Subscriptions.MarkDelivered(ctx, delivery, status); err != nil {
+			log.Printf("Failed to record delivery %s: %v", delivery.ID, err)
+		}
+		return
+	}
+
+	var responseStatus *int
+	if status != 0 {
+		responseStatus = &status
+	}
+
+	disabled, markErr := deliverer.Store.Subscriptions.MarkDeliveryFailed(ctx, delivery, responseStatus, err.Error())
+	if markErr != nil {
+		log.Printf("Failed to record delivery %s: %v", delivery.ID, markErr)
+		return
+	}
+	if disabled {
+		log.Printf("Disabled event subscription %s after repeated delivery failures", delivery.SubscriptionID)
+	}
+}
+
+// Sends one attempt, returning the response status when there was one. Any
+// 2xx counts as delivered.
+func (deliverer *Deliverer) post(ctx context.Context, claimed *models.PendingDelivery) (int, error) {
+	delivery := claimed.Delivery
+
+	body, err := json.Marshal(models.DeliveryEnvelope{
+		ID:        delivery.ID,
+		Type:      delivery.EventType,
+		GuildID:   delivery.GuildID,
+		CreatedAt: delivery.CreatedAt,
+		Data:      delivery.Payload,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, claimed.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set(models.SubscriptionEventHeader, delivery.EventType)
+	req.Header.Set(models.SubscriptionDeliveryHeader, delivery.ID.String())
+	req.Header.Set(models.SubscriptionTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(models.SubscriptionSignatureHeader, models.SignDelivery(claimed.Secret, timestamp, body))
+
+	resp, err := deliverer.Client.Do(req)
+	if err != nil {
+		var urlErr interface{ Timeout() bool }
+		if errors.As(err, &urlErr) && urlErr.Timeout() {
+			return 0, errors.New("request timed out")
+		}
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// drained so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseBody))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver responded with %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
@@ -14,6 +14,14 @@ type Event struct {
 }
 
 const (
-	EventSendMessage    = "SEND_MESSAGE"
-	EventReceiveMessage = "RECEIVE_MESSAGE"
+	EventReceiveMessage    = "RECEIVE_MESSAGE"
+	EventMessageUpdate     = "MESSAGE_UPDATE"
+	EventMessageDelete     = "MESSAGE_DELETE"
+	EventMessageDeleteBulk = "MESSAGE_DELETE_BULK"
+
+	EventChannelRecipientAdd    = "CHANNEL_RECIPIENT_ADD"
+	EventChannelRecipientRemove = "CHANNEL_RECIPIENT_REMOVE"
+
+	EventMemberListSubscribe = "MEMBER_LIST_SUBSCRIBE"
+	EventMemberListUpdate    = "MEMBER_LIST_UPDATE"
 )
@@ -1,7 +1,16 @@
 package types
 
+import "github.com/google/uuid"
+
 type HubInterface interface {
 	BroadcastMessage(event Event)
 	UnregisterClient(client *Client)
 	RegisterClient(client *Client)
+	ConnectionCounts() map[uuid.UUID]int
+	OnlineUsers() map[uuid.UUID]bool
+
+	SubscribeMemberList(client *Client, ranges []MemberListRange)
+	SetMemberListSubscription(client *Client, guildID uuid.UUID, ranges []MemberListRange) bool
+	MemberListSubscribers(guildID uuid.UUID) map[*Client][]MemberListRange
+	SendToClient(client *Client, event Event)
 }
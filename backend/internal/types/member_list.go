@@ -0,0 +1,10 @@
+package types
+
+// First and last index of a slice of the member list, both inclusive
+type MemberListRange [2]int
+
+// Sent by a client to follow parts of its guild's member list, replacing
+// whatever it followed before
+type MemberListSubscribePayload struct {
+	Ranges []MemberListRange `json:"ranges"`
+}
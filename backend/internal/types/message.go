@@ -1,5 +1,13 @@
 package types
 
-type MessagePayload struct {
-	Content string `json:"content"`
+import "github.com/google/uuid"
+
+type MessageDeletePayload struct {
+	ID        uuid.UUID `json:"id"`
+	ChannelID uuid.UUID `json:"channel_id"`
+}
+
+type MessageDeleteBulkPayload struct {
+	IDs       []uuid.UUID `json:"ids"`
+	ChannelID uuid.UUID   `json:"channel_id"`
 }
@@ -0,0 +1,298 @@
+package unfurl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mana/internal/models"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultTimeout      = 5 * time.Second
+	defaultMaxBodyBytes = 512 * 1024 // og tags live in <head>, no need for the whole page
+	maxRedirects        = 3
+	userAgent           = "Mozilla/5.0 (compatible; ManaBot/0.1; +link-preview)"
+)
+
+var ErrBlockedAddress = errors.New("unfurl: destination address is not allowed")
+
+// ranges the std lib helpers don't cover
+var blockedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",     // "this" network
+	"100.64.0.0/10", // carrier grade nat
+	"192.0.0.0/24",  // ietf protocol assignments
+	"192.0.2.0/24",  // test-net-1
+	"198.18.0.0/15", // benchmarking
+	"198.51.100.0/24",
+	"203.0.113.0/24",
+	"240.0.0.0/4",  // reserved
+	"64:ff9b::/96", // nat64, can map onto private v4
+	"2001:db8::/32",
+)
+
+// Fetches link metadata. Client can be swapped out, ie for an httptest server.
+type Fetcher struct {
+	Client       *http.Client
+	MaxBodyBytes int64
+}
+
+// Makes a fetcher whose client refuses to connect to private, loopback
+// and otherwise internal addresses
+func NewFetcher() *Fetcher {
+	return &Fetcher{
+		Client:       newSafeClient(defaultTimeout),
+		MaxBodyBytes: defaultMaxBodyBytes,
+	}
+}
+
+func newSafeClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Transport: NewSafeTransport(timeout, false),
+		Timeout:   timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return errors.New("unfurl: too many redirects")
+			}
+			return checkScheme(req.URL)
+		},
+	}
+}
+
+// A transport that refuses to connect to private, loopback and otherwise
+// internal addresses, redirects included. allowLoopback lets loopback
+// through, for receivers running next to the server in development.
+func NewSafeTransport(timeout time.Duration, allowLoopback bool) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+
+		// runs after dns resolution, so rebinding a hostname to an internal ip is caught too
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			ip := net.ParseIP(host)
+			if ip == nil || !allowedIP(ip, allowLoopback) {
+				return ErrBlockedAddress
+			}
+			return nil
+		},
+	}
+
+	return &http.Transport{
+		Proxy:                 nil, // a proxy would bypass the dial check
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+}
+
+// Resolves host and fails with ErrBlockedAddress if any address it points
+// at couldn't be dialed by a safe transport. The dial check still has the
+// final say, dns can change after this.
+func CheckHost(ctx context.Context, host string, allowLoopback bool) error {
+	if ip := net.ParseIP(host); ip != nil {
+		if !allowedIP(ip, allowLoopback) {
+			return ErrBlockedAddress
+		}
+		return nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+
+	for _, addr := range addrs {
+		if !allowedIP(addr.IP, allowLoopback) {
+			return ErrBlockedAddress
+		}
+	}
+	return nil
+}
+
+func allowedIP(ip net.IP, allowLoopback bool) bool {
+	return IsPublicIP(ip) || (allowLoopback && ip.IsLoopback())
+}
+
+// Reports whether ip is routable on the public internet
+func IsPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() ||
+		ip.IsPrivate() ||
+		ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() {
+		return false
+	}
+
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Fetches the page at rawURL and builds an embed from its metadata
+func (fetcher *Fetcher) Fetch(ctx context.Context, rawURL string) (*models.Embed, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkScheme(target); err != nil {
+		return nil, err
+	}
+
+	resp, err := fetcher.get(ctx, target.String(), "text/html,application/xhtml+xml,image/*;q=0.8")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unfurl: unexpected status %d", resp.StatusCode)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+
+	// direct links to images embed as themselves
+	if strings.HasPrefix(mediaType, "image/") {
+		return &models.Embed{URL: rawURL, Type: models.EmbedTypeImage, ImageURL: rawURL}, nil
+	}
+
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, fmt.Errorf("unfurl: unsupported content type %q", mediaType)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, fetcher.MaxBodyBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	meta := parseMetadata(string(body))
+	embed := meta.toEmbed(rawURL, resp.Request.URL)
+
+	// oEmbed fills in what OpenGraph left out
+	if meta.oEmbedURL != "" {
+		if oembed, err := fetcher.fetchOEmbed(ctx, resp.Request.URL, meta.oEmbedURL); err == nil {
+			oembed.mergeInto(embed)
+		}
+	}
+
+	if embed.Title == "" && embed.Description == "" && embed.ImageURL == "" {
+		return nil, errors.New("unfurl: page has no usable metadata")
+	}
+
+	return embed, nil
+}
+
+type oEmbedResponse struct {
+	Type         string `json:"type"`
+	Title        string `json:"title"`
+	AuthorName   string `json:"author_name"`
+	ProviderName string `json:"provider_name"`
+	ThumbnailURL string `json:"thumbnail_url"`
+	URL          string `json:"url"` // set for photo types
+}
+
+func (fetcher *Fetcher) fetchOEmbed(ctx context.Context, base *url.URL, href string) (*oEmbedResponse, error) {
+	target, err := base.Parse(href)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkScheme(target); err != nil {
+		return nil, err
+	}
+
+	resp, err := fetcher.get(ctx, target.String(), "application/json")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unfurl: unexpected oembed status %d", resp.StatusCode)
+	}
+
+	var oembed oEmbedResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, fetcher.MaxBodyBytes)).Decode(&oembed); err != nil {
+		return nil, err
+	}
+
+	return &oembed, nil
+}
+
+func (oembed *oEmbedResponse) mergeInto(embed *models.Embed) {
+	if embed.Title == "" {
+		embed.Title = oembed.Title
+	}
+	if embed.ProviderName == "" {
+		embed.ProviderName = oembed.ProviderName
+	}
+	if embed.AuthorName == "" {
+		embed.AuthorName = oembed.AuthorName
+	}
+	if embed.ImageURL == "" {
+		if oembed.Type == "photo" && isHTTPURL(oembed.URL) {
+			embed.ImageURL = oembed.URL
+		} else if isHTTPURL(oembed.ThumbnailURL) {
+			embed.ImageURL = oembed.ThumbnailURL
+		}
+	}
+	if oembed.Type == "video" {
+		embed.Type = models.EmbedTypeVideo
+	}
+}
+
+func (fetcher *Fetcher) get(ctx context.Context, target string, accept string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", accept)
+
+	return fetcher.Client.Do(req)
+}
+
+func checkScheme(target *url.URL) error {
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return fmt.Errorf("unfurl: unsupported scheme %q", target.Scheme)
+	}
+	if target.User != nil {
+		return errors.New("unfurl: urls with credentials are not allowed")
+	}
+	return nil
+}
+
+func isHTTPURL(raw string) bool {
+	target, err := url.Parse(raw)
+	return err == nil && checkScheme(target) == nil && target.Host != ""
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
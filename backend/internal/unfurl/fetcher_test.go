@@ -0,0 +1,67 @@
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const openGraphPage = `<html><head>
+<meta property="og:title" content="A page">
+<meta property="og:description" content="About the page">
+</head><body></body></html>`
+
+// a fetcher that can reach httptest servers, which listen on loopback
+func newTestFetcher(server *httptest.Server) *Fetcher {
+	return &Fetcher{Client: server.Client(), MaxBodyBytes: defaultMaxBodyBytes}
+}
+
+func TestFetchOpenGraph(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(openGraphPage))
+	}))
+	defer server.Close()
+
+	embed, err := newTestFetcher(server).Fetch(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if embed.Title != "A page" || embed.Description != "About the page" {
+		t.Errorf("got title %q, description %q", embed.Title, embed.Description)
+	}
+}
+
+func TestFetchNotFound(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(openGraphPage))
+	}))
+	defer server.Close()
+
+	embed, err := newTestFetcher(server).Fetch(context.Background(), server.URL)
+	if err == nil {
+		t.Fatalf("expected an error for a 404, got embed %+v", embed)
+	}
+}
+
+func TestFetchBlocksLoopback(t *testing.T) {
+	requested := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = true
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(openGraphPage))
+	}))
+	defer server.Close()
+
+	_, err := NewFetcher().Fetch(context.Background(), server.URL)
+	if !errors.Is(err, ErrBlockedAddress) {
+		t.Fatalf("expected ErrBlockedAddress, got %v", err)
+	}
+	if requested {
+		t.Error("the safe fetcher reached a loopback server")
+	}
+}
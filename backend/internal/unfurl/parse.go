@@ -0,0 +1,127 @@
+package unfurl
+
+import (
+	"html"
+	"mana/internal/models"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	maxTitleLength       = 256
+	maxDescriptionLength = 1024
+	maxSiteNameLength    = 128
+)
+
+var (
+	metaTagRegex   = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	linkTagRegex   = regexp.MustCompile(`(?is)<link\s[^>]*>`)
+	titleTagRegex  = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	attributeRegex = regexp.MustCompile(`(?is)([a-z][a-z0-9:_-]*)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+)
+
+// What we could pull out of a page's <head>
+type metadata struct {
+	title       string
+	description string
+	siteName    string
+	image       string
+	ogType      string
+	pageTitle   string
+	oEmbedURL   string
+}
+
+func parseMetadata(page string) *metadata {
+	meta := &metadata{}
+
+	for _, tag := range metaTagRegex.FindAllString(page, -1) {
+		attrs := parseAttributes(tag)
+
+		key := attrs["property"]
+		if key == "" {
+			key = attrs["name"]
+		}
+		content := strings.TrimSpace(attrs["content"])
+		if content == "" {
+			continue
+		}
+
+		// first value wins, og tags come before twitter/plain fallbacks
+		switch strings.ToLower(key) {
+		case "og:title", "twitter:title":
+			setOnce(&meta.title, content)
+		case "og:description", "twitter:description", "description":
+			setOnce(&meta.description, content)
+		case "og:site_name":
+			setOnce(&meta.siteName, content)
+		case "og:image", "og:image:url", "og:image:secure_url", "twitter:image":
+			setOnce(&meta.image, content)
+		case "og:type":
+			setOnce(&meta.ogType, content)
+		}
+	}
+
+	for _, tag := range linkTagRegex.FindAllString(page, -1) {
+		attrs := parseAttributes(tag)
+		if strings.EqualFold(attrs["type"], "application/json+oembed") && strings.Contains(strings.ToLower(attrs["rel"]), "alternate") {
+			setOnce(&meta.oEmbedURL, strings.TrimSpace(attrs["href"]))
+		}
+	}
+
+	if match := titleTagRegex.FindStringSubmatch(page); match != nil {
+		meta.pageTitle = strings.TrimSpace(html.UnescapeString(match[1]))
+	}
+
+	return meta
+}
+
+func (meta *metadata) toEmbed(rawURL string, base *url.URL) *models.Embed {
+	embed := &models.Embed{
+		URL:         rawURL,
+		Type:        models.EmbedTypeLink,
+		Title:       truncate(meta.title, maxTitleLength),
+		Description: truncate(meta.description, maxDescriptionLength),
+		SiteName:    truncate(meta.siteName, maxSiteNameLength),
+	}
+
+	if embed.Title == "" {
+		embed.Title = truncate(meta.pageTitle, maxTitleLength)
+	}
+
+	if strings.HasPrefix(strings.ToLower(meta.ogType), "video") {
+		embed.Type = models.EmbedTypeVideo
+	}
+
+	// images are often relative to the page
+	if meta.image != "" {
+		if image, err := base.Parse(meta.image); err == nil && isHTTPURL(image.String()) {
+			embed.ImageURL = image.String()
+		}
+	}
+
+	return embed
+}
+
+func parseAttributes(tag string) map[string]string {
+	attrs := make(map[string]string)
+	for _, match := range attributeRegex.FindAllStringSubmatch(tag, -1) {
+		value := match[2] + match[3] + match[4] // only one group matches
+		attrs[strings.ToLower(match[1])] = html.UnescapeString(value)
+	}
+	return attrs
+}
+
+func setOnce(field *string, value string) {
+	if *field == "" {
+		*field = value
+	}
+}
+
+func truncate(value string, max int) string {
+	runes := []rune(value)
+	if len(runes) <= max {
+		return value
+	}
+	return string(runes[:max-1]) + "…"
+}
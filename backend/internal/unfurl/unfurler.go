@@ -0,0 +1,166 @@
+package unfurl
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"mana/internal/db"
+	"mana/internal/models"
+	"mana/internal/types"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	queueSize      = 256
+	workerCount    = 4
+	cacheTTL       = 24 * time.Hour
+	failedCacheTTL = time.Hour
+	messageTimeout = 15 * time.Second
+)
+
+// <https://...> opts a single link out of embedding
+var linkRegex = regexp.MustCompile(`<?https?://[^\s<>]+>?`)
+
+// Resolves embeds for new messages in the background and pushes
+// a MESSAGE_UPDATE once they're ready
+type Unfurler struct {
+	Store   *db.Store
+	Hub     types.HubInterface
+	Fetcher *Fetcher
+
+	queue chan *models.Message
+}
+
+func NewUnfurler(store *db.Store, hub types.HubInterface) *Unfurler {
+	return &Unfurler{
+		Store:   store,
+		Hub:     hub,
+		Fetcher: NewFetcher(),
+		queue:   make(chan *models.Message, queueSize),
+	}
+}
+
+// Queues a message for unfurling, never blocks the caller
+func (unfurler *Unfurler) Enqueue(message *models.Message) {
+	if len(ExtractLinks(message.Content)) == 0 {
+		return
+	}
+
+	select {
+	case unfurler.queue <- message:
+	default:
+		log.Printf("Unfurl queue full, skipping message %s", message.ID)
+	}
+}
+
+// Run starts the workers and blocks until ctx is done
+func (unfurler *Unfurler) Run(ctx context.Context) {
+	for i := 0; i < workerCount; i++ {
+		go unfurler.work(ctx)
+	}
+	<-ctx.Done()
+}
+
+func (unfurler *Unfurler) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case message := <-unfurler.queue:
+			unfurler.unfurlMessage(ctx, message)
+		}
+	}
+}
+
+func (unfurler *Unfurler) unfurlMessage(ctx context.Context, message *models.Message) {
+	ctx, cancel := context.WithTimeout(ctx, messageTimeout)
+	defer cancel()
+
+	var embeds []models.Embed
+	for _, link := range ExtractLinks(message.Content) {
+		embed := unfurler.resolve(ctx, link)
+		if embed != nil {
+			embeds = append(embeds, *embed)
+		}
+	}
+
+	if len(embeds) == 0 {
+		return
+	}
+
+	if err := unfurler.Store.Messages.UpdateMessageEmbeds(ctx, message.ID, embeds); err != nil {
+		log.Printf("Failed to save embeds for message %s: %v", message.ID, err)
+		return
+	}
+
+	updated := *message
+	updated.Embeds = embeds
+
+	data, err := json.Marshal(updated)
+	if err != nil {
+		log.Printf("Failed to marshal message %s: %v", message.ID, err)
+		return
+	}
+
+	unfurler.Hub.BroadcastMessage(types.Event{
+		Type:      types.EventMessageUpdate,
+		ChannelID: message.ChannelID,
+		Data:      data,
+	})
+}
+
+// Gets the embed for a link from cache, fetching it when missing or stale
+func (unfurler *Unfurler) resolve(ctx context.Context, link string) *models.Embed {
+	embed, hit, err := unfurler.Store.Embeds.GetCachedEmbed(ctx, link, cacheTTL)
+	if err != nil {
+		log.Printf("Failed to read embed cache for %s: %v", link, err)
+	}
+	if hit && (embed != nil || unfurler.recentlyFailed(ctx, link)) {
+		return embed
+	}
+
+	embed, err = unfurler.Fetcher.Fetch(ctx, link)
+	if err != nil {
+		embed = nil
+	}
+
+	if err := unfurler.Store.Embeds.UpsertCachedEmbed(ctx, link, embed); err != nil {
+		log.Printf("Failed to cache embed for %s: %v", link, err)
+	}
+
+	return embed
+}
+
+// failures are retried sooner than successes are refreshed
+func (unfurler *Unfurler) recentlyFailed(ctx context.Context, link string) bool {
+	_, hit, err := unfurler.Store.Embeds.GetCachedEmbed(ctx, link, failedCacheTTL)
+	return err == nil && hit
+}
+
+// Pulls unique http(s) links out of message content, skipping <wrapped> ones
+func ExtractLinks(content string) []string {
+	seen := make(map[string]bool)
+	var links []string
+
+	for _, match := range linkRegex.FindAllString(content, -1) {
+		if strings.HasPrefix(match, "<") && strings.HasSuffix(match, ">") {
+			continue
+		}
+
+		link := strings.TrimPrefix(match, "<")
+		link = strings.TrimRight(link, ".,;:!?)]}>'\"")
+		if seen[link] {
+			continue
+		}
+
+		seen[link] = true
+		links = append(links, link)
+		if len(links) == models.MaxEmbedsPerMessage {
+			break
+		}
+	}
+
+	return links
+}
@@ -17,14 +17,9 @@ func HandleEvent(client *types.Client, raw []byte) {
 
 	// handle event types
 	switch event.Type {
-	case types.EventSendMessage:
-		handleSendMessage(client, event.Data)
+	case types.EventMemberListSubscribe:
+		handleMemberListSubscribe(client, event.Data)
 	default:
 		log.Printf("Unhandled event type: %s", event.Type)
 	}
 }
-
-func mustMarshal(data any) []byte {
-	b, _ := json.Marshal(data)
-	return b
-}
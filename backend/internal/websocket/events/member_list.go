@@ -0,0 +1,18 @@
+package events
+
+import (
+	"encoding/json"
+	"log"
+	"mana/internal/types"
+)
+
+func handleMemberListSubscribe(client *types.Client, raw json.RawMessage) {
+	var payload types.MemberListSubscribePayload
+
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		log.Printf("Invalid MEMBER_LIST_SUBSCRIBE payload: %v", err)
+		return
+	}
+
+	client.Hub.SubscribeMemberList(client, payload.Ranges)
+}
@@ -1,12 +1,21 @@
 package websocket
 
 import (
+	"encoding/json"
+	"log"
 	"mana/internal/types"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+const (
+	// per client, member list requests past this in a window are dropped
+	memberListRequestLimit  = 5
+	memberListRequestWindow = 5 * time.Second
+)
+
 type Hub struct {
 	// read write lock
 	mutex sync.RWMutex
@@ -14,6 +23,25 @@ type Hub struct {
 	// maps channel id to all clients connected to that channel
 	Channels map[uuid.UUID]map[*types.Client]bool
 
+	// maps user id to how many connections they have open, across all channels
+	Users map[uuid.UUID]int
+
+	// member list ranges each client asked to be kept up to date on
+	memberLists map[*types.Client]*memberListSubscription
+
+	// when each client's current member list request window started and how
+	// many requests it has made in it
+	memberListRequests map[*types.Client]*requestWindow
+
+	// called without the lock when a user's first connection opens, set before Run
+	OnUserOnline func(userID uuid.UUID)
+
+	// called without the lock when a user's last connection goes away, set before Run
+	OnUserOffline func(userID uuid.UUID)
+
+	// called without the lock when a client asks for member list ranges, set before Run
+	OnMemberListSubscribe func(client *types.Client, ranges []types.MemberListRange)
+
 	// Channels for events
 	Register   chan *types.Client
 	Unregister chan *types.Client
@@ -22,10 +50,13 @@ type Hub struct {
 
 func NewHub() *Hub {
 	return &Hub{
-		Channels:   make(map[uuid.UUID]map[*types.Client]bool),
-		Register:   make(chan *types.Client),
-		Unregister: make(chan *types.Client),
-		Broadcast:  make(chan types.Event),
+		Channels:           make(map[uuid.UUID]map[*types.Client]bool),
+		Users:              make(map[uuid.UUID]int),
+		memberLists:        make(map[*types.Client]*memberListSubscription),
+		memberListRequests: make(map[*types.Client]*requestWindow),
+		Register:           make(chan *types.Client),
+		Unregister:         make(chan *types.Client),
+		Broadcast:          make(chan types.Event),
 	}
 }
 
@@ -46,6 +77,10 @@ func (hub *Hub) Run() {
 			}
 
 			hub.Channels[client.ChannelID][client] = true
+			hub.Users[client.UserID]++
+			if hub.Users[client.UserID] == 1 && hub.OnUserOnline != nil {
+				go hub.OnUserOnline(client.UserID)
+			}
 
 			hub.mutex.Unlock()
 
@@ -62,6 +97,7 @@ func (hub *Hub) Run() {
 					// unregister them from clients
 					delete(clients, client)
 					close(client.Send)
+					hub.userDisconnected(client)
 
 					// if we have 0 clients left, remove this channel from hub
 					if len(clients) == 0 {
@@ -74,19 +110,27 @@ func (hub *Hub) Run() {
 
 		// Deliver an event to all clients connected to a channelID
 		case event := <-hub.Broadcast:
+			// clients need the event type to know what the data is
+			payload, err := json.Marshal(event)
+			if err != nil {
+				log.Printf("Failed to marshal %s event: %v", event.Type, err)
+				continue
+			}
+
 			hub.mutex.Lock()
 
 			// if we have clients in the given event's channel
 			if clients, ok := hub.Channels[event.ChannelID]; ok {
 
-				// send event data to every connected client for that channel
+				// send event to every connected client for that channel
 				for client := range clients {
 					select {
-					case client.Send <- event.Data:
+					case client.Send <- payload:
 					default:
 						// client is unresponsive
 						close(client.Send)
 						delete(clients, client)
+						hub.userDisconnected(client)
 					}
 				}
 			}
@@ -97,6 +141,23 @@ func (hub *Hub) Run() {
 	}
 }
 
+// must hold the lock
+func (hub *Hub) userDisconnected(client *types.Client) {
+	delete(hub.memberLists, client)
+	delete(hub.memberListRequests, client)
+
+	userID := client.UserID
+	hub.Users[userID]--
+	if hub.Users[userID] > 0 {
+		return
+	}
+
+	delete(hub.Users, userID)
+	if hub.OnUserOffline != nil {
+		go hub.OnUserOffline(userID)
+	}
+}
+
 func (h *Hub) BroadcastMessage(event types.Event) {
 	h.Broadcast <- event
 }
@@ -108,3 +169,178 @@ func (h *Hub) UnregisterClient(client *types.Client) {
 func (h *Hub) RegisterClient(client *types.Client) {
 	h.Register <- client
 }
+
+// Snapshot of how many connections each channel has open
+func (h *Hub) ConnectionCounts() map[uuid.UUID]int {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	counts := make(map[uuid.UUID]int, len(h.Channels))
+	for channelID, clients := range h.Channels {
+		counts[channelID] = len(clients)
+	}
+
+	return counts
+}
+
+// Snapshot of the users with a connection open to the channel
+func (h *Hub) ChannelUsers(channelID uuid.UUID) []uuid.UUID {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	seen := make(map[uuid.UUID]bool)
+	var userIDs []uuid.UUID
+	for client := range h.Channels[channelID] {
+		if !seen[client.UserID] {
+			seen[client.UserID] = true
+			userIDs = append(userIDs, client.UserID)
+		}
+	}
+
+	return userIDs
+}
+
+type memberListSubscription struct {
+	guildID uuid.UUID
+	ranges  []types.MemberListRange
+}
+
+type requestWindow struct {
+	start    time.Time
+	requests int
+}
+
+// Hands a client's member list request to OnMemberListSubscribe, unless the
+// client is over its limit
+func (h *Hub) SubscribeMemberList(client *types.Client, ranges []types.MemberListRange) {
+	if h.OnMemberListSubscribe != nil && h.allowMemberListRequest(client) {
+		go h.OnMemberListSubscribe(client, ranges)
+	}
+}
+
+func (h *Hub) allowMemberListRequest(client *types.Client) bool {
+	now := time.Now()
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !h.connected(client) {
+		return false
+	}
+
+	window, ok := h.memberListRequests[client]
+	if !ok || now.Sub(window.start) >= memberListRequestWindow {
+		h.memberListRequests[client] = &requestWindow{start: now, requests: 1}
+		return true
+	}
+
+	if window.requests >= memberListRequestLimit {
+		return false
+	}
+
+	window.requests++
+	return true
+}
+
+// Remembers which ranges of the guild's member list the client follows,
+// replacing what it followed before. False if the client is gone.
+func (h *Hub) SetMemberListSubscription(client *types.Client, guildID uuid.UUID, ranges []types.MemberListRange) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !h.connected(client) {
+		return false
+	}
+
+	h.memberLists[client] = &memberListSubscription{guildID: guildID, ranges: ranges}
+	return true
+}
+
+// Snapshot of the clients following the guild's member list, with their ranges
+func (h *Hub) MemberListSubscribers(guildID uuid.UUID) map[*types.Client][]types.MemberListRange {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	subscribers := make(map[*types.Client][]types.MemberListRange)
+	for client, subscription := range h.memberLists {
+		if subscription.guildID == guildID {
+			subscribers[client] = subscription.ranges
+		}
+	}
+
+	return subscribers
+}
+
+// Delivers an event to one client, dropped if the client is already gone
+func (h *Hub) SendToClient(client *types.Client, event types.Event) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to marshal %s event: %v", event.Type, err)
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !h.connected(client) {
+		return
+	}
+
+	select {
+	case client.Send <- payload:
+	default:
+		// client is unresponsive
+		close(client.Send)
+		delete(h.Channels[client.ChannelID], client)
+		if len(h.Channels[client.ChannelID]) == 0 {
+			delete(h.Channels, client.ChannelID)
+		}
+		h.userDisconnected(client)
+	}
+}
+
+// Closes the user's connections to any of the channels, used when they're
+// removed from the guild the channels belong to
+func (h *Hub) DisconnectUser(userID uuid.UUID, channelIDs []uuid.UUID) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for _, channelID := range channelIDs {
+		clients, ok := h.Channels[channelID]
+		if !ok {
+			continue
+		}
+
+		for client := range clients {
+			if client.UserID != userID {
+				continue
+			}
+
+			close(client.Send)
+			delete(clients, client)
+			h.userDisconnected(client)
+		}
+
+		if len(clients) == 0 {
+			delete(h.Channels, channelID)
+		}
+	}
+}
+
+// Snapshot of the users with at least one connection open
+func (h *Hub) OnlineUsers() map[uuid.UUID]bool {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	online := make(map[uuid.UUID]bool, len(h.Users))
+	for userID := range h.Users {
+		online[userID] = true
+	}
+
+	return online
+}
+
+// must hold the lock
+func (h *Hub) connected(client *types.Client) bool {
+	return h.Channels[client.ChannelID][client]
+}
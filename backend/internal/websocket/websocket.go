@@ -2,7 +2,6 @@ package websocket
 
 import (
 	"log"
-	"mana/internal/auth"
 	"mana/internal/types"
 	"net/http"
 
@@ -18,20 +17,14 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
-func ServeWebsocket(hub *Hub, w http.ResponseWriter, r *http.Request) {
+// userID was authenticated by the route, from either a login or a bot token
+func ServeWebsocket(hub *Hub, userID uuid.UUID, w http.ResponseWriter, r *http.Request) {
 
 	// extra channel ID from query
-	channelIDStr := chi.URLParam(r, "channel_id")
+	channelIDStr := chi.URLParam(r, "id")
 	channelID, err := uuid.Parse(channelIDStr)
 	if err != nil {
-		http.Error(w, "Invalid channel_id", http.StatusBadRequest)
-		return
-	}
-
-	// get user id
-	userID, err := auth.GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
 		return
 	}
 